@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+)
+
+var (
+	keyUser   string
+	keyOwner  string
+	keyAll    bool
+	keyResume bool
+	keyWindow time.Duration
+)
+
+// ApikeyCmd manages lifecycle of API keys, keys created by AdduserCmd.
+var ApikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "list, suspend, rotate or revoke API keys",
+}
+
+var apikeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list API keys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			keys, err := service.ListKeys(keyOwner, keyAll)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-6s %-24s %-16s %-18s %-16s %-8s %-20s %s\n", "ID", "USER", "OWNER", "PREFIX", "ROLE", "SUSPEND", "EXPIRES", "LAST USED")
+			for _, item := range keys {
+				fmt.Printf("%-6d %-24s %-16s %-18s %-16s %-8t %-20s %s %s\n", item.ID, item.UserName, item.Owner, item.KeyPrefix,
+					item.Role, item.Suspend, formatTime(item.Expiretion), formatTime(item.LastUsedAt), item.LastUsedIP)
+			}
+			return nil
+		})
+	},
+}
+
+var apikeySuspendCmd = &cobra.Command{
+	Use:   "suspend",
+	Short: "suspend API key, --resume to resume it",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			key, err := service.FindKey(keyUser)
+			if err != nil {
+				return err
+			}
+			if err := service.SuspendKey(key, !keyResume); err != nil {
+				return err
+			}
+			fmt.Printf("API key of %s suspend=%t\n", keyUser, !keyResume)
+			return nil
+		})
+	},
+}
+
+var apikeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "rotate API key, previous key valid within the window",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			key, err := service.FindKey(keyUser)
+			if err != nil {
+				return err
+			}
+			raw, err := service.RotateKey(key, keyWindow)
+			if err != nil {
+				return err
+			}
+			fmt.Println("API key rotated.", raw)
+			return nil
+		})
+	},
+}
+
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "revoke API key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			key, err := service.FindKey(keyUser)
+			if err != nil {
+				return err
+			}
+			if err := service.RevokeKey(key); err != nil {
+				return err
+			}
+			fmt.Printf("API key of %s revoked\n", keyUser)
+			return nil
+		})
+	},
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
+func init() {
+	apikeyListCmd.Flags().StringVarP(&keyOwner, "owner", "o", "", "Ownername, empty for all")
+	apikeyListCmd.Flags().BoolVarP(&keyAll, "all", "a", false, "include suspended keys")
+	apikeySuspendCmd.Flags().BoolVar(&keyResume, "resume", false, "resume the suspended key")
+	apikeyRotateCmd.Flags().DurationVarP(&keyWindow, "window", "w", 0, "previous key valid within, default auth.lifecycle.rotateWindow")
+	for _, item := range []*cobra.Command{apikeySuspendCmd, apikeyRotateCmd, apikeyRevokeCmd} {
+		item.Flags().StringVarP(&keyUser, "username", "u", "", "API Key name")
+		item.MarkFlagRequired("username")
+	}
+	ApikeyCmd.AddCommand(apikeyListCmd, apikeySuspendCmd, apikeyRotateCmd, apikeyRevokeCmd)
+}
@@ -0,0 +1,8 @@
+package cmd
+
+import (
+	"github.com/techquest-tech/gin-shared/pkg/messaging"
+)
+
+// DeadLetterCmd manages stored dead letters: deadletters list/show/replay/purge
+var DeadLetterCmd = messaging.DeadLetterCmd
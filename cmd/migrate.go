@@ -0,0 +1,8 @@
+package cmd
+
+import (
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+)
+
+// MigrateCmd runs versioned migrations: migrate up/down/status/baseline
+var MigrateCmd = orm.MigrateCmd
@@ -0,0 +1,8 @@
+package cmd
+
+import (
+	"github.com/techquest-tech/gin-shared/pkg/query"
+)
+
+// QueryCmd runs configured raw queries from CLI: query test <uri>
+var QueryCmd = query.QueryCmd
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+)
+
+var (
+	roleUser string
+	roleName string
+)
+
+// RoleCmd grants or revokes roles of API keys, permissions of roles defined in auth.roles or role_permissions table.
+var RoleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "grant or revoke roles of API key",
+}
+
+var roleGrantCmd = &cobra.Command{
+	Use:   "grant",
+	Short: "grant role to API key user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			if err := service.GrantRole(roleUser, roleName); err != nil {
+				return err
+			}
+			fmt.Printf("role %s granted to %s\n", roleName, roleUser)
+			return nil
+		})
+	},
+}
+
+var roleRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "revoke role from API key user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(service *auth.AuthService) error {
+			if err := service.RevokeRole(roleUser, roleName); err != nil {
+				return err
+			}
+			fmt.Printf("role %s revoked from %s\n", roleName, roleUser)
+			return nil
+		})
+	},
+}
+
+func init() {
+	for _, item := range []*cobra.Command{roleGrantCmd, roleRevokeCmd} {
+		item.Flags().StringVarP(&roleUser, "username", "u", "", "API Key name")
+		item.Flags().StringVarP(&roleName, "role", "r", "", "role name")
+		item.MarkFlagRequired("username")
+		item.MarkFlagRequired("role")
+		RoleCmd.AddCommand(item)
+	}
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"github.com/techquest-tech/gin-shared/pkg/schedule"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AuditPage of query result
+type AuditPage struct {
+	Total int64      `json:"total"`
+	Items []AuditLog `json:"items"`
+}
+
+func init() {
+	core.ProvideStartup(startAudit)
+	ginshared.GetContainer().Provide(initAuditAPI, ginshared.ControllerOptions)
+}
+
+// startAudit registers DB callbacks and the retention job (audit.retention) on schedule.CleanupService.
+func startAudit(a *Auditor, cs *schedule.CleanupService, logger *zap.Logger) (core.Startup, error) {
+	if !a.Settings.Enabled || a.Db == nil {
+		logger.Info("audit is disabled.")
+		return nil, nil
+	}
+	if err := a.RegisterCallbacks(a.Db); err != nil {
+		return nil, err
+	}
+	if a.Settings.Retention == "" {
+		return nil, nil
+	}
+	stmt := &gorm.Statement{DB: a.Db}
+	if err := stmt.Parse(&AuditLog{}); err != nil {
+		return nil, err
+	}
+	req := cs.GetDefaultRequest()
+	req.Tables = []string{stmt.Schema.Table}
+	req.PrefixIncluded = true
+	req.DeletedField = "created_at"
+	req.Duration = a.Settings.Retention
+	err := schedule.CreateSchedule("audit_retention", a.Settings.RetentionSchedule, func() {
+		if err := cs.Cleanup(req); err != nil {
+			logger.Error("audit retention failed", zap.Error(err))
+		}
+	})
+	return nil, err
+}
+
+// initAuditAPI enables query & verify endpoints if audit.api, guarded by API key with audit.perm.
+func initAuditAPI(router *gin.Engine, a *Auditor, service *auth.AuthService, logger *zap.Logger) ginshared.DiController {
+	if !a.Settings.Enabled || !a.Settings.API || a.Db == nil {
+		return nil
+	}
+	base := viper.GetString("baseUri") + a.Settings.Base
+	group := router.Group(base, service.Auth, auth.Require(a.Settings.Perm))
+	doc := func(op *openapi.Operation) *openapi.Operation {
+		op.Tags = []string{"audit"}
+		return op
+	}
+	openapi.GET(group, "", doc(&openapi.Operation{
+		Summary: "query audit logs",
+		Params: []openapi.Param{
+			{Name: "kind", Description: "request or entity"},
+			{Name: "actor"}, {Name: "entity"}, {Name: "entityId"}, {Name: "outcome"},
+			{Name: "from", Description: "RFC3339"}, {Name: "to", Description: "RFC3339"},
+			{Name: "page", Type: "integer"}, {Name: "pageSize", Type: "integer"},
+		},
+		Response: AuditPage{},
+	}), a.query)
+	openapi.GET(group, "verify", doc(&openapi.Operation{
+		Summary:  "verify hash chain",
+		Params:   []openapi.Param{{Name: "node", Description: "current node if empty"}},
+		Response: VerifyResult{},
+	}), a.verify)
+	logger.Info("audit API enabled", zap.String("base", base))
+	return a
+}
+
+func (a *Auditor) query(c *gin.Context) {
+	q := &AuditQuery{}
+	if err := c.ShouldBindQuery(q); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	if owner := c.GetString("owner"); owner != "" {
+		q.Owner = owner
+	}
+	items, total, err := a.Query(q)
+	if err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, AuditPage{Total: total, Items: items})
+}
+
+func (a *Auditor) verify(c *gin.Context) {
+	result, err := a.Verify(c.Query("node"))
+	if err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, result)
+}
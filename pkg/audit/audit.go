@@ -0,0 +1,373 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	KindRequest = "request"
+	KindEntity  = "entity"
+
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailed  = "failed"
+
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+var ErrNoDB = errors.New("audit requires DB")
+
+// Settings of audit, config key audit
+type Settings struct {
+	Enabled           bool
+	Methods           []string      // methods recorded by Middleware
+	Batch             int           // rows per insert
+	Buffer            int           // pending rows, flushed by the caller when full
+	MaxPending        int           // rows kept pending while writing fails, the oldest dropped beyond
+	FlushInterval     time.Duration // pending rows written at least every interval
+	MaxRows           int           // rows of a delete by conditions audited, more rows not audited
+	Chain             bool          // hash chain per node for tamper evidence
+	Retention         string        // e.g. 180d, empty keeps forever
+	RetentionSchedule string
+	API               bool // query & verify endpoints
+	Base              string
+	Perm              string
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Methods:           []string{"POST", "PUT", "PATCH", "DELETE"},
+		Batch:             100,
+		Buffer:            1000,
+		MaxPending:        10000,
+		FlushInterval:     2 * time.Second,
+		MaxRows:           100,
+		RetentionSchedule: "0 3 * * *",
+		Base:              "/audit",
+		Perm:              "audit:read",
+	}
+}
+
+// AuditLog is one audit record, request outcome (KindRequest) or entity change (KindEntity).
+type AuditLog struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Node      string    `gorm:"size:64;index"`
+	Kind      string    `gorm:"size:16"`
+	RequestID string    `gorm:"size:32;index"`
+	Actor     string    `gorm:"size:128;index"`
+	Owner     string    `gorm:"size:64;index"`
+	ClientIP  string    `gorm:"size:64"`
+	Method    string    `gorm:"size:16"`
+	Route     string    `gorm:"size:255"`
+	Path      string    `gorm:"size:512"`
+	Status    int
+	Outcome   string `gorm:"size:16"`
+	Latency   int64  // ms
+	Entity    string `gorm:"size:128;index"`
+	EntityID  string `gorm:"size:64;index"`
+	Action    string `gorm:"size:16"`
+	Changes   string `gorm:"type:text"` // JSON, field: [before, after]
+	Error     string `gorm:"size:512"`
+	PrevHash  string `gorm:"size:64"`
+	Hash      string `gorm:"size:64"`
+}
+
+// digest of the record chained with the previous hash, time in ms so it survives DB precision.
+func (l *AuditLog) digest() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%d\n%s\n%s\n%s\n%s\n%s\n%s",
+		l.PrevHash, l.CreatedAt.UnixMilli(), l.Node, l.Kind, l.RequestID, l.Actor, l.Owner, l.ClientIP,
+		l.Method, l.Route, l.Path, l.Status, l.Outcome, l.Entity, l.EntityID, l.Action, l.Changes, l.Error)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Auditor buffers records and writes them in batches, hash chained if Settings.Chain.
+type Auditor struct {
+	Settings *Settings
+	Db       *gorm.DB
+	logger   *zap.Logger
+	node     string
+
+	lock      sync.Mutex
+	pending   []*AuditLog
+	flushLock sync.Mutex
+	lastHash  *string
+	flushNow  chan struct{}
+	stopped   bool
+	dropped   atomic.Int64
+}
+
+type AuditorParam struct {
+	dig.In
+	DB     *gorm.DB `optional:"true"`
+	Logger *zap.Logger
+}
+
+func NewAuditor(p AuditorParam) *Auditor {
+	settings := DefaultSettings()
+	if sub := viper.Sub("audit"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	node, _ := os.Hostname()
+	a := &Auditor{
+		Settings: settings,
+		Db:       p.DB,
+		logger:   p.Logger,
+		node:     node,
+		flushNow: make(chan struct{}, 1),
+	}
+	if settings.Enabled && a.Db != nil {
+		a.start()
+	}
+	return a
+}
+
+func init() {
+	orm.AppendEntityIf("audit.enabled", &AuditLog{})
+	core.Provide(NewAuditor)
+}
+
+func (a *Auditor) start() {
+	stop := make(chan struct{})
+	interval := a.Settings.FlushInterval
+	if interval <= 0 {
+		interval = DefaultSettings().FlushInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-a.flushNow:
+			case <-stop:
+				return
+			}
+			if err := a.Flush(); err != nil {
+				a.logger.Error("flush audit logs failed.", zap.Error(err))
+			}
+		}
+	}()
+	core.OnServiceStopping(func() {
+		close(stop)
+		a.lock.Lock()
+		a.stopped = true
+		a.lock.Unlock()
+		if err := a.Flush(); err != nil {
+			a.logger.Error("flush audit logs failed.", zap.Error(err))
+		}
+	})
+}
+
+// Record queues the record, written synchronously if the buffer is full or the service stopped.
+func (a *Auditor) Record(entry *AuditLog) {
+	if !a.Settings.Enabled || a.Db == nil {
+		return
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.CreatedAt = entry.CreatedAt.Truncate(time.Millisecond)
+	entry.Node = a.node
+	a.lock.Lock()
+	a.pending = append(a.pending, entry)
+	size, stopped := len(a.pending), a.stopped
+	a.lock.Unlock()
+	switch {
+	case stopped || size >= a.Settings.Buffer:
+		if err := a.Flush(); err != nil {
+			a.logger.Error("flush audit logs failed.", zap.Error(err))
+		}
+	case size >= a.Settings.Batch:
+		select {
+		case a.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns the count of records dropped since started, pending beyond MaxPending while writing failed.
+func (a *Auditor) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Flush writes pending records, records kept pending if failed, up to MaxPending.
+func (a *Auditor) Flush() error {
+	a.flushLock.Lock()
+	defer a.flushLock.Unlock()
+	a.lock.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.lock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := a.write(pending)
+	if err != nil {
+		a.lock.Lock()
+		a.pending = append(pending, a.pending...)
+		over := 0
+		if a.Settings.MaxPending > 0 && len(a.pending) > a.Settings.MaxPending {
+			over = len(a.pending) - a.Settings.MaxPending
+			a.pending = a.pending[over:]
+		}
+		a.lock.Unlock()
+		if over > 0 {
+			total := a.dropped.Add(int64(over))
+			a.logger.Error("audit logs pending over max, the oldest dropped.", zap.Int("dropped", over),
+				zap.Int64("totalDropped", total), zap.Int("maxPending", a.Settings.MaxPending))
+		}
+	}
+	return err
+}
+
+func (a *Auditor) write(pending []*AuditLog) error {
+	if !a.Settings.Chain {
+		return a.Db.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(pending, a.Settings.Batch).Error
+	}
+	if a.lastHash == nil {
+		last := AuditLog{}
+		err := a.Db.Where("node = ?", a.node).Order("id desc").Limit(1).Find(&last).Error
+		if err != nil {
+			return err
+		}
+		a.lastHash = &last.Hash
+	}
+	prev := *a.lastHash
+	for _, item := range pending {
+		item.PrevHash = prev
+		item.Hash = item.digest()
+		prev = item.Hash
+	}
+	err := a.Db.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(pending, a.Settings.Batch).Error
+	if err != nil {
+		for _, item := range pending {
+			item.ID = 0
+		}
+		return err
+	}
+	a.lastHash = &prev
+	return nil
+}
+
+// AuditQuery filters audit logs, empty fields ignored.
+type AuditQuery struct {
+	Kind     string    `form:"kind"`
+	Actor    string    `form:"actor"`
+	Owner    string    `form:"owner"`
+	Entity   string    `form:"entity"`
+	EntityID string    `form:"entityId"`
+	Outcome  string    `form:"outcome"`
+	From     time.Time `form:"from"`
+	To       time.Time `form:"to"`
+	Page     int       `form:"page"`
+	PageSize int       `form:"pageSize"`
+}
+
+// Query returns audit logs, latest first, and the total matched.
+func (a *Auditor) Query(q *AuditQuery) ([]AuditLog, int64, error) {
+	if a.Db == nil {
+		return nil, 0, ErrNoDB
+	}
+	tx := a.Db.Model(&AuditLog{})
+	for col, value := range map[string]string{
+		"kind": q.Kind, "actor": q.Actor, "owner": q.Owner,
+		"entity": q.Entity, "entity_id": q.EntityID, "outcome": q.Outcome,
+	} {
+		if value != "" {
+			tx = tx.Where(col+" = ?", value)
+		}
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_at < ?", q.To)
+	}
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if q.PageSize <= 0 || q.PageSize > 500 {
+		q.PageSize = 50
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	result := make([]AuditLog, 0)
+	err := tx.Order("id desc").Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&result).Error
+	return result, total, err
+}
+
+// VerifyResult of the hash chain, BrokenAt is the ID of first record mismatched.
+type VerifyResult struct {
+	Node     string `json:"node"`
+	Checked  int    `json:"checked"`
+	BrokenAt uint   `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify walks the hash chain of the node (current node if empty), records removed by retention start a new head.
+func (a *Auditor) Verify(node string) (*VerifyResult, error) {
+	if a.Db == nil {
+		return nil, ErrNoDB
+	}
+	if node == "" {
+		node = a.node
+	}
+	result := &VerifyResult{Node: node}
+	prev := ""
+	first := true
+	rows := make([]AuditLog, 0)
+	err := a.Db.Where("node = ? AND hash <> ?", node, "").Order("id").FindInBatches(&rows, 500, func(tx *gorm.DB, batch int) error {
+		for _, item := range rows {
+			if result.BrokenAt != 0 {
+				return nil
+			}
+			result.Checked++
+			switch {
+			case !first && item.PrevHash != prev:
+				result.BrokenAt, result.Reason = item.ID, "previous hash mismatched"
+			case item.Hash != item.digest():
+				result.BrokenAt, result.Reason = item.ID, "hash mismatched"
+			}
+			first = false
+			prev = item.Hash
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	if result.BrokenAt != 0 {
+		a.logger.Warn("audit chain broken", zap.String("node", node), zap.Uint("id", result.BrokenAt), zap.String("reason", result.Reason))
+	}
+	return result, nil
+}
+
+func (a *Auditor) audited(method string) bool {
+	for _, item := range a.Settings.Methods {
+		if strings.EqualFold(item, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type order struct {
+	gorm.Model
+	Code   string
+	Status string
+	Secret string `json:"-"`
+}
+
+func newAuditor(t *testing.T) *Auditor {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&AuditLog{}, &order{}))
+	settings := DefaultSettings()
+	settings.Enabled = true
+	settings.Chain = true
+	a := &Auditor{Settings: settings, Db: db, logger: zap.NewNop(), node: "n1", flushNow: make(chan struct{}, 1)}
+	assert.NoError(t, a.RegisterCallbacks(db))
+	Track(&order{})
+	return a
+}
+
+func TestAudit(t *testing.T) {
+	a := newAuditor(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(a.Middleware())
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("user"); user != "" {
+			c.Set(auth.KeyUser, &auth.AuthKey{UserName: user})
+			c.Set("owner", "acme")
+			return
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+	r.POST("/orders/:id", func(c *gin.Context) {
+		db := a.Db.WithContext(c)
+		o := &order{Code: c.Param("id"), Status: "new", Secret: "s"}
+		assert.NoError(t, db.Create(o).Error)
+		assert.NoError(t, db.Model(o).Updates(map[string]any{"status": "paid"}).Error)
+		assert.NoError(t, db.Delete(o).Error)
+		c.Status(http.StatusOK)
+	})
+	call := func(user string) int {
+		req := httptest.NewRequest(http.MethodPost, "/orders/A1", nil)
+		req.Header.Set("user", user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, call("bob"))
+	assert.Equal(t, http.StatusUnauthorized, call(""))
+	assert.NoError(t, a.Flush())
+
+	entities, total, err := a.Query(&AuditQuery{Kind: KindEntity, Actor: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	actions := []string{}
+	for _, item := range entities {
+		actions = append(actions, item.Action)
+		assert.Equal(t, "1", item.EntityID)
+		assert.Equal(t, "acme", item.Owner)
+		assert.NotContains(t, item.Changes, "Secret")
+	}
+	assert.Equal(t, []string{ActionDelete, ActionUpdate, ActionCreate}, actions)
+	changes := map[string][2]any{}
+	assert.NoError(t, json.Unmarshal([]byte(entities[1].Changes), &changes))
+	assert.Equal(t, [2]any{"new", "paid"}, changes["Status"])
+
+	requests, _, err := a.Query(&AuditQuery{Kind: KindRequest})
+	assert.NoError(t, err)
+	assert.Len(t, requests, 2)
+	assert.Equal(t, OutcomeDenied, requests[0].Outcome)
+	assert.Equal(t, "/orders/:id", requests[1].Route)
+	assert.Equal(t, entities[0].RequestID, requests[1].RequestID)
+
+	result, err := a.Verify("")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result.Checked)
+	assert.Zero(t, result.BrokenAt)
+
+	assert.NoError(t, a.Db.Model(&AuditLog{}).Where("id = ?", 2).Update("actor", "eve").Error)
+	result, err = a.Verify("n1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), result.BrokenAt)
+}
+
+func TestAuditAssignedAndDeleteByID(t *testing.T) {
+	a := newAuditor(t)
+	db := a.Db
+	o := &order{Code: "B1", Status: "new"}
+	assert.NoError(t, db.Create(o).Error)
+
+	// Code changed in memory only, not assigned by the update
+	o.Code = "B2"
+	assert.NoError(t, db.Model(o).Update("status", "paid").Error)
+	assert.NoError(t, db.Model(o).Select("status").Updates(&order{Status: "sent", Code: "B3"}).Error)
+	assert.NoError(t, db.Delete(&order{}, o.ID).Error)
+	assert.NoError(t, a.Flush())
+
+	logs, total, err := a.Query(&AuditQuery{Kind: KindEntity})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Equal(t, ActionDelete, logs[0].Action)
+	assert.Equal(t, "1", logs[0].EntityID)
+	assert.Contains(t, logs[0].Changes, "B1")
+	for _, item := range logs[1:3] {
+		changes := map[string][2]any{}
+		assert.NoError(t, json.Unmarshal([]byte(item.Changes), &changes))
+		assert.Contains(t, changes, "Status")
+		assert.NotContains(t, changes, "Code", "not assigned")
+	}
+}
+
+func TestPendingCapped(t *testing.T) {
+	a := newAuditor(t)
+	a.Settings.MaxPending = 3
+	assert.NoError(t, a.Db.Migrator().DropTable(&AuditLog{}))
+	for i := 0; i < 5; i++ {
+		a.Record(&AuditLog{Kind: KindRequest, Path: fmt.Sprintf("/orders/%d", i)})
+		assert.Error(t, a.Flush())
+	}
+	assert.Len(t, a.pending, 3)
+	assert.Equal(t, int64(2), a.Dropped())
+	assert.Equal(t, "/orders/2", a.pending[0].Path, "the oldest dropped")
+}
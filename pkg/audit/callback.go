@@ -0,0 +1,308 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	keyBefore     = "audit:before"      // the row before update or delete of single entity
+	keyBeforeRows = "audit:before_rows" // rows deleted by conditions
+)
+
+var tracked sync.Map
+
+// Track records changes of the entities, JSON field names used and fields with json:"-" never recorded.
+func Track(entities ...any) {
+	for _, item := range entities {
+		tt := reflect.TypeOf(item)
+		for tt.Kind() == reflect.Ptr {
+			tt = tt.Elem()
+		}
+		tracked.Store(tt, strings.TrimLeft(tt.String(), "*"))
+	}
+}
+
+func entityOf(db *gorm.DB) (string, bool) {
+	if db.Statement.Schema == nil {
+		return "", false
+	}
+	name, ok := tracked.Load(db.Statement.Schema.ModelType)
+	if !ok {
+		return "", false
+	}
+	return name.(string), true
+}
+
+// RegisterCallbacks registers create, update & delete callbacks of tracked entities, the same way as messaging.
+func (a *Auditor) RegisterCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Update().Before("gorm:update").Register("audit:before_update", a.loadBefore(false)),
+		cb.Delete().Before("gorm:delete").Register("audit:before_delete", a.loadBefore(true)),
+		cb.Create().After("gorm:after_create").Register("audit:create", a.callback(ActionCreate)),
+		cb.Update().After("gorm:after_update").Register("audit:update", a.callback(ActionUpdate)),
+		cb.Delete().After("gorm:after_delete").Register("audit:delete", a.callback(ActionDelete)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	a.logger.Info("audit callbacks registered")
+	return nil
+}
+
+// primaryKey of single struct value, false for batch operations.
+func primaryKey(db *gorm.DB) (any, bool) {
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if rv.Kind() != reflect.Struct || field == nil {
+		return nil, false
+	}
+	value, zero := field.ValueOf(db.Statement.Context, rv)
+	return value, !zero
+}
+
+// loadBefore loads current row of the entity before update or delete,
+// rows matched by the WHERE clause for deletes without primary key, e.g. db.Delete(&Order{}, id).
+func (a *Auditor) loadBefore(deleting bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || !a.Settings.Enabled {
+			return
+		}
+		entity, ok := entityOf(db)
+		if !ok {
+			return
+		}
+		pk, ok := primaryKey(db)
+		if !ok {
+			if deleting && reflect.Indirect(db.Statement.ReflectValue).Kind() == reflect.Struct {
+				a.loadDeleted(db, entity)
+			}
+			return
+		}
+		before := reflect.New(db.Statement.Schema.ModelType).Interface()
+		field := db.Statement.Schema.PrioritizedPrimaryField
+		err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+			Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: pk}).
+			Take(before).Error
+		if err != nil {
+			a.logger.Debug("load entity before change failed", zap.Error(err))
+			return
+		}
+		db.Statement.Settings.Store(keyBefore, before)
+	}
+}
+
+// loadDeleted loads rows to delete by the WHERE clause, up to Settings.MaxRows, more rows not audited.
+func (a *Auditor) loadDeleted(db *gorm.DB, entity string) {
+	where, ok := db.Statement.Clauses["WHERE"]
+	if !ok || where.Expression == nil {
+		a.logger.Warn("delete without conditions not audited", zap.String("entity", entity))
+		return
+	}
+	rows := reflect.New(reflect.SliceOf(reflect.PointerTo(db.Statement.Schema.ModelType)))
+	err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Clauses(where.Expression).Limit(a.Settings.MaxRows + 1).Find(rows.Interface()).Error
+	if err != nil {
+		a.logger.Warn("load entities before delete failed, not audited", zap.String("entity", entity), zap.Error(err))
+		return
+	}
+	if rows.Elem().Len() > a.Settings.MaxRows {
+		a.logger.Warn("delete by conditions not audited, too many rows", zap.String("entity", entity),
+			zap.Int("maxRows", a.Settings.MaxRows))
+		return
+	}
+	db.Statement.Settings.Store(keyBeforeRows, rows.Elem())
+}
+
+func (a *Auditor) callback(action string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || !a.Settings.Enabled || db.Statement.RowsAffected == 0 {
+			return
+		}
+		entity, ok := entityOf(db)
+		if !ok {
+			return
+		}
+		var before any
+		if v, ok := db.Statement.Settings.Load(keyBefore); ok {
+			before = v
+		}
+		rv := reflect.Indirect(db.Statement.ReflectValue)
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			if action != ActionCreate {
+				return
+			}
+			for i := 0; i < rv.Len(); i++ {
+				a.entity(db, entity, action, nil, rv.Index(i))
+			}
+			return
+		}
+		if action == ActionDelete {
+			if v, ok := db.Statement.Settings.Load(keyBeforeRows); ok {
+				rows := v.(reflect.Value)
+				for i := 0; i < rows.Len(); i++ {
+					a.entity(db, entity, action, rows.Index(i).Interface(), reflect.Value{})
+				}
+				return
+			}
+			if before == nil {
+				return
+			}
+			a.entity(db, entity, action, before, reflect.Value{})
+			return
+		}
+		if action == ActionUpdate && before == nil {
+			a.logger.Debug("batch update not audited", zap.String("entity", entity))
+			return
+		}
+		a.entity(db, entity, action, before, rv)
+	}
+}
+
+// assigned returns JSON names of the fields assigned by the update, by Statement.Dest (map or struct) & Selects/Omits.
+// Save assigns all fields, Updates(struct) non-zero fields, auto update time always assigned unless omitted.
+func assigned(db *gorm.DB) map[string]bool {
+	stmt := db.Statement
+	selected, restricted := stmt.SelectAndOmitColumns(false, true)
+	fields := make([]*schema.Field, 0)
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	switch dest.Kind() {
+	case reflect.Map:
+		for _, key := range dest.MapKeys() {
+			if field := stmt.Schema.LookUpField(fmt.Sprint(key.Interface())); field != nil {
+				fields = append(fields, field)
+			}
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.AutoUpdateTime > 0 {
+				fields = append(fields, field)
+			}
+		}
+	case reflect.Struct:
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			if restricted || field.AutoUpdateTime > 0 || dest.Type() != stmt.Schema.ModelType {
+				fields = append(fields, field)
+				continue
+			}
+			if _, zero := field.ValueOf(stmt.Context, dest); !zero {
+				fields = append(fields, field)
+			}
+		}
+	}
+	result := map[string]bool{}
+	for _, field := range fields {
+		updatable, ok := selected[field.DBName]
+		if (ok && !updatable) || (restricted && !ok) {
+			continue
+		}
+		if name := jsonName(field); name != "" {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// jsonName of the field as Diff keys, empty for json:"-".
+func jsonName(field *schema.Field) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
+
+func (a *Auditor) entity(db *gorm.DB, entity, action string, before any, after reflect.Value) {
+	var afterValue any
+	target := after
+	if after.IsValid() {
+		afterValue = after.Interface()
+	} else {
+		target = reflect.Indirect(reflect.ValueOf(before))
+	}
+	changes, err := Diff(before, afterValue)
+	if err != nil {
+		a.logger.Error("diff entity failed", zap.String("entity", entity), zap.Error(err))
+		return
+	}
+	if action == ActionUpdate {
+		// fields of the model not assigned are not changed by the update, even if differ from the row
+		only := assigned(db)
+		for k := range changes {
+			if !only[k] {
+				delete(changes, k)
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	raw, _ := json.Marshal(changes)
+	entry := &AuditLog{
+		Kind:    KindEntity,
+		Entity:  entity,
+		Action:  action,
+		Changes: string(raw),
+	}
+	if field := db.Statement.Schema.PrioritizedPrimaryField; field != nil {
+		if value, zero := field.ValueOf(db.Statement.Context, reflect.Indirect(target)); !zero {
+			entry.EntityID = fmt.Sprint(value)
+		}
+	}
+	if s := scopeOf(db.Statement.Context); s != nil {
+		entry.Actor, entry.Owner, entry.RequestID = s.resolve()
+	}
+	a.Record(entry)
+}
+
+func toMap(value any) (map[string]any, error) {
+	result := map[string]any{}
+	if value == nil {
+		return result, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(raw, &result)
+	return result, err
+}
+
+// Diff returns changed fields as [before, after] by JSON representation, before or after nil for create & delete.
+func Diff(before, after any) (map[string][2]any, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	n, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string][2]any{}
+	for k, v := range n {
+		if old, ok := b[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = [2]any{b[k], v}
+		}
+	}
+	for k, v := range b {
+		if _, ok := n[k]; !ok {
+			changes[k] = [2]any{v, nil}
+		}
+	}
+	return changes, nil
+}
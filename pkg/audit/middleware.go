@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/thanhpk/randstr"
+)
+
+type ctxKey int
+
+const scopeKey ctxKey = iota
+
+const KeyRequestID = "auditRequestID"
+
+// scope of the audited request, actor resolved when used so auth middlewares after Middleware still count.
+type scope struct {
+	c         *gin.Context
+	actor     string
+	owner     string
+	requestID string
+}
+
+// WithActor attributes entity changes made with ctx to the actor, for jobs & consumers out of HTTP requests.
+func WithActor(ctx context.Context, actor, owner string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, scopeKey, &scope{actor: actor, owner: owner, requestID: randstr.Hex(8)})
+}
+
+// ActorOf returns actor & owner of current request, API key user, keycloak/oidc subject or user set by other middlewares.
+func ActorOf(c *gin.Context) (string, string) {
+	owner := c.GetString("owner")
+	if v, ok := c.Get(auth.KeyUser); ok {
+		if key, ok := v.(*auth.AuthKey); ok && key != nil && key.UserName != "" {
+			return key.UserName, owner
+		}
+	}
+	if tk, ok := c.Get("token"); ok {
+		if token, ok := tk.(ginkeycloak.KeyCloakToken); ok {
+			if token.PreferredUsername != "" {
+				return token.PreferredUsername, owner
+			}
+			return token.Sub, owner
+		}
+	}
+	return c.GetString("user"), owner
+}
+
+func scopeOf(ctx context.Context) *scope {
+	if ctx == nil {
+		return nil
+	}
+	if s, ok := ctx.Value(scopeKey).(*scope); ok {
+		return s
+	}
+	if c, ok := ctx.(*gin.Context); ok {
+		if s, ok := c.Request.Context().Value(scopeKey).(*scope); ok {
+			return s
+		}
+		return &scope{c: c}
+	}
+	return nil
+}
+
+func (s *scope) resolve() (string, string, string) {
+	if s.c != nil {
+		actor, owner := ActorOf(s.c)
+		return actor, owner, s.requestID
+	}
+	return s.actor, s.owner, s.requestID
+}
+
+func outcomeOf(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= http.StatusBadRequest:
+		return OutcomeFailed
+	}
+	return OutcomeSuccess
+}
+
+// Middleware records audited methods (audit.methods) with actor, route & outcome,
+// entity changes by DB with the request context (db.WithContext(c)) are attributed to the same actor.
+func (a *Auditor) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Settings.Enabled || !a.audited(c.Request.Method) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		s := &scope{c: c, requestID: randstr.Hex(8)}
+		c.Set(KeyRequestID, s.requestID)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), scopeKey, s))
+		c.Next()
+
+		actor, owner := ActorOf(c)
+		status := c.Writer.Status()
+		entry := &AuditLog{
+			CreatedAt: start,
+			Kind:      KindRequest,
+			RequestID: s.requestID,
+			Actor:     actor,
+			Owner:     owner,
+			ClientIP:  c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Path:      c.Request.URL.Path,
+			Status:    status,
+			Outcome:   outcomeOf(status),
+			Latency:   time.Since(start).Milliseconds(),
+		}
+		if err := c.Errors.Last(); err != nil {
+			entry.Outcome = OutcomeFailed
+			entry.Error = truncate(err.Error(), 512)
+		}
+		a.Record(entry)
+	}
+}
+
+// Middleware of the DI provided Auditor.
+func Middleware() gin.HandlerFunc {
+	return core.GetService[*Auditor]().Middleware()
+}
+
+func truncate(value string, size int) string {
+	if len(value) > size {
+		return value[:size]
+	}
+	return value
+}
@@ -0,0 +1,437 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/notify"
+	"github.com/techquest-tech/gin-shared/pkg/schedule"
+	"github.com/thanhpk/randstr"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	KeyScopes = "scopes" // scopes of the API key, []string, empty means not restricted
+
+	prefixLen  = 8
+	maxRoleLen = 512 // size of AuthKey.Role
+)
+
+var ErrKeyNotFound = errors.New("API key is not found")
+
+// KeyLifecycle settings for API keys, config key auth.lifecycle
+type KeyLifecycle struct {
+	CacheTTL      time.Duration // validated keys cached locally, other pods see changes after TTL
+	FlushInterval time.Duration // last used info written in batches
+	FlushBatch    int           // flush earlier if pending keys reached
+	RotateWindow  time.Duration // previous key still valid after rotated
+	WarnBefore    time.Duration // warn keys expiring within
+	WarnTemplate  string        // notify email template for expiry warnings
+	WarnSchedule  string        // cron of WarnExpiring, e.g. @daily, disabled if empty
+	Admin         bool          // enable admin REST endpoints
+	AdminBase     string
+	AdminPerm     string // permission required for admin endpoints
+}
+
+func DefaultKeyLifecycle() KeyLifecycle {
+	return KeyLifecycle{
+		CacheTTL:      time.Minute,
+		FlushInterval: 30 * time.Second,
+		FlushBatch:    200,
+		RotateWindow:  24 * time.Hour,
+		WarnBefore:    7 * 24 * time.Hour,
+		WarnTemplate:  "apikeyExpiring",
+		AdminBase:     "/apikeys",
+		AdminPerm:     "apikeys:admin",
+	}
+}
+
+// KeyRequest for new API key
+type KeyRequest struct {
+	Owner     string     `json:"owner"`
+	UserName  string     `json:"userName" binding:"required"`
+	Remark    string     `json:"remark"`
+	Role      string     `json:"role"`
+	Scopes    []string   `json:"scopes"`
+	AllowIPs  []string   `json:"allowIPs"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type keyUsage struct {
+	at time.Time
+	ip string
+}
+
+// keyPrefix returns hash of the raw key prefix, indexed for lookup, empty for short raw keys.
+// the prefix itself never stored, so stored prefixes don't narrow the key space.
+func keyPrefix(raw string) string {
+	if len(raw) < 2*prefixLen {
+		return ""
+	}
+	return Hash(raw[:prefixLen])[:2*prefixLen]
+}
+
+func splitList(s string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// ScopeList returns scopes of the key, empty means all permissions of the roles.
+func (k *AuthKey) ScopeList() []string {
+	return splitList(k.Scopes)
+}
+
+// AllowedIP checks ip against AllowIPs (IP or CIDR), empty list allows all.
+func (k *AuthKey) AllowedIP(ip string) bool {
+	allows := splitList(k.AllowIPs)
+	if len(allows) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	for _, item := range allows {
+		if item == ip {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(item); err == nil && parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// active checks key matched by hashed is usable now, previous key valid within rotate window.
+func (k *AuthKey) active(hashed string, now time.Time) error {
+	switch {
+	case k.Suspend:
+		return errors.New("apiKey has been suspend")
+	case k.Expiretion != nil && k.Expiretion.Before(now):
+		return errors.New("apiKey is expired")
+	case hashed == k.ApiKey:
+		return nil
+	case hashed == k.PrevApiKey && k.PrevExpiresAt != nil && k.PrevExpiresAt.After(now):
+		return nil
+	}
+	return errors.New("apiKey has been rotated")
+}
+
+func (a *AuthService) keyCache() *cache.CacheRam[*AuthKey] {
+	a.cacheOnce.Do(func() {
+		if a.cache == nil {
+			a.cache = cache.NewRAMCacheProvider[*AuthKey](a.Lifecycle.CacheTTL)
+		}
+	})
+	return a.cache
+}
+
+// invalidate removes cached key, current and previous hash.
+func (a *AuthService) invalidate(key *AuthKey) {
+	c := a.keyCache()
+	c.Del(key.ApiKey)
+	if key.PrevApiKey != "" {
+		c.Del(key.PrevApiKey)
+	}
+}
+
+// lookup finds key of raw from cache or DB by prefix hash, previous key of rotated included.
+// keys without prefix (created before prefixes) found by the key hash.
+func (a *AuthService) lookup(raw string) (*AuthKey, error) {
+	hashed := Hash(raw)
+	if k, ok := a.keyCache().Get(hashed); ok {
+		return k, nil
+	}
+	k, err := a.lookupByPrefix(raw, hashed)
+	if err == nil && k == nil {
+		k = &AuthKey{}
+		err = a.Db.Where("api_key = ?", hashed).
+			Or("prev_api_key = ? and prev_expires_at > ?", hashed, time.Now()).
+			First(k).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.keyCache().Set(hashed, k)
+	return k, nil
+}
+
+// lookupByPrefix returns the key matched hashed among keys of the prefix, nil if not found.
+func (a *AuthService) lookupByPrefix(raw, hashed string) (*AuthKey, error) {
+	prefix := keyPrefix(raw)
+	if prefix == "" {
+		return nil, nil
+	}
+	candidates := make([]*AuthKey, 0)
+	err := a.Db.Where("key_prefix = ?", prefix).
+		Or("prev_key_prefix = ? and prev_expires_at > ?", prefix, time.Now()).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range candidates {
+		if subtle.ConstantTimeCompare([]byte(item.ApiKey), []byte(hashed)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(item.PrevApiKey), []byte(hashed)) == 1 {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// touch records last used info, written by flushUsage in batches.
+func (a *AuthService) touch(key *AuthKey, ip string) {
+	if a.Db == nil || key.builtin {
+		return
+	}
+	a.usageOnce.Do(a.startUsage)
+	a.usageLock.Lock()
+	a.usage[key.ID] = keyUsage{at: time.Now(), ip: ip}
+	full := len(a.usage) >= a.Lifecycle.FlushBatch
+	a.usageLock.Unlock()
+	if full {
+		select {
+		case a.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (a *AuthService) startUsage() {
+	a.usageLock.Lock()
+	if a.usage == nil {
+		a.usage = make(map[uint]keyUsage)
+	}
+	a.usageLock.Unlock()
+	a.flushNow = make(chan struct{}, 1)
+	stop := make(chan struct{})
+	interval := a.Lifecycle.FlushInterval
+	if interval <= 0 {
+		interval = DefaultKeyLifecycle().FlushInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-a.flushNow:
+			case <-stop:
+				return
+			}
+			if err := a.FlushUsage(); err != nil {
+				a.logger.Error("flush API key usage failed.", zap.Error(err))
+			}
+		}
+	}()
+	core.OnServiceStopping(func() {
+		close(stop)
+		if err := a.FlushUsage(); err != nil {
+			a.logger.Error("flush API key usage failed.", zap.Error(err))
+		}
+	})
+}
+
+// FlushUsage writes pending last used info to DB.
+func (a *AuthService) FlushUsage() error {
+	a.usageLock.Lock()
+	pending := a.usage
+	a.usage = make(map[uint]keyUsage)
+	a.usageLock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := a.Db.Transaction(func(tx *gorm.DB) error {
+		for id, item := range pending {
+			err := tx.Model(&AuthKey{}).Where("id = ?", id).UpdateColumns(map[string]any{
+				"last_used_at": item.at,
+				"last_used_ip": item.ip,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		a.logger.Debug("API key usage flushed", zap.Int("keys", len(pending)))
+	}
+	return err
+}
+
+// CreateKey creates API key with role, scopes & allowed IPs, returns the raw key (only shown once).
+func (a *AuthService) CreateKey(req *KeyRequest) (string, *AuthKey, error) {
+	raw := randstr.String(32)
+	key := &AuthKey{
+		UserName:   req.UserName,
+		ApiKey:     Hash(raw),
+		KeyPrefix:  keyPrefix(raw),
+		Owner:      req.Owner,
+		Role:       req.Role,
+		Remark:     req.Remark,
+		Scopes:     strings.Join(req.Scopes, ","),
+		AllowIPs:   strings.Join(req.AllowIPs, ","),
+		Expiretion: req.ExpiresAt,
+	}
+	if err := a.Db.Create(key).Error; err != nil {
+		return "", nil, err
+	}
+	a.logger.Info("API key created", zap.String("user", key.UserName), zap.String("prefix", key.KeyPrefix))
+	return raw, key, nil
+}
+
+// ListKeys lists API keys of owner, empty owner for all.
+func (a *AuthService) ListKeys(owner string, includeSuspended bool) ([]*AuthKey, error) {
+	result := make([]*AuthKey, 0)
+	tx := a.Db.Order("id")
+	if owner != "" {
+		tx = tx.Where("owner = ?", owner)
+	}
+	if !includeSuspended {
+		tx = tx.Where("suspend = ?", false)
+	}
+	err := tx.Find(&result).Error
+	return result, err
+}
+
+// GetKey returns API key by ID, owner not empty limits to keys of the owner.
+func (a *AuthService) GetKey(owner string, id uint) (*AuthKey, error) {
+	key := &AuthKey{}
+	tx := a.Db.Where("id = ?", id)
+	if owner != "" {
+		tx = tx.Where("owner = ?", owner)
+	}
+	if err := tx.First(key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// FindKey returns API key by username.
+func (a *AuthService) FindKey(username string) (*AuthKey, error) {
+	key := &AuthKey{}
+	if err := a.Db.First(key, "user_name = ?", username).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w, %s", ErrKeyNotFound, username)
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// SuspendKey suspends or resumes the key.
+func (a *AuthService) SuspendKey(key *AuthKey, suspend bool) error {
+	if err := a.Db.Model(key).Update("suspend", suspend).Error; err != nil {
+		return err
+	}
+	a.invalidate(key)
+	a.logger.Info("API key suspend changed", zap.String("user", key.UserName), zap.Bool("suspend", suspend))
+	return nil
+}
+
+// RotateKey issues new key, the current one keeps valid within window (RotateWindow if 0).
+func (a *AuthService) RotateKey(key *AuthKey, window time.Duration) (string, error) {
+	if window <= 0 {
+		window = a.Lifecycle.RotateWindow
+	}
+	raw := randstr.String(32)
+	prevExpires := time.Now().Add(window)
+	previous := *key
+	err := a.Db.Model(key).Updates(map[string]any{
+		"api_key":         Hash(raw),
+		"key_prefix":      keyPrefix(raw),
+		"prev_api_key":    key.ApiKey,
+		"prev_key_prefix": key.KeyPrefix,
+		"prev_expires_at": prevExpires,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	// after committed, or lookups in between cache the row before rotation again.
+	a.invalidate(&previous)
+	a.logger.Info("API key rotated", zap.String("user", key.UserName), zap.String("prefix", key.KeyPrefix),
+		zap.Time("previous valid until", prevExpires))
+	return raw, nil
+}
+
+// RevokeKey deletes the key, both current & previous key invalid immediately.
+func (a *AuthService) RevokeKey(key *AuthKey) error {
+	if err := a.Db.Delete(key).Error; err != nil {
+		return err
+	}
+	a.invalidate(key)
+	a.logger.Info("API key revoked", zap.String("user", key.UserName), zap.String("prefix", key.KeyPrefix))
+	return nil
+}
+
+// scheduleWarn runs WarnExpiring by WarnSchedule, emails by notify.EmailNotifer of the container or config key notify.
+func (a *AuthService) scheduleWarn() {
+	if a.Lifecycle.WarnSchedule == "" {
+		return
+	}
+	core.OnServiceStarted(func() {
+		err := schedule.CreateSchedule("apikey_expiry_warn", a.Lifecycle.WarnSchedule, func() {
+			notifier, err := expiryNotifier()
+			if err == nil {
+				_, err = a.WarnExpiring(notifier)
+			}
+			if err != nil {
+				a.logger.Error("warn expiring API keys failed.", zap.Error(err))
+			}
+		})
+		if err != nil {
+			a.logger.Error("schedule API key expiry warnings failed.", zap.Error(err))
+		}
+	})
+}
+
+func expiryNotifier() (*notify.EmailNotifer, error) {
+	if notifier := core.GetService[*notify.EmailNotifer](); notifier != nil {
+		return notifier, nil
+	}
+	notifier := &notify.EmailNotifer{}
+	if err := viper.UnmarshalKey("notify", notifier); err != nil {
+		return nil, err
+	}
+	return notifier, notifier.PostInit()
+}
+
+// WarnExpiring sends one email (WarnTemplate) listing keys expiring within WarnBefore, each key warned once.
+// template data: Keys []*AuthKey, Before time.Duration. scheduled by WarnSchedule.
+func (a *AuthService) WarnExpiring(notifier *notify.EmailNotifer) (int, error) {
+	now := time.Now()
+	keys := make([]*AuthKey, 0)
+	err := a.Db.Where("suspend = ? and expiry_warned_at is null and expiretion > ? and expiretion <= ?",
+		false, now, now.Add(a.Lifecycle.WarnBefore)).Find(&keys).Error
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	err = notifier.Send(a.Lifecycle.WarnTemplate, map[string]any{
+		"Keys":   keys,
+		"Before": a.Lifecycle.WarnBefore,
+	})
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]uint, 0, len(keys))
+	for _, item := range keys {
+		ids = append(ids, item.ID)
+	}
+	if err := a.Db.Model(&AuthKey{}).Where("id in ?", ids).UpdateColumn("expiry_warned_at", now).Error; err != nil {
+		return 0, err
+	}
+	a.logger.Info("expiring API keys warned", zap.Int("keys", len(keys)))
+	return len(keys), nil
+}
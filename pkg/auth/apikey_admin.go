@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"go.uber.org/zap"
+)
+
+// RotateRequest for rotating key, Window empty for auth.lifecycle.rotateWindow
+type RotateRequest struct {
+	Window string `json:"window"`
+}
+
+// KeyCreated returns the raw key, only shown once.
+type KeyCreated struct {
+	Key    string   `json:"key"`
+	ApiKey *AuthKey `json:"apiKey"`
+}
+
+func init() {
+	ginshared.GetContainer().Provide(initKeyAdmin, ginshared.ControllerOptions)
+}
+
+// initKeyAdmin enables admin endpoints of API keys if auth.lifecycle.admin, owner of current user limits the keys managed.
+func initKeyAdmin(router *gin.Engine, service *AuthService, logger *zap.Logger) ginshared.DiController {
+	if !service.Lifecycle.Admin || service.Db == nil {
+		return nil
+	}
+	base := viper.GetString("baseUri") + service.Lifecycle.AdminBase
+	group := router.Group(base, service.Auth, Require(service.Lifecycle.AdminPerm))
+	doc := func(op *openapi.Operation) *openapi.Operation {
+		op.Tags = []string{"apikeys"}
+		return op
+	}
+	idParam := []openapi.Param{{Name: "id", In: "path", Type: "integer", Required: true}}
+
+	openapi.GET(group, "", doc(&openapi.Operation{
+		Summary:  "list API keys",
+		Params:   []openapi.Param{{Name: "all", Type: "boolean", Description: "include suspended keys"}},
+		Response: []AuthKey{},
+	}), service.listKeys)
+	openapi.POST(group, "", doc(&openapi.Operation{
+		Summary:  "create API key",
+		Request:  KeyRequest{},
+		Response: KeyCreated{},
+	}), service.createKey)
+	openapi.POST(group, ":id/suspend", doc(&openapi.Operation{Summary: "suspend API key", Params: idParam}), service.suspendKey(true))
+	openapi.POST(group, ":id/resume", doc(&openapi.Operation{Summary: "resume API key", Params: idParam}), service.suspendKey(false))
+	openapi.POST(group, ":id/rotate", doc(&openapi.Operation{
+		Summary:     "rotate API key",
+		Description: "previous key keeps valid within the window",
+		Params:      idParam,
+		Request:     RotateRequest{},
+		Response:    KeyCreated{},
+	}), service.rotateKey)
+	openapi.DELETE(group, ":id", doc(&openapi.Operation{Summary: "revoke API key", Params: idParam}), service.revokeKey)
+
+	logger.Info("API key admin enabled", zap.String("base", base))
+	return service
+}
+
+func (a *AuthService) keyOf(c *gin.Context) (*AuthKey, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return nil, false
+	}
+	key, err := a.GetKey(c.GetString("owner"), uint(id))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, ginshared.UnifiedResp{Error: err.Error()})
+			return nil, false
+		}
+		ginshared.RespondErr(c, err, a.logger)
+		return nil, false
+	}
+	return key, true
+}
+
+func (a *AuthService) listKeys(c *gin.Context) {
+	keys, err := a.ListKeys(c.GetString("owner"), c.Query("all") == "true")
+	if err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, keys)
+}
+
+func (a *AuthService) createKey(c *gin.Context) {
+	req := &KeyRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	if owner := c.GetString("owner"); owner != "" {
+		req.Owner = owner
+	}
+	if err := grantable(c, req); err != nil {
+		a.logger.Warn("create API key denied", zap.String("user", c.GetString("user")), zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusForbidden, ginshared.GeneralResp{
+			ErrorCode:    "Forbidden",
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+	raw, key, err := a.CreateKey(req)
+	if err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, KeyCreated{Key: raw, ApiKey: key})
+}
+
+// grantable checks the new key never exceeds current user, roles must be held by current user
+// and scopes must be within scopes of current user. empty scopes inherit the scopes of current user.
+func grantable(c *gin.Context, req *KeyRequest) error {
+	held := RolesOf(c)
+	for _, role := range splitList(req.Role) {
+		if !slices.ContainsFunc(held, func(item string) bool { return strings.EqualFold(item, role) }) {
+			return fmt.Errorf("role %s is not granted to current user", role)
+		}
+	}
+	scopes := c.GetStringSlice(KeyScopes)
+	if len(scopes) == 0 {
+		return nil
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = scopes
+		return nil
+	}
+	for _, scope := range req.Scopes {
+		if !inScope(scopes, scope) {
+			return fmt.Errorf("scope %s is out of scopes of current user", scope)
+		}
+	}
+	return nil
+}
+
+func (a *AuthService) suspendKey(suspend bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := a.keyOf(c)
+		if !ok {
+			return
+		}
+		if err := a.SuspendKey(key, suspend); err != nil {
+			ginshared.RespondErr(c, err, a.logger)
+			return
+		}
+		ginshared.RespondOK(c, key)
+	}
+}
+
+func (a *AuthService) rotateKey(c *gin.Context) {
+	req := &RotateRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(req); err != nil {
+			ginshared.ReportBadrequest(c, err)
+			return
+		}
+	}
+	window := time.Duration(0)
+	if req.Window != "" {
+		dur, err := time.ParseDuration(req.Window)
+		if err != nil {
+			ginshared.ReportBadrequest(c, err)
+			return
+		}
+		window = dur
+	}
+	key, ok := a.keyOf(c)
+	if !ok {
+		return
+	}
+	raw, err := a.RotateKey(key, window)
+	if err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, KeyCreated{Key: raw, ApiKey: key})
+}
+
+func (a *AuthService) revokeKey(c *gin.Context) {
+	key, ok := a.keyOf(c)
+	if !ok {
+		return
+	}
+	if err := a.RevokeKey(key); err != nil {
+		ginshared.RespondErr(c, err, a.logger)
+		return
+	}
+	ginshared.RespondOK(c, nil)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestKeyLifecycle(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&AuthKey{}))
+	service := &AuthService{Db: db, logger: zap.NewNop(), Lifecycle: DefaultKeyLifecycle()}
+
+	raw, key, err := service.CreateKey(&KeyRequest{UserName: "u1", Owner: "acme", Scopes: []string{"orders:read"}})
+	assert.NoError(t, err)
+	assert.Equal(t, keyPrefix(raw), key.KeyPrefix)
+	assert.NotContains(t, key.KeyPrefix, raw[:prefixLen], "raw prefix never stored")
+
+	found, ok := service.Validate(raw)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"orders:read"}, found.ScopeList())
+
+	rotated, err := service.RotateKey(key, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, keyPrefix(rotated), key.KeyPrefix)
+	_, ok = service.Validate(rotated)
+	assert.True(t, ok)
+	_, ok = service.Validate(raw)
+	assert.True(t, ok, "previous key valid within window")
+	_, ok = service.Validate(raw[:prefixLen] + rotated[prefixLen:])
+	assert.False(t, ok, "the same prefix only")
+
+	key, err = service.FindKey("u1")
+	assert.NoError(t, err)
+	assert.NoError(t, service.SuspendKey(key, true))
+	_, ok = service.Validate(rotated)
+	assert.False(t, ok, "cached key invalidated")
+
+	assert.NoError(t, service.SuspendKey(key, false))
+	service.touch(found, "10.1.1.1")
+	assert.NoError(t, service.FlushUsage())
+	key, _ = service.FindKey("u1")
+	assert.NotNil(t, key.LastUsedAt)
+	assert.Equal(t, "10.1.1.1", key.LastUsedIP)
+
+	assert.NoError(t, service.RevokeKey(key))
+	_, ok = service.Validate(rotated)
+	assert.False(t, ok)
+}
+
+func TestUsageZeroInterval(t *testing.T) {
+	service := &AuthService{logger: zap.NewNop(), Lifecycle: KeyLifecycle{FlushBatch: 1}}
+	assert.NotPanics(t, service.startUsage, "default interval if not positive")
+}
+
+func TestAllowedIP(t *testing.T) {
+	key := &AuthKey{AllowIPs: "192.168.1.10, 10.0.0.0/8"}
+	assert.True(t, key.AllowedIP("192.168.1.10"))
+	assert.True(t, key.AllowedIP("10.2.3.4"))
+	assert.False(t, key.AllowedIP("192.168.1.11"))
+	assert.True(t, (&AuthKey{}).AllowedIP("1.2.3.4"))
+
+	assert.True(t, inScope(nil, "orders:write"))
+	assert.True(t, inScope([]string{"orders:*"}, "orders:write"))
+	assert.False(t, inScope([]string{"orders:read"}, "orders:write"))
+}
+
+func TestCreateKeyGrantable(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&AuthKey{}))
+	service := &AuthService{Db: db, logger: zap.NewNop(), Lifecycle: DefaultKeyLifecycle()}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(KeyRole, c.GetHeader("role"))
+		c.Set(KeyScopes, splitList(c.GetHeader("scopes")))
+	})
+	r.POST("/keys", service.createKey)
+
+	call := func(role, scopes, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("role", role)
+		req.Header.Set("scopes", scopes)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, call("operator", "", `{"userName":"u1","role":"admin"}`))
+	assert.Equal(t, http.StatusForbidden, call("operator", "", `{"userName":"u1","role":"operator,admin"}`))
+	assert.Equal(t, http.StatusForbidden, call("operator", "orders:read", `{"userName":"u1","role":"operator","scopes":["orders:write"]}`))
+	assert.Equal(t, http.StatusOK, call("Operator", "orders:*", `{"userName":"u1","role":"operator","scopes":["orders:write"]}`))
+	assert.Equal(t, http.StatusOK, call("admin", "", `{"userName":"u2","role":"admin"}`))
+
+	assert.Equal(t, http.StatusOK, call("operator", "orders:read", `{"userName":"u3","role":"operator"}`))
+	key, err := service.FindKey("u3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders:read"}, key.ScopeList(), "scopes inherited from current user")
+}
@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
 	"github.com/techquest-tech/gin-shared/pkg/core"
 	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
 	"github.com/techquest-tech/gin-shared/pkg/orm"
 	"github.com/thanhpk/randstr"
 	"go.uber.org/dig"
@@ -32,6 +35,7 @@ const (
 
 func init() {
 	orm.AppendEntity(&AuthKey{})
+	openapi.RegisterAuth(openapi.SchemeAPIKey, (*AuthService)(nil).Auth)
 
 	core.GetContainer().Provide(func(ap AuthServiceParam) *AuthService {
 		// c := cache.New[*AuthKey]()
@@ -40,6 +44,7 @@ func init() {
 			logger: ap.Logger,
 			// userCache: c,
 			HeaderKey: "apiKey",
+			Lifecycle: DefaultKeyLifecycle(),
 		}
 		authSetting := viper.Sub("auth")
 		if authSetting != nil {
@@ -48,6 +53,7 @@ func init() {
 		// if viper.GetBool(ginshared.KeyInitDB) {
 		// 	ap.DB.AutoMigrate(&AuthKey{})
 		// }
+		authService.scheduleWarn()
 		ap.Logger.Info("api key service inited.")
 		return authService
 	})
@@ -56,13 +62,23 @@ func init() {
 
 type AuthKey struct {
 	gorm.Model
-	UserName   string `gorm:"size:255"`
-	ApiKey     string `gorm:"size:255;unique"`
-	Owner      string `gorm:"size:255"`
-	Role       string `gorm:"size:64"`
-	Remark     string `gorm:"size:64"`
-	Suspend    bool
-	Expiretion *time.Time
+	UserName       string `gorm:"size:255"`
+	ApiKey         string `gorm:"size:255;unique" json:"-"`
+	KeyPrefix      string `gorm:"size:16;index"` // hash of the first chars of raw key, for lookup & to identify key without exposing it
+	Owner          string `gorm:"size:255"`
+	Role           string `gorm:"size:512"` // roles, comma separated
+	Remark         string `gorm:"size:64"`
+	Scopes         string `gorm:"size:512"` // permissions the key limited to, comma separated
+	AllowIPs       string `gorm:"size:512"` // IP or CIDR, comma separated
+	Suspend        bool
+	Expiretion     *time.Time
+	PrevApiKey     string `gorm:"size:255;index" json:"-"` // rotated key, valid until PrevExpiresAt
+	PrevKeyPrefix  string `gorm:"size:16;index" json:"-"`
+	PrevExpiresAt  *time.Time
+	LastUsedAt     *time.Time
+	LastUsedIP     string `gorm:"size:64"`
+	ExpiryWarnedAt *time.Time
+	builtin        bool
 }
 
 type AuthService struct {
@@ -71,6 +87,14 @@ type AuthService struct {
 	Keys   []string
 	// userCache *cache.Cache[*AuthKey]
 	HeaderKey string
+	Lifecycle KeyLifecycle
+
+	cache     *cache.CacheRam[*AuthKey]
+	cacheOnce sync.Once
+	usage     map[uint]keyUsage
+	usageLock sync.Mutex
+	usageOnce sync.Once
+	flushNow  chan struct{}
 }
 
 type Owner struct {
@@ -118,9 +142,10 @@ func (a *AuthService) Validate(key string) (*AuthKey, bool) {
 				Model: gorm.Model{
 					ID: uint(index),
 				},
-				ApiKey: hashed,
-				Owner:  owner,
-				Role:   "admin",
+				ApiKey:  hashed,
+				Owner:   owner,
+				Role:    "admin",
+				builtin: true,
 			}
 			// a.userCache.Set(hashed, c)
 			return c, true
@@ -132,28 +157,18 @@ func (a *AuthService) Validate(key string) (*AuthKey, bool) {
 		return nil, false
 	}
 
-	// if !found {
-	authkey := &AuthKey{}
-	err := a.Db.First(authkey, "api_key = ?", hashed).Error
-
+	authkey, err := a.lookup(key)
 	if err != nil {
 		a.logger.Error("sql query error", zap.Any("error", err))
 		return nil, false
 	}
-	a.logger.Debug("found hashed key in DB", zap.Uint("userID", authkey.ID))
-	// }
+	a.logger.Debug("found hashed key", zap.Uint("userID", authkey.ID))
 
-	if authkey.Suspend {
-		a.logger.Error("apiKey has been suspend", zap.String("apiKey", hashed))
-		return authkey, false
-	}
-	if authkey.Expiretion != nil && authkey.Expiretion.Before(time.Now()) {
-		a.logger.Error("apiKey is expired.", zap.String("apiKey", hashed), zap.Time("expiretion", *authkey.Expiretion))
+	if err := authkey.active(hashed, time.Now()); err != nil {
+		a.logger.Error("apiKey rejected", zap.String("prefix", authkey.KeyPrefix), zap.Uint("userID", authkey.ID), zap.Error(err))
 		return authkey, false
 	}
-	a.logger.Info("validate apiKey done")
-
-	// a.userCache.Set(hashed, authkey)
+	a.logger.Debug("validate apiKey done")
 
 	return authkey, true
 }
@@ -186,11 +201,22 @@ func (a *AuthService) Auth(c *gin.Context) {
 	}
 
 	if authkey, ok := a.Validate(key); ok {
+		ip := c.ClientIP()
+		if !authkey.AllowedIP(ip) {
+			a.logger.Warn("apiKey used from IP not allowed", zap.String("user", authkey.UserName), zap.String("ip", ip))
+			c.AbortWithStatusJSON(http.StatusForbidden, ginshared.GeneralResp{
+				ErrorCode:    "Forbidden",
+				ErrorMessage: "apiKey is not allowed from " + ip,
+			})
+			return
+		}
+		a.touch(authkey, ip)
 		c.Set(KeyUser, authkey)
+		c.Set(KeyScopes, authkey.ScopeList())
 		// c.Set("ownerID", authkey.ID)
 		c.Set("owner", authkey.Owner)
 		c.Set("user", authkey.UserName)
-		// c.Set("role", authkey.Role)
+		c.Set(KeyRole, authkey.Role)
 		c.Next()
 	} else {
 		resp := ginshared.GeneralResp{
@@ -224,10 +250,11 @@ func (a *AuthService) CreateUser(owner, username, remark, rawKey, storeCode stri
 	}
 
 	key := &AuthKey{
-		ApiKey:   Hash(u4),
-		UserName: username,
-		Owner:    owner,
-		Remark:   remark,
+		ApiKey:    Hash(u4),
+		KeyPrefix: keyPrefix(u4),
+		UserName:  username,
+		Owner:     owner,
+		Remark:    remark,
 	}
 	err := a.Db.Save(key).Error
 	if err != nil {
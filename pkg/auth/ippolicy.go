@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	IPAllow = "allow"
+	IPDeny  = "deny"
+)
+
+// IPRule adds allow or deny entry (IP or CIDR, v4 or v6) to the policy.
+type IPRule struct {
+	gorm.Model
+	Policy string `gorm:"size:64;index"`
+	Action string `gorm:"size:16"` // allow or deny
+	CIDR   string `gorm:"size:64"`
+	Remark string `gorm:"size:255"`
+}
+
+// IPPolicySettings of one policy, config key auth.ipPolicies.<name>
+type IPPolicySettings struct {
+	Allow []string
+	Deny  []string
+}
+
+type ipPolicy struct {
+	allow      []netip.Prefix
+	deny       []netip.Prefix
+	restricted bool // allow entries configured, even invalid ones, nothing allowed if none matched
+}
+
+// IPPolicies evaluates named IP policies, deny entries first, then allow entries if any.
+// policies from config (auth.ipPolicies) merged with IPRule rows, reloaded every auth.ipReload if set.
+type IPPolicies struct {
+	Db       *gorm.DB
+	logger   *zap.Logger
+	audit    *zap.Logger
+	Settings map[string]IPPolicySettings
+	lock     sync.RWMutex
+	policies map[string]*ipPolicy
+}
+
+func init() {
+	orm.AppendEntity(&IPRule{})
+	core.GetContainer().Provide(NewIPPolicies)
+}
+
+func NewIPPolicies(ap AuthServiceParam) *IPPolicies {
+	ips := &IPPolicies{
+		Db:       ap.DB,
+		logger:   ap.Logger,
+		audit:    ap.Logger.Named("ip-audit"),
+		Settings: map[string]IPPolicySettings{},
+	}
+	viper.UnmarshalKey("auth.ipPolicies", &ips.Settings)
+	if err := ips.Reload(); err != nil {
+		ap.Logger.Error("load ip rules failed.", zap.Error(err))
+	}
+	if interval := viper.GetDuration("auth.ipReload"); interval > 0 && ips.Db != nil {
+		ticker := time.NewTicker(interval)
+		stop := make(chan struct{})
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := ips.Reload(); err != nil {
+						ips.logger.Error("reload ip rules failed.", zap.Error(err))
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+		core.OnServiceStopping(func() {
+			close(stop)
+		})
+	}
+	return ips
+}
+
+// ParsePrefix parses IP or CIDR, single IP as /32 or /128, IPv4-mapped IPv6 unmapped.
+func ParsePrefix(item string) (netip.Prefix, error) {
+	item = strings.TrimSpace(item)
+	if strings.Contains(item, "/") {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return prefix, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(item)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (p *ipPolicy) add(logger *zap.Logger, action, item string) {
+	if action == IPAllow {
+		p.restricted = true
+	}
+	prefix, err := ParsePrefix(item)
+	if err != nil {
+		logger.Error("invalid ip rule ignored", zap.String("action", action), zap.String("cidr", item), zap.Error(err))
+		return
+	}
+	switch action {
+	case IPAllow:
+		p.allow = append(p.allow, prefix)
+	case IPDeny:
+		p.deny = append(p.deny, prefix)
+	default:
+		logger.Error("unknown ip rule action", zap.String("action", action))
+	}
+}
+
+func (p *ipPolicy) check(ip string) (bool, string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, "invalid ip"
+	}
+	addr = addr.Unmap()
+	for _, item := range p.deny {
+		if item.Contains(addr) {
+			return false, "denied by " + item.String()
+		}
+	}
+	if !p.restricted {
+		return true, ""
+	}
+	for _, item := range p.allow {
+		if item.Contains(addr) {
+			return true, ""
+		}
+	}
+	return false, "not in allow list"
+}
+
+// Reload rebuilds policies from settings & DB rules.
+func (ips *IPPolicies) Reload() error {
+	policies := map[string]*ipPolicy{}
+	get := func(name string) *ipPolicy {
+		name = strings.ToLower(name)
+		p, ok := policies[name]
+		if !ok {
+			p = &ipPolicy{}
+			policies[name] = p
+		}
+		return p
+	}
+	for name, item := range ips.Settings {
+		p := get(name)
+		for _, cidr := range item.Allow {
+			p.add(ips.logger, IPAllow, cidr)
+		}
+		for _, cidr := range item.Deny {
+			p.add(ips.logger, IPDeny, cidr)
+		}
+	}
+	total := 0
+	if ips.Db != nil {
+		rules := make([]IPRule, 0)
+		if err := ips.Db.Find(&rules).Error; err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			get(rule.Policy).add(ips.logger, strings.ToLower(rule.Action), rule.CIDR)
+		}
+		total = len(rules)
+	}
+	ips.lock.Lock()
+	ips.policies = policies
+	ips.lock.Unlock()
+	ips.logger.Info("ip policies loaded", zap.Int("policies", len(policies)), zap.Int("rules", total))
+	return nil
+}
+
+// Check evaluates ip against the policy, unknown policy allows all.
+func (ips *IPPolicies) Check(policy, ip string) (bool, string) {
+	ips.lock.RLock()
+	p, ok := ips.policies[strings.ToLower(policy)]
+	ips.lock.RUnlock()
+	if !ok {
+		return true, ""
+	}
+	return p.check(ip)
+}
+
+// Middleware blocks requests not allowed by the policy, blocked requests logged to ip-audit logger.
+func (ips *IPPolicies) Middleware(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if ok, reason := ips.Check(policy, ip); !ok {
+			ips.audit.Warn("request blocked",
+				zap.String("policy", policy),
+				zap.String("ip", ip),
+				zap.String("remote", c.Request.RemoteAddr),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String("user", c.GetString("user")),
+				zap.String("reason", reason),
+			)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Access denied",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// IPPolicy returns middleware of the named policy from the DI provided IPPolicies.
+func IPPolicy(policy string) gin.HandlerFunc {
+	return core.GetService[*IPPolicies]().Middleware(policy)
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestIPPolicies(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&IPRule{}))
+	ips := &IPPolicies{
+		Db:     db,
+		logger: zap.NewNop(),
+		audit:  zap.NewNop(),
+		Settings: map[string]IPPolicySettings{
+			"admin":  {Allow: []string{"10.0.0.0/8", "fd00::/8", "bad-cidr"}, Deny: []string{"10.0.0.66"}},
+			"broken": {Allow: []string{"300.1.1.1/8"}},
+		},
+	}
+	assert.NoError(t, ips.Reload())
+
+	check := func(policy, ip string) bool {
+		ok, _ := ips.Check(policy, ip)
+		return ok
+	}
+	assert.True(t, check("admin", "10.1.2.3"))
+	assert.True(t, check("Admin", "::ffff:10.1.2.3"), "IPv4-mapped")
+	assert.True(t, check("admin", "fd00::1"))
+	assert.False(t, check("admin", "10.0.0.66"), "deny first")
+	assert.False(t, check("admin", "192.168.1.1"))
+	assert.False(t, check("admin", "2001:db8::1"))
+	assert.False(t, check("broken", "10.1.1.1"), "invalid allow list blocks all")
+	assert.True(t, check("unknown", "1.2.3.4"))
+
+	assert.NoError(t, db.Create(&IPRule{Policy: "admin", Action: IPAllow, CIDR: "192.168.1.0/24"}).Error)
+	assert.NoError(t, db.Create(&IPRule{Policy: "admin", Action: IPDeny, CIDR: "10.9.0.0/16"}).Error)
+	assert.NoError(t, ips.Reload())
+	assert.True(t, check("admin", "192.168.1.1"))
+	assert.False(t, check("admin", "10.9.1.1"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	assert.NoError(t, r.SetTrustedProxies(nil))
+	r.GET("/admin", ips.Middleware("admin"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	call := func(remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = remote
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, call("10.1.1.1:1234", ""))
+	assert.Equal(t, http.StatusForbidden, call("8.8.8.8:1234", "10.1.1.1"), "untrusted proxy can't spoof")
+
+	assert.NoError(t, r.SetTrustedProxies([]string{"172.16.0.0/12"}))
+	assert.Equal(t, http.StatusOK, call("172.16.0.1:1234", "10.1.1.1"))
+	assert.Equal(t, http.StatusForbidden, call("172.16.0.1:1234", "8.8.8.8"))
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/keycloak"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	KeyRole   = "role"  // roles of the API key, comma separated
+	KeyRoles  = "roles" // resolved roles, []string
+	RoleAdmin = "admin"
+)
+
+// RolePermission grants permission to role, owner not empty means the override for the owner.
+type RolePermission struct {
+	gorm.Model
+	Owner      string `gorm:"size:255;index"`
+	Role       string `gorm:"size:255;index"`
+	Permission string `gorm:"size:255"`
+}
+
+// Authorizer checks permissions of roles, roles defined in config (auth.roles) and DB.
+// owner level definitions (auth.owners or RolePermission with owner) replace the global role.
+type Authorizer struct {
+	Db     *gorm.DB
+	logger *zap.Logger
+	Roles  map[string][]string            // role -> permissions
+	Owners map[string]map[string][]string // owner -> role -> permissions
+	lock   sync.RWMutex
+	loaded map[string]map[string][]string // permissions from DB, owner("" for global) -> role -> permissions
+}
+
+func init() {
+	orm.AppendEntity(&RolePermission{})
+	core.GetContainer().Provide(NewAuthorizer)
+}
+
+func NewAuthorizer(ap AuthServiceParam) *Authorizer {
+	authz := &Authorizer{
+		Db:     ap.DB,
+		logger: ap.Logger,
+		Roles: map[string][]string{
+			RoleAdmin: {"*"},
+		},
+		Owners: map[string]map[string][]string{},
+	}
+	if sub := viper.Sub("auth"); sub != nil {
+		sub.UnmarshalKey("roles", &authz.Roles)
+		sub.UnmarshalKey("owners", &authz.Owners)
+	}
+	if err := authz.Reload(); err != nil {
+		ap.Logger.Error("load role permissions failed.", zap.Error(err))
+	}
+	return authz
+}
+
+// Reload loads role permissions from DB.
+func (authz *Authorizer) Reload() error {
+	if authz.Db == nil {
+		return nil
+	}
+	items := make([]RolePermission, 0)
+	if err := authz.Db.Find(&items).Error; err != nil {
+		return err
+	}
+	loaded := map[string]map[string][]string{}
+	for _, item := range items {
+		owner, role := strings.ToLower(item.Owner), strings.ToLower(item.Role)
+		roles, ok := loaded[owner]
+		if !ok {
+			roles = map[string][]string{}
+			loaded[owner] = roles
+		}
+		roles[role] = append(roles[role], item.Permission)
+	}
+	authz.lock.Lock()
+	authz.loaded = loaded
+	authz.lock.Unlock()
+	authz.logger.Info("role permissions loaded", zap.Int("total", len(items)))
+	return nil
+}
+
+// Permissions returns permissions of the roles for owner, roles & owners are case insensitive.
+func (authz *Authorizer) Permissions(owner string, roles ...string) []string {
+	authz.lock.RLock()
+	defer authz.lock.RUnlock()
+	owner = strings.ToLower(owner)
+	result := make([]string, 0)
+	for _, role := range roles {
+		role = strings.ToLower(role)
+		switch {
+		case owner != "" && authz.loaded[owner][role] != nil:
+			result = append(result, authz.loaded[owner][role]...)
+		case owner != "" && authz.Owners[owner][role] != nil:
+			result = append(result, authz.Owners[owner][role]...)
+		default:
+			result = append(result, authz.Roles[role]...)
+			result = append(result, authz.loaded[""][role]...)
+		}
+	}
+	return result
+}
+
+// Allowed checks if any of roles granted the permission.
+func (authz *Authorizer) Allowed(owner string, roles []string, permission string) bool {
+	for _, granted := range authz.Permissions(owner, roles...) {
+		if MatchPermission(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPermission checks granted permission against required one, segments split by ":".
+// "*" segment matches any one segment, trailing "*" matches the rest,
+// e.g. "orders:*" grants "orders:write", "*:read" grants "orders:read", "*" grants all.
+func MatchPermission(granted, required string) bool {
+	g := strings.Split(granted, ":")
+	r := strings.Split(required, ":")
+	for i, item := range g {
+		if item == "*" && i == len(g)-1 {
+			return true
+		}
+		if i >= len(r) || (item != "*" && item != r[i]) {
+			return false
+		}
+	}
+	return len(g) == len(r)
+}
+
+// RolesOf returns roles of current user, from API key role or keycloak realm roles.
+func RolesOf(c *gin.Context) []string {
+	if v, ok := c.Get(KeyRoles); ok {
+		if roles, ok := v.([]string); ok {
+			return roles
+		}
+	}
+	roles := splitList(c.GetString(KeyRole))
+	if tk, ok := c.Get("token"); ok {
+		if token, ok := tk.(ginkeycloak.KeyCloakToken); ok {
+			if kc := core.GetService[*keycloak.KeycloakConfig](); kc != nil {
+				roles = append(roles, kc.Roles(token)...)
+			} else {
+				roles = append(roles, token.RealmAccess.Roles...)
+			}
+		}
+	}
+	c.Set(KeyRoles, roles)
+	return roles
+}
+
+var (
+	authzOnce sync.Once
+	authz     *Authorizer
+)
+
+func getAuthorizer() *Authorizer {
+	authzOnce.Do(func() {
+		authz = core.GetService[*Authorizer]()
+	})
+	return authz
+}
+
+// Require checks current user has all the permissions, use it after auth middleware (API key or keycloak).
+func Require(permissions ...string) gin.HandlerFunc {
+	return require(permissions, true)
+}
+
+// RequireAny checks current user has any of the permissions.
+func RequireAny(permissions ...string) gin.HandlerFunc {
+	return require(permissions, false)
+}
+
+func require(permissions []string, all bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles := RolesOf(c)
+		if len(roles) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginshared.GeneralResp{
+				ErrorCode:    "AuthFailed",
+				ErrorMessage: "no role for current user",
+			})
+			return
+		}
+		owner := c.GetString("owner")
+		scopes := c.GetStringSlice(KeyScopes)
+		authz := getAuthorizer()
+		granted := all
+		for _, item := range permissions {
+			ok := authz.Allowed(owner, roles, item) && inScope(scopes, item)
+			if all && !ok {
+				granted = false
+				break
+			}
+			if !all && ok {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			authz.logger.Warn("permission denied", zap.String("user", c.GetString("user")),
+				zap.Strings("roles", roles), zap.Strings("required", permissions))
+			c.AbortWithStatusJSON(http.StatusForbidden, ginshared.GeneralResp{
+				ErrorCode:    "Forbidden",
+				ErrorMessage: "permission denied, " + strings.Join(permissions, ","),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// inScope checks permission against scopes of the API key, empty scopes means not restricted.
+func inScope(scopes []string, permission string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, item := range scopes {
+		if MatchPermission(item, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantRole adds role to the API key of username.
+func (a *AuthService) GrantRole(username, role string) error {
+	return a.updateRoles(username, func(roles []string) []string {
+		for _, item := range roles {
+			if strings.EqualFold(item, role) {
+				return roles
+			}
+		}
+		return append(roles, role)
+	})
+}
+
+// RevokeRole removes role from the API key of username.
+func (a *AuthService) RevokeRole(username, role string) error {
+	return a.updateRoles(username, func(roles []string) []string {
+		result := make([]string, 0, len(roles))
+		for _, item := range roles {
+			if !strings.EqualFold(item, role) {
+				result = append(result, item)
+			}
+		}
+		return result
+	})
+}
+
+// updateRoles updates roles of all keys of username in a transaction, one statement per resulting roles.
+func (a *AuthService) updateRoles(username string, fn func(roles []string) []string) error {
+	keys := make([]*AuthKey, 0)
+	err := a.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_name = ?", username).Find(&keys).Error; err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("API key of %s is not found, %w", username, gorm.ErrRecordNotFound)
+		}
+		ids := make(map[string][]uint)
+		for _, key := range keys {
+			role := strings.Join(fn(splitList(key.Role)), ",")
+			if len(role) > maxRoleLen {
+				return fmt.Errorf("roles of %s longer than %d", username, maxRoleLen)
+			}
+			ids[role] = append(ids[role], key.ID)
+		}
+		for role, items := range ids {
+			if err := tx.Model(&AuthKey{}).Where("id in ?", items).Update("role", role).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		a.invalidate(key)
+	}
+	a.logger.Info("roles updated", zap.String("user", username), zap.Int("keys", len(keys)))
+	return nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMatchPermission(t *testing.T) {
+	assert.True(t, MatchPermission("*", "orders:write"))
+	assert.True(t, MatchPermission("orders:*", "orders:write"))
+	assert.True(t, MatchPermission("orders:*", "orders:items:write"))
+	assert.True(t, MatchPermission("*:read", "orders:read"))
+	assert.True(t, MatchPermission("orders:write", "orders:write"))
+	assert.False(t, MatchPermission("orders:read", "orders:write"))
+	assert.False(t, MatchPermission("*:read", "orders:items:read"))
+	assert.False(t, MatchPermission("orders", "orders:write"))
+}
+
+func TestRequire(t *testing.T) {
+	authzOnce.Do(func() {})
+	authz = &Authorizer{
+		logger: zap.NewNop(),
+		Roles: map[string][]string{
+			"admin":    {"*"},
+			"operator": {"orders:*", "tasks:read"},
+		},
+		Owners: map[string]map[string][]string{
+			"acme": {"operator": {"orders:read"}},
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(KeyRole, c.GetHeader("role"))
+		c.Set("owner", c.GetHeader("owner"))
+	})
+	r.POST("/orders", Require("orders:write"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/tasks", RequireAny("tasks:write", "tasks:read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	call := func(method, uri, role, owner string) int {
+		req := httptest.NewRequest(method, uri, nil)
+		req.Header.Set("role", role)
+		req.Header.Set("owner", owner)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/orders", "", ""))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/orders", "admin", ""))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/orders", "viewer,Operator", "other"))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/orders", "operator", "ACME"))
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/tasks", "operator", ""))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/tasks", "viewer", ""))
+}
+
+func TestUpdateRolesAllKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&AuthKey{}))
+	service := &AuthService{Db: db, logger: zap.NewNop(), Lifecycle: DefaultKeyLifecycle()}
+	for _, role := range []string{"operator", "operator,viewer"} {
+		_, _, err := service.CreateKey(&KeyRequest{UserName: "u1", Role: role})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, service.GrantRole("u1", "auditor"))
+	assert.NoError(t, service.RevokeRole("u1", "operator"))
+	roles := make([]string, 0)
+	assert.NoError(t, db.Model(&AuthKey{}).Order("id").Pluck("role", &roles).Error)
+	assert.Equal(t, []string{"auditor", "viewer,auditor"}, roles)
+	assert.Error(t, service.GrantRole("nobody", "auditor"))
+}
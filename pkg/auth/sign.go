@@ -1,25 +1,39 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/core"
 	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
 	"go.uber.org/zap"
 )
 
 func init() {
+	openapi.RegisterAuth(openapi.SchemeSign, (*SignService)(nil).Verify)
 	ginshared.GetContainer().Provide(func(logging *zap.Logger) (*SignService, error) {
 		ss := &SignService{
-			logger:       logging,
-			KeyTimestamp: "timestamp",
-			KeySign:      "sign",
-			KeyApp:       "app",
-			MaxDuration:  30 * time.Minute,
+			logger:        logging,
+			KeyTimestamp:  "timestamp",
+			KeySign:       "sign",
+			KeyApp:        "app",
+			KeyNonce:      "nonce",
+			KeyVersion:    "sign-version",
+			MaxDuration:   30 * time.Minute,
+			MaxSkew:       5 * time.Minute,
+			SignedHeaders: []string{"Content-Type"},
+			AllowV1:       true,
 		}
 		settings := viper.Sub("auth.sign")
 
@@ -40,15 +54,34 @@ type SignService struct {
 	KeySign      string
 	KeyApp       string
 	Secrets      map[string]string
+
+	// v2, HMAC-SHA256 over canonical request
+	KeyNonce      string
+	KeyVersion    string              // header of sign version, "2" for v2, v1 if missed
+	MaxSkew       time.Duration       // v2 timestamp allowed skew, nonce remembered for the window
+	SignedHeaders []string            // headers included in canonical request, same as client
+	AppSecrets    map[string][]string // app -> secrets, more than one for rotation, Secrets accepted as well
+	AllowV1       bool                // accept v1 (MD5) requests
+
+	nonces    cache.HashNX
+	local     *cache.CacheRam[bool]
+	nonceOnce sync.Once
 }
 
 func (ss *SignService) CheckMaxDuration(c *gin.Context) {
+	if ss.checkTimestamp(c) {
+		c.Next()
+	}
+}
+
+// checkTimestamp aborts the request if timestamp missed or out of MaxDuration, false if aborted.
+func (ss *SignService) checkTimestamp(c *gin.Context) bool {
 	reqTime := c.GetHeader(ss.KeyTimestamp)
 	if reqTime == "" {
 		ss.logger.Warn("header timestamp is missed. request rejected.")
 		c.JSON(http.StatusBadRequest, fmt.Sprintf("header %s is missed", ss.KeyTimestamp))
 		c.Abort()
-		return
+		return false
 	}
 
 	mm, _ := strconv.ParseInt(reqTime, 10, 64)
@@ -64,16 +97,21 @@ func (ss *SignService) CheckMaxDuration(c *gin.Context) {
 			zap.String("headerValue", reqTime))
 		c.JSON(http.StatusBadRequest, fmt.Sprintf("请求已超过最大允许值(%s), 实时差异 %s", ss.MaxDuration, duration))
 		c.Abort()
-		return
+		return false
 	}
 
 	ss.logger.Debug("check timestamp done", zap.Duration("duration", duration))
-
-	c.Next()
-
+	return true
 }
 
 func (ss *SignService) Sign(c *gin.Context) {
+	if ss.verifyV1(c) {
+		c.Next()
+	}
+}
+
+// verifyV1 aborts the request if v1 signature mismatched, false if aborted.
+func (ss *SignService) verifyV1(c *gin.Context) bool {
 	// buf := bytes.Buffer{}
 
 	appID := c.GetHeader(ss.KeyApp)
@@ -85,7 +123,7 @@ func (ss *SignService) Sign(c *gin.Context) {
 		ss.logger.Error("invalid appID", zap.String("reqID", appID))
 		c.JSON(http.StatusUnauthorized, fmt.Sprintf("非法%s %s", ss.KeyApp, appID))
 		c.Abort()
-		return
+		return false
 	}
 	// buf.WriteString("&secret=")
 	// buf.WriteString(secret)
@@ -100,17 +138,138 @@ func (ss *SignService) Sign(c *gin.Context) {
 		ss.logger.Error("signed failed", zap.Error(err))
 		c.JSON(http.StatusUnauthorized, "验证签名失败")
 		c.Abort()
-		return
+		return false
 	}
 
 	reqSigned := c.GetHeader(ss.KeySign)
-	if signed != reqSigned {
+	if subtle.ConstantTimeCompare([]byte(signed), []byte(reqSigned)) != 1 {
 		ss.logger.Error("sign validation failed.", zap.String("req", reqSigned), zap.String("signed", signed))
 		c.JSON(http.StatusUnauthorized, "验证签名失败")
 		c.Abort()
-		return
+		return false
 	}
 	ss.logger.Debug("signed check passed.", zap.String("signed", signed))
+	return true
+}
 
+// Verify checks v2 signed request, v1 (timestamp & MD5 sign) accepted if AllowV1.
+func (ss *SignService) Verify(c *gin.Context) {
+	if c.GetHeader(ss.KeyVersion) != SignVersion2 {
+		if !ss.AllowV1 {
+			ss.logger.Warn("v1 sign request rejected", zap.String("app", c.GetHeader(ss.KeyApp)))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, fmt.Sprintf("%s %s is required", ss.KeyVersion, SignVersion2))
+			return
+		}
+		if !ss.checkTimestamp(c) || !ss.verifyV1(c) {
+			return
+		}
+		c.Set("app", c.GetHeader(ss.KeyApp))
+		c.Next()
+		return
+	}
+	if err := ss.verifyV2(c); err != nil {
+		ss.logger.Error("sign v2 validation failed.", zap.String("app", c.GetHeader(ss.KeyApp)),
+			zap.String("path", c.Request.URL.Path), zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, "验证签名失败, "+err.Error())
+		return
+	}
+	c.Set("app", c.GetHeader(ss.KeyApp))
 	c.Next()
 }
+
+func (ss *SignService) secretsOf(app string) []string {
+	secrets, ok := ss.AppSecrets[app]
+	if !ok {
+		secrets = ss.AppSecrets[strings.ToLower(app)] // viper lower cases map keys
+	}
+	secrets = append([]string{}, secrets...)
+	if secret, ok := ss.Secrets[app]; ok {
+		secrets = append(secrets, secret)
+	} else if secret, ok := ss.Secrets[strings.ToLower(app)]; ok {
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+func (ss *SignService) verifyV2(c *gin.Context) error {
+	app := c.GetHeader(ss.KeyApp)
+	ts := c.GetHeader(ss.KeyTimestamp)
+	nonce := c.GetHeader(ss.KeyNonce)
+	reqSigned := c.GetHeader(ss.KeySign)
+	if app == "" || ts == "" || nonce == "" || reqSigned == "" {
+		return fmt.Errorf("headers %s, %s, %s & %s are required", ss.KeyApp, ss.KeyTimestamp, ss.KeyNonce, ss.KeySign)
+	}
+	secrets := ss.secretsOf(app)
+	if len(secrets) == 0 {
+		return fmt.Errorf("invalid %s %s", ss.KeyApp, app)
+	}
+	mm, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %s", ss.KeyTimestamp, ts)
+	}
+	reqTime := time.UnixMilli(mm)
+	if skew := time.Since(reqTime).Abs(); skew > ss.MaxSkew {
+		return fmt.Errorf("%s out of allowed skew %s", ss.KeyTimestamp, ss.MaxSkew)
+	}
+
+	canonical := CanonicalRequest(c.Request.Method, c.Request.URL.EscapedPath(), c.Request.URL.Query(),
+		c.Request.Header, ss.SignedHeaders, app, ts, nonce, ginshared.CloneRequestBody(c))
+	matched := false
+	for _, secret := range secrets {
+		if hmac.Equal([]byte(SignV2(secret, canonical)), []byte(reqSigned)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("signature mismatched")
+	}
+	// nonce checked after signature, unsigned requests can't burn nonces
+	replayed, err := ss.replayed(c.Request.Context(), app, nonce, reqTime)
+	if err != nil {
+		return err
+	}
+	if replayed {
+		return fmt.Errorf("%s %s replayed", ss.KeyNonce, nonce)
+	}
+	return nil
+}
+
+func (ss *SignService) initNonces() {
+	ss.nonceOnce.Do(func() {
+		if ss.nonces == nil {
+			func() {
+				// redis client panics if not connected, fallback to local cache
+				defer func() {
+					if r := recover(); r != nil {
+						ss.logger.Error("resolve cache.Hash failed.", zap.Any("error", r))
+					}
+				}()
+				core.GetContainer().Invoke(func(p core.OptionalParam[cache.Hash]) {
+					if nx, ok := p.P.(cache.HashNX); ok {
+						ss.nonces = nx
+					}
+				})
+			}()
+		}
+		if ss.nonces == nil {
+			ss.logger.Warn("cache.Hash with SetIfAbsent is not available, sign nonces checked by local cache only")
+			ss.local = cache.NewRAMCacheProvider[bool](2 * ss.MaxSkew)
+		}
+	})
+}
+
+// replayed remembers nonce of app atomically, nonces grouped by bucket of the request timestamp, bucket expires after the skew window.
+func (ss *SignService) replayed(ctx context.Context, app, nonce string, reqTime time.Time) (bool, error) {
+	ss.initNonces()
+	field := app + ":" + nonce
+	if ss.local != nil {
+		return !ss.local.SetIfAbsent(field, true), nil
+	}
+	bucket := fmt.Sprintf("sign:nonce:%d", reqTime.UnixMilli()/ss.MaxSkew.Milliseconds())
+	set, err := ss.nonces.SetIfAbsent(ctx, bucket, field, "1", 3*ss.MaxSkew)
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"go.uber.org/zap"
+)
+
+// replayTransport records the signed request for replaying
+type replayTransport struct {
+	last *http.Request
+	body string
+}
+
+func (rt *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.last = req.Clone(req.Context())
+	raw, _ := io.ReadAll(req.Body)
+	rt.body = string(raw)
+	req.Body = io.NopCloser(strings.NewReader(rt.body))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSignV2(t *testing.T) {
+	ss := &SignService{
+		logger:        zap.NewNop(),
+		KeyTimestamp:  "timestamp",
+		KeySign:       "sign",
+		KeyApp:        "app",
+		KeyNonce:      "nonce",
+		KeyVersion:    "sign-version",
+		MaxDuration:   time.Minute,
+		MaxSkew:       time.Minute,
+		SignedHeaders: []string{"Content-Type"},
+		AppSecrets:    map[string][]string{"app1": {"new-secret", "old-secret"}},
+		Secrets:       map[string]string{"app1": "v1-secret"},
+	}
+	ss.nonceOnce.Do(func() {
+		ss.local = cache.NewRAMCacheProvider[bool](time.Minute)
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ss.Verify)
+	reached := 0
+	r.POST("/orders", func(c *gin.Context) {
+		reached++
+		c.String(http.StatusOK, c.GetString("app"))
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	post := func(client *http.Client, uri string) int {
+		resp, err := client.Post(server.URL+uri, "application/json", strings.NewReader(`{"a":1}`))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	rt := &replayTransport{}
+	client := NewSignTransport("app1", "old-secret")
+	client.Base = rt
+	assert.Equal(t, http.StatusOK, post(client.Client(), "/orders?b=2&a=1"))
+
+	// replay the same signed request
+	replay := rt.last.Clone(rt.last.Context())
+	replay.Body = io.NopCloser(strings.NewReader(rt.body))
+	resp, err := http.DefaultTransport.RoundTrip(replay)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// tampered query
+	tampered := rt.last.Clone(rt.last.Context())
+	tampered.URL.RawQuery = "a=1&b=3"
+	tampered.Header.Set("nonce", "other")
+	tampered.Body = io.NopCloser(strings.NewReader(rt.body))
+	resp, err = http.DefaultTransport.RoundTrip(tampered)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	assert.Equal(t, http.StatusUnauthorized, post(NewSignTransport("app1", "wrong").Client(), "/orders"))
+
+	// v1 behind the flag
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	signed, _ := SignRequest("app1", ts, "v1-secret", []byte(`{"a":1}`))
+	v1 := func(signed string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/orders", strings.NewReader(`{"a":1}`))
+		req.Header.Set("app", "app1")
+		req.Header.Set("timestamp", ts)
+		req.Header.Set("sign", signed)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, v1(signed))
+	ss.AllowV1 = true
+	reached = 0
+	assert.Equal(t, http.StatusUnauthorized, v1("garbage"))
+	assert.Equal(t, 0, reached, "handler not reached by wrong v1 signature")
+	assert.Equal(t, http.StatusOK, v1(signed))
+	assert.Equal(t, 1, reached)
+}
+
+func TestSignNonceConcurrentReplay(t *testing.T) {
+	ss := &SignService{logger: zap.NewNop(), MaxSkew: time.Minute}
+	ss.nonceOnce.Do(func() {
+		ss.local = cache.NewRAMCacheProvider[bool](time.Minute)
+	})
+	now := time.Now()
+	accepted := atomic.Int32{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replayed, err := ss.replayed(context.Background(), "app1", "n1", now)
+			assert.NoError(t, err)
+			if !replayed {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), accepted.Load(), "only one of concurrent copies accepted")
+}
@@ -2,14 +2,29 @@ package auth
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/thanhpk/randstr"
 )
 
+const SignVersion2 = "2"
+
 // type SignedRequest struct {
 
 // }
 
+// SignRequest signs by v1, MD5 of app, secret, timestamp & body.
+// Deprecated: v1 doesn't sign method/path/query and allows replays, use SignV2.
 func SignRequest(app, ts, secret string, request []byte) (string, error) {
 	buf := bytes.Buffer{}
 	buf.WriteString("app=")
@@ -25,3 +40,106 @@ func SignRequest(app, ts, secret string, request []byte) (string, error) {
 
 	return signed, nil
 }
+
+// CanonicalRequest builds the v2 canonical request, lines of
+// method, escaped path, sorted query, signed headers (lower case name:trimmed value, in order),
+// app, timestamp, nonce and hex sha256 of body.
+func CanonicalRequest(method, path string, query url.Values, header http.Header, signedHeaders []string,
+	app, ts, nonce string, body []byte) string {
+	sorted := url.Values{}
+	for k, v := range query {
+		values := append([]string{}, v...)
+		sort.Strings(values)
+		sorted[k] = values
+	}
+	headers := make([]string, 0, len(signedHeaders))
+	for _, item := range signedHeaders {
+		headers = append(headers, strings.ToLower(item)+":"+strings.TrimSpace(header.Get(item)))
+	}
+	bodyHash := sha256.Sum256(body)
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		sorted.Encode(),
+		strings.Join(headers, "\n"),
+		app,
+		ts,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// SignV2 returns hex HMAC-SHA256 of the canonical request.
+func SignV2(secret, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignTransport signs outbound requests by v2, headers & signed headers must match the server SignService.
+type SignTransport struct {
+	App           string
+	Secret        string
+	SignedHeaders []string
+	KeyApp        string
+	KeyTimestamp  string
+	KeyNonce      string
+	KeySign       string
+	KeyVersion    string
+	Base          http.RoundTripper // http.DefaultTransport if nil
+}
+
+func NewSignTransport(app, secret string) *SignTransport {
+	return &SignTransport{
+		App:           app,
+		Secret:        secret,
+		SignedHeaders: []string{"Content-Type"},
+		KeyApp:        "app",
+		KeyTimestamp:  "timestamp",
+		KeyNonce:      "nonce",
+		KeySign:       "sign",
+		KeyVersion:    "sign-version",
+	}
+}
+
+// Client returns http client signs all requests.
+func (st *SignTransport) Client() *http.Client {
+	return &http.Client{Transport: st}
+}
+
+func (st *SignTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil && req.Body != http.NoBody {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = raw
+	}
+	signed := req.Clone(req.Context())
+	if len(body) > 0 {
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := randstr.Hex(16)
+	signed.Header.Set(st.KeyApp, st.App)
+	signed.Header.Set(st.KeyTimestamp, ts)
+	signed.Header.Set(st.KeyNonce, nonce)
+	signed.Header.Set(st.KeyVersion, SignVersion2)
+	canonical := CanonicalRequest(signed.Method, signed.URL.EscapedPath(), signed.URL.Query(), signed.Header,
+		st.SignedHeaders, st.App, ts, nonce, body)
+	signed.Header.Set(st.KeySign, SignV2(st.Secret, canonical))
+
+	base := st.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
@@ -23,6 +23,28 @@ type LocalRamHash struct {
 	ram sync.Map
 }
 
+// expiring value set by SetIfAbsent with ttl, absent after expiresAt.
+type expiring struct {
+	value     any
+	expiresAt time.Time
+}
+
+// load returns value of the key, expired values treated as absent.
+func (lhash *LocalRamHash) load(key string) (any, bool) {
+	vv, found := lhash.ram.Load(key)
+	if !found {
+		return nil, false
+	}
+	if item, ok := vv.(*expiring); ok {
+		if time.Now().After(item.expiresAt) {
+			lhash.ram.CompareAndDelete(key, vv)
+			return nil, false
+		}
+		return item.value, true
+	}
+	return vv, true
+}
+
 func (lhash *LocalRamHash) Existed(ctx context.Context, key string) (bool, error) {
 	return true, nil
 }
@@ -33,7 +55,7 @@ func (lhash *LocalRamHash) SetTTL(ctx context.Context, key string, ttl time.Dura
 func (lhash *LocalRamHash) GetValues(ctx context.Context, key string, fields ...string) ([]any, error) {
 	result := make([]any, len(fields))
 	for index, item := range fields {
-		if vv, found := lhash.ram.Load(key + item); found {
+		if vv, found := lhash.load(key + item); found {
 			result[index] = vv
 		}
 	}
@@ -46,11 +68,38 @@ func (lhash *LocalRamHash) SetValues(ctx context.Context, key string, values map
 	return nil
 }
 
+// SetIfAbsent sets field atomically if absent or expired, ttl > 0 expires the value like CacheRam.SetIfAbsent.
+func (lhash *LocalRamHash) SetIfAbsent(ctx context.Context, key, field string, value any, ttl time.Duration) (bool, error) {
+	var stored any = value
+	if ttl > 0 {
+		stored = &expiring{value: value, expiresAt: time.Now().Add(ttl)}
+	}
+	for {
+		existed, loaded := lhash.ram.LoadOrStore(key+field, stored)
+		if !loaded {
+			return true, nil
+		}
+		item, ok := existed.(*expiring)
+		if !ok || !time.Now().After(item.expiresAt) {
+			return false, nil
+		}
+		if lhash.ram.CompareAndSwap(key+field, existed, stored) {
+			return true, nil
+		}
+	}
+}
+
 func (lhash *LocalRamHash) GetAll(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 	lhash.ram.Range(func(k, v any) bool {
 		kStr, ok := k.(string)
 		if ok && len(kStr) > len(key) && kStr[:len(key)] == key {
+			if item, expirable := v.(*expiring); expirable {
+				if time.Now().After(item.expiresAt) {
+					return true
+				}
+				v = item.value
+			}
 			field := kStr[len(key):]
 			if vStr, ok := v.(string); ok {
 				result[field] = vStr
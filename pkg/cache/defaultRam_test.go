@@ -0,0 +1,34 @@
+//go:build ram
+
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+)
+
+func TestLocalRamHashSetIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	hash := &cache.LocalRamHash{}
+
+	ok, err := hash.SetIfAbsent(ctx, "lock:", "job", "node1", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = hash.SetIfAbsent(ctx, "lock:", "job", "node2", 50*time.Millisecond)
+	assert.False(t, ok, "held before ttl")
+
+	values, _ := hash.GetValues(ctx, "lock:", "job")
+	assert.Equal(t, "node1", values[0])
+
+	time.Sleep(80 * time.Millisecond)
+	values, _ = hash.GetValues(ctx, "lock:", "job")
+	assert.Nil(t, values[0], "expired")
+	ok, _ = hash.SetIfAbsent(ctx, "lock:", "job", "node2", 0)
+	assert.True(t, ok, "expired value is absent")
+	ok, _ = hash.SetIfAbsent(ctx, "lock:", "job", "node3", 0)
+	assert.False(t, ok, "no ttl never expires")
+}
@@ -28,6 +28,12 @@ type Hash interface {
 	GetAll(ctx context.Context, key string) (map[string]string, error)
 }
 
+// HashNX is implemented by Hash supporting atomic set-if-absent.
+type HashNX interface {
+	// SetIfAbsent sets field of key if absent, ttl of key refreshed, returns false if field existed.
+	SetIfAbsent(ctx context.Context, key, field string, value any, ttl time.Duration) (bool, error)
+}
+
 type CacheProvider[T any] interface {
 	Set(key string, value T)
 	Get(key string) (T, bool)
@@ -35,6 +41,23 @@ type CacheProvider[T any] interface {
 	Keys() []string
 }
 
+// Taker is implemented by CacheProvider supporting atomic get-and-delete, e.g. one time tokens.
+type Taker[T any] interface {
+	Take(key string) (T, bool)
+}
+
+// Take gets & deletes key, only one caller gets the value if provider is a Taker, Get then Del otherwise.
+func Take[T any](provider CacheProvider[T], key string) (T, bool) {
+	if taker, ok := provider.(Taker[T]); ok {
+		return taker.Take(key)
+	}
+	vv, found := provider.Get(key)
+	if found {
+		provider.Del(key)
+	}
+	return vv, found
+}
+
 // type CacheFactory[T any] interface {
 // 	New(t time.Duration) CacheProvider[T]
 // }
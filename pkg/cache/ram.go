@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -10,7 +11,8 @@ import (
 var DefaultLocalCacheItems = 0 //local cache items. it's important for performance & if redis failed.
 
 type CacheRam[T any] struct {
-	ram cache.Cache
+	ram  cache.Cache
+	take sync.Mutex // one Take at a time
 }
 
 func (cc *CacheRam[T]) Set(key string, value T) {
@@ -25,6 +27,23 @@ func (cc *CacheRam[T]) Get(key string) (T, bool) {
 	var vv T
 	return vv, false
 }
+
+// SetIfAbsent sets key atomically if absent or expired, returns false if existed.
+func (cc *CacheRam[T]) SetIfAbsent(key string, value T) bool {
+	return cc.ram.Add(key, value, cache.DefaultExpiration) == nil
+}
+
+// Take gets & deletes key, concurrent Take of the same key gets it once.
+func (cc *CacheRam[T]) Take(key string) (T, bool) {
+	cc.take.Lock()
+	defer cc.take.Unlock()
+	vv, found := cc.Get(key)
+	if found {
+		cc.ram.Delete(key)
+	}
+	return vv, found
+}
+
 func (cc *CacheRam[T]) Keys() []string {
 	all := cc.ram.Items()
 	return lo.Keys(all)
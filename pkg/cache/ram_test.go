@@ -0,0 +1,33 @@
+package cache_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+)
+
+func TestRamTake(t *testing.T) {
+	rr := cache.NewRAMCacheProvider[string](time.Minute)
+	rr.Set("state", "v1")
+
+	taken := atomic.Int32{}
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := cache.Take[string](rr, "state"); ok {
+				assert.Equal(t, "v1", v)
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), taken.Load(), "one time only")
+	_, ok := rr.Get("state")
+	assert.False(t, ok)
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -310,6 +311,29 @@ func (r *RedisProvider[T]) Keys() []string {
 	}
 	return keys
 }
+
+// Take gets & deletes key by GETDEL, one caller across nodes gets the value.
+func (r *RedisProvider[T]) Take(key string) (T, bool) {
+	var value T
+	if r.cache == nil {
+		zap.L().Warn("redis cache is not functional now, ")
+		return value, false
+	}
+	r.cache.DeleteFromLocalCache(r.prefix + key)
+	raw, err := r.Client.GetDel(context.TODO(), r.prefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			zap.L().Error("take redis cache failed.", zap.Error(err))
+		}
+		return value, false
+	}
+	if err := r.cache.Unmarshal(raw, &value); err != nil {
+		zap.L().Error("read redis cache failed.", zap.Error(err))
+		return value, false
+	}
+	return value, true
+}
+
 func (r *RedisProvider[T]) Del(key string) error {
 	err := r.cache.Delete(context.TODO(), r.prefix+key)
 	if r.legacyPrefix != "" && r.legacyPrefix != r.prefix {
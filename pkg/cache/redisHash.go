@@ -67,6 +67,20 @@ func (rs *RedisHashService) SetValues(ctx context.Context, key string, values ma
 	return nil
 }
 
+func (rs *RedisHashService) SetIfAbsent(ctx context.Context, key, field string, value any, ttl time.Duration) (bool, error) {
+	var set *redis.BoolCmd
+	_, err := rs.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		set = pipe.HSetNX(ctx, key, field, value)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		rs.Logger.Error("failed to set value if absent to redis", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+	return set.Val(), nil
+}
+
 func (rs *RedisHashService) GetAll(ctx context.Context, key string) (map[string]string, error) {
 	resp, err := rs.Client.HGetAll(ctx, key).Result()
 	if err != nil {
@@ -0,0 +1,262 @@
+package export
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	KeyExport     = "export"
+	DefaultFormat = "csv"
+	// HeaderTruncated is sent as trailer "true" if rows beyond MaxRows dropped.
+	HeaderTruncated = "X-Export-Truncated"
+	// HeaderError is sent as trailer with the error if export failed after streaming started.
+	HeaderError = "X-Export-Error"
+)
+
+var (
+	DefaultMaxRows   = 100000
+	ErrUnknownFormat = errors.New("unknown export format")
+)
+
+// Column selects & renames one column of the result set.
+type Column struct {
+	Name  string // column name in result set
+	Title string // output name, default Name
+}
+
+type Settings struct {
+	Filename string   // download file name without extension, default export_20060102150405
+	Columns  []Column // empty means all columns in result set
+	MaxRows  int      // row cap, default export.maxRows or DefaultMaxRows
+	Bom      bool     // write utf-8 BOM for csv, for Excel
+}
+
+// RowWriter writes rows in one format, Close must be called to flush the file.
+type RowWriter interface {
+	WriteRow(values []any) error
+	Close() error
+}
+
+// Format is one registered output format.
+type Format struct {
+	ContentType string
+	Ext         string
+	New         func(w io.Writer, columns []string, settings *Settings) (RowWriter, error)
+}
+
+var (
+	formatLocker sync.RWMutex
+	formats      = map[string]Format{}
+)
+
+func init() {
+	viper.SetDefault("export.maxRows", DefaultMaxRows)
+}
+
+// Register adds output format, e.g. parquet package registers "parquet".
+func Register(name string, format Format) {
+	formatLocker.Lock()
+	defer formatLocker.Unlock()
+	formats[strings.ToLower(name)] = format
+}
+
+func GetFormat(name string) (Format, bool) {
+	formatLocker.RLock()
+	defer formatLocker.RUnlock()
+	f, ok := formats[strings.ToLower(name)]
+	return f, ok
+}
+
+// Requested checks if export param in the request.
+func Requested(c *gin.Context) bool {
+	_, ok := c.GetQuery(KeyExport)
+	return ok
+}
+
+// FormatOf returns format name from export param, empty or "true" means csv.
+func FormatOf(c *gin.Context) string {
+	f := strings.ToLower(strings.TrimSpace(c.Query(KeyExport)))
+	if f == "" || f == "true" || f == "1" {
+		return DefaultFormat
+	}
+	return f
+}
+
+// Query streams the result of tx by DB cursor, e.g. Query(c, db.Model(&Order{}).Where(...), nil)
+// errors are responded as Rows does, returned for logging.
+func Query(c *gin.Context, tx *gorm.DB, settings *Settings) error {
+	rows, err := tx.Rows()
+	if err != nil {
+		return fail(c, http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+	return Rows(c, rows, settings)
+}
+
+// Raw streams the result of raw sql by DB cursor.
+func Raw(c *gin.Context, db *gorm.DB, settings *Settings, sql string, values ...any) error {
+	return Query(c, db.Raw(sql, values...), settings)
+}
+
+// fail responds err with status if nothing streamed yet, otherwise sets trailer HeaderError. returns err.
+func fail(c *gin.Context, status int, err error) error {
+	if !c.Writer.Written() {
+		header := c.Writer.Header()
+		for _, item := range []string{"Content-Disposition", "Trailer", "X-Export-Max-Rows"} {
+			header.Del(item)
+		}
+		c.AbortWithStatusJSON(status, ginshared.UnifiedResp{Error: err.Error()})
+		return err
+	}
+	c.Writer.Header().Set(HeaderError, strings.Join(strings.Fields(err.Error()), " "))
+	c.Abort()
+	return err
+}
+
+// Rows streams the sql rows to client in the requested format.
+// errors before streaming responded 400 (unknown format, bad columns) or 500,
+// after streaming started the file is incomplete and trailer HeaderError is set.
+func Rows(c *gin.Context, rows *sql.Rows, settings *Settings) error {
+	if settings == nil {
+		settings = &Settings{}
+	}
+	formatName := FormatOf(c)
+	format, ok := GetFormat(formatName)
+	if !ok {
+		return fail(c, http.StatusBadRequest, fmt.Errorf("%w: %s", ErrUnknownFormat, formatName))
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fail(c, http.StatusInternalServerError, err)
+	}
+	indexes, titles, err := selectColumns(columns, settings.Columns)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, err)
+	}
+
+	maxRows := settings.MaxRows
+	if maxRows <= 0 {
+		maxRows = viper.GetInt("export.maxRows")
+	}
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+
+	filename := settings.Filename
+	if filename == "" {
+		filename = "export_" + time.Now().Format("20060102150405")
+	}
+	filename = filename + format.Ext
+
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
+		strings.ReplaceAll(filename, `"`, ""), url.PathEscape(filename)))
+	c.Header("X-Export-Max-Rows", fmt.Sprintf("%d", maxRows))
+	c.Header("Trailer", HeaderTruncated+", "+HeaderError)
+	c.Status(http.StatusOK)
+
+	writer, err := format.New(c.Writer, titles, settings)
+	if err != nil {
+		return fail(c, http.StatusInternalServerError, err)
+	}
+
+	logger := zap.L().With(zap.String("format", formatName), zap.String("filename", filename))
+	raw := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range raw {
+		ptrs[i] = &raw[i]
+	}
+	values := make([]any, len(indexes))
+
+	count := 0
+	truncated := false
+	for rows.Next() {
+		if count >= maxRows {
+			truncated = true
+			break
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			writer.Close()
+			return fail(c, http.StatusInternalServerError, err)
+		}
+		for i, index := range indexes {
+			values[i] = normalize(raw[index])
+		}
+		if err := writer.WriteRow(values); err != nil {
+			writer.Close()
+			return fail(c, http.StatusInternalServerError, err)
+		}
+		count++
+		if count%1000 == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writer.Close()
+		return fail(c, http.StatusInternalServerError, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fail(c, http.StatusInternalServerError, err)
+	}
+	if truncated {
+		logger.Warn("export reached max rows, the rest rows dropped.", zap.Int("maxRows", maxRows))
+		c.Writer.Header().Set(HeaderTruncated, "true")
+	}
+	c.Writer.Flush()
+	logger.Info("export done.", zap.Int("rows", count), zap.Bool("truncated", truncated))
+	return nil
+}
+
+func normalize(v any) any {
+	switch vv := v.(type) {
+	case []byte:
+		return string(vv)
+	case sql.RawBytes:
+		return string(vv)
+	}
+	return v
+}
+
+func selectColumns(columns []string, selected []Column) ([]int, []string, error) {
+	if len(selected) == 0 {
+		indexes := make([]int, len(columns))
+		for i := range columns {
+			indexes[i] = i
+		}
+		return indexes, columns, nil
+	}
+	positions := make(map[string]int, len(columns))
+	for i, item := range columns {
+		positions[strings.ToLower(item)] = i
+	}
+	indexes := make([]int, 0, len(selected))
+	titles := make([]string, 0, len(selected))
+	for _, item := range selected {
+		index, ok := positions[strings.ToLower(item.Name)]
+		if !ok {
+			return nil, nil, fmt.Errorf("export column %s not found in result", item.Name)
+		}
+		title := item.Title
+		if title == "" {
+			title = item.Name
+		}
+		indexes = append(indexes, index)
+		titles = append(titles, title)
+	}
+	return indexes, titles, nil
+}
@@ -0,0 +1,100 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type item struct {
+	ID   uint
+	Code string
+	Qty  int
+}
+
+func setup(t *testing.T, settings *Settings) *gin.Engine {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&item{}))
+	db.Create(&[]item{{Code: "a,1", Qty: 1}, {Code: "<b>", Qty: 2}, {Code: "c", Qty: 3}})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/items", func(c *gin.Context) {
+		err := Query(c, db.Model(&item{}).Order("id"), settings)
+		assert.NoError(t, err)
+	})
+	return r
+}
+
+func get(r *gin.Engine, uri string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+	return w
+}
+
+func TestCsv(t *testing.T) {
+	r := setup(t, &Settings{
+		Filename: "items",
+		Columns:  []Column{{Name: "code", Title: "Code"}, {Name: "qty"}},
+		MaxRows:  2,
+	})
+	w := get(r, "/items?export")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="items.csv"`)
+	assert.Equal(t, "Code,qty\n\"a,1\",1\n<b>,2\n", w.Body.String())
+	assert.Equal(t, "true", w.Result().Trailer.Get(HeaderTruncated), "rows beyond MaxRows dropped")
+
+	w = get(r, "/items?export=ndjson")
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+}
+
+func TestXlsx(t *testing.T) {
+	r := setup(t, nil)
+	w := get(r, "/items?export=xlsx")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Result().Trailer.Get(HeaderTruncated))
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	assert.NoError(t, err)
+	sheet, _ := io.ReadAll(f)
+	assert.Contains(t, string(sheet), `<c r="B3" t="inlineStr"><is><t xml:space="preserve">&lt;b&gt;</t></is></c>`)
+	assert.Contains(t, string(sheet), `<c r="C4"><v>3</v></c>`)
+}
+
+func TestErrors(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&item{}))
+	r := gin.New()
+	r.GET("/items", func(c *gin.Context) {
+		columns := []Column{{Name: c.Query("column")}}
+		assert.Error(t, Query(c, db.Model(&item{}), &Settings{Columns: columns}))
+	})
+	r.GET("/broken", func(c *gin.Context) {
+		assert.Error(t, Query(c, db.Table("missing"), nil))
+	})
+
+	w := get(r, "/items?export=pdf&column=code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown export format")
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+
+	w = get(r, "/items?export&column=missing")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "export column missing not found")
+
+	w = get(r, "/broken?export")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+func init() {
+	Register("csv", Format{ContentType: "text/csv; charset=utf-8", Ext: ".csv", New: newCsvWriter})
+	Register("ndjson", Format{ContentType: "application/x-ndjson", Ext: ".ndjson", New: newNdjsonWriter})
+	Register("xlsx", Format{ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Ext: ".xlsx", New: newXlsxWriter})
+}
+
+func toString(v any) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCsvWriter(w io.Writer, columns []string, settings *Settings) (RowWriter, error) {
+	if settings.Bom {
+		if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return nil, err
+		}
+	}
+	cw := &csvWriter{
+		w:      csv.NewWriter(w),
+		record: make([]string, len(columns)),
+	}
+	return cw, cw.w.Write(columns)
+}
+
+func (cw *csvWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		cw.record[i] = toString(v)
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func newNdjsonWriter(w io.Writer, columns []string, settings *Settings) (RowWriter, error) {
+	return &ndjsonWriter{enc: json.NewEncoder(w), columns: columns}, nil
+}
+
+func (nw *ndjsonWriter) WriteRow(values []any) error {
+	row := make(map[string]any, len(values))
+	for i, v := range values {
+		row[nw.columns[i]] = v
+	}
+	return nw.enc.Encode(row)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
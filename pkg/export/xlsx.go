@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minimal streaming xlsx writer, one sheet with inline strings, no shared strings table
+// so rows can be written to client without holding the whole file in memory.
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+func newXlsxWriter(w io.Writer, columns []string, settings *Settings) (RowWriter, error) {
+	zw := zip.NewWriter(w)
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(sheet)}
+	if _, err := xw.sheet.WriteString(xlsxSheetHeader); err != nil {
+		return nil, err
+	}
+
+	header := make([]any, len(columns))
+	for i, item := range columns {
+		header[i] = item
+	}
+	return xw, xw.WriteRow(header)
+}
+
+// cellName returns A1 style reference, col starts from 0
+func cellName(col, row int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name + strconv.Itoa(row)
+}
+
+func (xw *xlsxWriter) WriteRow(values []any) error {
+	xw.row++
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `<row r="%d">`, xw.row)
+	for i, v := range values {
+		ref := cellName(i, xw.row)
+		switch vv := v.(type) {
+		case nil:
+			continue
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			fmt.Fprintf(b, `<c r="%s"><v>%v</v></c>`, ref, vv)
+		case bool:
+			bv := 0
+			if vv {
+				bv = 1
+			}
+			fmt.Fprintf(b, `<c r="%s" t="b"><v>%d</v></c>`, ref, bv)
+		default:
+			s := toString(v)
+			if t, ok := v.(time.Time); ok {
+				s = t.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			xml.EscapeText(b, []byte(s))
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := xw.sheet.WriteString(b.String())
+	return err
+}
+
+func (xw *xlsxWriter) Close() error {
+	if _, err := xw.sheet.WriteString(xlsxSheetFooter); err != nil {
+		return err
+	}
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zw.Close()
+}
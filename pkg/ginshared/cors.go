@@ -3,6 +3,7 @@
 package ginshared
 
 import (
+	"slices"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -21,23 +22,23 @@ type CorsComponent struct {
 
 func (c CorsComponent) OnEngineInited(r *gin.Engine) error {
 	log := zap.L()
-	// corsSettings := viper.Sub("CORS")
-	// if corsSettings != nil {
-	// 	corsSettings.Unmarshal(c)
-	// 	if c.Enabled {
+	origins := AllowOrigins()
+	if len(origins) == 0 {
+		log.Info("CORS disabled, allowOrigins is empty")
+		return nil
+	}
+	// credentials never sent to any origin
+	allowAll := slices.Contains(origins, "*")
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
+		AllowOrigins:     origins,
+		AllowWildcard:    true,
 		AllowMethods:     []string{"*"},
 		AllowHeaders:     []string{"*"},
 		ExposeHeaders:    []string{"*"},
-		AllowCredentials: true,
+		AllowCredentials: !allowAll,
 		MaxAge:           12 * time.Hour,
 	}))
-	log.Info("CORS enabled, defaults allow all")
-	// 	}
-	// } else {
-	// 	log.Info("CORS is disabled.")
-	// }
+	log.Info("CORS enabled", zap.Strings("allowOrigins", origins), zap.Bool("credentials", !allowAll))
 	return nil
 }
 
@@ -0,0 +1,420 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/export"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/messaging"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrConflict        = errors.New("record has been changed by others")
+	ErrVersionRequired = errors.New("version is required for update")
+	ErrOwnerRequired   = errors.New("owner is required")
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+	HeaderIfMatch   = "If-Match"
+)
+
+// Hooks are lifecycle callbacks, all of them run inside the same transaction as the change,
+// return error to rollback.
+type Hooks[T any] struct {
+	Query        func(c *gin.Context, tx *gorm.DB) *gorm.DB // extra scope for list/get/update/delete
+	BeforeCreate func(c *gin.Context, tx *gorm.DB, item *T) error
+	AfterCreate  func(c *gin.Context, tx *gorm.DB, item *T) error
+	BeforeUpdate func(c *gin.Context, tx *gorm.DB, old *T, changes map[string]any) error
+	AfterUpdate  func(c *gin.Context, tx *gorm.DB, item *T) error
+	BeforeDelete func(c *gin.Context, tx *gorm.DB, item *T) error
+	AfterDelete  func(c *gin.Context, tx *gorm.DB, item *T) error
+}
+
+// CRUD mounts list/get/create/update/soft-delete REST routes for gorm entity T.
+type CRUD[T any] struct {
+	DB             *gorm.DB
+	Logger         *zap.Logger
+	Messaging      messaging.MessagingService // optional, resolved from container if nil
+	Filters        []string                   // allow-listed filter fields, field name or column name
+	Sorts          []string                   // allow-listed sort fields
+	Search         []string                   // fields searched by q, case insensitive contains
+	Updatable      []string                   // allow-listed fields for update, empty means all except protected
+	OwnerField     string                     // owner scoping column, e.g. owner. empty disables owner scoping
+	VersionField   string                     // optimistic locking column, default updated_at
+	RequireVersion bool                       // reject update without version
+	PageSize       int
+	ReadOnly       bool
+	Security       []string         // openapi security schemes, detected from auth middlewares of the routes if empty
+	Export         *export.Settings // list with export param streams file, nil means all columns
+	Hooks          Hooks[T]
+
+	schema  *schema.Schema
+	allowed *orm.AllowList
+	msOnce  sync.Once
+	tagName string
+}
+
+func New[T any](db *gorm.DB, logger *zap.Logger) *CRUD[T] {
+	return &CRUD[T]{
+		DB:           db,
+		Logger:       logger,
+		VersionField: "updated_at",
+		PageSize:     DefaultPageSize,
+	}
+}
+
+func (cr *CRUD[T]) parse() error {
+	if cr.schema != nil {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: cr.DB}
+	var t T
+	if err := stmt.Parse(&t); err != nil {
+		return err
+	}
+	cr.schema = stmt.Schema
+	if cr.Logger == nil {
+		cr.Logger = zap.L()
+	}
+	cr.Logger = cr.Logger.With(zap.String("entity", cr.schema.Name))
+	if cr.VersionField != "" && cr.field(cr.VersionField) == nil {
+		cr.Logger.Warn("version field not found, optimistic locking disabled.", zap.String("field", cr.VersionField))
+		cr.VersionField = ""
+	}
+	if cr.OwnerField != "" && cr.field(cr.OwnerField) == nil {
+		return fmt.Errorf("owner field %s not found in %s", cr.OwnerField, cr.schema.Name)
+	}
+	cr.allowed = orm.AllowSchema(cr.schema, cr.Filters, cr.Sorts, []string{"*"})
+	for _, item := range cr.Search {
+		f := cr.field(item)
+		if f == nil || f.DBName == "" {
+			return fmt.Errorf("search field %s not found in %s", item, cr.schema.Name)
+		}
+		cr.allowed.Search = append(cr.allowed.Search, f.DBName)
+	}
+	return nil
+}
+
+// Mount registers the routes, e.g. Mount(authed, "/orders")
+func (cr *CRUD[T]) Mount(r gin.IRoutes, path string) {
+	if err := cr.parse(); err != nil {
+		panic(err)
+	}
+	path = "/" + strings.Trim(path, "/")
+	cr.tagName = strings.TrimPrefix(path, "/")
+
+	var t T
+	openapi.GET(r, path, cr.doc(&openapi.Operation{Summary: "list " + cr.schema.Name, Query: orm.QueryBase{}, Response: orm.PagingResult[T]{},
+		Params: cr.filterParams()}), cr.List)
+	openapi.GET(r, path+"/:id", cr.doc(&openapi.Operation{Summary: "get " + cr.schema.Name, Response: t}), cr.Get)
+	if cr.ReadOnly {
+		return
+	}
+	openapi.POST(r, path, cr.doc(&openapi.Operation{Summary: "create " + cr.schema.Name, Request: t, Response: t}), cr.Create)
+	openapi.PUT(r, path+"/:id", cr.doc(&openapi.Operation{Summary: "update " + cr.schema.Name, Request: t, Response: t}), cr.Update)
+	openapi.PATCH(r, path+"/:id", cr.doc(&openapi.Operation{Summary: "partial update " + cr.schema.Name, Request: map[string]any{}, Response: t}), cr.Update)
+	openapi.DELETE(r, path+"/:id", cr.doc(&openapi.Operation{Summary: "delete " + cr.schema.Name}), cr.Delete)
+}
+
+func (cr *CRUD[T]) doc(op *openapi.Operation) *openapi.Operation {
+	op.Security = cr.Security
+	op.Tags = []string{cr.tagName}
+	return op
+}
+
+func (cr *CRUD[T]) filterParams() []openapi.Param {
+	result := make([]openapi.Param, 0, len(cr.Filters))
+	for _, item := range cr.Filters {
+		result = append(result, openapi.Param{Name: item, Description: "filter, multiple values for IN"})
+	}
+	return result
+}
+
+// owner returns the owner of authenticated api key.
+func owner(c *gin.Context) string {
+	if v, ok := c.Get(auth.KeyUser); ok {
+		if key, ok := v.(*auth.AuthKey); ok {
+			return key.Owner
+		}
+	}
+	return c.GetString("owner")
+}
+
+func (cr *CRUD[T]) scoped(c *gin.Context, tx *gorm.DB) (*gorm.DB, error) {
+	if cr.OwnerField != "" {
+		o := owner(c)
+		if o == "" {
+			return nil, ErrOwnerRequired
+		}
+		tx = tx.Where(map[string]any{cr.field(cr.OwnerField).DBName: o})
+	}
+	if cr.Hooks.Query != nil {
+		tx = cr.Hooks.Query(c, tx)
+	}
+	return tx, nil
+}
+
+func (cr *CRUD[T]) List(c *gin.Context) {
+	req := orm.QueryBase{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = cr.PageSize
+	}
+	if req.PageSize > MaxPageSize {
+		req.PageSize = MaxPageSize
+	}
+
+	tx, err := cr.scoped(c, cr.DB.WithContext(c).Model(new(T)))
+	if err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	tx, err = cr.applyFilters(tx, c.Request.URL.Query())
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	tx, err = req.Apply(tx, cr.allowed)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+
+	if export.Requested(c) {
+		// errors responded by export
+		if err := export.Query(c, tx, cr.Export); err != nil {
+			cr.Logger.Error("export failed.", zap.Error(err))
+		}
+		return
+	}
+
+	result := &orm.PagingResult[T]{}
+	if err := result.Trigger(tx, req); err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	ginshared.RespondOK(c, result)
+}
+
+func (cr *CRUD[T]) first(c *gin.Context, tx *gorm.DB) (*T, error) {
+	tx, err := cr.scoped(c, tx)
+	if err != nil {
+		return nil, err
+	}
+	item := new(T)
+	pk := cr.schema.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("%s has no primary key", cr.schema.Name)
+	}
+	err = tx.Where(map[string]any{pk.DBName: c.Param("id")}).First(item).Error
+	return item, err
+}
+
+func (cr *CRUD[T]) Get(c *gin.Context) {
+	item, err := cr.first(c, cr.DB.WithContext(c))
+	if err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	ginshared.RespondOK(c, item)
+}
+
+func (cr *CRUD[T]) Create(c *gin.Context) {
+	item := new(T)
+	if err := c.ShouldBindJSON(item); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	rv := reflectValue(item)
+	if pk := cr.schema.PrioritizedPrimaryField; pk != nil && pk.AutoIncrement {
+		if err := pk.Set(c, rv, nil); err != nil {
+			cr.respondErr(c, err)
+			return
+		}
+	}
+	if cr.OwnerField != "" {
+		o := owner(c)
+		if o == "" {
+			cr.respondErr(c, ErrOwnerRequired)
+			return
+		}
+		if err := cr.field(cr.OwnerField).Set(c, rv, o); err != nil {
+			cr.respondErr(c, err)
+			return
+		}
+	}
+
+	err := cr.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if cr.Hooks.BeforeCreate != nil {
+			if err := cr.Hooks.BeforeCreate(c, tx, item); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+		if cr.Hooks.AfterCreate != nil {
+			return cr.Hooks.AfterCreate(c, tx, item)
+		}
+		return nil
+	})
+	if err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	cr.publish(c, item, messaging.GormActionSave)
+	c.JSON(http.StatusCreated, ginshared.UnifiedResp{Success: true, Result: item})
+}
+
+func (cr *CRUD[T]) Update(c *gin.Context) {
+	raw, err := c.GetRawData()
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	changes, version, err := cr.changes(c, raw)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	if v := c.GetHeader(HeaderIfMatch); v != "" {
+		version = strings.Trim(v, `"`)
+	}
+
+	var updated *T
+	err = cr.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		old, err := cr.first(c, tx)
+		if err != nil {
+			return err
+		}
+		where, err := cr.versionCond(c, old, version, changes)
+		if err != nil {
+			return err
+		}
+		if cr.Hooks.BeforeUpdate != nil {
+			if err := cr.Hooks.BeforeUpdate(c, tx, old, changes); err != nil {
+				return err
+			}
+		}
+		if len(changes) == 0 {
+			updated = old
+			return nil
+		}
+
+		q := tx.Model(old)
+		if where != nil {
+			q = q.Where(where)
+		}
+		result := q.Updates(changes)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrConflict
+		}
+
+		updated, err = cr.first(c, tx)
+		if err != nil {
+			return err
+		}
+		if cr.Hooks.AfterUpdate != nil {
+			return cr.Hooks.AfterUpdate(c, tx, updated)
+		}
+		return nil
+	})
+	if err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	cr.publish(c, updated, messaging.GormActionSave)
+	ginshared.RespondOK(c, updated)
+}
+
+func (cr *CRUD[T]) Delete(c *gin.Context) {
+	var deleted *T
+	err := cr.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		item, err := cr.first(c, tx)
+		if err != nil {
+			return err
+		}
+		if cr.Hooks.BeforeDelete != nil {
+			if err := cr.Hooks.BeforeDelete(c, tx, item); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+		deleted = item
+		if cr.Hooks.AfterDelete != nil {
+			return cr.Hooks.AfterDelete(c, tx, item)
+		}
+		return nil
+	})
+	if err != nil {
+		cr.respondErr(c, err)
+		return
+	}
+	cr.publish(c, deleted, messaging.GormActionDelete)
+	ginshared.RespondOK(c, deleted)
+}
+
+func (cr *CRUD[T]) publish(ctx context.Context, item *T, action messaging.GormAction) {
+	if _, ok := messaging.RegisteredKey(item); !ok {
+		return
+	}
+	// published by the outbox callbacks in the transaction of the change.
+	if messaging.OutboxActive() {
+		return
+	}
+	cr.msOnce.Do(func() {
+		if cr.Messaging != nil {
+			return
+		}
+		err := core.GetContainer().Invoke(func(p core.OptionalParam[messaging.MessagingService]) {
+			cr.Messaging = p.P
+		})
+		if err != nil {
+			cr.Logger.Warn("messaging service is not available, changes won't be published.", zap.Error(err))
+		}
+	})
+	if cr.Messaging == nil {
+		return
+	}
+	if err := messaging.PubGormPayload(context.WithoutCancel(ctx), cr.Messaging, item, action); err != nil {
+		cr.Logger.Error("publish entity changes failed.", zap.Error(err), zap.String("action", string(action)))
+	}
+}
+
+func (cr *CRUD[T]) respondErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, ginshared.UnifiedResp{Success: false, Error: "record not found"})
+	case errors.Is(err, ErrConflict):
+		c.JSON(http.StatusConflict, ginshared.UnifiedResp{Success: false, Error: err.Error()})
+	case errors.Is(err, ErrVersionRequired):
+		c.JSON(http.StatusPreconditionRequired, ginshared.UnifiedResp{Success: false, Error: err.Error()})
+	case errors.Is(err, ErrOwnerRequired):
+		c.JSON(http.StatusForbidden, ginshared.UnifiedResp{Success: false, Error: err.Error()})
+	default:
+		ginshared.RespondErr(c, err, cr.Logger)
+	}
+}
@@ -0,0 +1,104 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type order struct {
+	gorm.Model
+	Owner  string `json:"owner"`
+	Code   string `json:"code"`
+	Status string `json:"status"`
+}
+
+type resp struct {
+	Success bool
+	Result  json.RawMessage
+	Error   any
+}
+
+func setup(t *testing.T) *gin.Engine {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&order{}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group("/v1").Use(func(c *gin.Context) {
+		c.Set("owner", c.GetHeader("owner"))
+	})
+	cr := New[order](db, zap.NewNop())
+	cr.OwnerField = "owner"
+	cr.Filters = []string{"status"}
+	cr.Sorts = []string{"code"}
+	cr.Mount(group, "orders")
+	return r
+}
+
+func call(r *gin.Engine, method, uri, owner string, body any, headers ...string) (int, resp) {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		json.NewEncoder(buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, uri, buf)
+	req.Header.Set("owner", owner)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	result := resp{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	return w.Code, result
+}
+
+func TestCRUD(t *testing.T) {
+	r := setup(t)
+
+	code, created := call(r, http.MethodPost, "/v1/orders", "a", map[string]any{"code": "o1", "status": "new", "owner": "b"})
+	assert.Equal(t, http.StatusCreated, code)
+	o := order{}
+	json.Unmarshal(created.Result, &o)
+	assert.Equal(t, "a", o.Owner)
+	call(r, http.MethodPost, "/v1/orders", "a", map[string]any{"code": "o2", "status": "done"})
+	call(r, http.MethodPost, "/v1/orders", "b", map[string]any{"code": "o3", "status": "new"})
+
+	code, listed := call(r, http.MethodGet, "/v1/orders?status=new&orderBy=-code", "a", nil)
+	assert.Equal(t, http.StatusOK, code)
+	page := struct{ Total int64 }{}
+	json.Unmarshal(listed.Result, &page)
+	assert.Equal(t, int64(1), page.Total)
+
+	code, _ = call(r, http.MethodGet, "/v1/orders?code=o1", "a", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = call(r, http.MethodGet, "/v1/orders/3", "a", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	stale := o.UpdatedAt.Add(-time.Second).Format(time.RFC3339Nano)
+	code, _ = call(r, http.MethodPatch, "/v1/orders/1", "a", map[string]any{"status": "done"}, HeaderIfMatch, stale)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, updated := call(r, http.MethodPatch, "/v1/orders/1", "a", map[string]any{"status": "done", "UpdatedAt": o.UpdatedAt})
+	assert.Equal(t, http.StatusOK, code)
+	u := order{}
+	json.Unmarshal(updated.Result, &u)
+	assert.Equal(t, "done", u.Status)
+	assert.Equal(t, "o1", u.Code)
+
+	code, _ = call(r, http.MethodDelete, "/v1/orders/1", "a", nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = call(r, http.MethodGet, "/v1/orders/1", "a", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,160 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+func reflectValue(item any) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(item))
+}
+
+func jsonKey(f *schema.Field) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// field finds schema field by column name, struct field name or json name.
+func (cr *CRUD[T]) field(name string) *schema.Field {
+	if f := cr.schema.LookUpField(name); f != nil {
+		return f
+	}
+	for _, f := range cr.schema.Fields {
+		if f.DBName != "" && jsonKey(f) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func allowed(list []string, f *schema.Field) bool {
+	return lo.Contains(list, f.DBName) || lo.Contains(list, f.Name) || lo.Contains(list, jsonKey(f))
+}
+
+func (cr *CRUD[T]) protected(f *schema.Field) bool {
+	if f.PrimaryKey || f.AutoCreateTime > 0 || f.AutoUpdateTime > 0 {
+		return true
+	}
+	if f.DBName == "deleted_at" {
+		return true
+	}
+	if cr.OwnerField != "" && f == cr.field(cr.OwnerField) {
+		return true
+	}
+	return cr.VersionField != "" && f == cr.field(cr.VersionField)
+}
+
+// applyFilters applies allow-listed filters from query string, multiple values for IN.
+// query keys those not match any field are ignored, e.g. apiKey.
+func (cr *CRUD[T]) applyFilters(tx *gorm.DB, values url.Values) (*gorm.DB, error) {
+	for key, v := range values {
+		f := cr.field(key)
+		if f == nil || f.DBName == "" {
+			continue
+		}
+		if !allowed(cr.Filters, f) {
+			return nil, fmt.Errorf("filter on %s is not allowed", key)
+		}
+		if len(v) == 1 {
+			tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: v[0]})
+		} else {
+			tx = tx.Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Values: lo.ToAnySlice(v)})
+		}
+	}
+	return tx, nil
+}
+
+// changes returns the column values for partial update and the version from body if provided.
+func (cr *CRUD[T]) changes(ctx context.Context, raw []byte) (map[string]any, string, error) {
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, "", err
+	}
+	item := new(T)
+	if err := json.Unmarshal(raw, item); err != nil {
+		return nil, "", err
+	}
+	rv := reflectValue(item)
+
+	version := ""
+	result := make(map[string]any)
+	for key, value := range keys {
+		f := cr.field(key)
+		if f == nil || f.DBName == "" {
+			return nil, "", fmt.Errorf("unknown field %s", key)
+		}
+		if cr.VersionField != "" && f == cr.field(cr.VersionField) {
+			version = rawString(value)
+			continue
+		}
+		if cr.protected(f) {
+			continue
+		}
+		if len(cr.Updatable) > 0 && !allowed(cr.Updatable, f) {
+			return nil, "", fmt.Errorf("field %s is not updatable", key)
+		}
+		v, _ := f.ValueOf(ctx, rv)
+		result[f.DBName] = v
+	}
+	return result, version, nil
+}
+
+func rawString(raw json.RawMessage) string {
+	s := ""
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// versionCond checks the version and returns the where condition for update, nil if locking disabled.
+func (cr *CRUD[T]) versionCond(ctx context.Context, old *T, version string, changes map[string]any) (map[string]any, error) {
+	if cr.VersionField == "" {
+		return nil, nil
+	}
+	f := cr.field(cr.VersionField)
+	current, _ := f.ValueOf(ctx, reflectValue(old))
+
+	if version == "" {
+		if cr.RequireVersion {
+			return nil, ErrVersionRequired
+		}
+	} else if !sameVersion(current, version) {
+		return nil, ErrConflict
+	}
+
+	switch f.FieldType.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		if len(changes) > 0 {
+			changes[f.DBName] = gorm.Expr(f.DBName + " + 1")
+		}
+	}
+	return map[string]any{f.DBName: current}, nil
+}
+
+func sameVersion(current any, version string) bool {
+	switch v := current.(type) {
+	case time.Time:
+		t, err := time.Parse(time.RFC3339Nano, version)
+		return err == nil && t.Equal(v)
+	case *time.Time:
+		if v == nil {
+			return version == ""
+		}
+		return sameVersion(*v, version)
+	}
+	return fmt.Sprint(current) == version
+}
@@ -33,6 +33,7 @@ func GetbaseUrl() string {
 func initEngine(logger *zap.Logger, bus EventBus.Bus, p *Components,
 	tls *Tlssettings) *gin.Engine {
 	router := gin.New()
+	applyTrustedProxies(logger, router)
 	router.Use(ginzap.Ginzap(logger, time.RFC3339, false))
 	router.Use(ginzap.RecoveryWithZap(logger, true))
 
@@ -0,0 +1,26 @@
+package ginshared
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// applyTrustedProxies configures which proxies may set client IP headers.
+// config trustedProxies (IP or CIDR), remoteIPHeaders & trustedPlatform (e.g. X-Real-IP, cloudflare via CF-Connecting-IP),
+// no proxy trusted by default so X-Forwarded-For can't spoof c.ClientIP().
+func applyTrustedProxies(logger *zap.Logger, router *gin.Engine) {
+	proxies := viper.GetStringSlice("trustedProxies")
+	if len(proxies) == 0 {
+		proxies = nil
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		logger.Error("invalid trusted proxies, no proxy trusted", zap.Strings("proxies", proxies), zap.Error(err))
+		router.SetTrustedProxies(nil)
+	}
+	if headers := viper.GetStringSlice("remoteIPHeaders"); len(headers) > 0 {
+		router.RemoteIPHeaders = headers
+	}
+	router.TrustedPlatform = viper.GetString("trustedPlatform")
+	logger.Info("trusted proxies applied", zap.Strings("proxies", proxies), zap.Strings("headers", router.RemoteIPHeaders))
+}
@@ -2,14 +2,66 @@ package ginshared
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+const (
+	KeyCorsOrigins      = "cors.allowOrigins"
+	KeyWebsocketOrigins = "websocket.allowOrigins"
 )
 
 var ToWebsocket = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin:     CheckOrigin,
+}
+
+func init() {
+	// HTTP APIs allow any origin (without credentials) as before, websocket same origin only
+	viper.SetDefault(KeyCorsOrigins, []string{"*"})
+	viper.SetDefault(KeyWebsocketOrigins, []string{})
+}
+
+// AllowOrigins returns the CORS allowed origins, empty disables CORS.
+// e.g. ["https://app.example.com", "https://*.example.com"]
+func AllowOrigins() []string {
+	return viper.GetStringSlice(KeyCorsOrigins)
+}
+
+// WebsocketOrigins returns origins allowed to upgrade to websocket besides the same origin.
+func WebsocketOrigins() []string {
+	return viper.GetStringSlice(KeyWebsocketOrigins)
+}
+
+// CheckOrigin checks Origin header against the websocket allowed origins, same host always allowed.
+func CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, item := range WebsocketOrigins() {
+		if matchOrigin(item, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
 		return true
-	},
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return false
+	}
+	return len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
 }
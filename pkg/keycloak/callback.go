@@ -3,10 +3,12 @@ package keycloak
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/thanhpk/randstr"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
@@ -55,6 +57,10 @@ func init() {
 	})
 }
 
+const cookieState = "oauth_state"
+
+// RedirectKeyCloakLogin redirects to keycloak login, random state kept in cookie and checked by KeycloakCallback.
+// Deprecated: use oidc.Provider, PKCE flow works with any OIDC issuer.
 func RedirectKeyCloakLogin(c *gin.Context, redirectURI string) {
 	if oauthConfig == nil {
 		c.AbortWithStatus(http.StatusServiceUnavailable)
@@ -66,12 +72,17 @@ func RedirectKeyCloakLogin(c *gin.Context, redirectURI string) {
 		schema = "https"
 	}
 
-	redirectTo := fmt.Sprintf("%s://%s%s", schema, c.Request.Host, redirectURI)
-	oauthConfig.RedirectURL = redirectTo
-	url := oauthConfig.AuthCodeURL("state", oauth2.AccessTypeOffline)
+	// copy of config for the request, the shared one never changed
+	config := *oauthConfig
+	config.RedirectURL = fmt.Sprintf("%s://%s%s", schema, c.Request.Host, redirectURI)
+	state := randstr.Hex(16)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cookieState, state+"|"+config.RedirectURL, 600, "/", "", c.Request.TLS != nil, true)
+	url := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	c.Redirect(http.StatusSeeOther, url)
 }
 
+// Deprecated: use oidc.Provider.
 func KeycloakCallback() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if oauthConfig == nil {
@@ -83,7 +94,18 @@ func KeycloakCallback() gin.HandlerFunc {
 			c.String(http.StatusBadRequest, "Code not found")
 			return
 		}
-		token, err := oauthConfig.Exchange(c, code)
+		saved, _ := c.Cookie(cookieState)
+		state, redirectTo, _ := strings.Cut(saved, "|")
+		if state == "" || c.Query("state") != state {
+			zap.L().Warn("keycloak callback state mismatched")
+			c.String(http.StatusBadRequest, "invalid state")
+			c.Abort()
+			return
+		}
+		c.SetCookie(cookieState, "", -1, "/", "", c.Request.TLS != nil, true)
+		config := *oauthConfig
+		config.RedirectURL = redirectTo
+		token, err := config.Exchange(c, code)
 		if err != nil {
 			// c.String(http.StatusInternalServerError, "Failed to exchange token: %v", err)
 			zap.L().Error("exchange token failed", zap.Error(err))
@@ -1,12 +1,14 @@
 package keycloak
 
 import (
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
 	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
 	"go.uber.org/zap"
 )
 
@@ -19,7 +21,7 @@ func MustLogin() gin.HandlerFunc {
 	logCurrentUser := viper.GetBool("keycloak.debug")
 	keycloakFunc := ginkeycloak.Auth(ginkeycloak.AuthCheck(), buildconfig)
 
-	return func(ctx *gin.Context) {
+	return openapi.Secured(openapi.SchemeKeycloak, nil, func(ctx *gin.Context) {
 		keycloakFunc(ctx)
 		if logCurrentUser {
 			tk, ok := ctx.Get("token")
@@ -38,7 +40,7 @@ func MustLogin() gin.HandlerFunc {
 				zap.L().Warn("no token provided.")
 			}
 		}
-	}
+	})
 }
 
 func init() {
@@ -48,6 +50,19 @@ func init() {
 type KeycloakConfig struct {
 	DefaultRoles []string
 	BuildConfig  ginkeycloak.BuilderConfig
+	RoleMapping  map[string]string // realm role (lower case) -> app role, unmapped realm roles used as is
+}
+
+// Roles maps realm roles of the token to app roles.
+func (kc *KeycloakConfig) Roles(token ginkeycloak.KeyCloakToken) []string {
+	roles := make([]string, 0, len(token.RealmAccess.Roles))
+	for _, item := range token.RealmAccess.Roles {
+		if mapped, ok := kc.RoleMapping[strings.ToLower(item)]; ok {
+			item = mapped
+		}
+		roles = append(roles, item)
+	}
+	return roles
 }
 
 func (kc *KeycloakConfig) Auth(roles ...string) gin.HandlerFunc {
@@ -58,7 +73,7 @@ func (kc *KeycloakConfig) Auth(roles ...string) gin.HandlerFunc {
 	for _, role := range roles {
 		x = x.RestrictButForRealm(role)
 	}
-	return x.Build()
+	return openapi.Secured(openapi.SchemeKeycloak, roles, x.Build())
 }
 
 func NewKeycloakConfig(logger *zap.Logger) *KeycloakConfig {
@@ -75,6 +90,7 @@ func NewKeycloakConfig(logger *zap.Logger) *KeycloakConfig {
 	settings.Unmarshal(&buildconfig)
 	config.BuildConfig = buildconfig
 	config.DefaultRoles = settings.GetStringSlice("roles")
+	config.RoleMapping = settings.GetStringMapString("roleMapping")
 
 	logger.Info("load keycloak config", zap.Any("config", config.BuildConfig.Url))
 
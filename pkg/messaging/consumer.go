@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// Message delivered to BatchProcessor.
+type Message struct {
+	ID         string
+	Topic      string
+	Group      string
+	Payload    []byte
+	Deliveries int64 // delivery count, 1 for the first delivery
+}
+
+// BatchProcessor processes messages read together, return BatchError to ack the succeeded messages only,
+// any other error fails the whole batch.
+type BatchProcessor func(ctx context.Context, messages []Message) error
+
+// BatchError of BatchProcessor, message ID -> error, messages not included are acked.
+type BatchError map[string]error
+
+func (e BatchError) Error() string {
+	items := make([]string, 0, len(e))
+	for id, err := range e {
+		items = append(items, fmt.Sprintf("%s: %v", id, err))
+	}
+	return fmt.Sprintf("%d messages failed, %s", len(e), strings.Join(items, "; "))
+}
+
+// failureOf the message in err of BatchProcessor, nil if succeeded.
+func failureOf(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+	var be BatchError
+	if errors.As(err, &be) {
+		return be[id]
+	}
+	return err
+}
+
+// SubOptions of a subscription, config key messaging.subOptions for Sub.
+type SubOptions struct {
+	Workers  int           // concurrent handlers, 1 by default
+	KeyField string        // top level JSON field of payload, messages of the same key processed in order by one worker
+	Batch    int           // messages per read, max size of batches for BatchProcessor
+	Block    time.Duration // read block timeout, stop checked in between
+	Retry    *RetryPolicy  `mapstructure:"-"` // nil for SetRetryPolicy or messaging.retry
+	// KeyFunc of payload, KeyField used if nil. messages without key spread across workers.
+	KeyFunc func(payload []byte) string `mapstructure:"-"`
+}
+
+func DefaultSubOptions() *SubOptions {
+	return &SubOptions{
+		Workers: 1,
+		Batch:   100,
+		Block:   5 * time.Second,
+	}
+}
+
+// normalized copy of options, defaults for empty fields.
+func (o *SubOptions) normalized() *SubOptions {
+	result := DefaultSubOptions()
+	if o == nil {
+		return result
+	}
+	*result = *o
+	if result.Workers <= 0 {
+		result.Workers = 1
+	}
+	if result.Batch <= 0 {
+		result.Batch = DefaultSubOptions().Batch
+	}
+	if result.Block <= 0 {
+		result.Block = DefaultSubOptions().Block
+	}
+	if result.KeyFunc == nil && result.KeyField != "" {
+		result.KeyFunc = KeyOf(result.KeyField)
+	}
+	return result
+}
+
+// KeyOf returns KeyFunc reading the top level field of JSON payload, empty if not found.
+func KeyOf(field string) func(payload []byte) string {
+	return func(payload []byte) string {
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(payload, &values); err != nil {
+			return ""
+		}
+		raw, ok := values[field]
+		if !ok {
+			return ""
+		}
+		s := ""
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+		return string(raw)
+	}
+}
+
+// partition of the key in workers, seq spreads messages without key.
+func partition(key string, seq, workers int) int {
+	if workers <= 1 {
+		return 0
+	}
+	if key == "" {
+		return seq % workers
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,33 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubOptions(t *testing.T) {
+	opts := (&SubOptions{Workers: 4, KeyField: "Key"}).normalized()
+	assert.Equal(t, 100, opts.Batch)
+	assert.Equal(t, 5*time.Second, opts.Block)
+	assert.Equal(t, "order", opts.KeyFunc([]byte(`{"Key":"order","Payload":"{}"}`)))
+	assert.Equal(t, "7", KeyOf("id")([]byte(`{"id":7}`)))
+	assert.Equal(t, "", KeyOf("id")([]byte(`not json`)))
+	assert.Equal(t, 1, (*SubOptions)(nil).normalized().Workers)
+
+	w := partition("order:1", 0, 4)
+	for seq := 1; seq < 10; seq++ {
+		assert.Equal(t, w, partition("order:1", seq, 4), "the same key to the same worker")
+	}
+	assert.Equal(t, 2, partition("", 6, 4), "no key spread")
+	assert.Equal(t, 0, partition("order:1", 3, 1))
+
+	err := BatchError{"1-0": errors.New("timeout")}
+	assert.Error(t, failureOf(err, "1-0"))
+	assert.NoError(t, failureOf(err, "2-0"), "not in BatchError acked")
+	assert.Error(t, failureOf(errors.New("db down"), "2-0"), "other error fails all")
+	assert.NoError(t, failureOf(nil, "1-0"))
+	assert.Contains(t, err.Error(), "1 messages failed")
+}
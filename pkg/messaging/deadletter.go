@@ -0,0 +1,443 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"github.com/techquest-tech/gin-shared/pkg/schedule"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	EventDeadLetter      = "messaging.deadletter"       // payload *DeadLetter, published when stored
+	EventDeadLetterAlert = "messaging.deadletter.alert" // payload topic & count, AlertThreshold reached in AlertWindow
+)
+
+var (
+	ErrNoDeadLetters    = errors.New("dead letters are not enabled")
+	ErrFilterRequired   = errors.New("filter or All required")
+	ErrInvalidPayload   = errors.New("payload is not JSON")
+	abandonedFileName   = "receivedAbandoned.log"
+	activeDeadLetters   *DeadLetters
+	deadLetterBatchSize = 100
+)
+
+// RetryTopicInfix of RetryTopic.
+const RetryTopicInfix = ":retry:"
+
+// RetryTopic of the group, read by subscriptions of the group besides topic, so replays reach the group only.
+func RetryTopic(topic, group string) string {
+	if strings.HasSuffix(topic, RetryTopicInfix+group) {
+		return topic
+	}
+	return topic + RetryTopicInfix + group
+}
+
+// DeadLetterSettings of dead letters, config key messaging.deadLetters
+type DeadLetterSettings struct {
+	Enabled           bool
+	Retention         string // e.g. 30d, empty keeps forever
+	RetentionSchedule string
+	API               bool
+	Base              string
+	Perm              string
+	AlertThreshold    int // 0 disables EventDeadLetterAlert
+	AlertWindow       time.Duration
+}
+
+func DefaultDeadLetterSettings() *DeadLetterSettings {
+	return &DeadLetterSettings{
+		Retention:         "30d",
+		RetentionSchedule: "40 3 * * *",
+		Base:              "/messaging/deadletters",
+		Perm:              "messaging:admin",
+		AlertWindow:       10 * time.Minute,
+	}
+}
+
+// DeadLetter is a message failed or expired, with the original payload.
+type DeadLetter struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `gorm:"index"`
+	Topic         string    `gorm:"size:128;index"`
+	ConsumerGroup string    `gorm:"size:128;index"`
+	Consumer      string    `gorm:"size:128"`
+	MessageID     string    `gorm:"size:64"`
+	Payload       string    `gorm:"type:text"`
+	Error         string    `gorm:"size:1024"`
+	Attempts      int
+	Meta          string `gorm:"type:text"` // other fields of the abandoned item, JSON
+	Replays       int
+	ReplayedAt    *time.Time
+}
+
+// DeadLetterQuery filters dead letters, empty fields ignored.
+type DeadLetterQuery struct {
+	IDs      []uint    `form:"id" json:"ids,omitempty"`
+	Topic    string    `form:"topic" json:"topic,omitempty"`
+	Group    string    `form:"group" json:"group,omitempty"`
+	Consumer string    `form:"consumer" json:"consumer,omitempty"`
+	Error    string    `form:"error" json:"error,omitempty"` // contains
+	From     time.Time `form:"from" json:"from,omitempty"`
+	To       time.Time `form:"to" json:"to,omitempty"`
+	Replayed *bool     `form:"replayed" json:"replayed,omitempty"`
+	All      bool      `form:"all" json:"all,omitempty"` // required by replay & purge without filters
+	Page     int       `form:"page" json:"page,omitempty"`
+	PageSize int       `form:"pageSize" json:"pageSize,omitempty"`
+}
+
+func (q *DeadLetterQuery) filtered() bool {
+	return len(q.IDs) > 0 || q.Topic != "" || q.Group != "" || q.Consumer != "" || q.Error != "" ||
+		!q.From.IsZero() || !q.To.IsZero() || q.Replayed != nil
+}
+
+func (q *DeadLetterQuery) apply(tx *gorm.DB) *gorm.DB {
+	if len(q.IDs) > 0 {
+		tx = tx.Where("id IN ?", q.IDs)
+	}
+	for col, value := range map[string]string{"topic": q.Topic, "consumer_group": q.Group, "consumer": q.Consumer} {
+		if value != "" {
+			tx = tx.Where(col+" = ?", value)
+		}
+	}
+	if q.Error != "" {
+		tx = tx.Where("error LIKE ?", "%"+q.Error+"%")
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_at < ?", q.To)
+	}
+	if q.Replayed != nil {
+		tx = tx.Where(map[bool]string{true: "replayed_at IS NOT NULL", false: "replayed_at IS NULL"}[*q.Replayed])
+	}
+	return tx
+}
+
+// DeadLetters stores abandoned messages, lists, replays & purges them.
+type DeadLetters struct {
+	Settings *DeadLetterSettings
+	DB       *gorm.DB
+	Service  MessagingService
+	logger   *zap.Logger
+
+	lock   sync.Mutex
+	counts map[string][]time.Time // topic -> stored at, within AlertWindow
+}
+
+type DeadLettersParam struct {
+	dig.In
+	DB      *gorm.DB         `optional:"true"`
+	Service MessagingService `optional:"true"`
+	Logger  *zap.Logger
+}
+
+func NewDeadLetters(p DeadLettersParam) *DeadLetters {
+	settings := DefaultDeadLetterSettings()
+	if sub := viper.Sub("messaging.deadLetters"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return &DeadLetters{Settings: settings, DB: p.DB, Service: p.Service, logger: p.Logger, counts: map[string][]time.Time{}}
+}
+
+func init() {
+	orm.AppendEntityIf("messaging.deadLetters.enabled", &DeadLetter{})
+	core.Provide(NewDeadLetters)
+	core.ProvideStartup(startDeadLetters)
+}
+
+// startDeadLetters routes AbandonedChan to dead letters, and the retention job (messaging.deadLetters.retention).
+func startDeadLetters(dl *DeadLetters, cs *schedule.CleanupService, logger *zap.Logger) (core.Startup, error) {
+	if !dl.Settings.Enabled || dl.DB == nil {
+		logger.Info("dead letters are disabled, abandoned messages appended to file.", zap.String("file", abandonedFileName))
+		return nil, nil
+	}
+	activeDeadLetters = dl
+	if dl.Settings.Retention == "" {
+		return nil, nil
+	}
+	stmt := &gorm.Statement{DB: dl.DB}
+	if err := stmt.Parse(&DeadLetter{}); err != nil {
+		return nil, err
+	}
+	req := cs.GetDefaultRequest()
+	req.Tables = []string{stmt.Schema.Table}
+	req.PrefixIncluded = true
+	req.DeletedField = "created_at"
+	req.Duration = dl.Settings.Retention
+	err := schedule.CreateSchedule("messaging_deadletter_retention", dl.Settings.RetentionSchedule, func() {
+		if err := cs.Cleanup(req); err != nil {
+			logger.Error("dead letter retention failed", zap.Error(err))
+		}
+	})
+	return nil, err
+}
+
+// routeAbandoned stores items of AbandonedChan as dead letters if enabled, otherwise (or failed) appends them to file.
+func routeAbandoned(c chan any) {
+	for item := range c {
+		if dl := activeDeadLetters; dl != nil {
+			err := dl.Add(context.Background(), DeadLetterOf(item))
+			if err == nil {
+				continue
+			}
+			dl.logger.Error("store dead letter failed, append to file.", zap.Error(err))
+		}
+		appendAbandoned(item)
+	}
+}
+
+// Abandon sends item to AbandonedChan without blocking the caller (workers, transactions),
+// appended to the abandoned file synchronously if the channel is full.
+func Abandon(item any) {
+	select {
+	case AbandonedChan <- item:
+	default:
+		zap.L().Warn("abandoned channel is full, item appended to file.")
+		appendAbandoned(item)
+	}
+}
+
+var (
+	abandonedLock sync.Mutex
+	abandonedFile *os.File
+)
+
+// appendAbandoned appends item to the abandoned file, one line per item.
+func appendAbandoned(item any) {
+	raw, err := json.Marshal(abandonedRecord(item))
+	if err != nil {
+		zap.L().Error("marshal abandoned message failed", zap.Error(err))
+		return
+	}
+	abandonedLock.Lock()
+	defer abandonedLock.Unlock()
+	if abandonedFile == nil {
+		abandonedFile, err = os.OpenFile(filepath.Join(core.DefaultFolder, abandonedFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			zap.L().Error("open abandoned file failed, message logged only.", zap.Error(err), zap.ByteString("item", raw))
+			abandonedFile = nil
+			return
+		}
+	}
+	if _, err := fmt.Fprintf(abandonedFile, "%s\t%s\n", time.Now().Format(time.RFC3339), raw); err != nil {
+		zap.L().Error("append abandoned message failed", zap.Error(err), zap.ByteString("item", raw))
+	}
+}
+
+// abandonedRecord of item appended to file, dead letters as maps of the keys DeadLetterOf reads,
+// so lines keep the format of core.AppendToFile (time, tab, JSON of the map).
+func abandonedRecord(item any) any {
+	var dl *DeadLetter
+	switch v := item.(type) {
+	case *DeadLetter:
+		dl = v
+	case DeadLetter:
+		dl = &v
+	default:
+		return item
+	}
+	record := map[string]any{}
+	if dl.Meta != "" {
+		json.Unmarshal([]byte(dl.Meta), &record)
+	}
+	record["topic"] = dl.Topic
+	record["group"] = dl.ConsumerGroup
+	record["consumer"] = dl.Consumer
+	record["ID"] = dl.MessageID
+	record["error"] = dl.Error
+	record["attempts"] = dl.Attempts
+	record["payload"] = dl.Payload
+	return record
+}
+
+// DeadLetterOf converts item of AbandonedChan, well known keys of maps mapped, others kept in Meta.
+func DeadLetterOf(item any) *DeadLetter {
+	switch v := item.(type) {
+	case *DeadLetter:
+		return v
+	case DeadLetter:
+		return &v
+	case map[string]any:
+		dl := &DeadLetter{}
+		meta := map[string]any{}
+		text := func(value any) string {
+			switch s := value.(type) {
+			case string:
+				return s
+			case []byte:
+				return string(s)
+			}
+			raw, _ := json.Marshal(value)
+			return string(raw)
+		}
+		for key, value := range v {
+			switch key {
+			case "topic":
+				dl.Topic = text(value)
+			case "group":
+				dl.ConsumerGroup = text(value)
+			case "consumer":
+				dl.Consumer = text(value)
+			case "ID", "id", "messageID":
+				dl.MessageID = text(value)
+			case "error", "errorCode":
+				dl.Error = truncate(text(value), 1024)
+			case "attempts":
+				dl.Attempts = toInt(value)
+			case "payload", "data", "raw":
+				dl.Payload = text(value)
+			default:
+				meta[key] = value
+			}
+		}
+		if len(meta) > 0 {
+			raw, _ := json.Marshal(meta)
+			dl.Meta = string(raw)
+		}
+		return dl
+	}
+	raw, _ := json.Marshal(item)
+	return &DeadLetter{Payload: string(raw)}
+}
+
+func toInt(value any) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// Add stores the dead letter, publishes EventDeadLetter, and EventDeadLetterAlert if AlertThreshold reached.
+func (dl *DeadLetters) Add(ctx context.Context, item *DeadLetter) error {
+	if dl.DB == nil {
+		return ErrNoDeadLetters
+	}
+	if err := dl.DB.WithContext(ctx).Session(&gorm.Session{SkipHooks: true}).Create(item).Error; err != nil {
+		return err
+	}
+	dl.logger.Warn("dead letter stored", zap.Uint("id", item.ID), zap.String("topic", item.Topic),
+		zap.String("group", item.ConsumerGroup), zap.String("error", item.Error))
+	core.Bus.Publish(EventDeadLetter, item)
+	if dl.Settings.AlertThreshold <= 0 {
+		return nil
+	}
+	now := time.Now()
+	dl.lock.Lock()
+	counts := append(dl.counts[item.Topic], now)
+	for len(counts) > 0 && now.Sub(counts[0]) > dl.Settings.AlertWindow {
+		counts = counts[1:]
+	}
+	alert := len(counts) >= dl.Settings.AlertThreshold
+	if alert {
+		// alert once per threshold reached
+		counts = nil
+	}
+	dl.counts[item.Topic] = counts
+	dl.lock.Unlock()
+	if alert {
+		dl.logger.Error("dead letters reached alert threshold", zap.String("topic", item.Topic),
+			zap.Int("threshold", dl.Settings.AlertThreshold), zap.Duration("window", dl.Settings.AlertWindow))
+		core.Bus.Publish(EventDeadLetterAlert, item.Topic, dl.Settings.AlertThreshold)
+	}
+	return nil
+}
+
+// List returns dead letters, latest first, and the total matched.
+func (dl *DeadLetters) List(ctx context.Context, q *DeadLetterQuery) ([]DeadLetter, int64, error) {
+	if dl.DB == nil {
+		return nil, 0, ErrNoDeadLetters
+	}
+	tx := q.apply(dl.DB.WithContext(ctx).Model(&DeadLetter{}))
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if q.PageSize <= 0 || q.PageSize > 500 {
+		q.PageSize = 50
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	result := make([]DeadLetter, 0)
+	err := tx.Order("id desc").Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&result).Error
+	return result, total, err
+}
+
+// Get returns the dead letter of id, gorm.ErrRecordNotFound if not found.
+func (dl *DeadLetters) Get(ctx context.Context, id uint) (*DeadLetter, error) {
+	if dl.DB == nil {
+		return nil, ErrNoDeadLetters
+	}
+	item := &DeadLetter{}
+	return item, dl.DB.WithContext(ctx).First(item, id).Error
+}
+
+// Replay republishes the original payload of matched dead letters in ID order, to RetryTopic of the failed group,
+// other groups of the topic don't receive them again. dead letters without group (e.g. outbox failures) are
+// published to the topic. returns replayed count, stops at the first failure.
+func (dl *DeadLetters) Replay(ctx context.Context, q *DeadLetterQuery) (int, error) {
+	if dl.DB == nil || dl.Service == nil {
+		return 0, ErrNoDeadLetters
+	}
+	if !q.filtered() && !q.All {
+		return 0, ErrFilterRequired
+	}
+	replayed := 0
+	rows := make([]DeadLetter, 0)
+	err := q.apply(dl.DB.WithContext(ctx)).Order("id").FindInBatches(&rows, deadLetterBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range rows {
+			item := &rows[i]
+			if !json.Valid([]byte(item.Payload)) {
+				return fmt.Errorf("%w, dead letter %d", ErrInvalidPayload, item.ID)
+			}
+			topic := item.Topic
+			if item.ConsumerGroup != "" {
+				topic = RetryTopic(item.Topic, item.ConsumerGroup)
+			}
+			if err := dl.Service.Pub(ctx, topic, json.RawMessage(item.Payload)); err != nil {
+				return err
+			}
+			now := time.Now()
+			err := dl.DB.WithContext(ctx).Model(item).Updates(map[string]any{"replays": item.Replays + 1, "replayed_at": now}).Error
+			if err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	}).Error
+	dl.logger.Info("dead letters replayed", zap.Int("replayed", replayed), zap.Error(err))
+	return replayed, err
+}
+
+// Purge deletes matched dead letters, returns deleted count.
+func (dl *DeadLetters) Purge(ctx context.Context, q *DeadLetterQuery) (int64, error) {
+	if dl.DB == nil {
+		return 0, ErrNoDeadLetters
+	}
+	if !q.filtered() && !q.All {
+		return 0, ErrFilterRequired
+	}
+	tx := q.apply(dl.DB.WithContext(ctx).Session(&gorm.Session{AllowGlobalUpdate: true})).Delete(&DeadLetter{})
+	dl.logger.Info("dead letters purged", zap.Int64("deleted", tx.RowsAffected), zap.Error(tx.Error))
+	return tx.RowsAffected, tx.Error
+}
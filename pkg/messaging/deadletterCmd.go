@@ -0,0 +1,111 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+)
+
+var deadLetterOptions = DeadLetterQuery{}
+
+func invokeDeadLetters(fn func(dl *DeadLetters) error) error {
+	return core.GetContainer().Invoke(func(dl *DeadLetters) error {
+		if dl.DB == nil {
+			return ErrNoDeadLetters
+		}
+		return fn(dl)
+	})
+}
+
+// DeadLetterCmd manages dead letters: list, show, replay & purge.
+var DeadLetterCmd = &cobra.Command{
+	Use:   "deadletters",
+	Short: "list, inspect, replay & purge dead letters",
+}
+
+var deadLetterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list dead letters, latest first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeDeadLetters(func(dl *DeadLetters) error {
+			items, total, err := dl.List(context.Background(), &deadLetterOptions)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCREATED AT\tTOPIC\tGROUP\tATTEMPTS\tREPLAYS\tERROR")
+			for _, item := range items {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\n", item.ID, item.CreatedAt.Format("2006-01-02 15:04:05"),
+					item.Topic, item.ConsumerGroup, item.Attempts, item.Replays, truncate(item.Error, 80))
+			}
+			fmt.Fprintf(w, "%d of %d\n", len(items), total)
+			return w.Flush()
+		})
+	},
+}
+
+var deadLetterShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "show dead letter with the original payload",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		return invokeDeadLetters(func(dl *DeadLetters) error {
+			item, err := dl.Get(context.Background(), uint(id))
+			if err != nil {
+				return err
+			}
+			raw, err := json.MarshalIndent(item, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			return nil
+		})
+	},
+}
+
+var deadLetterReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "replay matched dead letters to their topics, filter or --all required",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeDeadLetters(func(dl *DeadLetters) error {
+			count, err := dl.Replay(context.Background(), &deadLetterOptions)
+			fmt.Println("replayed", count)
+			return err
+		})
+	},
+}
+
+var deadLetterPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "delete matched dead letters, filter or --all required",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeDeadLetters(func(dl *DeadLetters) error {
+			count, err := dl.Purge(context.Background(), &deadLetterOptions)
+			fmt.Println("purged", count)
+			return err
+		})
+	},
+}
+
+func init() {
+	flags := DeadLetterCmd.PersistentFlags()
+	flags.UintSliceVar(&deadLetterOptions.IDs, "id", nil, "dead letter IDs")
+	flags.StringVarP(&deadLetterOptions.Topic, "topic", "t", "", "topic")
+	flags.StringVarP(&deadLetterOptions.Group, "group", "g", "", "consumer group")
+	flags.StringVarP(&deadLetterOptions.Error, "error", "e", "", "error contains")
+	deadLetterListCmd.Flags().IntVarP(&deadLetterOptions.PageSize, "size", "n", 50, "rows to list")
+	deadLetterReplayCmd.Flags().BoolVar(&deadLetterOptions.All, "all", false, "replay all dead letters if no filter")
+	deadLetterPurgeCmd.Flags().BoolVar(&deadLetterOptions.All, "all", false, "purge all dead letters if no filter")
+	DeadLetterCmd.AddCommand(deadLetterListCmd, deadLetterShowCmd, deadLetterReplayCmd, deadLetterPurgeCmd)
+}
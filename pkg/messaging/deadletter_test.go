@@ -0,0 +1,127 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeBroker delivers to every group subscribed to the topic, groups subscribe their RetryTopic as Sub does.
+type fakeBroker struct {
+	subs     map[string][]string // topic -> groups
+	received map[string][]string // group -> payloads
+}
+
+func (f *fakeBroker) Pub(ctx context.Context, topic string, payload any) error {
+	raw, _ := json.Marshal(payload)
+	for _, group := range f.subs[topic] {
+		f.received[group] = append(f.received[group], string(raw))
+	}
+	return nil
+}
+
+func (f *fakeBroker) Sub(ctx context.Context, topic, group string, processor Processor) error {
+	for _, stream := range []string{topic, RetryTopic(topic, group)} {
+		f.subs[stream] = append(f.subs[stream], group)
+	}
+	return nil
+}
+
+func TestDeadLetterReplayToGroup(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&DeadLetter{}))
+	broker := &fakeBroker{subs: map[string][]string{}, received: map[string][]string{}}
+	ctx := context.Background()
+	assert.NoError(t, broker.Sub(ctx, "orders", "billing", nil))
+	assert.NoError(t, broker.Sub(ctx, "orders", "report", nil))
+
+	dl := &DeadLetters{Settings: DefaultDeadLetterSettings(), DB: db, Service: broker, logger: zap.NewNop(), counts: map[string][]time.Time{}}
+	assert.NoError(t, dl.Add(ctx, &DeadLetter{Topic: "orders", ConsumerGroup: "billing", Error: "Timeout", Payload: `{"id":1}`}))
+	count, err := dl.Replay(ctx, &DeadLetterQuery{Group: "billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{`{"id":1}`}, broker.received["billing"])
+	assert.Empty(t, broker.received["report"], "groups handled the message already don't receive the replay")
+	assert.Equal(t, "orders:retry:billing", RetryTopic("orders:retry:billing", "billing"))
+}
+
+func TestDeadLetters(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&DeadLetter{}))
+
+	item := DeadLetterOf(map[string]any{
+		"topic":    "orders",
+		"group":    "billing",
+		"error":    "ValidationError",
+		"attempts": 3,
+		"ID":       "1-0",
+		"raw":      []byte(`{"Key":"order","Payload":"1"}`),
+		"tenant":   "t1",
+	})
+	assert.Equal(t, "orders", item.Topic)
+	assert.Equal(t, "billing", item.ConsumerGroup)
+	assert.Equal(t, "1-0", item.MessageID)
+	assert.Equal(t, 3, item.Attempts)
+	assert.Equal(t, `{"tenant":"t1"}`, item.Meta)
+	record := abandonedRecord(item).(map[string]any)
+	assert.Equal(t, "orders", record["topic"], "file lines keep the map format")
+	assert.Equal(t, "t1", record["tenant"])
+	assert.Equal(t, item, DeadLetterOf(record))
+
+	fake := &fakeMessaging{failed: map[string]bool{}}
+	settings := DefaultDeadLetterSettings()
+	settings.AlertThreshold = 2
+	dl := &DeadLetters{Settings: settings, DB: db, Service: fake, logger: zap.NewNop(), counts: map[string][]time.Time{}}
+
+	alerts := make(chan string, 10)
+	alert := func(topic string, threshold int) { alerts <- topic }
+	assert.NoError(t, core.Bus.Subscribe(EventDeadLetterAlert, alert))
+	defer core.Bus.Unsubscribe(EventDeadLetterAlert, alert)
+
+	ctx := context.Background()
+	assert.NoError(t, dl.Add(ctx, item))
+	assert.Empty(t, alerts)
+	raw, _ := json.Marshal(GormPayload{Key: "invoice", Payload: "2"})
+	assert.NoError(t, dl.Add(ctx, &DeadLetter{Topic: "orders", ConsumerGroup: "report", Error: "Timeout", Payload: string(raw)}))
+	assert.Equal(t, "orders", <-alerts, "threshold reached in window")
+	assert.NoError(t, dl.Add(ctx, &DeadLetter{Topic: "audit", Error: "Timeout", Payload: "not json"}))
+
+	items, total, err := dl.List(ctx, &DeadLetterQuery{Topic: "orders"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, "report", items[0].ConsumerGroup, "latest first")
+
+	_, err = dl.Replay(ctx, &DeadLetterQuery{})
+	assert.ErrorIs(t, err, ErrFilterRequired)
+	count, err := dl.Replay(ctx, &DeadLetterQuery{Group: "billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, fake.payload, 1)
+	assert.Equal(t, "order", fake.payload[0].Key)
+	_, err = dl.Replay(ctx, &DeadLetterQuery{Topic: "audit"})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+
+	replayed := true
+	items, _, err = dl.List(ctx, &DeadLetterQuery{Replayed: &replayed})
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, 1, items[0].Replays)
+
+	_, err = dl.Purge(ctx, &DeadLetterQuery{})
+	assert.ErrorIs(t, err, ErrFilterRequired)
+	deleted, err := dl.Purge(ctx, &DeadLetterQuery{Error: "Timeout"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	deleted, err = dl.Purge(ctx, &DeadLetterQuery{All: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
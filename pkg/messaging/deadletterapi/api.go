@@ -0,0 +1,177 @@
+// Package deadletterapi mounts the API of messaging dead letters, guarded by auth.AuthService.
+// import it to enable the API, messaging.deadLetters.api & enabled required.
+package deadletterapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/messaging"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DeadLetterPage of list result
+type DeadLetterPage struct {
+	Total int64                  `json:"total"`
+	Items []messaging.DeadLetter `json:"items"`
+}
+
+// DeadLetterResult of replay & purge
+type DeadLetterResult struct {
+	Count int64 `json:"count"`
+}
+
+type controller struct {
+	dl     *messaging.DeadLetters
+	logger *zap.Logger
+}
+
+func init() {
+	ginshared.GetContainer().Provide(initDeadLetterAPI, ginshared.ControllerOptions)
+}
+
+// initDeadLetterAPI enables list, inspect, replay & purge endpoints if messaging.deadLetters.api,
+// guarded by API key with messaging.deadLetters.perm. not mounted without AuthService.
+func initDeadLetterAPI(router *gin.Engine, dl *messaging.DeadLetters, service core.OptionalParam[*auth.AuthService], logger *zap.Logger) ginshared.DiController {
+	if !dl.Settings.Enabled || !dl.Settings.API || dl.DB == nil {
+		return nil
+	}
+	if service.P == nil {
+		logger.Warn("dead letter API requires AuthService, not mounted.")
+		return nil
+	}
+	ctl := &controller{dl: dl, logger: logger}
+	base := viper.GetString("baseUri") + dl.Settings.Base
+	group := router.Group(base, service.P.Auth, auth.Require(dl.Settings.Perm))
+	doc := func(op *openapi.Operation) *openapi.Operation {
+		op.Tags = []string{"deadletters"}
+		return op
+	}
+	openapi.GET(group, "", doc(&openapi.Operation{
+		Summary: "list dead letters, latest first",
+		Params: []openapi.Param{
+			{Name: "id", Type: "integer"}, {Name: "topic"}, {Name: "group"}, {Name: "consumer"},
+			{Name: "error", Description: "contains"},
+			{Name: "from", Description: "RFC3339"}, {Name: "to", Description: "RFC3339"},
+			{Name: "replayed", Type: "boolean"},
+			{Name: "page", Type: "integer"}, {Name: "pageSize", Type: "integer"},
+		},
+		Response: DeadLetterPage{},
+	}), ctl.list)
+	openapi.GET(group, ":id", doc(&openapi.Operation{
+		Summary:  "inspect dead letter with the original payload",
+		Response: messaging.DeadLetter{},
+	}), ctl.get)
+	openapi.POST(group, ":id/replay", doc(&openapi.Operation{
+		Summary:  "replay dead letter to its topic",
+		Response: DeadLetterResult{},
+	}), ctl.replayOne)
+	openapi.POST(group, "replay", doc(&openapi.Operation{
+		Summary:  "replay matched dead letters, filter or all required",
+		Request:  messaging.DeadLetterQuery{},
+		Response: DeadLetterResult{},
+	}), ctl.replay)
+	openapi.POST(group, "purge", doc(&openapi.Operation{
+		Summary:  "delete matched dead letters, filter or all required",
+		Request:  messaging.DeadLetterQuery{},
+		Response: DeadLetterResult{},
+	}), ctl.purge)
+	logger.Info("dead letter API enabled", zap.String("base", base))
+	return ctl
+}
+
+func (ctl *controller) respondErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, messaging.ErrFilterRequired), errors.Is(err, messaging.ErrInvalidPayload):
+		ginshared.ReportBadrequest(c, err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, err.Error())
+	default:
+		ginshared.RespondErr(c, err, ctl.logger)
+	}
+}
+
+func (ctl *controller) list(c *gin.Context) {
+	q := &messaging.DeadLetterQuery{}
+	if err := c.ShouldBindQuery(q); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	items, total, err := ctl.dl.List(c, q)
+	if err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	ginshared.RespondOK(c, DeadLetterPage{Total: total, Items: items})
+}
+
+func (ctl *controller) get(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	item, err := ctl.dl.Get(c, uint(id))
+	if err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	ginshared.RespondOK(c, item)
+}
+
+func (ctl *controller) replayOne(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	if _, err := ctl.dl.Get(c, uint(id)); err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	count, err := ctl.dl.Replay(c, &messaging.DeadLetterQuery{IDs: []uint{uint(id)}})
+	if err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	ctl.logger.Info("dead letter replayed", zap.Uint64("id", id), zap.String("user", c.GetString("user")))
+	ginshared.RespondOK(c, DeadLetterResult{Count: int64(count)})
+}
+
+func (ctl *controller) replay(c *gin.Context) {
+	q := &messaging.DeadLetterQuery{}
+	if err := c.ShouldBindJSON(q); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	count, err := ctl.dl.Replay(c, q)
+	if err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	ctl.logger.Info("dead letters replayed by API", zap.Int("count", count), zap.String("user", c.GetString("user")))
+	ginshared.RespondOK(c, DeadLetterResult{Count: int64(count)})
+}
+
+func (ctl *controller) purge(c *gin.Context) {
+	q := &messaging.DeadLetterQuery{}
+	if err := c.ShouldBindJSON(q); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	count, err := ctl.dl.Purge(c, q)
+	if err != nil {
+		ctl.respondErr(c, err)
+		return
+	}
+	ctl.logger.Info("dead letters purged by API", zap.Int64("count", count), zap.String("user", c.GetString("user")))
+	ginshared.RespondOK(c, DeadLetterResult{Count: count})
+}
@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/techquest-tech/gin-shared/pkg/core"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// DefaultAbandonedBuffer of AbandonedChan, senders not blocked by slow dead letter storage.
+const DefaultAbandonedBuffer = 1000
+
 var (
 	chSender            chan any
 	ms                  MessagingService
@@ -20,8 +24,8 @@ var (
 
 func init() {
 
-	AbandonedChan = make(chan any)
-	go core.AppendToFile(AbandonedChan, "receivedAbandoned.log")
+	AbandonedChan = make(chan any, DefaultAbandonedBuffer)
+	go routeAbandoned(AbandonedChan)
 
 	if GormCallbackEnabled {
 		core.ProvideStartup(func(service MessagingService, db *gorm.DB) core.Startup {
@@ -64,16 +68,39 @@ func GetPayloadID(payload any) (uint, bool) {
 	return 0, false
 }
 
-func pubGormAction(ctx context.Context, payload any, action GormAction) error {
-	if !GormCallbackEnabled {
-		return nil
+// RegisteredKey returns the key if payload type registered by Reg
+func RegisteredKey(payload any) (string, bool) {
+	if payload == nil {
+		return "", false
 	}
-
 	tt := reflect.TypeOf(payload)
 	key := tt.String()
 	key = strings.TrimLeft(key, "*")
 
-	if _, ok := m[key]; !ok {
+	_, ok := m[key]
+	return key, ok
+}
+
+// PubGormPayload pubs the entity changes to DefaultGormToipc, not registered entity will be ignored.
+func PubGormPayload(ctx context.Context, service MessagingService, payload any, action GormAction) error {
+	key, ok := RegisteredKey(payload)
+	if !ok {
+		return nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return service.Pub(ctx, DefaultGormToipc, GormPayload{Key: key, Payload: string(raw), Action: action, SynctAt: time.Now(), Source: syncSource()})
+}
+
+func pubGormAction(ctx context.Context, payload any, action GormAction) error {
+	if !GormCallbackEnabled {
+		return nil
+	}
+
+	key, ok := RegisteredKey(payload)
+	if !ok {
 		zap.L().Info("not registered key, ignored.", zap.String("key", key))
 		return nil
 	}
@@ -96,7 +123,7 @@ func pubGormAction(ctx context.Context, payload any, action GormAction) error {
 		logger.Error("marshal payload failed.", zap.Error(err))
 		return err
 	}
-	ms.Pub(ctx, DefaultGormToipc, GormPayload{Key: key, Payload: string(raw), Action: action})
+	ms.Pub(ctx, DefaultGormToipc, GormPayload{Key: key, Payload: string(raw), Action: action, SynctAt: time.Now(), Source: syncSource()})
 	logger.Debug("callback done.")
 	return nil
 }
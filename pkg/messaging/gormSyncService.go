@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/samber/lo"
+	"github.com/spf13/viper"
 	"github.com/techquest-tech/gin-shared/pkg/core"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -21,11 +22,16 @@ var (
 )
 
 func NewGormObjSyncService(ms MessagingService, logger *zap.Logger, db *gorm.DB) *GormObjSyncService {
-	return &GormObjSyncService{
+	ss := &GormObjSyncService{
 		MessageService: ms,
 		DB:             db,
 		Logger:         logger,
+		Strategy:       LastWriterWins,
 	}
+	if sub := viper.Sub("messaging.sync"); sub != nil {
+		sub.Unmarshal(ss)
+	}
+	return ss
 }
 
 type Sharding func(tx *gorm.DB, key string, payload any) (tablename string, err error)
@@ -38,6 +44,11 @@ type GormObjSyncService struct {
 	Logger         *zap.Logger
 	Sharding       Sharding
 	Dispath        DispathFn
+	Strategy       ConflictStrategy // config messaging.sync.strategy, lww by default
+	Priorities     map[string]int   // priority of sources for SourcePriority, 0 if not listed
+	Resolver       ConflictResolver // for CustomResolver
+	VersionField   string           // Version or UpdatedAt if empty, entities without version always saved
+	Watermarks     bool             // config messaging.sync.watermarks, keeps SyncWatermark of applied versions
 }
 
 var cfg = &gorm.Session{
@@ -47,7 +58,11 @@ var cfg = &gorm.Session{
 }
 
 func (ss *GormObjSyncService) Abandoned(kp *GormPayload, errCode, topic, consumer string) {
-	AbandonedChan <- map[string]any{
+	Abandon(abandonedItem(kp, errCode, topic, consumer))
+}
+
+func abandonedItem(kp *GormPayload, errCode, topic, consumer string) map[string]any {
+	return map[string]any{
 		"error":    errCode,
 		"topic":    topic,
 		"consumer": consumer,
@@ -57,72 +72,165 @@ func (ss *GormObjSyncService) Abandoned(kp *GormPayload, errCode, topic, consume
 	}
 }
 
+// conflictItem reports the skipped change with the reason & versions.
+func conflictItem(kp *GormPayload, c *Conflict, reason, topic, consumer string) map[string]any {
+	return map[string]any{
+		"error":         "Conflict",
+		"reason":        reason,
+		"topic":         topic,
+		"consumer":      consumer,
+		"key":           c.Key,
+		"action":        c.Action,
+		"source":        c.Source,
+		"version":       c.Version,
+		"current":       c.Current,
+		"currentSource": c.CurrentSource,
+		"data":          kp.Payload,
+	}
+}
+
+// skipped collects reports of messages skipped in a transaction, abandoned only after commit.
+// the messages are received again if rolled back, so are their reports. nil abandons at once.
+type skipped []any
+
+func (s *skipped) add(item any) {
+	if s == nil {
+		Abandon(item)
+		return
+	}
+	*s = append(*s, item)
+}
+
+func (s skipped) abandon() {
+	for _, item := range s {
+		Abandon(item)
+	}
+}
+
+// ProcessGormObject saves or deletes the entity in a transaction, stale changes skipped by Strategy.
 func (ss *GormObjSyncService) ProcessGormObject(ctx context.Context, topic, consumer string, kp *GormPayload, payload any, tt reflect.Type) error {
+	s := skipped{}
+	err := ss.DB.Session(cfg).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return ss.apply(ctx, tx, topic, consumer, kp, payload, tt, &s)
+	})
+	if err == nil {
+		s.abandon()
+	}
+	return err
+}
+
+// apply writes the change in tx, failures abandoned at once, skipped changes added to s.
+func (ss *GormObjSyncService) apply(ctx context.Context, tx *gorm.DB, topic, consumer string, kp *GormPayload, payload any, tt reflect.Type, s *skipped) error {
 	l := ss.Logger.With(zap.String("key", kp.Key))
-	tx := ss.DB.Session(cfg)
 
 	id, hasID := GetPayloadID(payload)
 
+	table := ""
 	if ss.Sharding != nil {
-		tablename, err := ss.Sharding(tx, kp.Key, payload)
+		var err error
+		table, err = ss.Sharding(tx, kp.Key, payload)
 		if err != nil {
 			ss.Abandoned(kp, "ShardingFailed:"+err.Error(), topic, consumer)
 			return err
 		}
-		tx = tx.Table(tablename)
-		l.Debug("sharding table for payload", zap.String("table", tablename))
+		l.Debug("sharding table for payload", zap.String("table", table))
+	}
+	session := func() *gorm.DB {
+		s := tx.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+		if table != "" {
+			s = s.Table(table)
+		}
+		return s
 	}
 
 	switch kp.Action {
-	case GormActionSave, "":
-		err := tx.Save(payload).Error
+	case GormActionSave, "", GormActionDelete:
+	default:
+		ss.Logger.Info("unknown action.", zap.String("action", string(kp.Action)))
+		// return errors.ErrUnsupported
+		s.add(abandonedItem(kp, "UnknownAction", topic, consumer))
+		return nil
+	}
+	deleted := kp.Action == GormActionDelete
+	if deleted && hasID && id == 0 {
+		l.Warn("empty ID for delete action, just ignore it.", zap.Any("payload", payload))
+		// DroppedPayload.Push(&FailedPayload{Payload: payload, Key: kp.Key, FailedCode: "empty_id"})
+		s.add(abandonedItem(kp, "DeletedOnEmptyID", topic, consumer))
+		return nil
+	}
+
+	var wm *SyncWatermark
+	if version, ok := versionOf(payload, ss.VersionField); ok {
+		key := kp.Key
+		if hasID {
+			key = fmt.Sprintf("%s:%d", kp.Key, id)
+		}
+		current, err := ss.current(tx, table, key, id, tt)
 		if err != nil {
-			l.Error("save object failed.", zap.Error(err), zap.String("data", tt.Name()), zap.Any("payload", payload))
-			ss.Abandoned(kp, "SaveFailed:"+err.Error(), topic, consumer)
 			return err
 		}
-		l.Info("save object done.", zap.String("data", tt.Name()), zap.Uint("id", id))
-	case GormActionDelete:
-		if hasID && id == 0 {
-			l.Warn("empty ID for delete action, just ignore it.", zap.Any("payload", payload))
-			// DroppedPayload.Push(&FailedPayload{Payload: payload, Key: kp.Key, FailedCode: "empty_id"})
-			ss.Abandoned(kp, "DeletedOnEmptyID", topic, consumer)
-			return nil
+		if current != nil {
+			c := &Conflict{Key: key, Action: kp.Action, Source: kp.Source, Version: version,
+				Current: current.Version, CurrentSource: current.Source, Deleted: current.Deleted, Payload: payload}
+			if apply, reason := ss.resolve(ctx, c); !apply {
+				l.Warn("conflicted change skipped.", zap.String("reason", reason), zap.Uint("id", id))
+				s.add(conflictItem(kp, c, reason, topic, consumer))
+				return nil
+			}
 		}
-		err := tx.Delete(payload).Error
+		wm = &SyncWatermark{EntityKey: key, Version: version, Source: kp.Source, Deleted: deleted}
+	}
+
+	if deleted {
+		err := session().Delete(payload).Error
 		if err != nil {
 			l.Error("delete object failed.", zap.Error(err), zap.String("data", tt.Name()), zap.Any("payload", payload))
 			ss.Abandoned(kp, "DeleteFailed:"+err.Error(), topic, consumer)
 			return err
 		}
 		l.Info("delete object done.", zap.String("data", tt.Name()), zap.Uint("id", id))
-	default:
-		ss.Logger.Info("unknown action.", zap.String("action", string(kp.Action)))
-		// return errors.ErrUnsupported
-		ss.Abandoned(kp, "UnknownAction", topic, consumer)
+	} else {
+		err := session().Save(payload).Error
+		if err != nil {
+			l.Error("save object failed.", zap.Error(err), zap.String("data", tt.Name()), zap.Any("payload", payload))
+			ss.Abandoned(kp, "SaveFailed:"+err.Error(), topic, consumer)
+			return err
+		}
+		l.Info("save object done.", zap.String("data", tt.Name()), zap.Uint("id", id))
+	}
+	if wm != nil {
+		return ss.watermark(ctx, tx, wm)
 	}
-
 	return nil
 }
 
-func (ss *GormObjSyncService) ReceiveGormObjectSaved(ctx context.Context, topic, consumer string, raw []byte) error {
+// decode returns the registered entity of raw message, nil payload if abandoned (added to s).
+func (ss *GormObjSyncService) decode(raw []byte, topic, consumer string, s *skipped) (*GormPayload, any, reflect.Type, error) {
 	kp, err := ToKeyAndPayload(raw)
 	if err != nil {
 		ss.Logger.Error("unexpected payload", zap.Error(err))
-		return err
+		return nil, nil, nil, err
 	}
 	l := ss.Logger.With(zap.String("key", kp.Key))
 	tt, ok := m[kp.Key]
 	if !ok {
 		l.Error("received object failed. unknown key, just drop it.", zap.String("key", kp.Key))
-		ss.Abandoned(kp, "UnknownKey", topic, consumer)
-		return nil
+		s.add(abandonedItem(kp, "UnknownKey", topic, consumer))
+		return kp, nil, nil, nil
 	}
 	payload := reflect.New(tt).Interface()
 	err = json.Unmarshal([]byte(kp.Payload), payload)
 	if err != nil {
 		l.Error("unexpected payload,", zap.Error(err))
-		ss.Abandoned(kp, err.Error(), topic, consumer)
+		s.add(abandonedItem(kp, err.Error(), topic, consumer))
+		return kp, nil, tt, err
+	}
+	return kp, payload, tt, nil
+}
+
+func (ss *GormObjSyncService) ReceiveGormObjectSaved(ctx context.Context, topic, consumer string, raw []byte) error {
+	kp, payload, tt, err := ss.decode(raw, topic, consumer, nil)
+	if err != nil || payload == nil {
 		return err
 	}
 	fn := ss.Dispath
@@ -132,6 +240,29 @@ func (ss *GormObjSyncService) ReceiveGormObjectSaved(ctx context.Context, topic,
 	return fn(ctx, topic, consumer, kp, payload, tt)
 }
 
+// ApplyBatch applies received changes in a single transaction, all rolled back if any failed to write.
+// unknown or malformed messages are skipped, Dispath is not used. skipped messages & conflicts are
+// abandoned once committed, failures at once.
+func (ss *GormObjSyncService) ApplyBatch(ctx context.Context, topic, consumer string, raws [][]byte) error {
+	s := skipped{}
+	err := ss.DB.Session(cfg).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, raw := range raws {
+			kp, payload, tt, _ := ss.decode(raw, topic, consumer, &s)
+			if payload == nil {
+				continue
+			}
+			if err := ss.apply(ctx, tx, topic, consumer, kp, payload, tt, &s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		s.abandon()
+	}
+	return err
+}
+
 type GormAction string
 
 const (
@@ -144,6 +275,7 @@ type GormPayload struct {
 	Action  GormAction
 	Payload string
 	SynctAt time.Time
+	Source  string `json:",omitempty"` // SyncSource of the publisher
 }
 
 func ToKeyAndPayload(raw []byte) (*GormPayload, error) {
@@ -198,6 +330,33 @@ func allSyncKey() []string {
 	return allKeys
 }
 
+// republish enqueues entities to outbox if started, so they are ordered with live changes, otherwise pubs directly.
+func republish(ctx context.Context, db *gorm.DB, entities []any, action GormAction) error {
+	if activeOutbox == nil {
+		for _, item := range entities {
+			if err := PubGormPayload(ctx, ms, item, action); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	msgs := make([]*OutboxMessage, 0, len(entities))
+	for _, item := range entities {
+		msg, ok, err := entityMessage(item, action)
+		if err != nil {
+			return err
+		}
+		if ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true}).CreateInBatches(msgs, 100).Error
+}
+
+// PubEntitiesSince republishes registered entities updated or deleted in [since, to], a repair tool of outbox & sync.
 func PubEntitiesSince(ctx context.Context, keys []string, since time.Time, to time.Time) error {
 	return core.GetContainer().Invoke(func(db *gorm.DB, logger *zap.Logger, msService MessagingService) error {
 		if ms == nil {
@@ -238,8 +397,8 @@ func PubEntitiesSince(ctx context.Context, keys []string, since time.Time, to ti
 						action = GormActionDelete
 					}
 
-					for _, item := range rr {
-						pubGormAction(ctx, item, action)
+					if err := republish(ctx, db, rr, action); err != nil {
+						return err
 					}
 
 					processed += len(rr)
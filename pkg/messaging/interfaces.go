@@ -9,3 +9,10 @@ type MessagingService interface {
 }
 
 type Processor func(ctx context.Context, topic, consumer string, payload []byte) error
+
+// Broadcaster fans out payloads to every node listening, not durable, missed while the node is down.
+// implemented by the Redis implementation with pub/sub, no consumer group created per node.
+type Broadcaster interface {
+	Broadcast(ctx context.Context, channel string, payload any) error
+	Listen(ctx context.Context, channel string, fn func(ctx context.Context, payload []byte)) error
+}
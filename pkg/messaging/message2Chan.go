@@ -25,11 +25,11 @@ func (r *MessagingAdaptor[T]) Adaptor(ctx context.Context, topic, consumer strin
 	if err := json.Unmarshal(payload, &tr); err != nil {
 		logger.Error("unexpected tracing details format", zap.ByteString("payload", payload), zap.Error(err))
 
-		AbandonedChan <- map[string]any{
+		Abandon(map[string]any{
 			"topic": topic,
 			"raw":   payload,
 			"error": err.Error(),
-		}
+		})
 		return nil
 	}
 	r.ChanAdaaptor.Push(tr)
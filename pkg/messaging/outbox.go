@@ -0,0 +1,327 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"github.com/techquest-tech/gin-shared/pkg/schedule"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed" // MaxAttempts reached, sent to AbandonedChan by Abandon
+)
+
+// OutboxSettings of the transactional outbox, config key messaging.outbox
+type OutboxSettings struct {
+	Enabled           bool
+	Interval          time.Duration // relay polling interval
+	Batch             int           // rows per relay round
+	MaxAttempts       int           // 0 retries forever
+	MinBackoff        time.Duration // doubled per attempt
+	MaxBackoff        time.Duration
+	Retention         string // sent rows kept, e.g. 7d, empty keeps forever
+	RetentionSchedule string
+}
+
+func DefaultOutboxSettings() *OutboxSettings {
+	return &OutboxSettings{
+		Interval:          time.Second,
+		Batch:             100,
+		MinBackoff:        time.Second,
+		MaxBackoff:        10 * time.Minute,
+		Retention:         "7d",
+		RetentionSchedule: "30 3 * * *",
+	}
+}
+
+// backoff before the next attempt, MinBackoff doubled per failed attempt up to MaxBackoff.
+func (s *OutboxSettings) backoff(attempts int) time.Duration {
+	return backoff(s.MinBackoff, s.MaxBackoff, attempts)
+}
+
+// OutboxMessage is a message written in the transaction of the change, published by the relay.
+type OutboxMessage struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Topic     string    `gorm:"size:128"`
+	Key       string    `gorm:"size:255;index"` // messages of the same key published in order, e.g. entity & ID
+	Payload   string    `gorm:"type:text"`      // JSON
+	Status    string    `gorm:"size:16;index"`
+	Attempts  int
+	NextAt    time.Time
+	SentAt    *time.Time `gorm:"index"`
+	LastError string     `gorm:"size:1024"`
+}
+
+// Outbox records entity changes in the same transaction, the leader relays them to MessagingService.
+type Outbox struct {
+	Settings *OutboxSettings
+	DB       *gorm.DB
+	Service  MessagingService
+	logger   *zap.Logger
+}
+
+type OutboxParam struct {
+	dig.In
+	DB      *gorm.DB         `optional:"true"`
+	Service MessagingService `optional:"true"`
+	Logger  *zap.Logger
+}
+
+func NewOutbox(p OutboxParam) *Outbox {
+	settings := DefaultOutboxSettings()
+	if sub := viper.Sub("messaging.outbox"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return &Outbox{Settings: settings, DB: p.DB, Service: p.Service, logger: p.Logger}
+}
+
+// activeOutbox is set when outbox started, PubEntitiesSince enqueues to it.
+var activeOutbox *Outbox
+
+// OutboxActive returns true if changes of registered entities are published by the outbox,
+// direct publishing (e.g. PubGormPayload after commit) should be skipped to avoid duplicates.
+func OutboxActive() bool {
+	return activeOutbox != nil
+}
+
+func init() {
+	orm.AppendEntityIf("messaging.outbox.enabled", &OutboxMessage{})
+	core.Provide(NewOutbox)
+	core.ProvideStartup(startOutbox)
+}
+
+// startOutbox registers DB callbacks, the relay & retention job (messaging.outbox.retention).
+func startOutbox(o *Outbox, cs *schedule.CleanupService, logger *zap.Logger) (core.Startup, error) {
+	if !o.Settings.Enabled || o.DB == nil {
+		logger.Info("messaging outbox is disabled.")
+		return nil, nil
+	}
+	if o.Service == nil {
+		return nil, fmt.Errorf("messaging outbox requires MessagingService")
+	}
+	if GormCallbackEnabled {
+		// legacy callbacks publish the same changes directly.
+		return nil, fmt.Errorf("messaging outbox can't be enabled with GormCallbackEnabled")
+	}
+	if err := o.RegisterCallbacks(o.DB); err != nil {
+		return nil, err
+	}
+	activeOutbox = o
+	o.start()
+	if o.Settings.Retention == "" {
+		return nil, nil
+	}
+	stmt := &gorm.Statement{DB: o.DB}
+	if err := stmt.Parse(&OutboxMessage{}); err != nil {
+		return nil, err
+	}
+	req := cs.GetDefaultRequest()
+	req.Tables = []string{stmt.Schema.Table}
+	req.PrefixIncluded = true
+	req.DeletedField = "sent_at"
+	req.Duration = o.Settings.Retention
+	err := schedule.CreateSchedule("messaging_outbox_retention", o.Settings.RetentionSchedule, func() {
+		if err := cs.Cleanup(req); err != nil {
+			logger.Error("outbox retention failed", zap.Error(err))
+		}
+	})
+	return nil, err
+}
+
+// RegisterCallbacks records changes of registered entities (see Reg) to outbox, in the transaction of the change.
+// changes are written without transaction if SkipDefaultTransaction.
+func (o *Outbox) RegisterCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:after_create").Register("messaging:outbox_create", o.callback(GormActionSave)),
+		cb.Update().After("gorm:after_update").Register("messaging:outbox_update", o.callback(GormActionSave)),
+		cb.Delete().After("gorm:after_delete").Register("messaging:outbox_delete", o.callback(GormActionDelete)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	o.logger.Info("messaging outbox callbacks registered")
+	return nil
+}
+
+// callback enqueues each entity of the statement, errors roll back the change.
+func (o *Outbox) callback(action GormAction) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || db.Statement.SkipHooks || db.Statement.RowsAffected == 0 {
+			return
+		}
+		rv := reflect.Indirect(db.Statement.ReflectValue)
+		payloads := []any{}
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				payloads = append(payloads, rv.Index(i).Interface())
+			}
+		case reflect.Struct:
+			payloads = append(payloads, rv.Interface())
+		}
+		for _, payload := range payloads {
+			if err := EnqueueEntity(db, payload, action); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+// entityMessage of the registered entity, false if not registered or without ID.
+func entityMessage(payload any, action GormAction) (*OutboxMessage, bool, error) {
+	key, ok := RegisteredKey(payload)
+	if !ok {
+		return nil, false, nil
+	}
+	id, hasID := GetPayloadID(payload)
+	if hasID && id == 0 {
+		zap.L().Warn("empty ID, outbox skipped", zap.String("key", key), zap.String("action", string(action)))
+		return nil, false, nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	msg, err := json.Marshal(GormPayload{Key: key, Action: action, Payload: string(raw), SynctAt: time.Now(), Source: syncSource()})
+	if err != nil {
+		return nil, false, err
+	}
+	if hasID {
+		key = fmt.Sprintf("%s:%d", key, id)
+	}
+	return &OutboxMessage{Topic: DefaultGormToipc, Key: key, Payload: string(msg), Status: OutboxPending, NextAt: time.Now()}, true, nil
+}
+
+// EnqueueEntity writes the change of registered entity to outbox by connection of tx, not registered entity ignored.
+func EnqueueEntity(tx *gorm.DB, payload any, action GormAction) error {
+	msg, ok, err := entityMessage(payload, action)
+	if err != nil || !ok {
+		return err
+	}
+	return tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(msg).Error
+}
+
+// Enqueue writes the payload to outbox by connection of tx, published to topic after commit, in order of key.
+func Enqueue(tx *gorm.DB, topic, key string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := &OutboxMessage{Topic: topic, Key: key, Payload: string(raw), Status: OutboxPending, NextAt: time.Now()}
+	return tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(msg).Error
+}
+
+func (o *Outbox) start() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(o.Settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			if !schedule.IsLeader() {
+				continue
+			}
+			for {
+				sent, err := o.Relay(context.Background())
+				if err != nil {
+					o.logger.Error("relay outbox failed.", zap.Error(err))
+				}
+				// drain backlog without waiting the next tick.
+				if err != nil || sent < o.Settings.Batch {
+					break
+				}
+			}
+		}
+	}()
+	core.OnServiceStopping(func() {
+		close(stop)
+	})
+}
+
+// Relay publishes due pending messages in ID order, returns sent count.
+// a failed message blocks later messages of the same key until it is sent or failed out, blocked keys are
+// excluded by the query, so they never fill the batch.
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	stmt := &gorm.Statement{DB: o.DB}
+	if err := stmt.Parse(&OutboxMessage{}); err != nil {
+		return 0, err
+	}
+	table := stmt.Schema.Table
+	now := time.Now()
+	waiting := o.DB.Table(table+" w").Select("1").
+		Where("w.key = o.key AND w.status = ? AND w.id < o.id AND w.next_at > ?", OutboxPending, now)
+	rows := make([]OutboxMessage, 0)
+	err := o.DB.WithContext(ctx).Table(table+" o").Select("o.*").
+		Where("o.status = ? AND o.next_at <= ?", OutboxPending, now).
+		Where("NOT EXISTS (?)", waiting).
+		Order("o.id").Limit(o.Settings.Batch).Find(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+	tx := o.DB.WithContext(ctx).Session(&gorm.Session{SkipHooks: true})
+	blocked := map[string]bool{}
+	sent := 0
+	for i := range rows {
+		row := &rows[i]
+		if blocked[row.Key] {
+			continue
+		}
+		row.Attempts++
+		updates := map[string]any{"attempts": row.Attempts}
+		var abandoned map[string]any
+		err := o.Service.Pub(ctx, row.Topic, json.RawMessage(row.Payload))
+		switch {
+		case err == nil:
+			updates["status"], updates["sent_at"] = OutboxSent, now
+			sent++
+		case o.Settings.MaxAttempts > 0 && row.Attempts >= o.Settings.MaxAttempts:
+			o.logger.Error("outbox message failed out.", zap.Uint("id", row.ID), zap.String("key", row.Key), zap.Int("attempts", row.Attempts), zap.Error(err))
+			updates["status"], updates["last_error"] = OutboxFailed, truncate(err.Error(), 1024)
+			abandoned = map[string]any{
+				"error":    "OutboxFailed:" + err.Error(),
+				"topic":    row.Topic,
+				"key":      row.Key,
+				"attempts": row.Attempts,
+				"data":     row.Payload,
+			}
+		default:
+			o.logger.Warn("publish outbox message failed, retry later.", zap.Uint("id", row.ID), zap.String("key", row.Key), zap.Int("attempts", row.Attempts), zap.Error(err))
+			updates["next_at"], updates["last_error"] = now.Add(o.Settings.backoff(row.Attempts)), truncate(err.Error(), 1024)
+			blocked[row.Key] = true
+		}
+		if err := tx.Model(row).Updates(updates).Error; err != nil {
+			return sent, err
+		}
+		// after marked failed, never blocks the relay
+		if abandoned != nil {
+			Abandon(abandoned)
+		}
+	}
+	return sent, nil
+}
+
+func truncate(s string, size int) string {
+	if len(s) > size {
+		return s[:size]
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type outboxOrder struct {
+	ID     uint `gorm:"primarykey"`
+	Status string
+}
+
+type fakeMessaging struct {
+	failed  map[string]bool // keys of GormPayload failed to pub
+	payload []GormPayload
+}
+
+func (f *fakeMessaging) Pub(ctx context.Context, topic string, payload any) error {
+	raw, _ := json.Marshal(payload)
+	kp, _ := ToKeyAndPayload(raw)
+	if f.failed[kp.Payload] {
+		return errors.New("broker down")
+	}
+	f.payload = append(f.payload, *kp)
+	return nil
+}
+
+func (f *fakeMessaging) Sub(ctx context.Context, topic, consumer string, processor Processor) error {
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&outboxOrder{}, &OutboxMessage{}))
+	Reg(&outboxOrder{})
+
+	fake := &fakeMessaging{failed: map[string]bool{}}
+	settings := DefaultOutboxSettings()
+	settings.MaxAttempts = 2
+	o := &Outbox{Settings: settings, DB: db, Service: fake, logger: zap.NewNop()}
+	assert.NoError(t, o.RegisterCallbacks(db))
+
+	order := &outboxOrder{Status: "new"}
+	assert.NoError(t, db.Create(order).Error)
+	assert.NoError(t, db.Model(order).Update("status", "paid").Error)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(t, tx.Create(&outboxOrder{Status: "rolled back"}).Error)
+		return errors.New("abort")
+	})
+	assert.Error(t, err)
+	count := int64(0)
+	db.Model(&OutboxMessage{}).Count(&count)
+	assert.Equal(t, int64(2), count, "outbox rows rolled back with the change")
+
+	// the first change fails, the second change of the same key waits.
+	first := OutboxMessage{}
+	assert.NoError(t, db.Order("id").First(&first).Error)
+	kp, _ := ToKeyAndPayload([]byte(first.Payload))
+	fake.failed[kp.Payload] = true
+	sent, err := o.Relay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.NoError(t, db.First(&first, first.ID).Error)
+	assert.Equal(t, 1, first.Attempts)
+	assert.True(t, first.NextAt.After(time.Now()))
+
+	delete(fake.failed, kp.Payload)
+	assert.NoError(t, db.Model(&first).Update("next_at", time.Now().Add(-time.Second)).Error)
+	sent, err = o.Relay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Len(t, fake.payload, 2)
+	assert.Contains(t, fake.payload[0].Payload, `"new"`)
+	assert.Contains(t, fake.payload[1].Payload, `"paid"`)
+
+	// a failing key with more rows than Batch doesn't starve other keys.
+	settings.Batch = 2
+	settings.MaxAttempts = 0
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, Enqueue(db, "t", "bad", GormPayload{Key: "x", Payload: "poisoned"}))
+	}
+	assert.NoError(t, Enqueue(db, "t", "good", GormPayload{Key: "x", Payload: "healthy"}))
+	fake.failed["poisoned"] = true
+	fake.payload = nil
+	sent, err = o.Relay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent, "batch filled by the failing key")
+	sent, err = o.Relay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent, "blocked key excluded, healthy key sent")
+	assert.Len(t, fake.payload, 1)
+	assert.Equal(t, "healthy", fake.payload[0].Payload)
+
+	assert.Equal(t, time.Second, settings.backoff(1))
+	assert.Equal(t, 4*time.Second, settings.backoff(3))
+	assert.Equal(t, settings.MaxBackoff, settings.backoff(30))
+}
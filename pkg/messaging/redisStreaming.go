@@ -8,8 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -25,10 +25,10 @@ var ConsumerName string
 var ResetTopics []string
 
 const (
-	DefaultMsgLimit          = 5000 //math.MaxInt16, redis loading too much, make it 5K
-	DefaultAttKey            = "payload"
-	DefaultSchedule          = "@every 30m"
-	DefaultDeadLetterDurtion = 8 * time.Hour //if messaging pending for more than this duration, will be put to dead letter
+	DefaultMsgLimit     = 5000 //math.MaxInt16, redis loading too much, make it 5K
+	DefaultAttKey       = "payload"
+	DefaultSchedule     = "@every 1m" // reclaim idle pendings, see RetryPolicy.MinIdle
+	DefaultReclaimBatch = 100
 )
 
 type MessagnePending struct {
@@ -44,6 +44,8 @@ type DefaultMessgingService struct {
 	Client          *redis.Client
 	PendingSchedule string
 	Settings        map[string]int64 // settings for streaming limit settings. default 10000
+	Retry           *RetryPolicy     // default of subscriptions, see SetRetryPolicy
+	SubOptions      *SubOptions      // options of Sub
 }
 
 func (msg *DefaultMessgingService) Pub(ctx context.Context, topic string, payload any) error {
@@ -74,116 +76,259 @@ func (msg *DefaultMessgingService) Pub(ctx context.Context, topic string, payloa
 	return nil
 }
 
-func (msg *DefaultMessgingService) handleMessage(ctx context.Context, topic, group string, logger *zap.Logger,
-	processor Processor, v redis.XMessage) error {
+// Broadcast publishes payload as JSON to channel by pub/sub.
+func (msg *DefaultMessgingService) Broadcast(ctx context.Context, channel string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return msg.Client.Publish(ctx, channel, raw).Err()
+}
+
+// Listen calls fn for payloads broadcasted to channel, until ctx done or service stopping.
+func (msg *DefaultMessgingService) Listen(ctx context.Context, channel string, fn func(ctx context.Context, payload []byte)) error {
+	sub := msg.Client.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return err
+	}
+	msg.Logger.Info("listen broadcast", zap.String("channel", channel))
+	core.OnServiceStopping(func() {
+		sub.Close()
+	})
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				fn(ctx, []byte(m.Payload))
+			}
+		}
+	}()
+	return nil
+}
+
+// handleMessage processes the message with in-process retries of policy, acks it if done or dead lettered,
+// otherwise it is kept pending and reclaimed later. deliveries is the delivery count of XPENDING.
+// stream is read & acked, topic of the message passed to processor, they differ for RetryTopic.
+func (msg *DefaultMessgingService) handleMessage(ctx context.Context, stream, topic, group string, logger *zap.Logger,
+	processor Processor, policy *RetryPolicy, v redis.XMessage, deliveries int64) error {
 	id := v.ID
-	value := v.Values
-	if value == nil {
+	vv, _ := v.Values[DefaultAttKey].(string)
+	var err error
+	if vv == "" {
+		// the placeholder message added when the stream created.
 		logger.Warn("message value is empty", zap.String("messageID", id))
 	} else {
-		logger.Debug("recieved message", zap.String("ID", id), zap.Any("value", value))
-		raw := value[DefaultAttKey]
-		vv := raw.(string)
-		err := processor(WithMessageID(ctx, id), topic, group, []byte(vv))
+		logger.Debug("recieved message", zap.String("ID", id), zap.Any("value", v.Values))
+		attempts := 0
+		attempts, err = policy.run(ctx, func() error {
+			return processor(WithMessageID(ctx, id), topic, group, []byte(vv))
+		})
 		if err != nil {
-			logger.Error("processor return error", zap.Error(err))
-			return err
+			reason := policy.exhausted(err, id, deliveries)
+			if reason == "" {
+				logger.Warn("processor return error, message kept pending.", zap.String("messageID", id),
+					zap.Int("attempts", attempts), zap.Int64("deliveries", deliveries), zap.Error(err))
+				return err
+			}
+			logger.Error("processor return error, message dead lettered.", zap.String("messageID", id),
+				zap.String("reason", reason), zap.Int64("deliveries", deliveries), zap.Error(err))
+			deadLetter(topic, group, id, vv, err, deliveries, reason, attempts)
 		}
 	}
 
-	resp := msg.Client.XAck(ctx, topic, group, id)
+	resp := msg.Client.XAck(ctx, stream, group, id)
 	if resp.Err() != nil {
 		logger.Error("ack message failed.", zap.Error(resp.Err()))
 	}
 	logger.Debug("process done")
-	return nil
+	return err
 }
 
+// ProcessPendings reclaims messages pending idle longer than RetryPolicy.MinIdle by XAUTOCLAIM, from this or
+// dead consumers, and processes them again. they are dead lettered once MaxDeliveries or MaxAge reached.
 func (msg *DefaultMessgingService) ProcessPendings(ctx context.Context, topic, group string, processor Processor) {
-	logger := msg.Logger.With(zap.String("topic", topic))
+	logger := msg.Logger.With(zap.String("topic", topic), zap.String("group", group))
+	policy := retryPolicyOf(topic, group, msg.Retry)
+	for _, stream := range []string{topic, RetryTopic(topic, group)} {
+		msg.reclaim(ctx, stream, group, logger, policy, msg.processorHandler(stream, topic, group, logger, processor, policy))
+	}
+}
 
-	cmdPending, err := msg.Client.XPending(ctx, topic, group).Result()
-	if err != nil {
-		logger.Error("read pending message failed.", zap.Error(err))
-		return
+// streamHandler handles messages read together, deliveries by ID, 1 if not found.
+type streamHandler func(ctx context.Context, items []redis.XMessage, deliveries map[string]int64)
+
+func deliveryOf(deliveries map[string]int64, id string) int64 {
+	if v, ok := deliveries[id]; ok {
+		return v
 	}
-	if cmdPending.Count > 0 {
-		for c, p := range cmdPending.Consumers {
-			xrangeResult, err := msg.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
-				Stream:   topic,
-				Group:    group,
-				Count:    p,
-				Start:    cmdPending.Lower,
-				End:      cmdPending.Higher,
-				Consumer: c,
-			}).Result()
-			if err != nil {
-				logger.Warn("read pending message by XPendingExt failed. will try next round.", zap.String("topic", topic), zap.Error(err))
-				continue
-			}
-			logger.Info("read pending message done.", zap.Int("count", len(xrangeResult)))
-			for _, pendingItem := range xrangeResult {
-				readResult, err := msg.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
-					Group:    group,
-					Consumer: pendingItem.Consumer,
-					Streams:  []string{topic, pendingItem.ID},
-					Count:    1,
-					Block:    0,
-				}).Result()
-				if err != nil {
-					logger.Error("read pending message failed.", zap.Error(err))
-					return
-				}
-				if len(readResult) == 0 {
-					continue
-				}
-				for _, item := range readResult[0].Messages {
-					err = msg.handleMessage(ctx, topic, group, logger, processor, item)
-					if err != nil {
-						logger.Error("process pending message failed. ", zap.Error(err))
-						strT := item.ID
-						index := strings.IndexRune(item.ID, '-')
-						if index > 0 {
-							strT = item.ID[:index]
-						}
+	return 1
+}
 
-						unixTimeint, err := strconv.ParseInt(strT, 10, 64)
-						if err != nil {
-							logger.Warn("convert pending message id failed.", zap.Error(err))
-						}
-						pendinged := time.Since(time.Unix(unixTimeint/1000, 0))
-						logger.Info("checking pending duration", zap.Duration("pendinged", pendinged))
-						if pendinged >= DefaultDeadLetterDurtion || err != nil {
-							logger.Warn("pending message expired, abandoned.", zap.String("duration", pendinged.String()))
-							// resp := msg.Client.XAdd(ctx, &redis.XAddArgs{
-							// 	Stream: fmt.Sprintf("%s.%s.deadletter", topic, group),
-							// 	Values: item.Values,
-							// })
-							// if resp.Err() != nil {
-							// 	logger.Error("send to dead letter failed.", zap.Error(resp.Err()))
-							// }
-							payload := item.Values
-							payload["ID"] = item.ID
-							payload["consumer"] = c
-							payload["topic"] = topic
-							payload["duration"] = pendinged.String()
-
-							AbandonedChan <- payload
-
-							ackResp := msg.Client.XAck(ctx, topic, group, item.ID)
-							if ackResp.Err() != nil {
-								logger.Error("ack pending message failed.", zap.Error(ackResp.Err()))
-							}
-						}
-					}
+func (msg *DefaultMessgingService) processorHandler(stream, topic, group string, logger *zap.Logger, processor Processor,
+	policy *RetryPolicy) streamHandler {
+	return func(ctx context.Context, items []redis.XMessage, deliveries map[string]int64) {
+		for _, item := range items {
+			msg.handleMessage(ctx, stream, topic, group, logger, processor, policy, item, deliveryOf(deliveries, item.ID))
+		}
+	}
+}
+
+func (msg *DefaultMessgingService) batchHandler(stream, topic, group string, logger *zap.Logger, processor BatchProcessor,
+	policy *RetryPolicy) streamHandler {
+	return func(ctx context.Context, items []redis.XMessage, deliveries map[string]int64) {
+		msg.handleBatch(ctx, stream, topic, group, logger, processor, policy, items, deliveries)
+	}
+}
+
+// handleBatch processes items by processor, retries failed messages of the batch in process, acks succeeded &
+// dead lettered messages, others kept pending.
+func (msg *DefaultMessgingService) handleBatch(ctx context.Context, stream, topic, group string, logger *zap.Logger,
+	processor BatchProcessor, policy *RetryPolicy, items []redis.XMessage, deliveries map[string]int64) {
+	acks := make([]string, 0, len(items))
+	batch := make([]Message, 0, len(items))
+	for _, v := range items {
+		payload, _ := v.Values[DefaultAttKey].(string)
+		if payload == "" {
+			logger.Warn("message value is empty", zap.String("messageID", v.ID))
+			acks = append(acks, v.ID)
+			continue
+		}
+		batch = append(batch, Message{ID: v.ID, Topic: topic, Group: group, Payload: []byte(payload), Deliveries: deliveryOf(deliveries, v.ID)})
+	}
+	errs := map[string]error{}
+	poison := make([]Message, 0)
+	attempts := 0
+	if len(batch) > 0 {
+		attempts, _ = policy.run(ctx, func() error {
+			err := processor(ctx, batch)
+			remaining := make([]Message, 0)
+			var last error
+			for _, m := range batch {
+				merr := failureOf(err, m.ID)
+				switch {
+				case merr == nil:
+					acks = append(acks, m.ID)
+				case !policy.retryable(merr):
+					errs[m.ID] = merr
+					poison = append(poison, m)
+				default:
+					errs[m.ID] = merr
+					remaining = append(remaining, m)
+					last = merr
 				}
 			}
+			batch = remaining
+			return last
+		})
+	}
+	for _, m := range append(poison, batch...) {
+		err := errs[m.ID]
+		reason := policy.exhausted(err, m.ID, m.Deliveries)
+		if reason == "" {
+			logger.Warn("processor return error, message kept pending.", zap.String("messageID", m.ID),
+				zap.Int("attempts", attempts), zap.Int64("deliveries", m.Deliveries), zap.Error(err))
+			continue
+		}
+		logger.Error("processor return error, message dead lettered.", zap.String("messageID", m.ID),
+			zap.String("reason", reason), zap.Int64("deliveries", m.Deliveries), zap.Error(err))
+		deadLetter(topic, group, m.ID, string(m.Payload), err, m.Deliveries, reason, attempts)
+		acks = append(acks, m.ID)
+	}
+	if len(acks) == 0 {
+		return
+	}
+	if err := msg.Client.XAck(ctx, stream, group, acks...).Err(); err != nil {
+		logger.Error("ack message failed.", zap.Error(err))
+	}
+	logger.Debug("process done", zap.Int("acked", len(acks)))
+}
+
+// deadLetter sends the message exhausted retries to AbandonedChan by Abandon, acked by the caller.
+func deadLetter(topic, group, id, payload string, err error, deliveries int64, reason string, attempts int) {
+	meta, _ := json.Marshal(map[string]any{"reason": reason, "attempts": attempts})
+	item := &DeadLetter{
+		Topic:         topic,
+		ConsumerGroup: group,
+		Consumer:      consumerName(),
+		MessageID:     id,
+		Payload:       payload,
+		Error:         truncate(err.Error(), 1024),
+		Attempts:      int(deliveries),
+		Meta:          string(meta),
+	}
+	Abandon(item)
+}
+
+// reclaim pendings idle longer than policy.MinIdle to this consumer, and handles them.
+func (msg *DefaultMessgingService) reclaim(ctx context.Context, stream, group string, logger *zap.Logger,
+	policy *RetryPolicy, handle streamHandler) {
+	reclaimed := 0
+	start := "0-0"
+	for {
+		messages, next, err := msg.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			MinIdle:  policy.MinIdle,
+			Start:    start,
+			Count:    DefaultReclaimBatch,
+			Consumer: consumerName(),
+		}).Result()
+		if err != nil {
+			logger.Error("reclaim pending message failed.", zap.Error(err))
+			return
+		}
+		if len(messages) > 0 {
+			handle(ctx, messages, msg.deliveries(ctx, stream, group, messages, logger))
 		}
+		reclaimed += len(messages)
+		if next == "" || next == "0-0" || ctx.Err() != nil {
+			break
+		}
+		start = next
+	}
+	if reclaimed > 0 {
+		logger.Info("process pending message done", zap.Int("reclaimed", reclaimed))
+	}
+}
 
-		logger.Info("process pending message done")
-	} else {
-		logger.Info("no pending messages")
+// deliveries of reclaimed messages by XPENDING of each ID in one pipeline, ID -> count.
+// other pendings between the IDs never counted in.
+func (msg *DefaultMessgingService) deliveries(ctx context.Context, stream, group string, messages []redis.XMessage,
+	logger *zap.Logger) map[string]int64 {
+	result := make(map[string]int64, len(messages))
+	if len(messages) == 0 {
+		return result
+	}
+	cmds := make([]*redis.XPendingExtCmd, 0, len(messages))
+	_, err := msg.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, item := range messages {
+			cmds = append(cmds, p.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: stream,
+				Group:  group,
+				Start:  item.ID,
+				End:    item.ID,
+				Count:  1,
+			}))
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("read delivery count failed.", zap.Error(err))
+	}
+	for _, cmd := range cmds {
+		for _, item := range cmd.Val() {
+			result[item.ID] = item.RetryCount
+		}
 	}
+	return result
 }
 
 func (msg *DefaultMessgingService) checkAndCreate(ctx context.Context, topic, group string) error {
@@ -203,83 +348,261 @@ func (msg *DefaultMessgingService) checkAndCreate(ctx context.Context, topic, gr
 		// msg.Client.XGroupSetID(ctx, topic, group, "0")
 		logger.Info("topic created")
 	}
-	err := msg.Client.XGroupCreate(ctx, topic, group, "$").Err()
+	// retry streams are written before the group may exist, read from the beginning.
+	start := "$"
+	if strings.Contains(topic, RetryTopicInfix) {
+		start = "0"
+	}
+	err := msg.Client.XGroupCreate(ctx, topic, group, start).Err()
 	if err != nil {
 		logger.Warn("group might be created.", zap.Error(err), zap.String("group", group))
 	}
 	return nil
 }
 
+// consumerName of this node, ConsumerName or hostname.
+func consumerName() string {
+	if ConsumerName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			zap.L().Error("failed to get hostname, just make it empty", zap.Error(err))
+		}
+		ConsumerName = hostname //+ "-" + time.Now().Format("20060102150405")
+	}
+	return ConsumerName
+}
+
+// Sub processes messages of topic by group with SubOptions (config messaging.subOptions).
 func (msg *DefaultMessgingService) Sub(ctx context.Context, topic, group string, processor Processor) error {
+	return msg.SubWithOptions(ctx, topic, group, processor, msg.SubOptions)
+}
+
+// SubWithOptions processes messages by opts.Workers, until ctx done or service stopping, ctx should live as long as
+// the subscription.
+func (msg *DefaultMessgingService) SubWithOptions(ctx context.Context, topic, group string, processor Processor, opts *SubOptions) error {
 	if processor == nil {
 		return errors.New("processor is empty")
 	}
+	opts = opts.normalized()
+	logger := msg.Logger.With(zap.String("topic", topic), zap.String("group", group))
+	policy := msg.policyOf(topic, group, opts)
+	for _, stream := range []string{topic, RetryTopic(topic, group)} {
+		err := msg.subscribe(ctx, stream, group, logger, opts, policy, msg.processorHandler(stream, topic, group, logger, processor, policy))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	logger := msg.Logger.With(zap.String("topic", topic))
-	err := msg.checkAndCreate(ctx, topic, group)
+// SubBatch delivers messages to processor in batches up to opts.Batch, messages of a batch are read by one worker.
+func (msg *DefaultMessgingService) SubBatch(ctx context.Context, topic, group string, processor BatchProcessor, opts *SubOptions) error {
+	if processor == nil {
+		return errors.New("processor is empty")
+	}
+	opts = opts.normalized()
+	logger := msg.Logger.With(zap.String("topic", topic), zap.String("group", group))
+	policy := msg.policyOf(topic, group, opts)
+	for _, stream := range []string{topic, RetryTopic(topic, group)} {
+		err := msg.subscribe(ctx, stream, group, logger, opts, policy, msg.batchHandler(stream, topic, group, logger, processor, policy))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (msg *DefaultMessgingService) policyOf(topic, group string, opts *SubOptions) *RetryPolicy {
+	if opts.Retry != nil {
+		return opts.Retry
+	}
+	return retryPolicyOf(topic, group, msg.Retry)
+}
+
+// dispatched message to worker, deliveries 0 for new messages.
+type dispatched struct {
+	msg        redis.XMessage
+	deliveries int64
+}
+
+// dispatcher partitions messages to workers by key, shared by reading & reclaiming.
+type dispatcher struct {
+	lock    sync.Mutex
+	workers []chan dispatched
+	keyFunc func(payload []byte) string
+	seq     int
+	closed  bool
+}
+
+func newDispatcher(workers, buffer int, keyFunc func(payload []byte) string) *dispatcher {
+	d := &dispatcher{workers: make([]chan dispatched, workers), keyFunc: keyFunc}
+	for i := range d.workers {
+		d.workers[i] = make(chan dispatched, buffer)
+	}
+	return d
+}
+
+// send blocks until the worker of the message key accepts it, false if closed.
+func (d *dispatcher) send(v redis.XMessage, deliveries int64) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return false
+	}
+	key := ""
+	if raw, ok := v.Values[DefaultAttKey].(string); ok && d.keyFunc != nil {
+		key = d.keyFunc([]byte(raw))
+	}
+	d.workers[partition(key, d.seq, len(d.workers))] <- dispatched{msg: v, deliveries: deliveries}
+	d.seq++
+	return true
+}
+
+// close the workers, messages sent already still handled.
+func (d *dispatcher) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	for _, c := range d.workers {
+		close(c)
+	}
+}
+
+// subscribe reads messages to workers, partitioned by key if opts.KeyFunc, and schedules reclaim of pendings.
+// reading stops on ctx done or service stopping, messages dispatched already are handled before workers exit.
+func (msg *DefaultMessgingService) subscribe(ctx context.Context, stream, group string, logger *zap.Logger,
+	opts *SubOptions, policy *RetryPolicy, handle streamHandler) error {
+	err := msg.checkAndCreate(ctx, stream, group)
 	if err != nil {
 		return err
 	}
 
-	if lo.Contains(ResetTopics, topic) {
-		msg.Client.XGroupSetID(ctx, topic, group, "0")
-		logger.Info("reset topic", zap.String("topic", topic))
+	if lo.Contains(ResetTopics, stream) {
+		msg.Client.XGroupSetID(ctx, stream, group, "0")
+		logger.Info("reset topic", zap.String("stream", stream))
 	}
 
-	go func() {
-		if ConsumerName == "" {
-			hostname, err := os.Hostname()
-			if err != nil {
-				logger.Error("failed to get hostname, just make it empty", zap.Error(err))
+	readCtx, stopRead := context.WithCancel(ctx)
+	d := newDispatcher(opts.Workers, opts.Batch, opts.KeyFunc)
+	wg := sync.WaitGroup{}
+	for i := range d.workers {
+		wg.Add(1)
+		go func(c chan dispatched) {
+			defer wg.Done()
+			for item := range c {
+				items := []redis.XMessage{item.msg}
+				deliveries := map[string]int64{}
+				if item.deliveries > 0 {
+					deliveries[item.msg.ID] = item.deliveries
+				}
+			collect:
+				for len(items) < opts.Batch {
+					select {
+					case next, ok := <-c:
+						if !ok {
+							break collect
+						}
+						items = append(items, next.msg)
+						if next.deliveries > 0 {
+							deliveries[next.msg.ID] = next.deliveries
+						}
+					default:
+						break collect
+					}
+				}
+				// ctx done, kept pending & reclaimed later.
+				if ctx.Err() == nil {
+					handle(ctx, items, deliveries)
+				}
 			}
+		}(d.workers[i])
+	}
 
-			ConsumerName = hostname //+ "-" + time.Now().Format("20060102150405")
-		}
-
-		logger.Info("start consumer", zap.String("group", group),
-			zap.String("topic", topic), zap.String("consumer", ConsumerName))
-
-		for {
-			cmd := msg.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+	go func() {
+		defer d.close()
+		logger.Info("start consumer", zap.String("stream", stream), zap.String("consumer", consumerName()), zap.Int("workers", opts.Workers))
+		failures := 0
+		for readCtx.Err() == nil {
+			vv, err := msg.Client.XReadGroup(readCtx, &redis.XReadGroupArgs{
 				Group:    group,
-				Consumer: ConsumerName,
-				Streams:  []string{topic, ">"},
-				Count:    0,
-			})
-
-			vv, err := cmd.Result()
+				Consumer: consumerName(),
+				Streams:  []string{stream, ">"},
+				Count:    int64(opts.Batch),
+				Block:    opts.Block,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
 			if err != nil {
-				logger.Error("received message failed.", zap.Error(err))
+				if readCtx.Err() != nil {
+					break
+				}
+				failures++
+				logger.Error("received message failed.", zap.Error(err), zap.Int("failures", failures))
 				//just in case someone else delete the topic and crash the receiver
-				go msg.checkAndCreate(ctx, topic, group)
-
-				time.Sleep(time.Second)
+				msg.checkAndCreate(readCtx, stream, group)
+				select {
+				case <-readCtx.Done():
+				case <-time.After(backoff(time.Second, 30*time.Second, failures)):
+				}
 				continue
 			}
-			for _, v := range vv[0].Messages {
-				msg.handleMessage(ctx, topic, group, logger, processor, v)
+			failures = 0
+			for _, stream := range vv {
+				for _, v := range stream.Messages {
+					d.send(v, 0)
+				}
 			}
 		}
+		logger.Info("consumer stopped")
 	}()
 
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	core.OnServiceStopping(func() {
+		stopRead()
+		select {
+		case <-stopped:
+		case <-time.After(core.GraceShutdown):
+			logger.Warn("consumer workers not stopped in grace period")
+		}
+	})
+
 	pschedule := msg.PendingSchedule
 	if pschedule == "" {
 		pschedule = DefaultSchedule
 	}
 
-	schedule.CreateSchedule(fmt.Sprintf("check_pending_message/%s/%s", topic, group), pschedule, func() {
-		msg.ProcessPendings(context.TODO(), topic, group, processor)
+	err = schedule.CreateSchedule(fmt.Sprintf("check_pending_message/%s/%s", stream, group), pschedule, func() {
+		if readCtx.Err() != nil {
+			return
+		}
+		// reclaimed messages go through the workers as read ones, messages of one key kept in order.
+		msg.reclaim(ctx, stream, group, logger, policy, func(ctx context.Context, items []redis.XMessage, deliveries map[string]int64) {
+			for _, item := range items {
+				if !d.send(item, deliveryOf(deliveries, item.ID)) {
+					return
+				}
+			}
+		})
 	})
-
+	if err != nil {
+		logger.Warn("schedule reclaiming pending messages failed.", zap.Error(err))
+	}
 	return nil
 }
 
 func init() {
 	core.Provide(func(client *redis.Client, logger *zap.Logger) (MessagingService, *DefaultMessgingService) {
 		d := &DefaultMessgingService{
-			Client:   client,
-			Logger:   logger,
-			Settings: map[string]int64{},
+			Client:     client,
+			Logger:     logger,
+			Settings:   map[string]int64{},
+			Retry:      DefaultRetryPolicy(),
+			SubOptions: DefaultSubOptions(),
 		}
 		sub := viper.Sub("messaging")
 		if sub != nil {
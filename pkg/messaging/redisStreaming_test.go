@@ -0,0 +1,66 @@
+//go:build !ram
+
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+)
+
+func TestDeadLetterNotBlocking(t *testing.T) {
+	abandoned, folder := AbandonedChan, core.DefaultFolder
+	AbandonedChan = make(chan any)
+	core.DefaultFolder = t.TempDir()
+	defer func() {
+		AbandonedChan, core.DefaultFolder = abandoned, folder
+		abandonedFile.Close()
+		abandonedFile = nil
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		deadLetter("orders", "billing", "1-0", `{"id":1}`, errors.New("timeout"), 3, "MaxDeliveries", 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker blocked by slow router")
+	}
+	raw, err := os.ReadFile(filepath.Join(core.DefaultFolder, abandonedFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(raw), "\n"), "appended to file when the channel is full")
+	_, line, _ := strings.Cut(strings.TrimSpace(string(raw)), "\t")
+	item := map[string]any{}
+	assert.NoError(t, json.Unmarshal([]byte(line), &item))
+	dl := DeadLetterOf(item)
+	assert.Equal(t, "1-0", dl.MessageID)
+	assert.Equal(t, 3, dl.Attempts)
+}
+
+func TestDispatcherKeepsKeyOrder(t *testing.T) {
+	d := newDispatcher(4, 10, KeyOf("id"))
+	message := func(id, payload string) redis.XMessage {
+		return redis.XMessage{ID: id, Values: map[string]any{DefaultAttKey: payload}}
+	}
+	// new message read, then the earlier one of the same key reclaimed
+	assert.True(t, d.send(message("2-0", `{"id":"o1"}`), 0))
+	assert.True(t, d.send(message("1-0", `{"id":"o1"}`), 3))
+	d.close()
+	assert.False(t, d.send(message("3-0", `{"id":"o1"}`), 0), "closed")
+
+	worker := d.workers[partition("o1", 0, 4)]
+	first, second := <-worker, <-worker
+	assert.Equal(t, "2-0", first.msg.ID)
+	assert.Equal(t, "1-0", second.msg.ID)
+	assert.Equal(t, int64(3), second.deliveries)
+}
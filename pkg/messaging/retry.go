@@ -0,0 +1,117 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPoison marks errors of messages never processable, dead lettered without retries, see Poison.
+var ErrPoison = errors.New("poison message")
+
+// DefaultDeadLetterDurtion is the default RetryPolicy.MaxAge, messages pending longer than this are dead lettered.
+const DefaultDeadLetterDurtion = 8 * time.Hour
+
+// Poison wraps err as not retryable.
+func Poison(err error) error {
+	return fmt.Errorf("%w: %w", ErrPoison, err)
+}
+
+// IsRetryable is the default classification, ErrPoison & payload not decodable are not retryable.
+func IsRetryable(err error) bool {
+	var syntax *json.SyntaxError
+	var typed *json.UnmarshalTypeError
+	return !errors.Is(err, ErrPoison) && !errors.As(err, &syntax) && !errors.As(err, &typed)
+}
+
+// RetryPolicy of a subscription, config key messaging.retry for all subscriptions, SetRetryPolicy per subscription.
+type RetryPolicy struct {
+	Retries       int           // in-process retries after the first failure, 0 disables
+	MinBackoff    time.Duration // doubled per retry
+	MaxBackoff    time.Duration
+	MaxDeliveries int64                // deliveries counted by XPENDING, 0 unlimited
+	MaxAge        time.Duration        // pending since published longer than this, 0 unlimited
+	MinIdle       time.Duration        // pending idle longer than this reclaimed from other consumers
+	Retryable     func(err error) bool `mapstructure:"-" json:"-"` // nil for IsRetryable
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Retries:       3,
+		MinBackoff:    100 * time.Millisecond,
+		MaxBackoff:    5 * time.Second,
+		MaxDeliveries: 10,
+		MaxAge:        DefaultDeadLetterDurtion,
+		MinIdle:       5 * time.Minute,
+	}
+}
+
+var retryPolicies sync.Map // topic/group -> *RetryPolicy
+
+// SetRetryPolicy of the subscription, empty group for all groups of the topic. set it before Sub.
+func SetRetryPolicy(topic, group string, policy *RetryPolicy) {
+	retryPolicies.Store(topic+"/"+group, policy)
+}
+
+// retryPolicyOf the subscription, fallback if not set.
+func retryPolicyOf(topic, group string, fallback *RetryPolicy) *RetryPolicy {
+	for _, key := range []string{topic + "/" + group, topic + "/"} {
+		if p, ok := retryPolicies.Load(key); ok {
+			return p.(*RetryPolicy)
+		}
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return DefaultRetryPolicy()
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// run calls fn, retries retryable errors with backoff until Retries reached or ctx done, returns attempts.
+func (p *RetryPolicy) run(ctx context.Context, fn func() error) (int, error) {
+	attempts := 0
+	for {
+		attempts++
+		err := fn()
+		if err == nil || attempts > p.Retries || !p.retryable(err) {
+			return attempts, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-time.After(backoff(p.MinBackoff, p.MaxBackoff, attempts)):
+		}
+	}
+}
+
+// exhausted returns why the failed message is dead lettered, empty if it should be kept pending for redelivery.
+func (p *RetryPolicy) exhausted(err error, id string, deliveries int64) string {
+	switch {
+	case !p.retryable(err):
+		return "Poison"
+	case p.MaxDeliveries > 0 && deliveries >= p.MaxDeliveries:
+		return "MaxDeliveries"
+	}
+	if at, ok := StreamIDTime(id); ok && p.MaxAge > 0 && time.Since(at) >= p.MaxAge {
+		return "MaxAge"
+	}
+	return ""
+}
+
+// backoff before the next attempt, lower doubled per failed attempt up to upper.
+func backoff(lower, upper time.Duration, attempts int) time.Duration {
+	d := lower
+	for i := 1; i < attempts && d < upper; i++ {
+		d *= 2
+	}
+	return min(d, upper)
+}
@@ -0,0 +1,51 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := &RetryPolicy{Retries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, MaxDeliveries: 3, MaxAge: time.Hour}
+	ctx := context.Background()
+
+	calls := 0
+	attempts, err := p.run(ctx, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("db locked")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts, "succeeded on the last retry")
+
+	attempts, err = p.run(ctx, func() error { return errors.New("timeout") })
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+	attempts, err = p.run(ctx, func() error { return json.Unmarshal([]byte("{"), &struct{}{}) })
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "poison not retried")
+
+	now := fmt.Sprintf("%d-0", time.Now().UnixMilli())
+	old := fmt.Sprintf("%d-0", time.Now().Add(-2*time.Hour).UnixMilli())
+	assert.Equal(t, "", p.exhausted(errors.New("timeout"), now, 1), "kept pending")
+	assert.Equal(t, "Poison", p.exhausted(Poison(errors.New("unknown tenant")), now, 1))
+	assert.Equal(t, "MaxDeliveries", p.exhausted(errors.New("timeout"), now, 3))
+	assert.Equal(t, "MaxAge", p.exhausted(errors.New("timeout"), old, 1))
+
+	p.Retryable = func(err error) bool { return err.Error() != "fatal" }
+	assert.Equal(t, "Poison", p.exhausted(errors.New("fatal"), now, 1))
+
+	SetRetryPolicy("orders", "", p)
+	defer retryPolicies.Delete("orders/")
+	assert.Same(t, p, retryPolicyOf("orders", "billing", nil))
+	assert.Equal(t, DefaultRetryPolicy(), retryPolicyOf("invoices", "billing", nil))
+	assert.Equal(t, 2*time.Millisecond, backoff(time.Millisecond, 2*time.Millisecond, 5))
+}
@@ -0,0 +1,170 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ConflictStrategy decides whether a received change overwrites the current one.
+type ConflictStrategy string
+
+const (
+	LastWriterWins ConflictStrategy = "lww"             // newer or equal version wins, default
+	SourcePriority ConflictStrategy = "source-priority" // higher priority source wins, LWW for the same priority
+	CustomResolver ConflictStrategy = "custom"          // Resolver decides
+)
+
+// Conflict of a received change and the current state of the entity.
+type Conflict struct {
+	Key           string // entity key & ID
+	Action        GormAction
+	Source        string
+	Version       int64 // version of the received change, UnixMicro for time columns
+	Current       int64
+	CurrentSource string
+	Deleted       bool // deleted already
+	Payload       any
+}
+
+// ConflictResolver returns true to apply the change, otherwise the reason reported to abandoned sink.
+type ConflictResolver func(ctx context.Context, c *Conflict) (bool, string)
+
+// SyncWatermark is the last applied version per entity, late messages compared with it.
+type SyncWatermark struct {
+	EntityKey string `gorm:"primaryKey;size:255"`
+	Version   int64
+	Source    string `gorm:"size:64"`
+	Deleted   bool
+	MessageID string `gorm:"size:64"`
+	UpdatedAt time.Time
+}
+
+func init() {
+	orm.AppendEntityIf("messaging.sync.watermarks", &SyncWatermark{})
+}
+
+// SyncSource is sent as Source of entity changes, config messaging.source, hostname by default.
+var SyncSource string
+
+func syncSource() string {
+	if SyncSource == "" {
+		SyncSource = viper.GetString("messaging.source")
+	}
+	if SyncSource == "" {
+		SyncSource, _ = os.Hostname()
+	}
+	return SyncSource
+}
+
+// versionOf returns the version of entity by field, Version or UpdatedAt if empty, false if not versioned.
+func versionOf(payload any, field string) (int64, bool) {
+	vv := reflect.ValueOf(payload)
+	for vv.Kind() == reflect.Ptr && !vv.IsNil() {
+		vv = vv.Elem()
+	}
+	if vv.Kind() != reflect.Struct {
+		return 0, false
+	}
+	names := []string{field}
+	if field == "" {
+		names = []string{"Version", "UpdatedAt"}
+	}
+	for _, name := range names {
+		f := vv.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		switch v := f.Interface().(type) {
+		case time.Time:
+			return v.UnixMicro(), !v.IsZero()
+		case *time.Time:
+			if v == nil {
+				return 0, false
+			}
+			return v.UnixMicro(), !v.IsZero()
+		}
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return f.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(f.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+// resolve returns true if the change should be applied, otherwise the reason.
+func (ss *GormObjSyncService) resolve(ctx context.Context, c *Conflict) (bool, string) {
+	lww := func() (bool, string) {
+		if c.Version >= c.Current {
+			return true, ""
+		}
+		return false, fmt.Sprintf("StaleVersion: %d < %d", c.Version, c.Current)
+	}
+	switch ss.Strategy {
+	case CustomResolver:
+		if ss.Resolver == nil {
+			return lww()
+		}
+		return ss.Resolver(ctx, c)
+	case SourcePriority:
+		incoming, current := ss.Priorities[c.Source], ss.Priorities[c.CurrentSource]
+		switch {
+		case incoming > current:
+			return true, ""
+		case incoming < current:
+			return false, fmt.Sprintf("LowerPriority: %s(%d) < %s(%d)", c.Source, incoming, c.CurrentSource, current)
+		}
+	}
+	return lww()
+}
+
+// current returns the state of the entity from watermark if enabled, otherwise from the row, nil if not found.
+func (ss *GormObjSyncService) current(tx *gorm.DB, table, key string, id uint, tt reflect.Type) (*SyncWatermark, error) {
+	if ss.Watermarks {
+		wm := &SyncWatermark{}
+		result := tx.Session(&gorm.Session{NewDB: true}).Where("entity_key = ?", key).Limit(1).Find(wm)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return wm, result.Error
+		}
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	for tt.Kind() == reflect.Ptr {
+		tt = tt.Elem()
+	}
+	row := reflect.New(tt).Interface()
+	q := tx.Session(&gorm.Session{NewDB: true}).Unscoped()
+	if table != "" {
+		q = q.Table(table)
+	}
+	result := q.Limit(1).Find(row, id)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	version, ok := versionOf(row, ss.VersionField)
+	if !ok {
+		return nil, nil
+	}
+	return &SyncWatermark{EntityKey: key, Version: version}, nil
+}
+
+// watermark saves the applied version of the entity.
+func (ss *GormObjSyncService) watermark(ctx context.Context, tx *gorm.DB, wm *SyncWatermark) error {
+	if !ss.Watermarks {
+		return nil
+	}
+	if id, ok := MessageIDFromContext(ctx); ok {
+		wm.MessageID = id
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Clauses(clause.OnConflict{UpdateAll: true}).Create(wm).Error
+}
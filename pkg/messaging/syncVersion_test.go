@@ -0,0 +1,103 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type syncItem struct {
+	ID        uint `gorm:"primarykey"`
+	Name      string
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"`
+}
+
+func TestVersionAwareSync(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&syncItem{}, &SyncWatermark{}))
+	Reg(syncItem{})
+
+	ss := NewGormObjSyncService(nil, zap.NewNop(), db)
+	ss.Watermarks = true
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	message := func(name, source string, at time.Duration, action GormAction) []byte {
+		raw, _ := json.Marshal(syncItem{ID: 1, Name: name, UpdatedAt: base.Add(at)})
+		msg, _ := json.Marshal(GormPayload{Key: "messaging.syncItem", Action: action, Payload: string(raw), Source: source})
+		return msg
+	}
+	name := func() string {
+		item := syncItem{}
+		db.Unscoped().Limit(1).Find(&item, 1)
+		return item.Name
+	}
+	ctx := context.Background()
+	abandoned := AbandonedChan
+	AbandonedChan = make(chan any, 10)
+	defer func() { AbandonedChan = abandoned }()
+	reports := AbandonedChan
+
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("v2", "a", 2*time.Minute, GormActionSave)))
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("v1", "a", time.Minute, GormActionSave)))
+	assert.Equal(t, "v2", name(), "late older message skipped")
+	report := (<-reports).(map[string]any)
+	assert.Equal(t, "Conflict", report["error"])
+	assert.Contains(t, report["reason"], "StaleVersion")
+
+	ss.Strategy = SourcePriority
+	ss.Priorities = map[string]int{"erp": 10}
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("erp", "erp", time.Minute, GormActionSave)))
+	assert.Equal(t, "erp", name(), "higher priority source wins")
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("v3", "a", 3*time.Minute, GormActionSave)))
+	assert.Equal(t, "erp", name(), "lower priority source skipped even if newer")
+	assert.Contains(t, (<-reports).(map[string]any)["reason"], "LowerPriority")
+
+	ss.Strategy = CustomResolver
+	ss.Resolver = func(ctx context.Context, c *Conflict) (bool, string) { return c.Source == "admin", "admin only" }
+	assert.NoError(t, ss.ApplyBatch(ctx, "t", "c", [][]byte{
+		message("v4", "a", 4*time.Minute, GormActionSave),
+		message("admin", "admin", 0, GormActionSave),
+		[]byte(`{"Key":"unknown"}`),
+	}))
+	assert.Equal(t, "admin", name())
+	assert.Equal(t, "Conflict", (<-reports).(map[string]any)["error"])
+	assert.Equal(t, "UnknownKey", (<-reports).(map[string]any)["error"])
+
+	// reports of skipped messages are withdrawn with the rolled back batch, failures kept.
+	ss.Sharding = func(tx *gorm.DB, key string, payload any) (string, error) {
+		if payload.(*syncItem).Name == "boom" {
+			return "", errors.New("boom")
+		}
+		return "", nil
+	}
+	assert.Error(t, ss.ApplyBatch(ctx, "t", "c", [][]byte{
+		message("v4", "a", 4*time.Minute, GormActionSave),
+		message("boom", "admin", 0, GormActionSave),
+	}))
+	assert.Equal(t, "ShardingFailed:boom", (<-reports).(map[string]any)["error"])
+	assert.Len(t, reports, 0)
+	ss.Sharding = nil
+
+	ss.Strategy = LastWriterWins
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("", "a", 5*time.Minute, GormActionDelete)))
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("late", "a", 4*time.Minute, GormActionSave)))
+	assert.Equal(t, "", name(), "deleted, late save not resurrected")
+	wm := SyncWatermark{}
+	assert.NoError(t, db.First(&wm, "entity_key = ?", "messaging.syncItem:1").Error)
+	assert.True(t, wm.Deleted)
+	assert.Equal(t, base.Add(5*time.Minute).UnixMicro(), wm.Version)
+
+	ss.Watermarks = false
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("v6", "a", 6*time.Minute, GormActionSave)))
+	assert.NoError(t, ss.ReceiveGormObjectSaved(ctx, "t", "c", message("v5", "a", 5*time.Minute, GormActionSave)))
+	assert.Equal(t, "v6", name(), "compared with the row without watermarks")
+	assert.NoError(t, db.First(&wm, "entity_key = ?", "messaging.syncItem:1").Error)
+	assert.Equal(t, base.Add(5*time.Minute).UnixMicro(), wm.Version, "watermark not updated")
+}
@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/thanhpk/randstr"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+const (
+	KeyOauth2   = "oauth2"    // *oauth2.Token after callback
+	KeyReturnTo = "returnTo"  // relative path to return after login
+	KeyIDToken  = "id_token"  // extra of oauth2 token
+	ParamReturn = "return_to" // query param of login
+	// CookieState binds the login state to the browser started the login.
+	CookieState = "oidc_state"
+)
+
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+// LoginState kept in cache between login redirect & callback, one time only.
+type LoginState struct {
+	Verifier    string
+	Nonce       string
+	RedirectURL string
+	ReturnTo    string
+}
+
+func (p *Provider) states() cache.CacheProvider[*LoginState] {
+	p.stateOnce.Do(func() {
+		if p.States == nil {
+			p.States = cache.NewCacheProvider[*LoginState](p.Settings.StateTTL)
+		}
+	})
+	return p.States
+}
+
+func (p *Provider) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, p.Client)
+}
+
+// Config returns oauth2 config for redirectURL, new config for each call, never shared between requests.
+func (p *Provider) Config(ctx context.Context, redirectURL string) (*oauth2.Config, error) {
+	doc, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     p.Settings.ClientID,
+		ClientSecret: p.Settings.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      p.Settings.Scopes,
+	}, nil
+}
+
+// AuthURL starts login with random state, nonce & PKCE verifier stored in cache, returns the URL & state.
+// the state should be bound to the client, e.g. Login sets it as cookie checked by Callback.
+func (p *Provider) AuthURL(ctx context.Context, redirectURL, returnTo string) (string, string, error) {
+	config, err := p.Config(ctx, redirectURL)
+	if err != nil {
+		return "", "", err
+	}
+	state := randstr.Hex(16)
+	ls := &LoginState{
+		Verifier:    oauth2.GenerateVerifier(),
+		Nonce:       randstr.Hex(16),
+		RedirectURL: redirectURL,
+		ReturnTo:    returnTo,
+	}
+	p.states().Set(state, ls)
+	return config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(ls.Verifier),
+		oauth2.SetAuthURLParam("nonce", ls.Nonce)), state, nil
+}
+
+// Exchange takes the login state, exchanges code with PKCE verifier and verifies id_token & nonce.
+func (p *Provider) Exchange(ctx context.Context, state, code string) (*oauth2.Token, Claims, *LoginState, error) {
+	ls, ok := cache.Take(p.states(), state)
+	if !ok || ls == nil {
+		return nil, nil, nil, ErrInvalidState
+	}
+	config, err := p.Config(ctx, ls.RedirectURL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	token, err := config.Exchange(p.clientContext(ctx), code, oauth2.VerifierOption(ls.Verifier))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	raw, _ := token.Extra(KeyIDToken).(string)
+	if raw == "" {
+		return nil, nil, nil, fmt.Errorf("%w, id_token missed", ErrInvalidToken)
+	}
+	claims, err := p.Verify(ctx, raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if claims.String("nonce") != ls.Nonce {
+		return nil, nil, nil, fmt.Errorf("%w, nonce mismatched", ErrInvalidToken)
+	}
+	return token, claims, ls, nil
+}
+
+// Refresh returns new token by refresh token.
+func (p *Provider) Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	config, err := p.Config(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	expired := &oauth2.Token{RefreshToken: refreshToken, Expiry: time.Now().Add(-time.Minute)}
+	return config.TokenSource(p.clientContext(ctx), expired).Token()
+}
+
+// LogoutURL returns RP-initiated logout URL of the issuer, postLogout empty for PostLogoutRedirect.
+func (p *Provider) LogoutURL(ctx context.Context, idTokenHint, postLogout string) (string, error) {
+	doc, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	if doc.EndSessionEndpoint == "" {
+		return "", errors.New("end_session_endpoint is not supported by the issuer")
+	}
+	if postLogout == "" {
+		postLogout = p.Settings.PostLogoutRedirect
+	}
+	params := url.Values{}
+	params.Set("client_id", p.Settings.ClientID)
+	if idTokenHint != "" {
+		params.Set("id_token_hint", idTokenHint)
+	}
+	if postLogout != "" {
+		params.Set("post_logout_redirect_uri", postLogout)
+	}
+	sep := "?"
+	if strings.Contains(doc.EndSessionEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.EndSessionEndpoint + sep + params.Encode(), nil
+}
+
+// redirectURL returns RedirectURL or callback URL of current request.
+func (p *Provider) redirectURL(c *gin.Context, callback string) string {
+	if p.Settings.RedirectURL != "" {
+		return p.Settings.RedirectURL
+	}
+	schema := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		schema = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", schema, c.Request.Host, callback)
+}
+
+// safeReturn accepts relative path only, avoid open redirect.
+func safeReturn(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return ""
+	}
+	return returnTo
+}
+
+// stateCookie sets the state cookie for the callback path, maxAge < 0 deletes it.
+// SameSite lax, the callback is a top level redirect from the issuer.
+func (p *Provider) stateCookie(c *gin.Context, callback, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CookieState, state, maxAge, callback, "", secure, true)
+}
+
+// Login returns handler redirects to the issuer, callback is the path of Callback handler.
+func (p *Provider) Login(callback string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		redirectURL := p.redirectURL(c, callback)
+		uri, state, err := p.AuthURL(c, redirectURL, safeReturn(c.Query(ParamReturn)))
+		if err != nil {
+			p.logger.Error("build auth url failed.", zap.Error(err))
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		path := callback
+		if u, err := url.Parse(redirectURL); err == nil && u.Path != "" {
+			path = u.Path
+		}
+		p.stateCookie(c, path, state, int(p.Settings.StateTTL.Seconds()))
+		c.Redirect(http.StatusSeeOther, uri)
+	}
+}
+
+// Callback exchanges code, sets KeyOauth2, KeyClaims & KeyReturnTo, next handler responds.
+func (p *Provider) Callback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		p.logger.Warn("login failed by issuer", zap.String("error", reason), zap.String("description", c.Query("error_description")))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": reason})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "code not found"})
+		return
+	}
+	// state must be the one started by this browser, or login CSRF.
+	state := c.Query("state")
+	bound, _ := c.Cookie(CookieState)
+	p.stateCookie(c, c.Request.URL.Path, "", -1)
+	if state == "" || subtle.ConstantTimeCompare([]byte(bound), []byte(state)) != 1 {
+		p.logger.Warn("login state not bound to the browser", zap.Bool("cookie", bound != ""))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": ErrInvalidState.Error()})
+		return
+	}
+	token, claims, ls, err := p.Exchange(c, state, code)
+	if err != nil {
+		p.logger.Error("exchange token failed", zap.Error(err))
+		status := http.StatusUnauthorized
+		if errors.Is(err, ErrInvalidState) {
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	p.logger.Info("login done", zap.String("sub", claims.Subject()))
+	c.Set(KeyOauth2, token)
+	c.Set(KeyReturnTo, ls.ReturnTo)
+	p.setContext(c, claims)
+	c.Next()
+}
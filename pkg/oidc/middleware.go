@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+const (
+	KeyClaims = "claims" // Claims of the verified token
+	KeyRoles  = "roles"  // roles from RolesClaim, same key as auth.KeyRoles
+)
+
+// setContext maps claims to context, user, roles & ContextClaims.
+func (p *Provider) setContext(c *gin.Context, claims Claims) {
+	c.Set(KeyClaims, claims)
+	if user := claims.String(p.Settings.UserClaim); user != "" {
+		c.Set("user", user)
+	} else {
+		c.Set("user", claims.Subject())
+	}
+	if p.Settings.RolesClaim != "" {
+		c.Set(KeyRoles, claims.Strings(p.Settings.RolesClaim))
+	}
+	for key, path := range p.Settings.ContextClaims {
+		if value := claims.Get(path); value != nil {
+			c.Set(key, claims.String(path))
+		}
+	}
+}
+
+// MustLogin verifies bearer token, 401 if missed or invalid.
+func (p *Provider) MustLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || raw == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginshared.GeneralResp{
+				ErrorCode:    "AuthFailed",
+				ErrorMessage: "bearer token missed",
+			})
+			return
+		}
+		claims, err := p.Verify(c, strings.TrimSpace(raw))
+		if err != nil {
+			p.logger.Warn("verify token failed", zap.String("path", c.Request.URL.Path), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginshared.GeneralResp{
+				ErrorCode:    "AuthFailed",
+				ErrorMessage: "invalid token",
+			})
+			return
+		}
+		p.setContext(c, claims)
+		c.Next()
+	}
+}
+
+// TokenResp returned by built-in callback & refresh endpoints.
+type TokenResp struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"`
+	ReturnTo     string `json:"return_to,omitempty"`
+}
+
+func toResp(token *oauth2.Token) TokenResp {
+	idToken, _ := token.Extra(KeyIDToken).(string)
+	return TokenResp{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.Type(),
+		RefreshToken: token.RefreshToken,
+		IDToken:      idToken,
+		ExpiresIn:    token.ExpiresIn,
+	}
+}
+
+// RefreshReq by JSON or form.
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+}
+
+// Routes registers login, callback, refresh & logout under group.
+func (p *Provider) Routes(group *gin.RouterGroup) {
+	callback := strings.TrimSuffix(group.BasePath(), "/") + "/callback"
+	group.GET("login", p.Login(callback))
+	group.GET("callback", p.Callback, func(c *gin.Context) {
+		resp := toResp(c.MustGet(KeyOauth2).(*oauth2.Token))
+		resp.ReturnTo = c.GetString(KeyReturnTo)
+		ginshared.RespondOK(c, resp)
+	})
+	group.POST("refresh", func(c *gin.Context) {
+		req := &RefreshReq{}
+		if err := c.ShouldBind(req); err != nil {
+			ginshared.ReportBadrequest(c, err)
+			return
+		}
+		token, err := p.Refresh(c, req.RefreshToken)
+		if err != nil {
+			p.logger.Warn("refresh token failed", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginshared.UnifiedResp{Error: "refresh token failed"})
+			return
+		}
+		ginshared.RespondOK(c, toResp(token))
+	})
+	group.GET("logout", func(c *gin.Context) {
+		uri, err := p.LogoutURL(c, c.Query("id_token_hint"), "")
+		if err != nil {
+			p.logger.Error("build logout url failed.", zap.Error(err))
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		c.Redirect(http.StatusSeeOther, uri)
+	})
+}
+
+func init() {
+	ginshared.GetContainer().Provide(func(router *gin.Engine, p *Provider, logger *zap.Logger) ginshared.DiController {
+		if p == nil || !p.Settings.Enabled {
+			return nil
+		}
+		base := viper.GetString("baseUri") + p.Settings.Base
+		p.Routes(router.Group(base))
+		logger.Info("oidc login enabled", zap.String("base", base))
+		return p
+	}, ginshared.ControllerOptions)
+}
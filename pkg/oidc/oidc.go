@@ -0,0 +1,327 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoKey        = errors.New("signing key is not found")
+	ErrNoExpiry     = errors.New("token without exp")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrNoAudience   = errors.New("audience or clientID required")
+)
+
+// algorithms accepted for token signatures, "none" & HMAC never accepted.
+var algorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Settings of OIDC provider, config key oidc
+type Settings struct {
+	Enabled            bool // routes of login, callback, refresh & logout
+	Issuer             string
+	ClientID           string
+	ClientSecret       string
+	Audience           []string // accepted aud, ClientID if empty
+	Scopes             []string
+	ClockSkew          time.Duration
+	JwksTTL            time.Duration // keys refetched after
+	JwksMinRefresh     time.Duration // min interval to refetch keys for unknown kid
+	StateTTL           time.Duration // login state kept in cache
+	Base               string        // routes base under baseUri
+	RedirectURL        string        // callback URL, built from the request if empty
+	PostLogoutRedirect string
+	UserClaim          string            // claim set as user
+	RolesClaim         string            // dotted path of roles claim
+	ContextClaims      map[string]string // context key -> dotted claim path, e.g. owner: tenant
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Scopes:         []string{"openid", "profile", "email"},
+		ClockSkew:      time.Minute,
+		JwksTTL:        time.Hour,
+		JwksMinRefresh: time.Minute,
+		StateTTL:       10 * time.Minute,
+		Base:           "/oidc",
+		UserClaim:      "preferred_username",
+		RolesClaim:     "realm_access.roles",
+	}
+}
+
+// Discovery is the openid-configuration document.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+}
+
+// Provider validates bearer tokens of any OIDC issuer and runs the PKCE authorization code flow.
+// discovery & keys fetched lazily and cached, keys refetched if unknown kid found (key rotation).
+type Provider struct {
+	Settings *Settings
+	Client   *http.Client
+	States   cache.CacheProvider[*LoginState] // login states, cache.NewCacheProvider if nil
+	logger   *zap.Logger
+
+	fetch     sync.Mutex // one fetch of discovery & keys at a time, guards discovery
+	discovery *Discovery
+	lock      sync.Mutex // guards keys & fetchedAt, never held during fetch
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+	stateOnce sync.Once
+}
+
+// NewProvider of settings, ErrNoAudience if neither Audience nor ClientID set, tokens of any audience never accepted.
+func NewProvider(settings *Settings, logger *zap.Logger) (*Provider, error) {
+	if logger == nil {
+		logger = zap.L()
+	}
+	if len(settings.Audience) == 0 && settings.ClientID == "" {
+		return nil, ErrNoAudience
+	}
+	settings.Issuer = strings.TrimSuffix(settings.Issuer, "/")
+	return &Provider{
+		Settings: settings,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+	}, nil
+}
+
+func loadSettings() *Settings {
+	settings := DefaultSettings()
+	if sub := viper.Sub("oidc"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return settings
+}
+
+func init() {
+	core.Provide(func(logger *zap.Logger) (*Provider, error) {
+		settings := loadSettings()
+		if settings.Issuer == "" {
+			logger.Debug("no settings for oidc")
+			return nil, nil
+		}
+		logger.Info("oidc provider", zap.String("issuer", settings.Issuer))
+		return NewProvider(settings, logger)
+	})
+}
+
+func (p *Provider) get(ctx context.Context, uri string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s failed, status %d", uri, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Discover returns the discovery document, fetched once.
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.fetch.Lock()
+	defer p.fetch.Unlock()
+	return p.discover(ctx)
+}
+
+func (p *Provider) discover(ctx context.Context) (*Discovery, error) {
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	doc := &Discovery{}
+	if err := p.get(ctx, p.Settings.Issuer+"/.well-known/openid-configuration", doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Settings.Issuer {
+		return nil, fmt.Errorf("issuer mismatched, expected %s, discovered %s", p.Settings.Issuer, doc.Issuer)
+	}
+	p.discovery = doc
+	p.logger.Info("oidc discovery loaded", zap.String("issuer", doc.Issuer), zap.String("jwks", doc.JwksURI))
+	return doc, nil
+}
+
+// loaded returns the cached keys & when fetched.
+func (p *Provider) loaded() (*jose.JSONWebKeySet, time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.keys, p.fetchedAt
+}
+
+// refreshKeys fetches JWKS unless refreshed by others after seen, verifications of known keys not blocked.
+func (p *Provider) refreshKeys(ctx context.Context, seen time.Time) (*jose.JSONWebKeySet, time.Time, error) {
+	p.fetch.Lock()
+	defer p.fetch.Unlock()
+	if keys, fetchedAt := p.loaded(); keys != nil && fetchedAt.After(seen) {
+		return keys, fetchedAt, nil
+	}
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := p.get(ctx, doc.JwksURI, keys); err != nil {
+		return nil, time.Time{}, err
+	}
+	fetchedAt := time.Now()
+	p.lock.Lock()
+	p.keys, p.fetchedAt = keys, fetchedAt
+	p.lock.Unlock()
+	p.logger.Info("oidc keys loaded", zap.Int("keys", len(keys.Keys)))
+	return keys, fetchedAt, nil
+}
+
+// key returns the verification key of kid, keys refetched when expired or kid unknown.
+func (p *Provider) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	keys, fetchedAt := p.loaded()
+	var err error
+	if keys == nil || time.Since(fetchedAt) > p.Settings.JwksTTL {
+		if keys, fetchedAt, err = p.refreshKeys(ctx, fetchedAt); err != nil {
+			return nil, err
+		}
+	}
+	if key := lookup(keys, kid); key != nil {
+		return key, nil
+	}
+	if time.Since(fetchedAt) < p.Settings.JwksMinRefresh {
+		return nil, fmt.Errorf("%w, kid %s", ErrNoKey, kid)
+	}
+	if keys, _, err = p.refreshKeys(ctx, fetchedAt); err != nil {
+		return nil, err
+	}
+	if key := lookup(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w, kid %s", ErrNoKey, kid)
+}
+
+func lookup(keys *jose.JSONWebKeySet, kid string) *jose.JSONWebKey {
+	if kid == "" {
+		if len(keys.Keys) == 1 {
+			return &keys.Keys[0]
+		}
+		return nil
+	}
+	for _, item := range keys.Key(kid) {
+		if item.Use == "" || item.Use == "sig" {
+			return &item
+		}
+	}
+	return nil
+}
+
+func (p *Provider) audience() []string {
+	if len(p.Settings.Audience) > 0 {
+		return p.Settings.Audience
+	}
+	return []string{p.Settings.ClientID}
+}
+
+// Verify checks signature, issuer, audience & time claims of the token, returns all claims.
+func (p *Provider) Verify(ctx context.Context, raw string) (Claims, error) {
+	tok, err := jwt.ParseSigned(raw, algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidToken, err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w, one signature expected", ErrInvalidToken)
+	}
+	key, err := p.key(ctx, tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+	std := jwt.Claims{}
+	claims := Claims{}
+	if err := tok.Claims(key.Key, &std, &claims); err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidToken, err)
+	}
+	if std.Expiry == nil {
+		return nil, ErrNoExpiry
+	}
+	err = std.ValidateWithLeeway(jwt.Expected{
+		Issuer:      p.Settings.Issuer,
+		AnyAudience: p.audience(),
+		Time:        time.Now(),
+	}, p.Settings.ClockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// Claims of token, nested claims by dotted path.
+type Claims map[string]any
+
+// Get returns claim by dotted path, e.g. realm_access.roles
+func (c Claims) Get(path string) any {
+	var current any = map[string]any(c)
+	for _, item := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if current, ok = m[item]; !ok {
+			return nil
+		}
+	}
+	return current
+}
+
+func (c Claims) String(path string) string {
+	switch v := c.Get(path).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Strings returns list claim, string claim split by space (e.g. scope).
+func (c Claims) Strings(path string) []string {
+	switch v := c.Get(path).(type) {
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			result = append(result, fmt.Sprint(item))
+		}
+		return result
+	case []string:
+		return v
+	case string:
+		return strings.Fields(v)
+	}
+	return nil
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
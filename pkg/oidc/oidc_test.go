@@ -0,0 +1,133 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/oidc"
+	"github.com/techquest-tech/gin-shared/pkg/oidc/oidctest"
+	"go.uber.org/zap"
+)
+
+func newProvider(issuer *oidctest.Server) *oidc.Provider {
+	settings := oidc.DefaultSettings()
+	settings.Issuer = issuer.Issuer()
+	settings.ClientID = "app"
+	settings.JwksMinRefresh = 0
+	settings.ContextClaims = map[string]string{"owner": "tenant"}
+	p, _ := oidc.NewProvider(settings, zap.NewNop())
+	p.States = cache.NewRAMCacheProvider[*oidc.LoginState](time.Minute)
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	issuer := oidctest.NewServer("app")
+	defer issuer.Close()
+	p := newProvider(issuer)
+	ctx := context.Background()
+
+	claims, err := p.Verify(ctx, issuer.Token(map[string]any{"realm_access": map[string]any{"roles": []string{"admin"}}}))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject())
+	assert.Equal(t, []string{"admin"}, claims.Strings("realm_access.roles"))
+
+	_, err = p.Verify(ctx, issuer.Token(map[string]any{"aud": "other"}))
+	assert.ErrorIs(t, err, oidc.ErrInvalidToken)
+	_, err = p.Verify(ctx, issuer.Token(map[string]any{"exp": time.Now().Add(-2 * time.Minute).Unix()}))
+	assert.ErrorIs(t, err, oidc.ErrInvalidToken)
+	_, err = p.Verify(ctx, issuer.Token(map[string]any{"exp": time.Now().Add(-30 * time.Second).Unix()}))
+	assert.NoError(t, err, "within clock skew")
+	_, err = p.Verify(ctx, issuer.Token(map[string]any{"iss": "https://evil"}))
+	assert.ErrorIs(t, err, oidc.ErrInvalidToken)
+
+	// new key fetched for unknown kid
+	issuer.Rotate()
+	_, err = p.Verify(ctx, issuer.Token(nil))
+	assert.NoError(t, err)
+
+	settings := oidc.DefaultSettings()
+	settings.Issuer = issuer.Issuer()
+	_, err = oidc.NewProvider(settings, zap.NewNop())
+	assert.ErrorIs(t, err, oidc.ErrNoAudience, "tokens of any audience never accepted")
+}
+
+func TestMustLoginAndFlow(t *testing.T) {
+	issuer := oidctest.NewServer("app")
+	defer issuer.Close()
+	p := newProvider(issuer)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Routes(r.Group("/oidc"))
+	r.GET("/me", p.MustLogin(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user": c.GetString("user"), "owner": c.GetString("owner"), "roles": c.GetStringSlice("roles")})
+	})
+	app := httptest.NewServer(r)
+	defer app.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	me := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, app.URL+"/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, me(issuer.Token(map[string]any{"tenant": "acme"})))
+	assert.Equal(t, http.StatusUnauthorized, me("bad"))
+
+	// login -> issuer authorize -> callback
+	resp, err := client.Get(app.URL + "/oidc/login?return_to=/home")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	authorize := resp.Header.Get("Location")
+	assert.Contains(t, authorize, "code_challenge_method=S256")
+	resp, err = client.Get(authorize)
+	assert.NoError(t, err)
+	callback := resp.Header.Get("Location")
+	assert.True(t, strings.HasPrefix(callback, app.URL+"/oidc/callback"))
+
+	// login CSRF, callback of others' login opened by another browser
+	other := &http.Client{CheckRedirect: client.CheckRedirect}
+	resp, err = other.Get(callback)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = client.Get(callback)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body := struct {
+		Result oidc.TokenResp
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	assert.Equal(t, "/home", body.Result.ReturnTo)
+	assert.Equal(t, http.StatusOK, me(body.Result.AccessToken))
+
+	// state is one time only
+	resp, err = client.Get(callback)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	token, err := p.Refresh(context.Background(), body.Result.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+
+	logout, err := p.LogoutURL(context.Background(), body.Result.IDToken, "https://app/bye")
+	assert.NoError(t, err)
+	assert.Contains(t, logout, issuer.Issuer()+"/logout?")
+	assert.Contains(t, logout, "post_logout_redirect_uri=")
+}
@@ -0,0 +1,205 @@
+// Package oidctest is an in-process OIDC issuer for tests: discovery, JWKS with key rotation,
+// authorization code flow with PKCE (auto approved), refresh token & end session.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/thanhpk/randstr"
+)
+
+type authCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+type Server struct {
+	*httptest.Server
+	ClientID string
+	Subject  string
+	Claims   map[string]any // extra claims of issued tokens, e.g. preferred_username, realm_access
+	TTL      time.Duration
+
+	lock    sync.Mutex
+	keys    []jose.JSONWebKey // signing keys, first one used for new tokens
+	codes   map[string]authCode
+	refresh map[string]bool
+}
+
+// NewServer starts the issuer, Issuer() is the URL.
+func NewServer(clientID string) *Server {
+	s := &Server{
+		ClientID: clientID,
+		Subject:  "user-1",
+		Claims:   map[string]any{"preferred_username": "user1"},
+		TTL:      5 * time.Minute,
+		codes:    map[string]authCode{},
+		refresh:  map[string]bool{},
+	}
+	s.Rotate()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// Rotate adds a new signing key, previous keys still published.
+func (s *Server) Rotate() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	jwk := jose.JSONWebKey{Key: key, KeyID: randstr.Hex(8), Algorithm: string(jose.RS256), Use: "sig"}
+	s.keys = append([]jose.JSONWebKey{jwk}, s.keys...)
+}
+
+// Token issues a signed token, claims override the defaults (iss, sub, aud, exp, iat).
+func (s *Server) Token(claims map[string]any) string {
+	s.lock.Lock()
+	key := s.keys[0]
+	s.lock.Unlock()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	all := map[string]any{
+		"iss": s.Issuer(),
+		"sub": s.Subject,
+		"aud": s.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.TTL).Unix(),
+	}
+	for k, v := range s.Claims {
+		all[k] = v
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	raw, err := jwt.Signed(signer).Claims(all).Serialize()
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.Issuer(),
+		"authorization_endpoint": s.Issuer() + "/authorize",
+		"token_endpoint":         s.Issuer() + "/token",
+		"jwks_uri":               s.Issuer() + "/jwks",
+		"end_session_endpoint":   s.Issuer() + "/logout",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	set := jose.JSONWebKeySet{}
+	for _, item := range s.keys {
+		set.Keys = append(set.Keys, item.Public())
+	}
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, set)
+}
+
+// authorize approves immediately, redirects with code & state.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != s.ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	code := randstr.Hex(16)
+	s.lock.Lock()
+	s.codes[code] = authCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), redirectURI: q.Get("redirect_uri")}
+	s.lock.Unlock()
+	target, _ := url.Parse(q.Get("redirect_uri"))
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != s.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	nonce, reason := s.grant(r.PostForm)
+	if reason != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": reason})
+		return
+	}
+	refresh := randstr.Hex(16)
+	s.lock.Lock()
+	s.refresh[refresh] = true
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  s.Token(nil),
+		"token_type":    "Bearer",
+		"refresh_token": refresh,
+		"id_token":      s.Token(map[string]any{"nonce": nonce}),
+		"expires_in":    int(s.TTL.Seconds()),
+	})
+}
+
+// grant checks code with PKCE verifier or refresh token, both one time only.
+func (s *Server) grant(form url.Values) (string, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code, ok := s.codes[form.Get("code")]
+		delete(s.codes, form.Get("code"))
+		sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if !ok || code.redirectURI != form.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+			return "", "invalid_grant"
+		}
+		return code.nonce, ""
+	case "refresh_token":
+		if !s.refresh[form.Get("refresh_token")] {
+			return "", "invalid_grant"
+		}
+		delete(s.refresh, form.Get("refresh_token"))
+		return "", ""
+	}
+	return "", "unsupported_grant_type"
+}
@@ -0,0 +1,171 @@
+package openapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SchemeAPIKey   = "apiKey"   // auth.AuthService, api key in header/query
+	SchemeSign     = "sign"     // auth.SignService, signed request
+	SchemeKeycloak = "keycloak" // keycloak bearer token, roles as scopes
+)
+
+// Param is a single non-body parameter of an operation.
+type Param struct {
+	Name        string
+	In          string // query, path or header. default query
+	Type        string // string, integer, number, boolean, array. default string
+	Required    bool
+	Description string
+}
+
+// Operation describes one registered route.
+type Operation struct {
+	Method      string
+	Path        string // gin style path, e.g. /v1/orders/:id
+	Summary     string
+	Description string
+	Tags        []string
+	Query       any // struct bound by ShouldBindQuery, fields with `form` tag become query params, e.g. orm.QueryBase
+	Request     any // JSON request body
+	Response    any // JSON response body for 200, page/page_size query params added for PagingResult without Query
+	Params      []Param
+	Security    []string // SchemeAPIKey, SchemeSign or SchemeKeycloak, detected from auth middlewares if empty
+	Roles       []string // keycloak realm roles required, detected from keycloak middlewares if empty
+	Deprecated  bool
+}
+
+var (
+	locker     sync.Mutex
+	operations = make(map[string]*Operation)
+)
+
+func opKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// Add records route metadata, later registration for the same method & path overwrites the early one.
+func Add(op Operation) {
+	if op.Method == "" {
+		op.Method = http.MethodGet
+	}
+	op.Method = strings.ToUpper(op.Method)
+	op.Path = joinPath(op.Path)
+
+	locker.Lock()
+	defer locker.Unlock()
+	operations[opKey(op.Method, op.Path)] = &op
+}
+
+// Remove drops the operation of method & path, e.g. routes served dynamically and removed since.
+func Remove(method, path string) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	delete(operations, opKey(method, joinPath(path)))
+}
+
+// Handle registers handlers to the routes and records the route metadata for the spec,
+// Security & Roles detected from auth middlewares of the group & handlers if not set.
+func Handle(r gin.IRoutes, method, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	result := r.Handle(method, relativePath, handlers...)
+
+	op := Operation{}
+	if doc != nil {
+		op = *doc
+	}
+	op.Method = method
+	op.Path = joinPath(basePath(r), relativePath)
+	chain := append(gin.HandlersChain{}, groupHandlers(r)...)
+	Detect(&op, append(chain, handlers...)...)
+	Add(op)
+	return result
+}
+
+func GET(r gin.IRoutes, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return Handle(r, http.MethodGet, relativePath, doc, handlers...)
+}
+
+func POST(r gin.IRoutes, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return Handle(r, http.MethodPost, relativePath, doc, handlers...)
+}
+
+func PUT(r gin.IRoutes, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return Handle(r, http.MethodPut, relativePath, doc, handlers...)
+}
+
+func PATCH(r gin.IRoutes, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return Handle(r, http.MethodPatch, relativePath, doc, handlers...)
+}
+
+func DELETE(r gin.IRoutes, relativePath string, doc *Operation, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return Handle(r, http.MethodDelete, relativePath, doc, handlers...)
+}
+
+// Operations returns all recorded operations ordered by path & method.
+func Operations() []Operation {
+	locker.Lock()
+	result := make([]Operation, 0, len(operations))
+	for _, op := range operations {
+		result = append(result, *op)
+	}
+	locker.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Path == result[j].Path {
+			return result[i].Method < result[j].Method
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// Reset drops all recorded operations.
+func Reset() {
+	locker.Lock()
+	operations = make(map[string]*Operation)
+	locker.Unlock()
+}
+
+func basePath(r gin.IRoutes) string {
+	if g, ok := r.(interface{ BasePath() string }); ok {
+		return g.BasePath()
+	}
+	return ""
+}
+
+func joinPath(parts ...string) string {
+	result := ""
+	for _, item := range parts {
+		item = strings.Trim(item, "/")
+		if item == "" {
+			continue
+		}
+		result = result + "/" + item
+	}
+	if result == "" {
+		return "/"
+	}
+	return result
+}
+
+// toOpenapiPath converts gin path(/orders/:id/*file) to openapi path(/orders/{id}/{file}), and returns path params.
+func toOpenapiPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	params := make([]string, 0)
+	for index, item := range segments {
+		if strings.HasPrefix(item, ":") || strings.HasPrefix(item, "*") {
+			name := item[1:]
+			params = append(params, name)
+			segments[index] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type pagingResult[T any] struct {
+	Page     int
+	PageSize int
+	Total    int64
+	Error    error
+	Data     []T
+}
+
+type order struct {
+	ID        uint      `json:"id"`
+	Code      string    `json:"code"`
+	CreatedAt time.Time `json:"createdAt"`
+	Secret    string    `json:"-"`
+}
+
+type orderQuery struct {
+	Page   int    `form:"page"`
+	Status string `form:"status" binding:"required"`
+}
+
+func TestToOpenapiPath(t *testing.T) {
+	path, params := toOpenapiPath("/v1/orders/:id/files/*file")
+	assert.Equal(t, "/v1/orders/{id}/files/{file}", path)
+	assert.Equal(t, []string{"id", "file"}, params)
+}
+
+func TestSchemaGeneric(t *testing.T) {
+	b := newSchemaBuilder()
+	s := b.schemaOf(pagingResult[order]{})
+	assert.Equal(t, "#/components/schemas/pagingResult_order", s.Ref)
+
+	paging := b.components["pagingResult_order"]
+	assert.NotNil(t, paging)
+	assert.Equal(t, "array", paging.Properties["Data"].Type)
+	assert.Equal(t, "#/components/schemas/order", paging.Properties["Data"].Items.Ref)
+	assert.Equal(t, "string", paging.Properties["Error"].Type)
+
+	o := b.components["order"]
+	assert.Equal(t, "date-time", o.Properties["createdAt"].Format)
+	assert.NotContains(t, o.Properties, "Secret")
+}
+
+func TestHandle(t *testing.T) {
+	Reset()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group("/v1")
+	GET(group, "/orders/:id", &Operation{
+		Query:    orderQuery{},
+		Response: order{},
+		Security: []string{SchemeAPIKey},
+	}, func(c *gin.Context) {})
+
+	doc := Build()
+	op, ok := doc.Paths["/v1/orders/{id}"]["get"]
+	assert.True(t, ok)
+	assert.Len(t, op.Parameters, 3)
+	assert.Equal(t, "path", op.Parameters[0].In)
+	assert.True(t, op.Parameters[2].Required)
+	assert.Contains(t, doc.Components.SecuritySchemes, SchemeAPIKey)
+
+	assert.Len(t, r.Routes(), 1)
+	assert.Equal(t, http.MethodGet, r.Routes()[0].Method)
+}
+
+type PagingResult[T any] struct {
+	Data []T
+}
+
+type authService struct{}
+
+func (a *authService) Auth(c *gin.Context) {}
+
+func TestDetect(t *testing.T) {
+	Reset()
+	gin.SetMode(gin.TestMode)
+	RegisterAuth(SchemeAPIKey, (*authService)(nil).Auth)
+	roleAuth := func(role string) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Set("role", role) }
+	}
+	admin := Secured(SchemeKeycloak, []string{"admin"}, roleAuth("admin"))
+
+	r := gin.New()
+	authed := r.Group("/v1").Use((&authService{}).Auth)
+	GET(authed, "/orders", &Operation{Response: PagingResult[order]{}}, func(c *gin.Context) {})
+	GET(r, "/public", &Operation{Query: orderQuery{}, Response: PagingResult[order]{}}, roleAuth("other"), func(c *gin.Context) {})
+	POST(r, "/admin", nil, admin, func(c *gin.Context) {})
+
+	doc := Build()
+	op := doc.Paths["/v1/orders"]["get"]
+	assert.Equal(t, []map[string][]string{{SchemeAPIKey: {}}}, op.Security, "by auth of the group")
+	assert.Len(t, op.Parameters, 2, "page & page_size of PagingResult")
+	op = doc.Paths["/public"]["get"]
+	assert.Empty(t, op.Security, "closure not secured")
+	assert.Len(t, op.Parameters, 2, "paging by Query")
+	op = doc.Paths["/admin"]["post"]
+	assert.Equal(t, []map[string][]string{{SchemeKeycloak: {"admin"}}}, op.Security)
+}
+
+func TestSwaggerAssets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admin := adminRouter(loadSettings())
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	w := get("/swagger")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "https://")
+	for _, asset := range []string{"/swagger-ui/swagger-ui.css", "/swagger-ui/swagger-ui-bundle.js"} {
+		assert.Equal(t, http.StatusOK, get(asset).Code, asset)
+	}
+}
@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of openapi 3 schema object used by the generator.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	pkgPathRegexp = regexp.MustCompile(`[\w\-\.]+(/[\w\-\.]+)*\.`)
+	invalidName   = regexp.MustCompile(`[^A-Za-z0-9_\.\-]`)
+)
+
+type schemaBuilder struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+// componentName returns a readable name for named types, generic type args drop package path.
+// e.g. orm.PagingResult[github.com/a/b.Order] => PagingResult_Order
+func componentName(t reflect.Type) string {
+	name := pkgPathRegexp.ReplaceAllString(t.Name(), "")
+	name = strings.NewReplacer("[", "_", "]", "", ",", "_", "*", "").Replace(name)
+	return invalidName.ReplaceAllString(name, "")
+}
+
+func (b *schemaBuilder) schemaOf(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	return b.schema(t)
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time", Nullable: nullable}
+	case t == rawType:
+		return &Schema{}
+	case t.Kind() != reflect.Struct && (t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType)):
+		return &Schema{Type: "string", Nullable: nullable}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean", Nullable: nullable}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32", Nullable: nullable}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64", Nullable: nullable}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float", Nullable: nullable}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double", Nullable: nullable}
+	case reflect.String:
+		return &Schema{Type: "string", Nullable: nullable}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: nullable}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem()), Nullable: nullable}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem()), Nullable: nullable}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+			// struct with customized json format, e.g. gorm.DeletedAt
+			return &Schema{Nullable: nullable}
+		}
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name, ok := b.names[t]
+		if !ok {
+			name = b.uniqueName(t)
+			b.names[t] = name
+			b.components[name] = &Schema{Type: "object"} // placeholder for recursive types
+			b.components[name] = b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+func (b *schemaBuilder) uniqueName(t reflect.Type) string {
+	name := componentName(t)
+	if _, ok := b.components[name]; !ok {
+		return name
+	}
+	pkg := t.PkgPath()
+	if index := strings.LastIndexByte(pkg, '/'); index >= 0 {
+		pkg = pkg[index+1:]
+	}
+	base := pkg + "." + name
+	name = base
+	for i := 2; ; i++ {
+		if _, ok := b.components[name]; !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	b.fields(t, s)
+	return s
+}
+
+func (b *schemaBuilder) fields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, omit := jsonName(f)
+		if omit {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			b.fields(ft, s)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if ft.Kind() == reflect.Func || ft.Kind() == reflect.Chan {
+			continue
+		}
+		if ft.Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+			s.Properties[name] = &Schema{Type: "string", Nullable: true}
+			continue
+		}
+		s.Properties[name] = b.schema(f.Type)
+	}
+}
+
+// jsonName returns name from json tag, empty means use field name.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	return name, false
+}
+
+// queryParams converts struct fields with `form` tag to query params.
+func queryParams(v any) []Param {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	result := make([]Param, 0)
+	collectQueryParams(t, &result)
+	return result
+}
+
+func collectQueryParams(t reflect.Type, result *[]Param) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		tag := f.Tag.Get("form")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			collectQueryParams(ft, result)
+			continue
+		}
+		if name == "" || !f.IsExported() {
+			continue
+		}
+		p := Param{
+			Name:     name,
+			In:       "query",
+			Type:     paramType(ft),
+			Required: strings.Contains(f.Tag.Get("binding"), "required"),
+		}
+		*result = append(*result, p)
+	}
+}
+
+func paramType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "string"
+}
@@ -0,0 +1,83 @@
+package openapi
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// security implied by an auth middleware.
+type security struct {
+	scheme  string
+	roles   []string
+	handler gin.HandlerFunc // keeps the closure alive, so its address is not reused
+}
+
+var (
+	authFuncs    = make(map[uintptr]security) // by code, any value of the function or method
+	authHandlers = make(map[uintptr]security) // by func value, e.g. a closure of roles
+)
+
+// RegisterAuth marks every value of function or method h as auth middleware of scheme,
+// e.g. RegisterAuth(SchemeAPIKey, (*auth.AuthService)(nil).Auth) for all values of authService.Auth
+func RegisterAuth(scheme string, h gin.HandlerFunc) {
+	locker.Lock()
+	defer locker.Unlock()
+	authFuncs[reflect.ValueOf(h).Pointer()] = security{scheme: scheme}
+}
+
+// Secured marks h, a closure created for roles, as auth middleware of scheme & returns it.
+func Secured(scheme string, roles []string, h gin.HandlerFunc) gin.HandlerFunc {
+	locker.Lock()
+	defer locker.Unlock()
+	authHandlers[handlerID(h)] = security{scheme: scheme, roles: roles, handler: h}
+	return h
+}
+
+// handlerID is the address of the func value, shared by copies, different for each closure created.
+func handlerID(h gin.HandlerFunc) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&h))
+}
+
+// Detect sets Security & Roles of op by auth middlewares in handlers, unless set already.
+func Detect(op *Operation, handlers ...gin.HandlerFunc) {
+	schemes := make([]string, 0)
+	roles := make([]string, 0)
+	locker.Lock()
+	for _, h := range handlers {
+		if h == nil {
+			continue
+		}
+		s, ok := authHandlers[handlerID(h)]
+		if !ok {
+			s, ok = authFuncs[reflect.ValueOf(h).Pointer()]
+		}
+		if !ok {
+			continue
+		}
+		if !lo.Contains(schemes, s.scheme) {
+			schemes = append(schemes, s.scheme)
+		}
+		roles = lo.Union(roles, s.roles)
+	}
+	locker.Unlock()
+	if len(op.Security) == 0 && len(schemes) > 0 {
+		op.Security = schemes
+	}
+	if len(op.Roles) == 0 && len(roles) > 0 {
+		op.Roles = roles
+	}
+}
+
+// groupHandlers returns middlewares of the router group, e.g. auth used by the group.
+func groupHandlers(r gin.IRoutes) gin.HandlersChain {
+	switch g := r.(type) {
+	case *gin.RouterGroup:
+		return g.Handlers
+	case *gin.Engine:
+		return g.Handlers
+	}
+	return nil
+}
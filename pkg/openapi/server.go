@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+)
+
+//go:embed swagger.html
+var swaggerPage string
+
+// swaggerAssets of swagger-ui-dist, served by the admin listener, no CDN required.
+//
+//go:embed swagger-ui
+var swaggerAssets embed.FS
+
+var swaggerTmpl = template.Must(template.New("swagger").Parse(swaggerPage))
+
+type Settings struct {
+	Enabled bool
+	Path    string // spec uri, default /openapi.json
+	Swagger bool   // serve swagger ui on admin listener
+	Admin   string // admin listener address, default localhost:6061
+}
+
+type OpenapiComponent struct {
+	ginshared.DefaultComponent
+}
+
+func loadSettings() *Settings {
+	settings := &Settings{
+		Path:  "/openapi.json",
+		Admin: "localhost:6061",
+	}
+	if sub := viper.Sub("openapi"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return settings
+}
+
+// ServeSpec is gin handler for openapi json.
+func ServeSpec(c *gin.Context) {
+	c.JSON(http.StatusOK, Build())
+}
+
+func serveSwagger(specURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		err := swaggerTmpl.Execute(c.Writer, map[string]string{
+			"Title":   core.AppName,
+			"SpecURL": specURL,
+		})
+		if err != nil {
+			zap.L().Error("render swagger ui failed.", zap.Error(err))
+		}
+	}
+}
+
+func (oc *OpenapiComponent) OnEngineInited(r *gin.Engine) error {
+	logger := zap.L()
+	settings := loadSettings()
+	if !settings.Enabled {
+		logger.Debug("openapi is disabled.")
+		return nil
+	}
+	r.GET(settings.Path, ServeSpec)
+	logger.Info("openapi spec enabled.", zap.String("path", settings.Path))
+
+	if settings.Swagger && settings.Admin != "" {
+		startAdmin(settings, logger)
+	}
+	return nil
+}
+
+// adminRouter serves swagger ui, the embedded assets & spec.
+func adminRouter(settings *Settings) *gin.Engine {
+	admin := gin.New()
+	admin.Use(gin.Recovery())
+	admin.GET(settings.Path, ServeSpec)
+	admin.GET("/swagger", serveSwagger(settings.Path))
+	assets, _ := fs.Sub(swaggerAssets, "swagger-ui")
+	admin.StaticFS("/swagger-ui", http.FS(assets))
+	admin.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/swagger")
+	})
+	return admin
+}
+
+// startAdmin serves swagger ui & spec on a separated listener, keep it internal only.
+func startAdmin(settings *Settings, logger *zap.Logger) {
+	svc := &http.Server{
+		Addr:    settings.Admin,
+		Handler: adminRouter(settings),
+	}
+	logger = logger.With(zap.String("address", settings.Admin))
+
+	core.OnServiceStarted(func() {
+		logger.Info("swagger ui starting")
+		if err := svc.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("start swagger ui failed.", zap.Error(err))
+		}
+	})
+	core.OnServiceStopping(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := svc.Shutdown(ctx); err != nil {
+			logger.Warn("stop swagger ui failed.", zap.Error(err))
+		}
+	})
+}
+
+func init() {
+	ginshared.RegisterComponent(&OpenapiComponent{})
+}
@@ -0,0 +1,270 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+)
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type ParamObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type OperationObject struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []ParamObject         `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type             string `json:"type"`
+	Name             string `json:"name,omitempty"`
+	In               string `json:"in,omitempty"`
+	Scheme           string `json:"scheme,omitempty"`
+	BearerFormat     string `json:"bearerFormat,omitempty"`
+	OpenIdConnectUrl string `json:"openIdConnectUrl,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// Document is the openapi 3 document served as /openapi.json
+type Document struct {
+	Openapi    string                                `json:"openapi"`
+	Info       Info                                  `json:"info"`
+	Servers    []Server                              `json:"servers,omitempty"`
+	Paths      map[string]map[string]OperationObject `json:"paths"`
+	Components Components                            `json:"components"`
+}
+
+// Build generates openapi document from all recorded operations.
+func Build() *Document {
+	doc := &Document{
+		Openapi: "3.0.3",
+		Info: Info{
+			Title:       core.AppName,
+			Version:     core.Version,
+			Description: viper.GetString("openapi.description"),
+		},
+		Paths: make(map[string]map[string]OperationObject),
+	}
+	if title := viper.GetString("openapi.title"); title != "" {
+		doc.Info.Title = title
+	}
+	if domain := viper.GetString("domain"); domain != "" {
+		doc.Servers = []Server{{URL: "https://" + domain}}
+	}
+
+	b := newSchemaBuilder()
+	usedSchemes := make(map[string]bool)
+
+	for _, op := range Operations() {
+		path, pathParams := toOpenapiPath(op.Path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(map[string]OperationObject)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = buildOperation(b, op, pathParams, usedSchemes)
+	}
+
+	doc.Components.Schemas = b.components
+	doc.Components.SecuritySchemes = securitySchemes(usedSchemes)
+	return doc
+}
+
+func buildOperation(b *schemaBuilder, op Operation, pathParams []string, usedSchemes map[string]bool) OperationObject {
+	result := OperationObject{
+		Summary:     op.Summary,
+		Description: op.Description,
+		OperationID: operationID(op),
+		Tags:        op.Tags,
+		Deprecated:  op.Deprecated,
+		Responses:   make(map[string]Response),
+	}
+	if len(result.Tags) == 0 {
+		result.Tags = []string{defaultTag(op.Path)}
+	}
+
+	seen := make(map[string]bool)
+	addParam := func(p Param) {
+		if p.In == "" {
+			p.In = "query"
+		}
+		if seen[p.In+p.Name] {
+			return
+		}
+		seen[p.In+p.Name] = true
+		t := p.Type
+		if t == "" {
+			t = "string"
+		}
+		s := &Schema{Type: t}
+		if t == "array" {
+			s.Items = &Schema{Type: "string"}
+		}
+		result.Parameters = append(result.Parameters, ParamObject{
+			Name:        p.Name,
+			In:          p.In,
+			Required:    p.Required || p.In == "path",
+			Description: p.Description,
+			Schema:      s,
+		})
+	}
+
+	for _, name := range pathParams {
+		addParam(Param{Name: name, In: "path"})
+	}
+	for _, p := range op.Params {
+		addParam(p)
+	}
+	for _, p := range queryParams(op.Query) {
+		addParam(p)
+	}
+	if op.Query == nil && paging(op.Response) {
+		addParam(Param{Name: "page", Type: "integer", Description: "page index, start from 0"})
+		addParam(Param{Name: "page_size", Type: "integer", Description: "page size, -1 for all records"})
+	}
+
+	if op.Request != nil {
+		result.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/json": {Schema: b.schemaOf(op.Request)},
+			},
+		}
+	}
+
+	ok := Response{Description: "OK"}
+	if op.Response != nil {
+		ok.Content = map[string]MediaType{
+			"application/json": {Schema: b.schemaOf(op.Response)},
+		}
+	}
+	result.Responses["200"] = ok
+
+	for _, scheme := range op.Security {
+		usedSchemes[scheme] = true
+		scopes := []string{}
+		if scheme == SchemeKeycloak && len(op.Roles) > 0 {
+			scopes = op.Roles
+		}
+		result.Security = append(result.Security, map[string][]string{scheme: scopes})
+	}
+	if len(op.Security) > 0 {
+		result.Responses["401"] = Response{Description: http.StatusText(http.StatusUnauthorized)}
+	}
+	return result
+}
+
+// paging reports whether response is a PagingResult, e.g. orm.PagingResult[T].
+func paging(response any) bool {
+	if response == nil {
+		return false
+	}
+	t := reflect.TypeOf(response)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return strings.HasPrefix(t.Name(), "PagingResult[")
+}
+
+func operationID(op Operation) string {
+	replacer := strings.NewReplacer("/", "_", ":", "", "*", "", "{", "", "}", "", "-", "_", ".", "_")
+	return strings.ToLower(op.Method) + replacer.Replace(op.Path)
+}
+
+// defaultTag uses the first path segment after base uri as tag.
+func defaultTag(path string) string {
+	base := strings.Trim(viper.GetString("baseUri"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if base != "" && len(segments) > 1 && segments[0] == base {
+		segments = segments[1:]
+	}
+	if len(segments) == 0 || segments[0] == "" {
+		return "default"
+	}
+	return strings.TrimLeft(segments[0], ":*")
+}
+
+func securitySchemes(used map[string]bool) map[string]*SecurityScheme {
+	if len(used) == 0 {
+		return nil
+	}
+	result := make(map[string]*SecurityScheme)
+	if used[SchemeAPIKey] {
+		viper.SetDefault("auth.headerKey", "apiKey")
+		result[SchemeAPIKey] = &SecurityScheme{
+			Type:        "apiKey",
+			In:          "header",
+			Name:        viper.GetString("auth.headerKey"),
+			Description: "api key, also accepted in query string or post form",
+		}
+	}
+	if used[SchemeSign] {
+		viper.SetDefault("auth.sign.keySign", "sign")
+		result[SchemeSign] = &SecurityScheme{
+			Type:        "apiKey",
+			In:          "header",
+			Name:        viper.GetString("auth.sign.keySign"),
+			Description: "request signature, app & timestamp headers are required as well",
+		}
+	}
+	if used[SchemeKeycloak] {
+		url := strings.TrimRight(viper.GetString("keycloak.url"), "/")
+		realm := viper.GetString("keycloak.realm")
+		if url != "" && realm != "" {
+			result[SchemeKeycloak] = &SecurityScheme{
+				Type:             "openIdConnect",
+				OpenIdConnectUrl: url + "/realms/" + realm + "/.well-known/openid-configuration",
+			}
+		} else {
+			result[SchemeKeycloak] = &SecurityScheme{
+				Type:         "http",
+				Scheme:       "bearer",
+				BearerFormat: "JWT",
+			}
+		}
+	}
+	return result
+}
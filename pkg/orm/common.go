@@ -1,8 +1,13 @@
 package orm
 
 import (
+	"fmt"
+	"reflect"
+
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
 type QueryBase struct {
@@ -12,6 +17,12 @@ type QueryBase struct {
 	PageSize  int    `form:"pageSize"`
 	Q         string `form:"q"`
 	OrderBy   string `form:"orderBy"`
+	Cursor    string `form:"cursor"`   // keyset paging, token from Next or Prev of the previous page
+	Keyset    bool   `form:"keyset"`   // keyset paging for the first page
+	Estimate  bool   `form:"estimate"` // keyset paging total from catalog stats if supported
+	Filter    string `form:"filter"`   // e.g. status:in:a,b;created_at:gte:2024-01-01, see AllowList
+	Sort      string `form:"sort"`     // e.g. -created_at,name
+	Fields    string `form:"fields"`   // e.g. id,name
 }
 
 type PagingResult[T any] struct {
@@ -21,9 +32,15 @@ type PagingResult[T any] struct {
 	Total     int64
 	Error     error
 	Data      []T
+	Next      string `json:",omitempty"`
+	Prev      string `json:",omitempty"`
+	Estimated bool   `json:",omitempty"`
 }
 
 func (p *PagingResult[T]) Trigger(tx *gorm.DB, req QueryBase) error {
+	if req.Keyset || req.Cursor != "" {
+		return p.keyset(tx, req)
+	}
 	result := make([]T, 0)
 	p.Page = req.Page
 	p.PageSize = req.PageSize
@@ -55,3 +72,113 @@ func (p *PagingResult[T]) Trigger(tx *gorm.DB, req QueryBase) error {
 	}
 	return nil
 }
+
+// sortKeysOf returns order by of the query, e.g. applied by crud, or parsed from orderBy if none applied.
+func sortKeysOf(tx *gorm.DB, sch *schema.Schema, orderBy string) ([]SortKey, error) {
+	keys := make([]SortKey, 0)
+	if c, ok := tx.Statement.Clauses["ORDER BY"]; ok {
+		if ob, ok := c.Expression.(clause.OrderBy); ok {
+			for _, item := range ob.Columns {
+				if item.Column.Raw {
+					return nil, fmt.Errorf("raw order %s is not supported by keyset paging", item.Column.Name)
+				}
+				column := item.Column.Name
+				if item.Column.Table != "" && item.Column.Table != clause.CurrentTable {
+					column = item.Column.Table + "." + column
+				}
+				keys = append(keys, SortKey{Column: column, Desc: item.Desc})
+			}
+			return keys, nil
+		}
+	}
+	keys, err := ParseSort(orderBy)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range keys {
+		f := sch.LookUpField(item.Column)
+		if f == nil || f.DBName == "" {
+			return nil, fmt.Errorf("sort on %s is not allowed", item.Column)
+		}
+		keys[i].Column = f.DBName
+	}
+	return keys, nil
+}
+
+// keyset fetches the page after (or before) the cursor, PageSize+1 rows to tell if there are more.
+func (p *PagingResult[T]) keyset(tx *gorm.DB, req QueryBase) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(new(T)); err != nil {
+		return err
+	}
+	sch := stmt.Schema
+	pk := sch.PrioritizedPrimaryField
+	if pk == nil {
+		return fmt.Errorf("%s has no primary key", sch.Name)
+	}
+	keys, err := sortKeysOf(tx, sch, req.OrderBy)
+	if err != nil {
+		return err
+	}
+	keys = WithTieBreaker(keys, pk.DBName)
+	fields := make([]*schema.Field, len(keys))
+	for i, item := range keys {
+		if fields[i] = sch.LookUpField(fieldOf(item.Column)); fields[i] == nil {
+			return fmt.Errorf("sort on %s is not allowed", item.Column)
+		}
+	}
+
+	p.PageSize = req.PageSize
+	if p.PageSize <= 0 {
+		p.PageSize = 20
+	}
+	if req.Estimate {
+		var t T
+		total, estimated, err := int64(0), false, error(nil)
+		if Unfiltered(tx, t) {
+			total, estimated, err = EstimateCount(tx, sch.Table)
+		}
+		if err == nil && !estimated {
+			err = tx.Session(&gorm.Session{}).Model(t).Count(&total).Error
+		}
+		if err != nil {
+			zap.L().Error("count total failed.", zap.Error(err))
+			return err
+		}
+		p.Total = total
+		p.Estimated = estimated
+		p.TotalPage = (total + int64(p.PageSize) - 1) / int64(p.PageSize)
+	}
+
+	backward := false
+	if req.Cursor != "" {
+		values, back, err := DecodeCursor(keys, req.Cursor)
+		if err != nil {
+			return err
+		}
+		backward = back
+		cond, params := KeysetCond(keys, values, backward, func(column string) string {
+			return tx.Statement.Quote(column)
+		})
+		tx = tx.Where(cond, params...)
+	}
+	for i, item := range keys {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: item.Column}, Desc: item.Desc != backward, Reorder: i == 0})
+	}
+
+	result := make([]T, 0)
+	if err := tx.Limit(p.PageSize + 1).Find(&result).Error; err != nil {
+		zap.L().Error("query keyset page failed.", zap.Error(err))
+		return err
+	}
+	ctx := tx.Statement.Context
+	p.Data, p.Next, p.Prev, err = KeysetPage(result, keys, p.PageSize, backward, req.Cursor != "", func(row T) []any {
+		rv := reflect.Indirect(reflect.ValueOf(row))
+		values := make([]any, len(fields))
+		for i, f := range fields {
+			values[i], _ = f.ValueOf(ctx, rv)
+		}
+		return values
+	})
+	return err
+}
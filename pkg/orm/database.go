@@ -12,6 +12,7 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
+	"gorm.io/plugin/dbresolver"
 )
 
 func init() {
@@ -27,11 +28,11 @@ type OrmDialector func(dsn string) gorm.Dialector
 
 var (
 	DialectorMap = make(map[string]OrmDialector)
-	Connections  = make(map[string]*gorm.DB)
+	Connections  = make(map[string]*gorm.DB) // connections opened by Registry, keyed by config key
 )
 
 func InitDefaultDB(logger *zap.Logger) *gorm.DB {
-	return InitDBWithPrefix("database", "")
+	return InitDBWithPrefix(KeyDefault, "")
 }
 
 func SessionWithConfig(slowThreshold time.Duration, ignoredNotFound bool) *gorm.Session {
@@ -46,13 +47,18 @@ func SessionWithConfig(slowThreshold time.Duration, ignoredNotFound bool) *gorm.
 	}
 }
 
+// InitDBWithPrefix returns connection of the config key, opened once by the registry, panics if failed.
 func InitDBWithPrefix(sub string, prefix string) *gorm.DB {
-	logger := zap.L()
-	dbSettings := viper.Sub(sub)
-	if dbSettings == nil {
-		return nil
+	db, err := registry.open(sub, prefix)
+	if err != nil {
+		panic(err)
 	}
+	return db
+}
 
+// openDB opens the pool of the settings, replicas (DSN list) registered by dbresolver for reads.
+func openDB(dbSettings *viper.Viper, prefix string) (*gorm.DB, error) {
+	logger := zap.L()
 	dbSettings.SetDefault("type", "mysql")
 
 	dbType := dbSettings.GetString("type")
@@ -65,7 +71,7 @@ func InitDBWithPrefix(sub string, prefix string) *gorm.DB {
 	f, ok := DialectorMap[dbType]
 
 	if !ok {
-		panic(fmt.Errorf("driver %s is missed", dbType))
+		return nil, fmt.Errorf("driver %s is missed", dbType)
 	}
 
 	cfg := &gorm.Config{
@@ -92,7 +98,7 @@ func InitDBWithPrefix(sub string, prefix string) *gorm.DB {
 	db, err := gorm.Open(f(uri), cfg)
 
 	if err != nil {
-		panic(fmt.Sprintf("connect to db failed. err: %+v", err))
+		return nil, fmt.Errorf("connect to db failed. err: %+v", err)
 	}
 
 	// See "Important settings" section.
@@ -103,13 +109,31 @@ func InitDBWithPrefix(sub string, prefix string) *gorm.DB {
 
 	err = pool.Ping()
 	if err != nil {
-		panic(fmt.Errorf("connect to %s failed, %v", dbType, err))
+		return nil, fmt.Errorf("connect to %s failed, %v", dbType, err)
+	}
+
+	if replicas := dbSettings.GetStringSlice("replicas"); len(replicas) > 0 {
+		dialectors := make([]gorm.Dialector, 0, len(replicas))
+		for _, item := range replicas {
+			dialectors = append(dialectors, f(item))
+		}
+		resolver := dbresolver.Register(dbresolver.Config{Replicas: dialectors}).
+			SetConnMaxIdleTime(maxLifetime).
+			SetMaxOpenConns(max).
+			SetMaxIdleConns(idel)
+		if err := db.Use(resolver); err != nil {
+			return nil, fmt.Errorf("register replicas failed, %v", err)
+		}
+		if err := registerPrimaryCallbacks(db); err != nil {
+			return nil, err
+		}
+		logger.Info("read replicas registered", zap.Int("replicas", len(replicas)))
 	}
 
 	// pool = db
 	logger.Info("connected to " + dbType)
 
-	return db
+	return db, nil
 }
 
 func InitDB(sub string, logger *zap.Logger) *gorm.DB {
@@ -0,0 +1,226 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DDL statement recorded by dry run.
+type DDL struct {
+	Source      string // automigrate, views, migration <version>, post migrate
+	SQL         string
+	Destructive bool
+}
+
+// DryRunResult of initDB, statements in execution order.
+type DryRunResult struct {
+	Dialect    string
+	Statements []DDL
+}
+
+var (
+	destructive = regexp.MustCompile(`(?is)^\s*(DROP\s+(TABLE|COLUMN|INDEX|SCHEMA|DATABASE)|TRUNCATE|DELETE\s|ALTER\s+TABLE\s+.*\s(DROP|RENAME|MODIFY|CHANGE)\s|ALTER\s+TABLE\s+.*\sALTER\s+COLUMN\s+.*\sTYPE\s|EXEC\s+sp_rename)`)
+	savepoint   = regexp.MustCompile(`(?i)^\s*(SAVEPOINT|RELEASE\s+SAVEPOINT|ROLLBACK\s+TO)`)
+	readonly    = regexp.MustCompile(`(?i)^\s*(SELECT|SHOW|DESCRIBE|PRAGMA\s+[\w.]+\s*(\([^=;]*\))?\s*;?\s*$)`)
+)
+
+// IsDestructive reports statements may lose data: drops (views excluded, they are recreated), renames, type changes & deletes.
+func IsDestructive(stmt string) bool {
+	return destructive.MatchString(stmt)
+}
+
+// Destructive statements of the result.
+func (r *DryRunResult) Destructive() []DDL {
+	result := make([]DDL, 0)
+	for _, item := range r.Statements {
+		if item.Destructive {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Write prints statements grouped by source, destructive ones marked.
+func (r *DryRunResult) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "-- dialect: %s, statements: %d, destructive: %d\n", r.Dialect, len(r.Statements), len(r.Destructive())); err != nil {
+		return err
+	}
+	source := ""
+	for _, item := range r.Statements {
+		if item.Source != source {
+			source = item.Source
+			fmt.Fprintf(w, "\n-- %s\n", source)
+		}
+		if item.Destructive {
+			fmt.Fprintln(w, "-- DESTRUCTIVE")
+		}
+		stmt := strings.TrimSpace(item.SQL)
+		if !strings.HasSuffix(stmt, ";") && !strings.HasPrefix(stmt, "--") {
+			stmt += ";"
+		}
+		if _, err := fmt.Fprintln(w, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recorder is the ConnPool of dry run, reads go to the DB so the migrator sees the current schema, others recorded only.
+// writes through queries (postgres RETURNING, sqlserver OUTPUT INSERTED) are recorded as well, returning no rows.
+// It acts as a transaction so DB resolvers keep it and nested transactions become savepoints, which are skipped.
+type recorder struct {
+	pool    *sql.DB
+	db      *gorm.DB
+	lock    sync.Mutex
+	source  string
+	results []DDL
+}
+
+// isRead reports query is safe to run in dry run, SELECT (information_schema included), SHOW & reading PRAGMA.
+func isRead(query string) bool {
+	return readonly.MatchString(query)
+}
+
+func (r *recorder) record(query string, args ...any) {
+	stmt := query
+	if len(args) > 0 {
+		stmt = r.db.Dialector.Explain(query, args...)
+	}
+	r.lock.Lock()
+	r.results = append(r.results, DDL{Source: r.source, SQL: stmt, Destructive: IsDestructive(stmt)})
+	r.lock.Unlock()
+}
+
+// noRows is a query returning nothing, result of the recorded queries.
+func (r *recorder) noRows() string {
+	if r.db.Dialector.Name() == "mysql" {
+		return "SELECT 1 FROM DUAL WHERE 1 = 0"
+	}
+	return "SELECT 1 WHERE 1 = 0"
+}
+
+func (r *recorder) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if !isRead(query) {
+		return nil, fmt.Errorf("dry run: prepared statement is not supported, %s", query)
+	}
+	return r.pool.PrepareContext(ctx, query)
+}
+
+func (r *recorder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if savepoint.MatchString(query) {
+		return driverResult(0), nil
+	}
+	r.record(query, args...)
+	return driverResult(0), nil
+}
+
+func (r *recorder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if isRead(query) {
+		return r.pool.QueryContext(ctx, query, args...)
+	}
+	r.record(query, args...)
+	return r.pool.QueryContext(ctx, r.noRows())
+}
+
+func (r *recorder) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if isRead(query) {
+		return r.pool.QueryRowContext(ctx, query, args...)
+	}
+	r.record(query, args...)
+	return r.pool.QueryRowContext(ctx, r.noRows())
+}
+
+func (r *recorder) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return r, nil
+}
+
+func (r *recorder) Commit() error   { return nil }
+func (r *recorder) Rollback() error { return nil }
+
+type driverResult int64
+
+func (d driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (d driverResult) RowsAffected() (int64, error) { return int64(d), nil }
+
+// DryRun computes statements initDB would execute: CleanViews, AutoMigrate, pending migrations,
+// post migrate hooks (e.g. EnsureSoftDeleteUniqueIndex) and InitMysqlViews. Nothing is executed.
+func DryRun(db *gorm.DB, logger *zap.Logger, cleanViews ...string) (*DryRunResult, error) {
+	pool, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{pool: pool}
+	tx := db.Session(&gorm.Session{NewDB: true, SkipDefaultTransaction: true, PrepareStmt: false})
+	tx.Statement.ConnPool = rec
+	rec.db = tx
+	dialect := tx.Dialector.Name()
+	errs := make([]error, 0)
+
+	viewsToClean := cleanViews
+	if dialect == "postgres" && len(viewsToClean) == 0 {
+		viewsToClean = []string{"*"}
+	}
+	rec.source = "clean views"
+	if err := CleanViews(tx, logger, viewsToClean); err != nil {
+		errs = append(errs, err)
+	}
+
+	rec.source = "automigrate"
+	if err := tx.AutoMigrate(activeEntities()...); err != nil {
+		errs = append(errs, fmt.Errorf("automigrate: %w", err))
+	}
+
+	// pending steps read from the DB, schema_migrations may not exist yet
+	applied := map[string]bool{}
+	rows := make([]SchemaMigration, 0)
+	if tx.Migrator().HasTable(&SchemaMigration{}) {
+		if err := tx.Find(&rows).Error; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, item := range rows {
+		applied[item.Version] = true
+	}
+	for _, item := range registered() {
+		if applied[item.Version] {
+			continue
+		}
+		rec.source = fmt.Sprintf("migration %s %s", item.Version, item.Name)
+		if raw, ok := sqlOf(item.UpSQL, dialect); ok && item.Up == nil {
+			for _, stmt := range statements(raw) {
+				rec.ExecContext(context.Background(), stmt)
+			}
+			continue
+		}
+		rec.results = append(rec.results, DDL{Source: rec.source, SQL: "-- Go migration, not previewed"})
+	}
+
+	rec.source = "post migrate"
+	postMigrateMu.Lock()
+	hooks := make([]func(db *gorm.DB, logger *zap.Logger) error, len(postMigrateHooks))
+	copy(hooks, postMigrateHooks)
+	postMigrateMu.Unlock()
+	for _, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if err := hook(tx, logger); err != nil {
+			errs = append(errs, fmt.Errorf("post migrate: %w", err))
+		}
+	}
+
+	rec.source = "views"
+	if err := InitMysqlViews(tx, logger); err != nil {
+		errs = append(errs, err)
+	}
+	return &DryRunResult{Dialect: dialect, Statements: rec.results}, errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package orm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type dryRunItem struct {
+	ID   uint
+	Name string
+	Code string `gorm:"index"`
+}
+
+func TestDryRun(t *testing.T) {
+	saved, savedHooks := entities, postMigrateHooks
+	defer func() { entities, postMigrateHooks = saved, savedHooks }()
+	entities = []any{&dryRunItem{}}
+	postMigrateHooks = nil
+	RegisterPostMigrate(func(db *gorm.DB, logger *zap.Logger) error {
+		return EnsureSoftDeleteUniqueIndex(db, "dry_run_items", []string{"code"})
+	})
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Exec("create table dry_run_items (id integer primary key, name text)").Error)
+
+	result, err := DryRun(db, zap.NewNop())
+	assert.NoError(t, err)
+	out := bytes.Buffer{}
+	assert.NoError(t, result.Write(&out))
+	assert.Contains(t, out.String(), "-- dialect: sqlite")
+	assert.Contains(t, out.String(), "ALTER TABLE `dry_run_items` ADD `code` text;")
+	assert.Contains(t, out.String(), "CREATE INDEX `idx_dry_run_items_code`")
+	assert.Contains(t, out.String(), "-- post migrate")
+	assert.Empty(t, result.Destructive())
+	assert.False(t, db.Migrator().HasColumn(&dryRunItem{}, "code"), "nothing executed")
+
+	assert.True(t, IsDestructive("DROP TABLE `orders`"))
+	assert.True(t, IsDestructive("ALTER TABLE `orders` DROP COLUMN `code`"))
+	assert.True(t, IsDestructive("ALTER TABLE \"orders\" ALTER COLUMN \"code\" TYPE varchar(10)"))
+	assert.False(t, IsDestructive("DROP VIEW IF EXISTS v_orders"))
+	assert.False(t, IsDestructive("ALTER TABLE `orders` ADD `code` text"))
+}
+
+func TestDryRunQueryWrites(t *testing.T) {
+	saved, savedHooks := entities, postMigrateHooks
+	defer func() { entities, postMigrateHooks = saved, savedHooks }()
+	entities = []any{&dryRunItem{}}
+	postMigrateHooks = nil
+	// postgres style seeding, inserts go through QueryContext by RETURNING
+	RegisterPostMigrate(func(db *gorm.DB, logger *zap.Logger) error {
+		id := 0
+		if err := db.Raw("INSERT INTO dry_run_items (name) VALUES (?) RETURNING id", "seed").Scan(&id).Error; err != nil {
+			return err
+		}
+		return db.Create(&dryRunItem{Name: "created", Code: "c1"}).Error
+	})
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&dryRunItem{}))
+
+	result, err := DryRun(db, zap.NewNop())
+	assert.NoError(t, err)
+	out := bytes.Buffer{}
+	assert.NoError(t, result.Write(&out))
+	assert.Contains(t, out.String(), `INSERT INTO dry_run_items (name) VALUES ("seed") RETURNING id;`)
+	assert.Contains(t, out.String(), "INSERT INTO `dry_run_items`")
+
+	total := int64(0)
+	assert.NoError(t, db.Model(&dryRunItem{}).Count(&total).Error)
+	assert.Zero(t, total, "nothing inserted")
+
+	assert.True(t, isRead("SELECT count(*) FROM information_schema.tables"))
+	assert.True(t, isRead("PRAGMA table_info(`orders`)"))
+	assert.False(t, isRead("PRAGMA foreign_keys = OFF"))
+	assert.False(t, isRead("UPDATE orders SET code = 'x' RETURNING id"))
+	assert.False(t, isRead("INSERT INTO orders OUTPUT INSERTED.id VALUES (1)"))
+}
+
+func TestAppendEntityIf(t *testing.T) {
+	saved := entities
+	defer func() { entities = saved; delete(conditionalEntities, "test.feature.enabled") }()
+	entities = []any{&dryRunItem{}}
+	AppendEntityIf("test.feature.enabled", &SchemaMigration{})
+	assert.Len(t, activeEntities(), 1, "feature disabled")
+	viper.Set("test.feature.enabled", true)
+	defer viper.Set("test.feature.enabled", nil)
+	assert.Len(t, activeEntities(), 2)
+}
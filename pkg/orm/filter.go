@@ -0,0 +1,322 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// operators of the filter DSL, e.g. status:in:a,b;created_at:gte:2024-01-01
+const (
+	OpEq        = "eq"
+	OpNe        = "ne"
+	OpGt        = "gt"
+	OpGte       = "gte"
+	OpLt        = "lt"
+	OpLte       = "lte"
+	OpIn        = "in"
+	OpNotIn     = "nin"
+	OpBetween   = "between"
+	OpNull      = "null" // true or false, IS NULL or IS NOT NULL
+	OpContains  = "contains"
+	OpIContains = "icontains" // case insensitive
+	OpPrefix    = "prefix"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Column allowed by AllowList, Type converts filter values if set.
+type Column struct {
+	Name string
+	Type schema.DataType
+}
+
+// AllowList maps names in requests (column, field or json name) to columns allowed to filter, sort & select.
+type AllowList struct {
+	Filter map[string]Column
+	Sort   map[string]Column
+	Select map[string]Column
+	Keys   []string // always selected if fields requested, e.g. primary keys
+	Search []string // columns searched by q, case insensitive contains
+}
+
+// AllowColumns allows columns to filter, sort & select, e.g. result columns of raw queries.
+func AllowColumns(columns ...string) *AllowList {
+	a := &AllowList{Filter: map[string]Column{}, Sort: map[string]Column{}, Select: map[string]Column{}}
+	for _, item := range columns {
+		col := Column{Name: item}
+		a.Filter[item], a.Sort[item], a.Select[item] = col, col, col
+	}
+	return a
+}
+
+func jsonName(f *schema.Field) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// AllowSchema derives allow list from GORM schema, lists of column, field or json names, "*" for all.
+func AllowSchema(sch *schema.Schema, filters, sorts, fields []string) *AllowList {
+	a := &AllowList{Filter: map[string]Column{}, Sort: map[string]Column{}, Select: map[string]Column{}}
+	listed := func(list, names []string) bool {
+		return lo.Contains(list, "*") || len(lo.Intersect(list, names)) > 0
+	}
+	for _, f := range sch.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		col := Column{Name: f.DBName, Type: f.DataType}
+		names := lo.Uniq([]string{f.DBName, f.Name, jsonName(f)})
+		for _, name := range names {
+			if listed(filters, names) {
+				a.Filter[name] = col
+			}
+			if listed(sorts, names) {
+				a.Sort[name] = col
+			}
+			if listed(fields, names) {
+				a.Select[name] = col
+			}
+		}
+	}
+	for _, f := range sch.PrimaryFields {
+		a.Keys = append(a.Keys, f.DBName)
+	}
+	return a
+}
+
+// AllowModel parses model & derives allow list from its schema.
+func AllowModel(db *gorm.DB, model any, filters, sorts, fields []string) (*AllowList, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return AllowSchema(stmt.Schema, filters, sorts, fields), nil
+}
+
+// Filter of one column, values converted by column type.
+type Filter struct {
+	Column string
+	Op     string
+	Values []any
+}
+
+// ListQuery parsed from filter, sort & fields params, columns validated by the AllowList.
+type ListQuery struct {
+	Filters []Filter
+	Sort    []SortKey
+	Fields  []string
+	Search  string
+	search  []string
+}
+
+func convert(col Column, value string) (any, error) {
+	switch col.Type {
+	case schema.Int, schema.Uint:
+		return strconv.ParseInt(value, 10, 64)
+	case schema.Float:
+		return strconv.ParseFloat(value, 64)
+	case schema.Bool:
+		return strconv.ParseBool(value)
+	case schema.Time:
+		for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time %s", value)
+	}
+	return value, nil
+}
+
+func parseFilter(item string, allowed map[string]Column) (Filter, error) {
+	parts := strings.SplitN(item, ":", 3)
+	if len(parts) == 2 && parts[1] == OpNull {
+		parts = append(parts, "true")
+	}
+	if len(parts) != 3 {
+		return Filter{}, fmt.Errorf("%w: %s, expect field:op:value", ErrInvalidFilter, item)
+	}
+	col, ok := allowed[strings.TrimSpace(parts[0])]
+	if !ok {
+		return Filter{}, fmt.Errorf("%w: filter on %s is not allowed", ErrInvalidFilter, parts[0])
+	}
+	op := strings.ToLower(strings.TrimSpace(parts[1]))
+	raw := []string{parts[2]}
+	switch op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+	case OpContains, OpIContains, OpPrefix:
+		col.Type = schema.String
+	case OpIn, OpNotIn:
+		raw = strings.Split(parts[2], ",")
+	case OpBetween:
+		if raw = strings.Split(parts[2], ","); len(raw) != 2 {
+			return Filter{}, fmt.Errorf("%w: between expects 2 values", ErrInvalidFilter)
+		}
+	case OpNull:
+		col.Type = schema.Bool
+	default:
+		return Filter{}, fmt.Errorf("%w: unknown operator %s", ErrInvalidFilter, op)
+	}
+	values := make([]any, 0, len(raw))
+	for _, item := range raw {
+		v, err := convert(col, strings.TrimSpace(item))
+		if err != nil {
+			return Filter{}, fmt.Errorf("%w: %s", ErrInvalidFilter, err.Error())
+		}
+		values = append(values, v)
+	}
+	return Filter{Column: col.Name, Op: op, Values: values}, nil
+}
+
+// Parse validates filter ("field:op:value;..."), sort ("-created_at,name") & fields ("id,name") params.
+func (a *AllowList) Parse(filter, sort, fields string) (*ListQuery, error) {
+	q := &ListQuery{search: a.Search}
+	for _, item := range strings.Split(filter, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		f, err := parseFilter(item, a.Filter)
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = append(q.Filters, f)
+	}
+	keys, err := ParseSort(sort)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, err.Error())
+	}
+	for _, item := range keys {
+		col, ok := a.Sort[item.Column]
+		if !ok {
+			return nil, fmt.Errorf("%w: sort on %s is not allowed", ErrInvalidFilter, item.Column)
+		}
+		q.Sort = append(q.Sort, SortKey{Column: col.Name, Desc: item.Desc})
+	}
+	for _, item := range strings.Split(fields, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		col, ok := a.Select[item]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %s is not allowed", ErrInvalidFilter, item)
+		}
+		q.Fields = append(q.Fields, col.Name)
+	}
+	if len(q.Fields) > 0 {
+		// keys & sort columns kept for keyset paging.
+		for _, item := range q.Sort {
+			q.Fields = append(q.Fields, item.Column)
+		}
+		q.Fields = lo.Uniq(append(q.Fields, a.Keys...))
+	}
+	return q, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// Where returns parameterized conditions joined by AND, ILIKE for case insensitive contains on postgres.
+func (q *ListQuery) Where(dialect string, quote func(string) string) (string, []any) {
+	conds := make([]string, 0, len(q.Filters)+1)
+	args := make([]any, 0)
+	icontains := func(column string, value any) string {
+		args = append(args, "%"+escapeLike(fmt.Sprint(value))+"%")
+		if dialect == "postgres" {
+			return column + " ILIKE ? ESCAPE '!'"
+		}
+		return "LOWER(" + column + ") LIKE LOWER(?) ESCAPE '!'"
+	}
+	compare := map[string]string{OpEq: "=", OpNe: "<>", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+	for _, f := range q.Filters {
+		column := quote(f.Column)
+		switch f.Op {
+		case OpIn:
+			conds = append(conds, column+" IN ?")
+			args = append(args, f.Values)
+		case OpNotIn:
+			conds = append(conds, column+" NOT IN ?")
+			args = append(args, f.Values)
+		case OpBetween:
+			conds = append(conds, column+" BETWEEN ? AND ?")
+			args = append(args, f.Values...)
+		case OpNull:
+			if f.Values[0].(bool) {
+				conds = append(conds, column+" IS NULL")
+			} else {
+				conds = append(conds, column+" IS NOT NULL")
+			}
+		case OpContains:
+			conds = append(conds, column+" LIKE ? ESCAPE '!'")
+			args = append(args, "%"+escapeLike(f.Values[0].(string))+"%")
+		case OpPrefix:
+			conds = append(conds, column+" LIKE ? ESCAPE '!'")
+			args = append(args, escapeLike(f.Values[0].(string))+"%")
+		case OpIContains:
+			conds = append(conds, icontains(column, f.Values[0]))
+		default:
+			conds = append(conds, column+" "+compare[f.Op]+" ?")
+			args = append(args, f.Values[0])
+		}
+	}
+	if q.Search != "" && len(q.search) > 0 {
+		ors := make([]string, 0, len(q.search))
+		for _, item := range q.search {
+			ors = append(ors, icontains(quote(item), q.Search))
+		}
+		conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Apply applies conditions, order & selected fields to GORM query.
+func (q *ListQuery) Apply(tx *gorm.DB) *gorm.DB {
+	cond, args := q.Where(tx.Dialector.Name(), func(column string) string {
+		return tx.Statement.Quote(column)
+	})
+	if cond != "" {
+		tx = tx.Where(cond, args...)
+	}
+	for _, item := range q.Sort {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: item.Column}, Desc: item.Desc})
+	}
+	if len(q.Fields) > 0 {
+		tx = tx.Select(q.Fields)
+	}
+	return tx
+}
+
+// Apply applies Filter, Sort (OrderBy if Sort empty), Fields & Q of the request validated by the allow list.
+func (req QueryBase) Apply(tx *gorm.DB, allowed *AllowList) (*gorm.DB, error) {
+	sort := req.Sort
+	if sort == "" {
+		sort = req.OrderBy
+	}
+	q, err := allowed.Parse(req.Filter, sort, req.Fields)
+	if err != nil {
+		return nil, err
+	}
+	q.Search = req.Q
+	return q.Apply(tx), nil
+}
+
+// ApplyQuery applies the request to query of T, all columns of T allowed.
+func ApplyQuery[T any](tx *gorm.DB, req QueryBase) (*gorm.DB, error) {
+	allowed, err := AllowModel(tx, new(T), []string{"*"}, []string{"*"}, []string{"*"})
+	if err != nil {
+		return nil, err
+	}
+	return req.Apply(tx, allowed)
+}
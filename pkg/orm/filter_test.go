@@ -0,0 +1,77 @@
+package orm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type listed struct {
+	ID        uint
+	Name      string `json:"title"`
+	Status    string
+	Secret    string
+	CreatedAt time.Time
+}
+
+func TestListQuery(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&listed{}))
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	rows := []listed{
+		{Name: "Alpha", Status: "new", CreatedAt: day.Add(-time.Hour)},
+		{Name: "beta_1", Status: "done", CreatedAt: day.Add(time.Hour)},
+		{Name: "Gamma", Status: "new", CreatedAt: day.Add(2 * time.Hour)},
+		{Name: "delta", Status: "lost", CreatedAt: day.Add(3 * time.Hour)},
+	}
+	assert.NoError(t, db.Create(&rows).Error)
+
+	allowed, err := AllowModel(db, &listed{}, []string{"Status", "title", "created_at"}, []string{"*"}, []string{"id", "title"})
+	assert.NoError(t, err)
+	allowed.Search = []string{"name"}
+
+	find := func(req QueryBase) ([]listed, error) {
+		tx, err := req.Apply(db.Model(&listed{}), allowed)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]listed, 0)
+		return result, tx.Find(&result).Error
+	}
+
+	result, err := find(QueryBase{Filter: "status:in:new,done;created_at:gte:2024-01-01", Sort: "-created_at"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{3, 2}, []uint{result[0].ID, result[1].ID})
+
+	result, err = find(QueryBase{Filter: "title:contains:a_", OrderBy: "id"})
+	assert.NoError(t, err)
+	assert.Len(t, result, 1, "like wildcards escaped")
+
+	result, err = find(QueryBase{Q: "ALPHA"})
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+
+	result, err = find(QueryBase{Filter: "status:ne:lost", Fields: "title", Sort: "status"})
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	assert.NotZero(t, result[0].ID, "primary key selected")
+	assert.NotEmpty(t, result[0].Name)
+	assert.NotEmpty(t, result[0].Status, "sort column selected")
+	assert.True(t, result[0].CreatedAt.IsZero())
+
+	for _, req := range []QueryBase{
+		{Filter: "secret:eq:x"},
+		{Filter: "status:like:x"},
+		{Filter: "created_at:gt:yesterday"},
+		{Filter: "status"},
+		{Sort: "name; drop table listed"},
+		{Fields: "secret"},
+	} {
+		_, err = find(req)
+		assert.ErrorIs(t, err, ErrInvalidFilter, req)
+	}
+}
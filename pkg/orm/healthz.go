@@ -37,7 +37,17 @@ func (h *HealthController) Ping(c *gin.Context) {
 		statusMessage = fmt.Sprintf("ping test failed. %v", err)
 	}
 
-	c.JSON(statusCode, gin.H{"status": statusMessage, "appName": core.AppName, "version": core.Version})
+	// other connections opened by the registry
+	connections := map[string]string{}
+	for name, err := range registry.Ping(c) {
+		connections[name] = "OK"
+		if err != nil {
+			statusCode = 500
+			connections[name] = fmt.Sprintf("ping test failed. %v", err)
+		}
+	}
+
+	c.JSON(statusCode, gin.H{"status": statusMessage, "connections": connections, "appName": core.AppName, "version": core.Version})
 
 }
 
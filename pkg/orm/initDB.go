@@ -1,6 +1,11 @@
 package orm
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
 	"github.com/asaskevich/EventBus"
 	"github.com/spf13/cobra"
 	"github.com/techquest-tech/gin-shared/pkg/core"
@@ -14,24 +19,76 @@ var InitDBCmd = &cobra.Command{
 	Short: "init tables",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cleanViews, _ := cmd.Flags().GetStringSlice("cleanViews")
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			return core.Container.Invoke(func(db *gorm.DB, logger *zap.Logger) error {
+				return previewDB(cmd, db, logger, cleanViews)
+			})
+		}
 
-		return core.Container.Invoke(func(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus) {
-			MigrateTableAndView(db, logger, bus, cleanViews...)
+		return core.Container.Invoke(func(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus) error {
+			return MigrateTableAndView(db, logger, bus, cleanViews...)
 		})
 	},
 }
 
+// previewDB prints DDL of initDB, fails if destructive statements not allowed by --allow when --fail-on-destructive.
+func previewDB(cmd *cobra.Command, db *gorm.DB, logger *zap.Logger, cleanViews []string) error {
+	result, err := DryRun(db, logger, cleanViews...)
+	if err != nil {
+		logger.Warn("dry run finished with errors", zap.Error(err))
+	}
+	if result == nil {
+		return err
+	}
+	var w io.Writer = cmd.OutOrStdout()
+	if out, _ := cmd.Flags().GetString("out"); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = io.MultiWriter(w, f)
+	}
+	if err := result.Write(w); err != nil {
+		return err
+	}
+	if fail, _ := cmd.Flags().GetBool("fail-on-destructive"); !fail {
+		return nil
+	}
+	patterns, _ := cmd.Flags().GetStringSlice("allow")
+	allowed := make([]*regexp.Regexp, 0, len(patterns))
+	for _, item := range patterns {
+		re, err := regexp.Compile("(?i)" + item)
+		if err != nil {
+			return fmt.Errorf("invalid --allow %s: %w", item, err)
+		}
+		allowed = append(allowed, re)
+	}
+	unexpected := 0
+	for _, item := range result.Destructive() {
+		ok := false
+		for _, re := range allowed {
+			if re.MatchString(item.SQL) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unexpected++
+			logger.Error("unexpected destructive statement", zap.String("source", item.Source), zap.String("sql", item.SQL))
+		}
+	}
+	if unexpected > 0 {
+		return fmt.Errorf("%d unexpected destructive statements", unexpected)
+	}
+	return nil
+}
+
 func init() {
 	// rootCmd.AddCommand(initDBCmd)
 	InitDBCmd.Flags().StringSliceP("cleanViews", "c", []string{}, "Clean specified views before migration. Use '*' to clean all pending views. For postgres, default is '*'.")
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// initDBCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// initDBCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	InitDBCmd.Flags().Bool("dry-run", false, "print DDL without executing it")
+	InitDBCmd.Flags().StringP("out", "o", "", "write DDL of dry run to the file")
+	InitDBCmd.Flags().Bool("fail-on-destructive", false, "dry run fails if destructive statements found")
+	InitDBCmd.Flags().StringSlice("allow", []string{}, "regex of destructive statements expected, e.g. 'DROP INDEX idx_old'")
 }
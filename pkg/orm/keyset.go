@@ -0,0 +1,282 @@
+package orm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortKey of keyset paging, values of sort keys must not be null.
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSort parses "-created_at,name" or "created_at desc,name asc".
+func ParseSort(orderBy string) ([]SortKey, error) {
+	keys := make([]SortKey, 0)
+	for _, item := range strings.Split(orderBy, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key := SortKey{Column: item}
+		if strings.HasPrefix(item, "-") {
+			key = SortKey{Column: strings.TrimSpace(item[1:]), Desc: true}
+		} else if name, dir, ok := strings.Cut(item, " "); ok {
+			key.Column = name
+			switch strings.ToLower(strings.TrimSpace(dir)) {
+			case "desc":
+				key.Desc = true
+			case "asc":
+			default:
+				return nil, fmt.Errorf("invalid sort direction %s", dir)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// WithTieBreaker appends the primary key if not sorted by it yet, so the order is stable.
+func WithTieBreaker(keys []SortKey, pk string) []SortKey {
+	for _, item := range keys {
+		if strings.EqualFold(fieldOf(item.Column), pk) {
+			return keys
+		}
+	}
+	return append(keys, SortKey{Column: pk})
+}
+
+// fieldOf returns name of the column in result rows, e.g. o.created_at => created_at
+func fieldOf(column string) string {
+	if i := strings.LastIndexByte(column, '.'); i >= 0 {
+		column = column[i+1:]
+	}
+	return strings.Trim(column, "`\"[]")
+}
+
+func orderSignature(keys []SortKey) string {
+	items := make([]string, 0, len(keys))
+	for _, item := range keys {
+		dir := "a"
+		if item.Desc {
+			dir = "d"
+		}
+		items = append(items, fieldOf(item.Column)+":"+dir)
+	}
+	return strings.Join(items, ",")
+}
+
+var (
+	cursorOnce   sync.Once
+	cursorSecret []byte
+)
+
+// secret of cursor tokens, ConfigSecret if provided, otherwise random so tokens only valid in this process.
+func cursorKey() []byte {
+	cursorOnce.Do(func() {
+		func() {
+			defer func() {
+				recover()
+			}()
+			cursorSecret = core.GetService[core.ConfigSecret]()
+		}()
+		if len(cursorSecret) == 0 {
+			zap.L().Warn("config secret is not provided, cursor tokens only valid in this process")
+			cursorSecret = make([]byte, 32)
+			rand.Read(cursorSecret)
+		}
+	})
+	return cursorSecret
+}
+
+type cursorValue struct {
+	Type  string `json:"t,omitempty"`
+	Value any    `json:"v"`
+}
+
+type cursorPayload struct {
+	Order    string        `json:"o"`
+	Backward bool          `json:"b,omitempty"`
+	Values   []cursorValue `json:"v"`
+}
+
+func sign(payload []byte) string {
+	mac := hmac.New(sha256.New, cursorKey())
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// EncodeCursor signs values of the sort keys, backward for the previous page.
+func EncodeCursor(keys []SortKey, values []any, backward bool) (string, error) {
+	payload := cursorPayload{Order: orderSignature(keys), Backward: backward}
+	for _, v := range values {
+		switch value := v.(type) {
+		case time.Time:
+			payload.Values = append(payload.Values, cursorValue{Type: "time", Value: value.Format(time.RFC3339Nano)})
+		case *time.Time:
+			payload.Values = append(payload.Values, cursorValue{Type: "time", Value: value.Format(time.RFC3339Nano)})
+		case []byte:
+			payload.Values = append(payload.Values, cursorValue{Value: string(value)})
+		default:
+			payload.Values = append(payload.Values, cursorValue{Value: value})
+		}
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw) + "." + sign(raw), nil
+}
+
+// DecodeCursor verifies the token was issued for the same order, returns values & direction.
+func DecodeCursor(keys []SortKey, token string) ([]any, bool, error) {
+	body, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false, ErrInvalidCursor
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || !hmac.Equal([]byte(sign(raw)), []byte(signature)) {
+		return nil, false, ErrInvalidCursor
+	}
+	payload := cursorPayload{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, false, ErrInvalidCursor
+	}
+	if payload.Order != orderSignature(keys) || len(payload.Values) != len(keys) {
+		return nil, false, fmt.Errorf("%w: order changed", ErrInvalidCursor)
+	}
+	values := make([]any, 0, len(keys))
+	for _, item := range payload.Values {
+		value := item.Value
+		switch v := value.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				value = i
+			} else if f, err := v.Float64(); err == nil {
+				value = f
+			}
+		case string:
+			if item.Type == "time" {
+				t, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil {
+					return nil, false, ErrInvalidCursor
+				}
+				value = t
+			}
+		}
+		values = append(values, value)
+	}
+	return values, payload.Backward, nil
+}
+
+// KeysetCond builds (a > ?) OR (a = ? AND b > ?) ..., operators follow the direction of each key.
+func KeysetCond(keys []SortKey, values []any, backward bool, quote func(string) string) (string, []any) {
+	ors := make([]string, 0, len(keys))
+	params := make([]any, 0)
+	for i, key := range keys {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, quote(keys[j].Column)+" = ?")
+			params = append(params, values[j])
+		}
+		op := ">"
+		if key.Desc != backward {
+			op = "<"
+		}
+		ands = append(ands, quote(key.Column)+" "+op+" ?")
+		params = append(params, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", params
+}
+
+// KeysetOrder returns order by clause, reversed for backward pages.
+func KeysetOrder(keys []SortKey, backward bool, quote func(string) string) string {
+	items := make([]string, 0, len(keys))
+	for _, key := range keys {
+		dir := "ASC"
+		if key.Desc != backward {
+			dir = "DESC"
+		}
+		items = append(items, quote(key.Column)+" "+dir)
+	}
+	return strings.Join(items, ", ")
+}
+
+// KeysetPage trims the extra row fetched, restores order of backward pages and returns next & prev tokens.
+func KeysetPage[T any](rows []T, keys []SortKey, pageSize int, backward, hasCursor bool, valuesOf func(T) []any) ([]T, string, string, error) {
+	more := len(rows) > pageSize
+	if more {
+		rows = rows[:pageSize]
+	}
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, "", "", nil
+	}
+	next, prev := "", ""
+	var err error
+	if (!backward && more) || (backward && hasCursor) {
+		if next, err = EncodeCursor(keys, valuesOf(rows[len(rows)-1]), false); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if (!backward && hasCursor) || (backward && more) {
+		if prev, err = EncodeCursor(keys, valuesOf(rows[0]), true); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return rows, next, prev, nil
+}
+
+// Unfiltered returns true if the query of model has no where (scopes, tenant & soft delete included), join or group by,
+// only then EstimateCount of the table is the total of the query.
+func Unfiltered(tx *gorm.DB, model any) bool {
+	count := int64(0)
+	stmt := tx.Session(&gorm.Session{DryRun: true}).Model(model).Count(&count).Statement
+	for _, name := range []string{"WHERE", "GROUP BY"} {
+		if _, ok := stmt.Clauses[name]; ok {
+			return false
+		}
+	}
+	return len(stmt.Joins) == 0
+}
+
+// EstimateCount returns row count of the table from catalog stats (mysql, postgres, sqlserver),
+// estimated false for other dialects, count it then.
+func EstimateCount(db *gorm.DB, table string) (int64, bool, error) {
+	count := int64(0)
+	var err error
+	db = db.Session(&gorm.Session{NewDB: true})
+	switch db.Dialector.Name() {
+	case "mysql":
+		err = db.Raw("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table).Scan(&count).Error
+	case "postgres":
+		err = db.Raw("SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = to_regclass(?)", table).Scan(&count).Error
+	case "sqlserver":
+		err = db.Raw("SELECT SUM(rows) FROM sys.partitions WHERE object_id = OBJECT_ID(?) AND index_id IN (0, 1)", table).Scan(&count).Error
+	default:
+		return 0, false, nil
+	}
+	return count, true, err
+}
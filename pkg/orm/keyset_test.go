@@ -0,0 +1,76 @@
+package orm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type ranked struct {
+	ID    uint
+	Name  string
+	Score int
+}
+
+type softItem struct {
+	gorm.Model
+}
+
+func TestKeysetPaging(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&ranked{}))
+	for i := 1; i <= 7; i++ {
+		assert.NoError(t, db.Create(&ranked{Name: fmt.Sprintf("n%d", i), Score: i % 3}).Error)
+	}
+	// score desc, id asc: 2,5 | 1,4,7 | 3,6
+	ids := func(p *PagingResult[ranked]) []uint {
+		result := make([]uint, 0)
+		for _, item := range p.Data {
+			result = append(result, item.ID)
+		}
+		return result
+	}
+	page := func(cursor string) *PagingResult[ranked] {
+		p := &PagingResult[ranked]{}
+		assert.NoError(t, p.Trigger(db.Model(&ranked{}), QueryBase{PageSize: 3, OrderBy: "-score", Keyset: true, Cursor: cursor}))
+		return p
+	}
+
+	first := page("")
+	assert.Equal(t, []uint{2, 5, 1}, ids(first))
+	assert.NotEmpty(t, first.Next)
+	assert.Empty(t, first.Prev)
+
+	second := page(first.Next)
+	assert.Equal(t, []uint{4, 7, 3}, ids(second))
+	third := page(second.Next)
+	assert.Equal(t, []uint{6}, ids(third))
+	assert.Empty(t, third.Next)
+
+	back := page(third.Prev)
+	assert.Equal(t, []uint{4, 7, 3}, ids(back))
+	back = page(back.Prev)
+	assert.Equal(t, []uint{2, 5, 1}, ids(back))
+	assert.Empty(t, back.Prev)
+
+	p := &PagingResult[ranked]{}
+	err = p.Trigger(db.Model(&ranked{}), QueryBase{PageSize: 3, OrderBy: "name", Cursor: first.Next})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "cursor of another order")
+	err = p.Trigger(db.Model(&ranked{}), QueryBase{PageSize: 3, OrderBy: "-score", Cursor: first.Next[:len(first.Next)-2] + "xx"})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "tampered")
+
+	p = &PagingResult[ranked]{}
+	assert.NoError(t, p.Trigger(db.Model(&ranked{}).Where("score > ?", 0), QueryBase{PageSize: 3, Keyset: true, Estimate: true}))
+	assert.Equal(t, int64(5), p.Total)
+	assert.False(t, p.Estimated, "sqlite counts")
+	assert.Equal(t, []uint{1, 2, 4}, ids(p))
+
+	assert.True(t, Unfiltered(db.Model(&ranked{}), &ranked{}))
+	assert.False(t, Unfiltered(db.Model(&ranked{}).Where("score > ?", 0), &ranked{}), "estimate only for the whole table")
+	assert.False(t, Unfiltered(db.Scopes(func(tx *gorm.DB) *gorm.DB { return tx.Where("owner = ?", "a") }), &ranked{}))
+	assert.False(t, Unfiltered(db, &softItem{}), "soft deleted rows excluded")
+}
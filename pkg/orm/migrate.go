@@ -0,0 +1,520 @@
+package orm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/locker"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrChecksum     = errors.New("migration changed after applied")
+	ErrIrreversible = errors.New("migration has no down step")
+	ErrUnknown      = errors.New("migration is unknown")
+)
+
+const migrateLock = "orm:migrate"
+
+// Migration is one versioned step, ordered by Version. Go steps by Up/Down,
+// SQL steps by UpSQL/DownSQL keyed by dialect (mysql, postgres, sqlite, sqlserver), "" for any dialect.
+type Migration struct {
+	Version  string
+	Name     string
+	Up       func(tx *gorm.DB) error
+	Down     func(tx *gorm.DB) error
+	UpSQL    map[string]string
+	DownSQL  map[string]string
+	NoTx     bool   // run outside transaction, e.g. create index concurrently
+	Checksum string // optional for Go steps, change it when the step changed
+}
+
+// SchemaMigration records applied migrations.
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey;size:64"`
+	Name      string `gorm:"size:255"`
+	Checksum  string `gorm:"size:64"`
+	Baseline  bool   // marked applied by baseline, never ran
+	AppliedAt time.Time
+	Duration  int64 // ms
+}
+
+// MigrationStatus of registered or applied migrations.
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Baseline  bool
+	Changed   bool // checksum mismatched
+	Missing   bool // applied but not registered anymore
+}
+
+var (
+	migrationsMu sync.Mutex
+	migrations   = make(map[string]Migration)
+)
+
+// versionPattern of migration versions, numbers optionally separated by "_" or ".", e.g. 0001, 20260101120000, 20260101_01
+var versionPattern = regexp.MustCompile(`^[0-9]+([._][0-9]+)*$`)
+
+// compareVersion compares versions numerically segment by segment, so "9" < "10".
+func compareVersion(a, b string) int {
+	split := func(r rune) bool { return r == '_' || r == '.' }
+	as, bs := strings.FieldsFunc(a, split), strings.FieldsFunc(b, split)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := strings.TrimLeft(as[i], "0"), strings.TrimLeft(bs[i], "0")
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	if len(as) != len(bs) {
+		return len(as) - len(bs)
+	}
+	return strings.Compare(a, b)
+}
+
+// RegisterMigration adds versioned steps, duplicated or invalid versions (see versionPattern) panic.
+func RegisterMigration(items ...Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	for _, item := range items {
+		if item.Version == "" {
+			panic("migration version is required")
+		}
+		if !versionPattern.MatchString(item.Version) {
+			panic("invalid migration version " + item.Version + ", numbers separated by _ or . expected")
+		}
+		if _, ok := migrations[item.Version]; ok {
+			panic("duplicated migration version " + item.Version)
+		}
+		migrations[item.Version] = item
+	}
+}
+
+// MigrationFunc adapts post migrate hooks to a versioned step.
+func MigrationFunc(version, name string, fn func(db *gorm.DB, logger *zap.Logger) error) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return fn(tx, zap.L())
+		},
+	}
+}
+
+var sqlFile = regexp.MustCompile(`^([^_]+)_(.+)\.(up|down)(\.(mysql|postgres|sqlite|sqlserver))?\.sql$`)
+
+// RegisterSQLMigrations registers files of dir, e.g. embed.FS. Names are <version>_<name>.up.sql & <version>_<name>.down.sql,
+// dialect variants as <version>_<name>.up.postgres.sql.
+func RegisterSQLMigrations(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	found := map[string]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matched := sqlFile.FindStringSubmatch(entry.Name())
+		if matched == nil {
+			continue
+		}
+		raw, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		version, name, direction, dialect := matched[1], matched[2], matched[3], matched[5]
+		m, ok := found[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name, UpSQL: map[string]string{}, DownSQL: map[string]string{}}
+			found[version] = m
+		}
+		if direction == "up" {
+			m.UpSQL[dialect] = string(raw)
+		} else {
+			m.DownSQL[dialect] = string(raw)
+		}
+	}
+	for _, m := range found {
+		RegisterMigration(*m)
+	}
+	return nil
+}
+
+func registered() []Migration {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	result := make([]Migration, 0, len(migrations))
+	for _, item := range migrations {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareVersion(result[i].Version, result[j].Version) < 0
+	})
+	return result
+}
+
+func sqlOf(scripts map[string]string, dialect string) (string, bool) {
+	if raw, ok := scripts[dialect]; ok {
+		return raw, true
+	}
+	raw, ok := scripts[""]
+	return raw, ok
+}
+
+// checksum of the step, SQL of the dialect, Go steps by Checksum or version & name.
+func (m Migration) checksum(dialect string) string {
+	content := m.Checksum
+	if raw, ok := sqlOf(m.UpSQL, dialect); ok {
+		content = raw
+	}
+	if content == "" {
+		content = m.Version + "\n" + m.Name
+	}
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(content, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// directives of SQL migration files, comment lines so files still run by other tools.
+const (
+	DirectiveNoSplit   = "-- migrate:nosplit"   // the file is one statement, e.g. postgres functions with $$ bodies
+	DirectiveDelimiter = "-- migrate:delimiter" // following statements end by the delimiter, e.g. "-- migrate:delimiter //" or GO
+)
+
+// statements splits SQL by ";" at line end, or by the delimiter of DirectiveDelimiter lines.
+// a word delimiter (e.g. GO) ends the statement on a line of its own, others at line end. with DirectiveNoSplit the file is one statement.
+func statements(raw string) []string {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	lines := strings.Split(raw, "\n")
+	for _, line := range lines {
+		if strings.EqualFold(strings.TrimSpace(line), DirectiveNoSplit) {
+			return []string{strings.TrimSpace(raw)}
+		}
+	}
+	result := make([]string, 0)
+	current := strings.Builder{}
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			result = append(result, stmt)
+		}
+		current.Reset()
+	}
+	delimiter := ";"
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) > len(DirectiveDelimiter) && strings.EqualFold(trimmed[:len(DirectiveDelimiter)], DirectiveDelimiter) {
+			flush()
+			delimiter = strings.TrimSpace(trimmed[len(DirectiveDelimiter):])
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		switch {
+		case delimiter == ";":
+			current.WriteString(line)
+			current.WriteString("\n")
+			if strings.HasSuffix(trimmed, ";") {
+				flush()
+			}
+		case wordDelimiter.MatchString(delimiter):
+			if strings.EqualFold(trimmed, delimiter) {
+				flush()
+				continue
+			}
+			current.WriteString(line)
+			current.WriteString("\n")
+		case strings.HasSuffix(trimmed, delimiter):
+			current.WriteString(strings.TrimSuffix(trimmed, delimiter))
+			flush()
+		default:
+			current.WriteString(line)
+			current.WriteString("\n")
+		}
+	}
+	flush()
+	return result
+}
+
+var wordDelimiter = regexp.MustCompile(`^\w+$`)
+
+func (m Migration) run(tx *gorm.DB, up bool) error {
+	fn, scripts := m.Up, m.UpSQL
+	if !up {
+		fn, scripts = m.Down, m.DownSQL
+	}
+	if fn != nil {
+		return fn(tx)
+	}
+	raw, ok := sqlOf(scripts, tx.Dialector.Name())
+	if !ok {
+		if up {
+			return fmt.Errorf("migration %s has no SQL for %s", m.Version, tx.Dialector.Name())
+		}
+		return fmt.Errorf("%w: %s", ErrIrreversible, m.Version)
+	}
+	for _, stmt := range statements(raw) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrator applies versioned migrations, cross-pod lock by locker.Locker if available.
+type Migrator struct {
+	Db             *gorm.DB
+	Locker         locker.Locker
+	LockWait       time.Duration
+	LockTimeout    time.Duration
+	IgnoreChecksum bool
+	logger         *zap.Logger
+}
+
+func NewMigrator(db *gorm.DB, logger *zap.Logger) *Migrator {
+	m := &Migrator{Db: db, LockWait: 5 * time.Minute, LockTimeout: 30 * time.Minute, logger: logger}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Warn("locker is not available, migrations not locked", zap.Any("error", r))
+			}
+		}()
+		m.Locker = core.GetService[locker.Locker]()
+	}()
+	return m
+}
+
+func (m *Migrator) ensure() error {
+	return m.Db.AutoMigrate(&SchemaMigration{})
+}
+
+func (m *Migrator) applied() (map[string]SchemaMigration, error) {
+	if err := m.ensure(); err != nil {
+		return nil, err
+	}
+	rows := make([]SchemaMigration, 0)
+	if err := m.Db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]SchemaMigration, len(rows))
+	for _, item := range rows {
+		result[item.Version] = item
+	}
+	return result, nil
+}
+
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if m.Locker == nil {
+		return func() {}, nil
+	}
+	release, err := m.Locker.WaitForLocker(ctx, migrateLock, m.LockWait, m.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := release(context.Background()); err != nil {
+			m.logger.Warn("release migration lock failed", zap.Error(err))
+		}
+	}, nil
+}
+
+// Status of all registered & applied migrations, ordered by version.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	dialect := m.Db.Dialector.Name()
+	result := make([]MigrationStatus, 0)
+	for _, item := range registered() {
+		status := MigrationStatus{Version: item.Version, Name: item.Name}
+		if row, ok := applied[item.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied, status.AppliedAt, status.Baseline = true, &appliedAt, row.Baseline
+			status.Changed = !row.Baseline && row.Checksum != item.checksum(dialect)
+			delete(applied, item.Version)
+		}
+		result = append(result, status)
+	}
+	for _, row := range applied {
+		appliedAt := row.AppliedAt
+		result = append(result, MigrationStatus{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: &appliedAt, Baseline: row.Baseline, Missing: true})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareVersion(result[i].Version, result[j].Version) < 0
+	})
+	return result, nil
+}
+
+// Locked runs fn holding the migration lock, e.g. AutoMigrate & Up of initDB, so pods never migrate at the same time.
+func (m *Migrator) Locked(ctx context.Context, fn func() error) error {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// Up applies pending migrations up to target version (all if empty), returns versions applied.
+func (m *Migrator) Up(ctx context.Context, target string) ([]string, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return m.up(ctx, target)
+}
+
+// up applies pending migrations, the lock held by caller.
+func (m *Migrator) up(ctx context.Context, target string) ([]string, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	dialect := m.Db.Dialector.Name()
+	done := make([]string, 0)
+	for _, item := range registered() {
+		if target != "" && compareVersion(item.Version, target) > 0 {
+			break
+		}
+		checksum := item.checksum(dialect)
+		if row, ok := applied[item.Version]; ok {
+			if !row.Baseline && row.Checksum != checksum && !m.IgnoreChecksum {
+				return done, fmt.Errorf("%w: %s %s", ErrChecksum, item.Version, item.Name)
+			}
+			continue
+		}
+		start := time.Now()
+		record := &SchemaMigration{Version: item.Version, Name: item.Name, Checksum: checksum}
+		apply := func(tx *gorm.DB) error {
+			if err := item.run(tx, true); err != nil {
+				return err
+			}
+			record.AppliedAt = time.Now()
+			record.Duration = time.Since(start).Milliseconds()
+			return tx.Create(record).Error
+		}
+		db := m.Db.WithContext(ctx)
+		if item.NoTx {
+			err = apply(db)
+		} else {
+			err = db.Transaction(apply)
+		}
+		if err != nil {
+			m.logger.Error("migration failed", zap.String("version", item.Version), zap.String("name", item.Name), zap.Error(err))
+			return done, fmt.Errorf("migration %s %s failed: %w", item.Version, item.Name, err)
+		}
+		m.logger.Info("migration applied", zap.String("version", item.Version), zap.String("name", item.Name), zap.Duration("duration", time.Since(start)))
+		done = append(done, item.Version)
+	}
+	return done, nil
+}
+
+// Down rolls back the latest applied steps, returns versions rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]string, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersion(versions[i], versions[j]) > 0
+	})
+	migrationsMu.Lock()
+	known := make(map[string]Migration, len(migrations))
+	for k, v := range migrations {
+		known[k] = v
+	}
+	migrationsMu.Unlock()
+
+	done := make([]string, 0)
+	for _, version := range versions {
+		if len(done) >= steps {
+			break
+		}
+		item, ok := known[version]
+		if !ok {
+			return done, fmt.Errorf("%w: %s", ErrUnknown, version)
+		}
+		rollback := func(tx *gorm.DB) error {
+			if err := item.run(tx, false); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", version).Error
+		}
+		db := m.Db.WithContext(ctx)
+		if item.NoTx {
+			err = rollback(db)
+		} else {
+			err = db.Transaction(rollback)
+		}
+		if err != nil {
+			return done, fmt.Errorf("rollback %s %s failed: %w", item.Version, item.Name, err)
+		}
+		m.logger.Info("migration rolled back", zap.String("version", item.Version), zap.String("name", item.Name))
+		done = append(done, version)
+	}
+	return done, nil
+}
+
+// Baseline marks registered migrations up to version as applied without running them, for existing databases.
+func (m *Migrator) Baseline(ctx context.Context, version string) ([]string, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	dialect := m.Db.Dialector.Name()
+	done := make([]string, 0)
+	for _, item := range registered() {
+		if compareVersion(item.Version, version) > 0 {
+			break
+		}
+		if _, ok := applied[item.Version]; ok {
+			continue
+		}
+		err := m.Db.WithContext(ctx).Create(&SchemaMigration{
+			Version:   item.Version,
+			Name:      item.Name,
+			Checksum:  item.checksum(dialect),
+			Baseline:  true,
+			AppliedAt: time.Now(),
+		}).Error
+		if err != nil {
+			return done, err
+		}
+		done = append(done, item.Version)
+	}
+	m.logger.Info("migrations baselined", zap.String("version", version), zap.Int("total", len(done)))
+	return done, nil
+}
@@ -0,0 +1,124 @@
+package orm
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrator(t *testing.T) {
+	migrations = make(map[string]Migration)
+	defer func() { migrations = make(map[string]Migration) }()
+
+	fsys := fstest.MapFS{
+		"migrations/0001_orders.up.sql":          {Data: []byte("create table orders (id integer primary key, code text);\ncreate index idx_code on orders(code);")},
+		"migrations/0001_orders.down.sql":        {Data: []byte("drop table orders;")},
+		"migrations/0002_status.up.sql":          {Data: []byte("alter table orders add column status text;")},
+		"migrations/0002_status.up.postgres.sql": {Data: []byte("alter table orders add column status varchar(16);")},
+		"migrations/readme.md":                   {Data: []byte("ignored")},
+	}
+	assert.NoError(t, RegisterSQLMigrations(fsys, "migrations"))
+	RegisterMigration(MigrationFunc("0003", "backfill", func(db *gorm.DB, logger *zap.Logger) error {
+		return db.Exec("update orders set status = 'new' where status is null").Error
+	}))
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	m := &Migrator{Db: db, logger: zap.NewNop()}
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx, "0002")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0002"}, applied)
+	assert.NoError(t, db.Exec("insert into orders (code) values ('A1')").Error)
+	applied, err = m.Up(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0003"}, applied)
+	status := ""
+	assert.NoError(t, db.Raw("select status from orders").Scan(&status).Error)
+	assert.Equal(t, "new", status)
+
+	// irreversible step stops rollback
+	done, err := m.Down(ctx, 2)
+	assert.ErrorIs(t, err, ErrIrreversible)
+	assert.Empty(t, done)
+
+	changed := migrations["0002"]
+	changed.UpSQL = map[string]string{"": "alter table orders add column status integer;"}
+	migrations["0002"] = changed
+	_, err = m.Up(ctx, "")
+	assert.ErrorIs(t, err, ErrChecksum)
+	list, err := m.Status()
+	assert.NoError(t, err)
+	assert.Len(t, list, 3)
+	assert.True(t, list[1].Changed)
+
+	// baseline existing database
+	other, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	m2 := &Migrator{Db: other, logger: zap.NewNop()}
+	done, err = m2.Baseline(ctx, "0002")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0002"}, done)
+	list, err = m2.Status()
+	assert.NoError(t, err)
+	assert.True(t, list[0].Baseline)
+	assert.False(t, list[2].Applied)
+}
+
+func TestVersionOrder(t *testing.T) {
+	migrations = make(map[string]Migration)
+	defer func() { migrations = make(map[string]Migration) }()
+	noop := func(db *gorm.DB, logger *zap.Logger) error { return nil }
+	RegisterMigration(MigrationFunc("10", "ten", noop), MigrationFunc("9", "nine", noop), MigrationFunc("20260101_2", "b", noop), MigrationFunc("20260101_10", "c", noop))
+	assert.Panics(t, func() { RegisterMigration(MigrationFunc("v1", "invalid", noop)) })
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	m := &Migrator{Db: db, logger: zap.NewNop()}
+	applied, err := m.Up(context.Background(), "10")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"9", "10"}, applied, "numeric order")
+	applied, err = m.Up(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20260101_2", "20260101_10"}, applied)
+	_, err = m.Down(context.Background(), 1)
+	assert.ErrorContains(t, err, "20260101_10", "the latest rolled back first")
+	list, err := m.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "9", list[0].Version)
+}
+
+func TestFailedMigrationSkipsHooks(t *testing.T) {
+	migrations = make(map[string]Migration)
+	savedHooks := postMigrateHooks
+	defer func() { migrations, postMigrateHooks = make(map[string]Migration), savedHooks }()
+	RegisterMigration(MigrationFunc("1", "broken", func(db *gorm.DB, logger *zap.Logger) error {
+		return db.Exec("update missing set x = 1").Error
+	}))
+	ran := false
+	postMigrateHooks = nil
+	RegisterPostMigrate(func(db *gorm.DB, logger *zap.Logger) error { ran = true; return nil })
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.Error(t, MigrateTableAndView(db, zap.NewNop(), nil))
+	assert.False(t, ran, "hooks never run after failed migration")
+}
+
+func TestStatements(t *testing.T) {
+	assert.Equal(t, []string{"create table a (\n  id int\n);", "insert into a values (1);", "select 1"},
+		statements("-- comment\ncreate table a (\n  id int\n);\n\ninsert into a values (1);\nselect 1"))
+
+	fn := "-- migrate:nosplit\ncreate function f() returns trigger as $$\nbegin\n  return new;\nend;\n$$ language plpgsql;"
+	assert.Equal(t, []string{fn}, statements(fn))
+	assert.Equal(t, []string{"drop procedure if exists p;", "create procedure p()\nbegin\n  select 1;\nend", "select 2;"},
+		statements("drop procedure if exists p;\n-- migrate:delimiter //\ncreate procedure p()\nbegin\n  select 1;\nend //\n-- migrate:delimiter ;\nselect 2;"))
+	assert.Equal(t, []string{"create table a (id int);\ninsert into a values (1);", "create view v as select * from a;"},
+		statements("-- migrate:delimiter GO\ncreate table a (id int);\ninsert into a values (1);\ngo\ncreate view v as select * from a;\nGO\n"))
+}
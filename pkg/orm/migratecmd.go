@@ -0,0 +1,117 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var migrateOptions = struct {
+	to             string
+	steps          int
+	ignoreChecksum bool
+}{}
+
+func invokeMigrator(fn func(m *Migrator) error) error {
+	return core.GetContainer().Invoke(func(db *gorm.DB, logger *zap.Logger) error {
+		m := NewMigrator(db, logger)
+		m.IgnoreChecksum = migrateOptions.ignoreChecksum
+		return fn(m)
+	})
+}
+
+// MigrateCmd runs versioned migrations: up, down, status & baseline.
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "versioned DB migrations",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "apply pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeMigrator(func(m *Migrator) error {
+			applied, err := m.Up(context.Background(), migrateOptions.to)
+			for _, item := range applied {
+				fmt.Println("applied", item)
+			}
+			return err
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "roll back the latest migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeMigrator(func(m *Migrator) error {
+			done, err := m.Down(context.Background(), migrateOptions.steps)
+			for _, item := range done {
+				fmt.Println("rolled back", item)
+			}
+			return err
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "list migrations and if applied",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeMigrator(func(m *Migrator) error {
+			status, err := m.Status()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+			for _, item := range status {
+				state := "pending"
+				switch {
+				case item.Missing:
+					state = "missing"
+				case item.Changed:
+					state = "changed"
+				case item.Baseline:
+					state = "baseline"
+				case item.Applied:
+					state = "applied"
+				}
+				appliedAt := ""
+				if item.AppliedAt != nil {
+					appliedAt = item.AppliedAt.Format("2006-01-02 15:04:05")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.Version, item.Name, state, appliedAt)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+var migrateBaselineCmd = &cobra.Command{
+	Use:   "baseline <version>",
+	Short: "mark migrations up to version applied without running them",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return invokeMigrator(func(m *Migrator) error {
+			done, err := m.Baseline(context.Background(), args[0])
+			for _, item := range done {
+				fmt.Println("baseline", item)
+			}
+			return err
+		})
+	},
+}
+
+func init() {
+	MigrateCmd.PersistentFlags().BoolVar(&migrateOptions.ignoreChecksum, "ignoreChecksum", false, "apply even if applied migrations changed")
+	migrateUpCmd.Flags().StringVarP(&migrateOptions.to, "to", "t", "", "target version, all pending if empty")
+	migrateDownCmd.Flags().IntVarP(&migrateOptions.steps, "steps", "n", 1, "migrations to roll back")
+	MigrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd, migrateBaselineCmd)
+}
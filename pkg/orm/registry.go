@@ -0,0 +1,171 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	KeyDefault   = "database"  // config key of default connection
+	KeyDatabases = "databases" // named connections, databases.<name>
+	Default      = "default"
+)
+
+// Registry opens every database section once, named connections from databases.<name>, default from database.
+type Registry struct {
+	lock sync.Mutex
+}
+
+var registry = &Registry{}
+
+func GetRegistry() *Registry {
+	return registry
+}
+
+// keyOf maps connection name to the config key, legacy top level keys still accepted.
+func keyOf(name string) string {
+	switch {
+	case name == "" || strings.EqualFold(name, Default):
+		return KeyDefault
+	case viper.IsSet(KeyDatabases + "." + name):
+		return KeyDatabases + "." + name
+	}
+	return name
+}
+
+func (r *Registry) open(key, prefix string) (*gorm.DB, error) {
+	cacheKey := key
+	if prefix != "" {
+		cacheKey = key + "#" + prefix
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if db, ok := Connections[cacheKey]; ok {
+		return db, nil
+	}
+	settings := viper.Sub(key)
+	if settings == nil {
+		return nil, nil
+	}
+	db, err := openDB(settings, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("open %s failed: %w", key, err)
+	}
+	Connections[cacheKey] = db
+	return db, nil
+}
+
+// Open returns the connection, nil if not configured.
+func (r *Registry) Open(name string) (*gorm.DB, error) {
+	return r.open(keyOf(name), "")
+}
+
+// Register adds connection opened by others, e.g. tests.
+func (r *Registry) Register(name string, db *gorm.DB) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	Connections[keyOf(name)] = db
+}
+
+// Names of configured connections, default first.
+func (r *Registry) Names() []string {
+	names := make([]string, 0)
+	for name := range viper.GetStringMap(KeyDatabases) {
+		if name != Default {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if viper.IsSet(KeyDefault) {
+		names = append([]string{Default}, names...)
+	}
+	return names
+}
+
+// Ping checks every opened connection, key is the connection name.
+func (r *Registry) Ping(ctx context.Context) map[string]error {
+	r.lock.Lock()
+	opened := make(map[string]*gorm.DB, len(Connections))
+	for key, db := range Connections {
+		name := strings.TrimPrefix(key, KeyDatabases+".")
+		if key == KeyDefault {
+			name = Default
+		}
+		opened[name] = db
+	}
+	r.lock.Unlock()
+	result := make(map[string]error, len(opened))
+	for name, db := range opened {
+		pool, err := db.DB()
+		if err == nil {
+			err = pool.PingContext(ctx)
+		}
+		result[name] = err
+	}
+	return result
+}
+
+// DB returns the named connection, opened on first use, nil if not configured or failed.
+func DB(name string) *gorm.DB {
+	db, err := registry.Open(name)
+	if err != nil {
+		zap.L().Error("open connection failed", zap.String("name", name), zap.Error(err))
+		return nil
+	}
+	return db
+}
+
+// ProvideNamed provides named connections as dig values, inject by `name:"reports"`.
+func ProvideNamed(names ...string) {
+	for _, name := range names {
+		name := name
+		core.GetContainer().Provide(func() (*gorm.DB, error) {
+			db, err := registry.Open(name)
+			if err == nil && db == nil {
+				err = fmt.Errorf("connection %s is not configured", name)
+			}
+			return db, err
+		}, dig.Name(name))
+	}
+}
+
+type primaryKey struct{}
+
+// Primary forces queries with the context to the primary, e.g. reads right after writes.
+func Primary(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func forcePrimary(db *gorm.DB) {
+	if ctx := db.Statement.Context; ctx != nil && ctx.Value(primaryKey{}) != nil {
+		dbresolver.Write.ModifyStatement(db.Statement)
+	}
+}
+
+// registerPrimaryCallbacks after dbresolver, both before "*" so the later one runs first.
+func registerPrimaryCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().Before("*").Register("orm:primary", forcePrimary),
+		cb.Row().Before("*").Register("orm:primary", forcePrimary),
+		cb.Raw().Before("*").Register("orm:primary", forcePrimary),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
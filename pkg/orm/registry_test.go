@@ -0,0 +1,57 @@
+package orm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type item struct {
+	ID   uint
+	Name string
+}
+
+func TestRegistry(t *testing.T) {
+	DialectorMap["sqlite"] = sqlite.Open
+	dir := t.TempDir()
+	primary, replica := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+	for _, dsn := range []string{primary, replica} {
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+		assert.NoError(t, err)
+		assert.NoError(t, db.AutoMigrate(&item{}))
+		assert.NoError(t, db.Create(&item{Name: filepath.Base(dsn)}).Error)
+	}
+	viper.Set("databases.reports", map[string]any{
+		"type":       "sqlite",
+		"connection": primary,
+		"replicas":   []string{replica},
+	})
+	defer viper.Set("databases", nil)
+
+	db := DB("reports")
+	assert.NotNil(t, db)
+	assert.Same(t, db, DB("reports"), "opened once")
+	assert.Nil(t, DB("missing"))
+	assert.Contains(t, GetRegistry().Names(), "reports")
+
+	read := func(ctx context.Context) string {
+		result := item{}
+		assert.NoError(t, db.WithContext(ctx).First(&result).Error)
+		return result.Name
+	}
+	assert.Equal(t, "replica.db", read(context.Background()))
+	assert.Equal(t, "primary.db", read(Primary(context.Background())))
+
+	assert.NoError(t, db.Create(&item{Name: "new"}).Error)
+	total := int64(0)
+	assert.NoError(t, db.WithContext(Primary(context.Background())).Model(&item{}).Count(&total).Error)
+	assert.Equal(t, int64(2), total, "writes to primary")
+
+	health := GetRegistry().Ping(context.Background())
+	assert.NoError(t, health["reports"])
+}
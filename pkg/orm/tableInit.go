@@ -1,7 +1,10 @@
 package orm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/asaskevich/EventBus"
@@ -17,9 +20,37 @@ func AppendEntity(entity ...interface{}) {
 	entities = append(entities, entity...)
 }
 
+// conditionalEntities by config key, see AppendEntityIf.
+var conditionalEntities = make(map[string][]interface{})
+
+// AppendEntityIf registers entities of an optional feature, migrated only if the bool config key is true.
+func AppendEntityIf(key string, entity ...interface{}) {
+	conditionalEntities[key] = append(conditionalEntities[key], entity...)
+}
+
+// activeEntities to migrate, conditional entities included if their config key enabled.
+func activeEntities() []interface{} {
+	result := append([]interface{}{}, entities...)
+	keys := make([]string, 0, len(conditionalEntities))
+	for key := range conditionalEntities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if viper.GetBool(key) {
+			result = append(result, conditionalEntities[key]...)
+		}
+	}
+	return result
+}
+
 var postMigrateMu sync.Mutex
 var postMigrateHooks = make([]func(db *gorm.DB, logger *zap.Logger) error, 0)
 
+// RegisterPostMigrate runs fn after every AutoMigrate, on each startup with initDB, so fn must be idempotent.
+// hooks are not versioned: not recorded in schema_migrations, never rolled back & not shown by migrate status.
+//
+// Deprecated: register a versioned step by RegisterMigration(MigrationFunc(version, name, fn)), it runs once.
 func RegisterPostMigrate(fn func(db *gorm.DB, logger *zap.Logger) error) {
 	if fn == nil {
 		return
@@ -34,7 +65,9 @@ var ormOnce sync.Once
 var migrateFN = func(db *gorm.DB, logger *zap.Logger, bus core.OptionalParam[EventBus.Bus]) {
 	ormOnce.Do(func() {
 		if viper.GetBool(KeyInitDB) {
-			MigrateTableAndView(db, logger, bus.P)
+			if err := MigrateTableAndView(db, logger, bus.P); err != nil {
+				logger.Error("migrate failed, tables & views may be outdated", zap.Error(err))
+			}
 		}
 	})
 }
@@ -43,7 +76,16 @@ func ApplyMigrate() error {
 	return core.GetContainer().Invoke(migrateFN)
 }
 
-func MigrateTableAndView(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus, cleanViews ...string) {
+// MigrateTableAndView migrates entities, versioned migrations, post migrate hooks & views, all holding the migration lock.
+// a failed versioned migration returns at once, hooks & views depending on it never run.
+func MigrateTableAndView(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus, cleanViews ...string) error {
+	m := NewMigrator(db, logger)
+	return m.Locked(context.Background(), func() error {
+		return migrateTableAndView(m, db, logger, bus, cleanViews...)
+	})
+}
+
+func migrateTableAndView(m *Migrator, db *gorm.DB, logger *zap.Logger, bus EventBus.Bus, cleanViews ...string) error {
 	dialect := ""
 	if db != nil && db.Dialector != nil {
 		dialect = db.Dialector.Name()
@@ -62,14 +104,21 @@ func MigrateTableAndView(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus, clea
 
 	logger.Info("init all tables")
 
-	for _, item := range entities {
+	active := activeEntities()
+	for _, item := range active {
 		name := fmt.Sprintf("%T", item)
 		zap.L().Info("applied entity", zap.String("entity", name))
 	}
-	err := db.AutoMigrate(entities...)
+	err := db.AutoMigrate(active...)
 	if err != nil {
 		logger.Error("init tables failed", zap.Error(err))
 	} else {
+		applied, err := m.up(context.Background(), "")
+		if err != nil {
+			logger.Error("versioned migrations failed, post migrate hooks & views skipped", zap.Error(err))
+			return err
+		}
+		logger.Info("versioned migrations done", zap.Strings("applied", applied))
 		postMigrateMu.Lock()
 		hooks := make([]func(db *gorm.DB, logger *zap.Logger) error, len(postMigrateHooks))
 		copy(hooks, postMigrateHooks)
@@ -88,12 +137,12 @@ func MigrateTableAndView(db *gorm.DB, logger *zap.Logger, bus EventBus.Bus, clea
 		logger.Info("init tables done")
 	}
 
-	err = InitMysqlViews(db, logger)
-	if err != nil {
-		logger.Error("init views failed", zap.Error(err))
-	} else {
-		logger.Info("init views done.")
+	if viewErr := InitMysqlViews(db, logger); viewErr != nil {
+		logger.Error("init views failed", zap.Error(viewErr))
+		return errors.Join(err, viewErr)
 	}
+	logger.Info("init views done.")
+	return err
 }
 
 func init() {
@@ -87,6 +87,9 @@ func CleanViews(tx *gorm.DB, logger *zap.Logger, viewsToClean []string) error {
 func InitMysqlViews(tx *gorm.DB, logger *zap.Logger) error {
 
 	dbSettings := viper.Sub("database")
+	if dbSettings == nil {
+		return nil
+	}
 
 	tablePrefix := dbSettings.GetString("tablePrefix")
 
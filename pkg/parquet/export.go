@@ -0,0 +1,122 @@
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/techquest-tech/gin-shared/pkg/export"
+)
+
+// ExportRowGroupSize rows kept in memory before flushing one row group to client.
+var ExportRowGroupSize int64 = 10 * 1000
+
+// ErrColumnType is returned by export when a value doesn't fit the column type inferred from the first row.
+var ErrColumnType = errors.New("value mismatched parquet column type")
+
+func init() {
+	export.Register("parquet", export.Format{
+		ContentType: "application/vnd.apache.parquet",
+		Ext:         ".parquet",
+		New:         newExportWriter,
+	})
+}
+
+// SchemaOfRow infers parquet schema for map[string]any rows, e.g. raw query results.
+// column types come from the sample values, nil or unknown values become optional string.
+// returned type is the generated struct type, fields are in columns order.
+func SchemaOfRow(columns []string, sample []any) (*parquet.Schema, reflect.Type) {
+	fields := make([]reflect.StructField, len(columns))
+	for i, col := range columns {
+		var v any
+		if i < len(sample) {
+			v = sample[i]
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: reflect.PointerTo(columnType(v)),
+			Tag:  reflect.StructTag(fmt.Sprintf(`parquet:"%s,optional"`, col)),
+		}
+	}
+	t := reflect.StructOf(fields)
+	return parquet.SchemaOf(reflect.New(t).Interface()), t
+}
+
+func columnType(v any) reflect.Type {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return reflect.TypeOf(int64(0))
+	case float32, float64:
+		return reflect.TypeOf(float64(0))
+	case bool:
+		return reflect.TypeOf(false)
+	case time.Time:
+		return reflect.TypeOf(time.Time{})
+	}
+	return reflect.TypeOf("")
+}
+
+// setColumn sets value to pointer field. string columns take any value, float columns integers as well,
+// other mismatched values are ErrColumnType rather than truncated or dropped.
+func setColumn(field reflect.Value, v any) error {
+	if v == nil {
+		return nil
+	}
+	elemType := field.Type().Elem()
+	rv := reflect.ValueOf(v)
+	ptr := reflect.New(elemType)
+	valueType := columnType(v)
+	switch {
+	case elemType.Kind() == reflect.String && rv.Kind() == reflect.String:
+		ptr.Elem().SetString(rv.String())
+	case elemType.Kind() == reflect.String:
+		ptr.Elem().SetString(fmt.Sprint(v))
+	case valueType == elemType, elemType.Kind() == reflect.Float64 && valueType.Kind() == reflect.Int64:
+		ptr.Elem().Set(rv.Convert(elemType))
+	default:
+		return fmt.Errorf("%w, %s expected, got %T", ErrColumnType, elemType, v)
+	}
+	field.Set(ptr)
+	return nil
+}
+
+type exportWriter struct {
+	w       io.Writer
+	columns []string
+	rowType reflect.Type
+	writer  *parquet.Writer
+}
+
+func newExportWriter(w io.Writer, columns []string, settings *export.Settings) (export.RowWriter, error) {
+	return &exportWriter{w: w, columns: columns}, nil
+}
+
+func (ew *exportWriter) init(sample []any) {
+	var schema *parquet.Schema
+	schema, ew.rowType = SchemaOfRow(ew.columns, sample)
+	ew.writer = parquet.NewWriter(ew.w, schema, parquet.MaxRowsPerRowGroup(ExportRowGroupSize))
+}
+
+func (ew *exportWriter) WriteRow(values []any) error {
+	if ew.writer == nil {
+		ew.init(values)
+	}
+	row := reflect.New(ew.rowType)
+	for i, v := range values {
+		if err := setColumn(row.Elem().Field(i), v); err != nil {
+			return fmt.Errorf("column %s: %w", ew.columns[i], err)
+		}
+	}
+	return ew.writer.Write(row.Interface())
+}
+
+func (ew *exportWriter) Close() error {
+	if ew.writer == nil {
+		// no rows, write file with schema only.
+		ew.init(nil)
+	}
+	return ew.writer.Close()
+}
@@ -0,0 +1,72 @@
+package push
+
+import (
+	"context"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/messaging"
+	"github.com/techquest-tech/gin-shared/pkg/mqttclient"
+	"go.uber.org/zap"
+)
+
+// Bridge forwards Source (stream or mqtt topic) to push Topic, empty Topic means same as Source.
+type Bridge struct {
+	Source string
+	Topic  string
+}
+
+func (b Bridge) topic(source string) string {
+	if b.Topic != "" {
+		return b.Topic
+	}
+	return source
+}
+
+// BridgeChan forwards items of adaptor to topic, must be called before the adaptor started.
+func BridgeChan[T any](hub *Hub, adaptor *core.ChanAdaptor[T], topic string) {
+	adaptor.Subscripter("push."+topic, func(data T) error {
+		return hub.Publish(context.Background(), topic, data)
+	})
+}
+
+// BridgeMessaging forwards redis stream to topic. each message consumed by one pod of the group,
+// then fan-out to clients on all pods by the broker.
+func BridgeMessaging(ctx context.Context, hub *Hub, service messaging.MessagingService, bridge Bridge, group string) error {
+	if group == "" {
+		group = "push"
+	}
+	return service.Sub(ctx, bridge.Source, group, func(ctx context.Context, topic, consumer string, payload []byte) error {
+		return hub.Publish(ctx, bridge.topic(topic), payload)
+	})
+}
+
+// BridgeMqtt forwards mqtt topic (wildcards supported) to topic, use shared subscription
+// (mqttclient.GetSharedTopic) to avoid duplicated messages when running multi pods.
+func BridgeMqtt(hub *Hub, service *mqttclient.MqttService, bridge Bridge) error {
+	return service.Sub(bridge.Source, func(c mqtt.Client, msg mqtt.Message) {
+		if err := hub.Publish(context.Background(), bridge.topic(msg.Topic()), msg.Payload()); err != nil {
+			hub.logger.Error("push mqtt message failed.", zap.String("topic", msg.Topic()), zap.Error(err))
+		}
+	})
+}
+
+// startBridges starts the bridges from config.
+func (h *Hub) startBridges(ctx context.Context) {
+	if len(h.Settings.Streams) > 0 {
+		service := core.GetService[messaging.MessagingService]()
+		for _, item := range h.Settings.Streams {
+			if err := BridgeMessaging(ctx, h, service, item, h.Settings.Group); err != nil {
+				h.logger.Error("bridge stream failed.", zap.String("stream", item.Source), zap.Error(err))
+			}
+		}
+	}
+	if len(h.Settings.Mqtt) > 0 {
+		service := core.GetService[*mqttclient.MqttService]()
+		for _, item := range h.Settings.Mqtt {
+			if err := BridgeMqtt(h, service, item); err != nil {
+				h.logger.Error("bridge mqtt failed.", zap.String("mqtt", item.Source), zap.Error(err))
+			}
+		}
+	}
+}
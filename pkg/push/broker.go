@@ -0,0 +1,77 @@
+//go:build !ram
+
+package push
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+)
+
+// RedisBroker fan-out messages to all pods by redis pub/sub.
+type RedisBroker struct {
+	Client  *redis.Client
+	Channel string
+	Logger  *zap.Logger
+}
+
+func (rb *RedisBroker) Publish(ctx context.Context, msg *Message) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return rb.Client.Publish(ctx, rb.Channel, raw).Err()
+}
+
+func (rb *RedisBroker) Start(ctx context.Context, deliver func(msg *Message)) error {
+	sub := rb.Client.Subscribe(ctx, rb.Channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	rb.Logger.Info("push broker subscribed", zap.String("channel", rb.Channel))
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-ch:
+			if !ok {
+				return redis.ErrClosed
+			}
+			msg := &Message{}
+			if err := json.Unmarshal([]byte(m.Payload), msg); err != nil {
+				rb.Logger.Error("unexpected push message", zap.String("payload", m.Payload), zap.Error(err))
+				continue
+			}
+			deliver(msg)
+		}
+	}
+}
+
+func newRedisBroker(logger *zap.Logger) Broker {
+	settings := loadSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	var broker Broker
+	err := core.GetContainer().Invoke(func(client *redis.Client) {
+		broker = &RedisBroker{
+			Client:  client,
+			Channel: settings.Channel,
+			Logger:  logger,
+		}
+	})
+	if err != nil {
+		logger.Warn("redis is not available, push messages delivered on local pod only.", zap.Error(err))
+		return nil
+	}
+	return broker
+}
+
+func init() {
+	core.Provide(newRedisBroker)
+}
@@ -0,0 +1,285 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/thanhpk/randstr"
+	"go.uber.org/zap"
+)
+
+var ErrTooManyTopics = errors.New("too many topics")
+
+type Settings struct {
+	Enabled      bool
+	Base         string        // route base, default /push
+	Auth         string        // apikey(default), keycloak or none
+	Heartbeat    time.Duration // ping interval, default 25s
+	SendBuffer   int           // messages buffered per connection, slow client evicted when it's full
+	WriteTimeout time.Duration
+	MaxTopics    int      // max subscriptions per connection
+	Channel      string   // redis pub/sub channel for cross-pod fan-out
+	Group        string   // consumer group for stream bridges, default push
+	Streams      []Bridge // redis streams forwarded to clients
+	Mqtt         []Bridge // mqtt topics forwarded to clients
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Base:         "/push",
+		Auth:         AuthAPIKey,
+		Heartbeat:    25 * time.Second,
+		SendBuffer:   64,
+		WriteTimeout: 10 * time.Second,
+		MaxTopics:    32,
+		Channel:      "push.events",
+		Group:        "push",
+	}
+}
+
+// Message pushed to clients, Data is the json payload.
+type Message struct {
+	ID    string          `json:"id"`
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Broker fan-out messages to all pods, nil broker means local delivery only.
+type Broker interface {
+	Publish(ctx context.Context, msg *Message) error
+	Start(ctx context.Context, deliver func(msg *Message)) error
+}
+
+type client struct {
+	id     string
+	user   string
+	send   chan *Message
+	done   chan struct{}
+	once   sync.Once
+	topics map[string]bool
+}
+
+func (cl *client) close() {
+	cl.once.Do(func() {
+		close(cl.done)
+	})
+}
+
+type Hub struct {
+	Settings  *Settings
+	Broker    Broker
+	Authorize func(c *gin.Context, topic string) bool // check if current user can subscribe topic, nil denies all topics
+	logger    *zap.Logger
+	lock      sync.RWMutex
+	clients   map[*client]bool
+	topics    map[string]map[*client]bool // subscribed topic pattern -> clients
+}
+
+func NewHub(settings *Settings, broker Broker, logger *zap.Logger) *Hub {
+	defaults := DefaultSettings()
+	if settings == nil {
+		settings = defaults
+	}
+	if settings.Heartbeat <= 0 {
+		settings.Heartbeat = defaults.Heartbeat
+	}
+	if settings.WriteTimeout <= 0 {
+		settings.WriteTimeout = defaults.WriteTimeout
+	}
+	if settings.SendBuffer <= 0 {
+		settings.SendBuffer = defaults.SendBuffer
+	}
+	return &Hub{
+		Settings: settings,
+		Broker:   broker,
+		logger:   logger,
+		clients:  map[*client]bool{},
+		topics:   map[string]map[*client]bool{},
+	}
+}
+
+// Start receives messages from broker until ctx done.
+func (h *Hub) Start(ctx context.Context) {
+	if h.Broker == nil {
+		return
+	}
+	go func() {
+		for {
+			err := h.Broker.Start(ctx, h.deliver)
+			if ctx.Err() != nil {
+				return
+			}
+			h.logger.Error("push broker stopped, retry later.", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+}
+
+// Publish sends payload to all clients subscribed the topic, on all pods if broker enabled.
+func (h *Hub) Publish(ctx context.Context, topic string, payload any) error {
+	raw, ok := payload.([]byte)
+	if !ok || !json.Valid(raw) {
+		if ok {
+			payload = string(raw)
+		}
+		var err error
+		raw, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+	}
+	msg := &Message{ID: randstr.Hex(8), Topic: topic, Data: raw}
+	if h.Broker != nil {
+		return h.Broker.Publish(ctx, msg)
+	}
+	h.deliver(msg)
+	return nil
+}
+
+func (h *Hub) deliver(msg *Message) {
+	h.lock.RLock()
+	receivers := make([]*client, 0)
+	for pattern, clients := range h.topics {
+		if !Match(pattern, msg.Topic) {
+			continue
+		}
+		for cl := range clients {
+			receivers = append(receivers, cl)
+		}
+	}
+	h.lock.RUnlock()
+
+	for _, cl := range receivers {
+		select {
+		case cl.send <- msg:
+		case <-cl.done:
+		default:
+			h.logger.Warn("send buffer is full, evict slow client.", zap.String("client", cl.id), zap.String("user", cl.user))
+			h.remove(cl)
+		}
+	}
+}
+
+// Match checks topic against subscribed pattern, "*" matches all, "orders.*" matches orders.created.
+func Match(pattern, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return false
+}
+
+func (h *Hub) add(user string) *client {
+	cl := &client{
+		id:     randstr.Hex(8),
+		user:   user,
+		send:   make(chan *Message, h.Settings.SendBuffer),
+		done:   make(chan struct{}),
+		topics: map[string]bool{},
+	}
+	h.lock.Lock()
+	h.clients[cl] = true
+	h.lock.Unlock()
+	h.logger.Debug("client connected", zap.String("client", cl.id), zap.String("user", user))
+	return cl
+}
+
+func (h *Hub) remove(cl *client) {
+	h.lock.Lock()
+	delete(h.clients, cl)
+	for topic := range cl.topics {
+		h.unsubscribeLocked(cl, topic)
+	}
+	h.lock.Unlock()
+	cl.close()
+}
+
+func (h *Hub) subscribe(cl *client, topics ...string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, topic := range topics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" || cl.topics[topic] {
+			continue
+		}
+		if h.Settings.MaxTopics > 0 && len(cl.topics) >= h.Settings.MaxTopics {
+			return ErrTooManyTopics
+		}
+		cl.topics[topic] = true
+		clients, ok := h.topics[topic]
+		if !ok {
+			clients = map[*client]bool{}
+			h.topics[topic] = clients
+		}
+		clients[cl] = true
+	}
+	return nil
+}
+
+func (h *Hub) unsubscribe(cl *client, topics ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, topic := range topics {
+		h.unsubscribeLocked(cl, strings.TrimSpace(topic))
+	}
+}
+
+func (h *Hub) unsubscribeLocked(cl *client, topic string) {
+	delete(cl.topics, topic)
+	if clients, ok := h.topics[topic]; ok {
+		delete(clients, cl)
+		if len(clients) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// Clients returns the number of connected clients on this pod.
+func (h *Hub) Clients() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.clients)
+}
+
+// Close disconnects all clients.
+func (h *Hub) Close() {
+	h.lock.RLock()
+	clients := make([]*client, 0, len(h.clients))
+	for cl := range h.clients {
+		clients = append(clients, cl)
+	}
+	h.lock.RUnlock()
+	for _, cl := range clients {
+		h.remove(cl)
+	}
+}
+
+func newHubService(logger *zap.Logger, broker core.OptionalParam[Broker]) *Hub {
+	settings := loadSettings()
+	hub := NewHub(settings, broker.P, logger)
+	if !settings.Enabled {
+		return hub
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	core.OnServiceStarted(func() {
+		hub.Start(ctx)
+		hub.startBridges(ctx)
+	})
+	core.OnServiceStopping(func() {
+		cancel()
+		hub.Close()
+	})
+	return hub
+}
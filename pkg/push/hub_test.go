@@ -0,0 +1,135 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+)
+
+func setup(t *testing.T) (*Hub, *httptest.Server) {
+	gin.SetMode(gin.TestMode)
+	hub := NewHub(&Settings{SendBuffer: 2, MaxTopics: 2}, nil, zap.NewNop())
+	hub.Authorize = func(c *gin.Context, topic string) bool {
+		return topic != "admin"
+	}
+	r := gin.New()
+	r.GET("/sse", hub.ServeSSE)
+	r.GET("/ws", hub.ServeWS)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return hub, server
+}
+
+func waitClients(hub *Hub, n int) {
+	for i := 0; i < 100 && hub.Clients() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("*", "orders.created"))
+	assert.True(t, Match("orders.*", "orders.created"))
+	assert.True(t, Match("orders.created", "orders.created"))
+	assert.False(t, Match("orders.*", "tasks.done"))
+}
+
+func TestSSE(t *testing.T) {
+	hub, server := setup(t)
+
+	resp, err := http.Get(server.URL + "/sse?topics=admin")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	authorize := hub.Authorize
+	hub.Authorize = nil
+	resp, err = http.Get(server.URL + "/sse?topics=orders.*")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "all topics denied without Authorize")
+	hub.Authorize = authorize
+
+	resp, err = http.Get(server.URL + "/sse?topics=orders.*")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	waitClients(hub, 1)
+
+	hub.Publish(context.Background(), "tasks.done", map[string]any{"id": 0})
+	hub.Publish(context.Background(), "orders.created", map[string]any{"id": 1})
+
+	reader := bufio.NewReader(resp.Body)
+	lines := make([]string, 0)
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.True(t, strings.HasPrefix(lines[0], "id: "))
+	assert.Equal(t, "event: orders.created", lines[1])
+	assert.Equal(t, `data: {"id":1}`, lines[2])
+}
+
+func TestWebsocket(t *testing.T) {
+	hub, server := setup(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?topics=orders.*"
+
+	header := http.Header{"Origin": []string{"https://evil.example.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial(url, header)
+	assert.Error(t, err, "same origin only by default")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	other, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{server.URL}})
+	assert.NoError(t, err)
+	other.Close()
+
+	viper.Set(ginshared.KeyWebsocketOrigins, []string{"https://*.example.org"})
+	defer viper.Set(ginshared.KeyWebsocketOrigins, nil)
+	_, resp, err = websocket.DefaultDialer.Dial(url, header)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	header.Set("Origin", "https://app.example.org")
+	other, _, err = websocket.DefaultDialer.Dial(url, header)
+	assert.NoError(t, err)
+	other.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteJSON(Command{Action: ActionSubscribe, Topics: []string{"admin"}}))
+	reply := commandReply{}
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.NotEmpty(t, reply.Error)
+
+	assert.NoError(t, conn.WriteJSON(Command{Action: ActionSubscribe, Topics: []string{"tasks.*"}}))
+	reply = commandReply{}
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Empty(t, reply.Error)
+
+	hub.Publish(context.Background(), "tasks.done", []byte("plain text"))
+	msg := Message{}
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "tasks.done", msg.Topic)
+	assert.Equal(t, `"plain text"`, string(msg.Data))
+}
+
+func TestEvictSlowClient(t *testing.T) {
+	hub := NewHub(&Settings{SendBuffer: 1}, nil, zap.NewNop())
+	cl := hub.add("slow")
+	assert.NoError(t, hub.subscribe(cl, "orders.*"))
+
+	hub.Publish(context.Background(), "orders.created", 1)
+	assert.Equal(t, 1, hub.Clients())
+	hub.Publish(context.Background(), "orders.created", 2)
+	assert.Equal(t, 0, hub.Clients())
+	<-cl.done
+}
@@ -0,0 +1,142 @@
+package push
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/keycloak"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"go.uber.org/zap"
+)
+
+const (
+	AuthAPIKey   = "apikey"
+	AuthKeycloak = "keycloak"
+	AuthNone     = "none"
+
+	KeyTopics      = "topics"
+	KeyAccessToken = "access_token"
+)
+
+func init() {
+	core.Provide(newHubService)
+	ginshared.GetContainer().Provide(initPushController, ginshared.ControllerOptions)
+}
+
+func loadSettings() *Settings {
+	settings := DefaultSettings()
+	if sub := viper.Sub("push"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return settings
+}
+
+func initPushController(logger *zap.Logger, router *gin.Engine, authservice *auth.AuthService) ginshared.DiController {
+	settings := loadSettings()
+	if !settings.Enabled {
+		logger.Info("push is disabled.")
+		return nil
+	}
+	hub := core.GetService[*Hub]()
+
+	base := viper.GetString("baseUri") + settings.Base
+	group := router.Group(base)
+	switch settings.Auth {
+	case AuthAPIKey:
+		group.Use(authservice.Auth)
+	case AuthKeycloak:
+		group.Use(bearerFromQuery, keycloak.MustLogin(), keycloakUser)
+	case AuthNone:
+		logger.Warn("auth disabled for push", zap.String("base", base))
+	default:
+		panic(fmt.Errorf("unknown push auth %s", settings.Auth))
+	}
+
+	params := []openapi.Param{{Name: KeyTopics, Required: true, Description: "topics split by comma, e.g. orders.*,tasks.done"}}
+	openapi.GET(group, "sse", &openapi.Operation{
+		Summary:  "subscribe topics by server-sent events",
+		Tags:     []string{"push"},
+		Params:   params,
+		Response: Message{},
+	}, hub.ServeSSE)
+	openapi.GET(group, "ws", &openapi.Operation{
+		Summary:     "subscribe topics by websocket",
+		Description: `send {"action":"subscribe","topics":["orders.*"]} or {"action":"unsubscribe",...} to change subscriptions`,
+		Tags:        []string{"push"},
+		Params:      params,
+		Response:    Message{},
+	}, hub.ServeWS)
+
+	logger.Info("push enabled", zap.String("base", base), zap.String("auth", settings.Auth))
+	return hub
+}
+
+// bearerFromQuery, browsers can't set header for EventSource & WebSocket, token passed by access_token param.
+func bearerFromQuery(c *gin.Context) {
+	if token := c.Query(KeyAccessToken); token != "" && c.GetHeader("Authorization") == "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	c.Next()
+}
+
+func keycloakUser(c *gin.Context) {
+	if tk, ok := c.Get("token"); ok {
+		if token, ok := tk.(ginkeycloak.KeyCloakToken); ok {
+			c.Set("user", token.PreferredUsername)
+		}
+	}
+	c.Next()
+}
+
+func topicsOf(c *gin.Context) []string {
+	topics := make([]string, 0)
+	for _, item := range c.QueryArray(KeyTopics) {
+		for _, topic := range strings.Split(item, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+// AllowAll is the Authorize allows any topic for any user, e.g. only public topics pushed.
+func AllowAll(c *gin.Context, topic string) bool {
+	return true
+}
+
+// authorized checks topics by Authorize, all topics denied if Authorize not set.
+func (h *Hub) authorized(c *gin.Context, topics []string) error {
+	if h.Authorize == nil && len(topics) > 0 {
+		return fmt.Errorf("topic %s is not allowed, no Authorize for push topics", topics[0])
+	}
+	for _, topic := range topics {
+		if !h.Authorize(c, topic) {
+			return fmt.Errorf("topic %s is not allowed", topic)
+		}
+	}
+	return nil
+}
+
+// connect registers client with initial topics, responds error if failed.
+func (h *Hub) connect(c *gin.Context) (*client, bool) {
+	topics := topicsOf(c)
+	if err := h.authorized(c, topics); err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, ginshared.UnifiedResp{Error: err.Error()})
+		return nil, false
+	}
+	cl := h.add(c.GetString("user"))
+	if err := h.subscribe(cl, topics...); err != nil {
+		h.remove(cl)
+		ginshared.ReportBadrequest(c, err)
+		return nil, false
+	}
+	return cl, true
+}
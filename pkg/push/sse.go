@@ -0,0 +1,69 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ServeSSE streams messages of the subscribed topics as server-sent events, event name is the topic.
+func (h *Hub) ServeSSE(c *gin.Context) {
+	cl, ok := h.connect(c)
+	if !ok {
+		return
+	}
+	defer h.remove(cl)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	rc := http.NewResponseController(c.Writer)
+	heartbeat := time.NewTicker(h.Settings.Heartbeat)
+	defer heartbeat.Stop()
+
+	write := func(fn func(w io.Writer)) bool {
+		if h.Settings.WriteTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(h.Settings.WriteTimeout))
+		}
+		fn(c.Writer)
+		if err := rc.Flush(); err != nil {
+			h.logger.Debug("sse client gone", zap.String("client", cl.id), zap.Error(err))
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-cl.done:
+			return
+		case <-heartbeat.C:
+			if !write(func(w io.Writer) { io.WriteString(w, ": ping\n\n") }) {
+				return
+			}
+		case msg := <-cl.send:
+			if !write(func(w io.Writer) { writeEvent(w, msg) }) {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w io.Writer, msg *Message) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\n", msg.ID, msg.Topic)
+	for _, line := range bytes.Split(msg.Data, []byte("\n")) {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,125 @@
+package push
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+)
+
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// Command from websocket client to change subscriptions.
+type Command struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+}
+
+type commandReply struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// ServeWS pushes messages of the subscribed topics as json text frames,
+// origin checked against CORS allowed origins.
+func (h *Hub) ServeWS(c *gin.Context) {
+	cl, ok := h.connect(c)
+	if !ok {
+		return
+	}
+	conn, err := ginshared.ToWebsocket.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// upgrader replied error already.
+		h.logger.Warn("upgrade websocket failed.", zap.Error(err))
+		h.remove(cl)
+		return
+	}
+
+	// pong within 2 heartbeats, otherwise the client treated as gone.
+	wait := 2 * h.Settings.Heartbeat
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	replies := make(chan commandReply, 4)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		h.readCommands(c, conn, cl, replies)
+	}()
+	defer func() {
+		h.remove(cl)
+		conn.Close()
+		// gin context must not be used after handler returned.
+		<-readerDone
+	}()
+
+	heartbeat := time.NewTicker(h.Settings.Heartbeat)
+	defer heartbeat.Stop()
+	deadline := func() time.Time {
+		return time.Now().Add(h.Settings.WriteTimeout)
+	}
+
+	for {
+		var err error
+		select {
+		case <-cl.done:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), deadline())
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, deadline())
+		case reply := <-replies:
+			conn.SetWriteDeadline(deadline())
+			err = conn.WriteJSON(reply)
+		case msg := <-cl.send:
+			conn.SetWriteDeadline(deadline())
+			err = conn.WriteJSON(msg)
+		}
+		if err != nil {
+			h.logger.Debug("websocket client gone", zap.String("client", cl.id), zap.Error(err))
+			return
+		}
+	}
+}
+
+// readCommands reads subscribe/unsubscribe commands until connection closed.
+func (h *Hub) readCommands(c *gin.Context, conn *websocket.Conn, cl *client, replies chan commandReply) {
+	defer cl.close()
+	for {
+		cmd := Command{}
+		if err := conn.ReadJSON(&cmd); err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				h.logger.Debug("read websocket failed.", zap.String("client", cl.id), zap.Error(err))
+			}
+			return
+		}
+		reply := commandReply{Action: cmd.Action, Topics: cmd.Topics}
+		switch cmd.Action {
+		case ActionSubscribe:
+			err := h.authorized(c, cmd.Topics)
+			if err == nil {
+				err = h.subscribe(cl, cmd.Topics...)
+			}
+			if err != nil {
+				reply.Error = err.Error()
+			}
+		case ActionUnsubscribe:
+			h.unsubscribe(cl, cmd.Topics...)
+		default:
+			reply.Error = "unknown action " + cmd.Action
+		}
+		select {
+		case replies <- reply:
+		case <-cl.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	PreviewRows    = 10  // default rows of preview
+	MaxPreviewRows = 100 // max rows of preview
+)
+
+var (
+	ErrDefinitionNotFound = errors.New("query definition not found")
+	ErrPreviewReadOnly    = errors.New("preview runs a single SELECT or WITH statement only")
+)
+
+// DefinitionReq creates a new version of the uri.
+type DefinitionReq struct {
+	Uri        string `binding:"required"`
+	Definition string `binding:"required"`
+	Enabled    bool
+	Remark     string
+}
+
+// PreviewReq runs definition with params, limited rows in a read only & rolled back transaction.
+type PreviewReq struct {
+	Uri        string
+	Definition string `binding:"required"`
+	Params     map[string]any
+	Limit      int
+}
+
+type PreviewResult struct {
+	Sql    string
+	Params []any
+	Plan   []map[string]any `json:",omitempty"`
+	Data   []map[string]any
+}
+
+// explain returns explain statement of the dialect, empty if not supported.
+func explain(dialect, sql string) string {
+	switch dialect {
+	case "sqlite":
+		return "EXPLAIN QUERY PLAN " + sql
+	case "mysql", "postgres":
+		return "EXPLAIN " + sql
+	}
+	return ""
+}
+
+// Preview validates the definition, returns SQL, plan & first rows. children are not previewed.
+func (service *RawQuerySerice) Preview(ctx context.Context, req *PreviewReq) (*PreviewResult, error) {
+	item, err := ParseItem(lo.CoalesceOrEmpty(req.Uri, "preview"), req.Definition)
+	if err != nil {
+		return nil, err
+	}
+	service.prepare(&item)
+	q := item.Query
+	if req.Limit <= 0 {
+		req.Limit = PreviewRows
+	}
+	if req.Limit > MaxPreviewRows {
+		req.Limit = MaxPreviewRows
+	}
+	q.MaxRows = req.Limit
+	params := req.Params
+	if params == nil {
+		params = map[string]any{}
+	}
+	query, args, err := q.Build(params)
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly(query) {
+		return nil, ErrPreviewReadOnly
+	}
+	timeout := q.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := &PreviewResult{Sql: query, Params: args, Data: make([]map[string]any, 0)}
+	db, err := service.dbOf(q)
+	if err != nil {
+		return nil, err
+	}
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if stmt := explain(tx.Dialector.Name(), query); stmt != "" {
+			if err := tx.Raw(stmt, args...).Find(&result.Plan).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Raw(query, args...).Find(&result.Data).Error; err != nil {
+			return err
+		}
+		return errPreviewDone
+	}, &sql.TxOptions{ReadOnly: true})
+	if errors.Is(err, errPreviewDone) {
+		err = nil
+	}
+	return result, err
+}
+
+// readOnly returns true for a single SELECT or WITH statement, leading comments ignored.
+// DDL commits implicitly on some databases, the rolled back transaction can't undo it.
+func readOnly(query string) bool {
+	q := strings.TrimSpace(query)
+	for {
+		switch {
+		case strings.HasPrefix(q, "--"):
+			_, rest, _ := strings.Cut(q, "\n")
+			q = strings.TrimSpace(rest)
+		case strings.HasPrefix(q, "/*"):
+			_, rest, _ := strings.Cut(q, "*/")
+			q = strings.TrimSpace(rest)
+		case strings.HasPrefix(q, "("):
+			q = strings.TrimSpace(q[1:])
+		default:
+			if strings.Contains(strings.TrimRight(q, "; \t\r\n"), ";") {
+				return false
+			}
+			fields := strings.Fields(q)
+			if len(fields) == 0 {
+				return false
+			}
+			first := strings.ToUpper(fields[0])
+			return first == "SELECT" || first == "WITH"
+		}
+	}
+}
+
+// errPreviewDone rolls back the preview transaction.
+var errPreviewDone = errors.New("preview done")
+
+// mountAdmin registers endpoints of definitions under AdminBase, guarded by API key with AdminPerm.
+func (service *RawQuerySerice) mountAdmin(router *gin.Engine) {
+	group := router.Group(service.AdminBase, service.authservice.Auth, auth.Require(service.AdminPerm))
+	doc := func(op *openapi.Operation) *openapi.Operation {
+		op.Tags = []string{"queries admin"}
+		return op
+	}
+	openapi.GET(group, "", doc(&openapi.Operation{
+		Summary:  "list query definitions, all versions",
+		Params:   []openapi.Param{{Name: "uri"}},
+		Response: []QueryDefinition{},
+	}), service.listDefinitions)
+	openapi.POST(group, "", doc(&openapi.Operation{
+		Summary:  "create a new version of query definition",
+		Request:  DefinitionReq{},
+		Response: QueryDefinition{},
+	}), service.createDefinition)
+	openapi.PATCH(group, ":id", doc(&openapi.Operation{
+		Summary: "enable or disable query definition version",
+		Params:  []openapi.Param{{Name: "enabled", Type: "boolean", Required: true}},
+	}), service.enableDefinition)
+	openapi.POST(group, "preview", doc(&openapi.Operation{
+		Summary:  "dry run query definition with explain & limited rows",
+		Request:  PreviewReq{},
+		Response: PreviewResult{},
+	}), service.preview)
+	openapi.POST(group, "reload", doc(&openapi.Operation{
+		Summary: "reload query definitions on all nodes",
+	}), func(c *gin.Context) {
+		service.changed(c, "")
+		ginshared.RespondOK(c, nil)
+	})
+}
+
+func (service *RawQuerySerice) listDefinitions(c *gin.Context) {
+	rows := make([]QueryDefinition, 0)
+	tx := service.db.WithContext(c).Order("uri, version desc")
+	if uri := strings.Trim(c.Query("uri"), "/"); uri != "" {
+		tx = tx.Where("uri = ?", uri)
+	}
+	if err := tx.Find(&rows).Error; err != nil {
+		ginshared.RespondErr(c, err, service.logger)
+		return
+	}
+	ginshared.RespondOK(c, rows)
+}
+
+func (service *RawQuerySerice) createDefinition(c *gin.Context) {
+	req := &DefinitionReq{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	item, err := ParseItem(req.Uri, req.Definition)
+	if err == nil {
+		service.prepare(&item)
+		_, _, err = item.Query.Build(map[string]any{})
+		if errors.Is(err, ErrInvalidParam) {
+			// required params missing, definition itself is fine.
+			err = nil
+		}
+	}
+	if err == nil {
+		err = checkItem(item)
+	}
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	def := &QueryDefinition{Uri: item.Uri, Enabled: req.Enabled, Definition: req.Definition, Remark: req.Remark, CreatedBy: c.GetString("user")}
+	err = service.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		latest := 0
+		if err := tx.Model(&QueryDefinition{}).Unscoped().Where("uri = ?", def.Uri).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		def.Version = latest + 1
+		return tx.Create(def).Error
+	})
+	if err != nil {
+		ginshared.RespondErr(c, err, service.logger)
+		return
+	}
+	service.logger.Info("query definition created", zap.String("uri", def.Uri), zap.Int("version", def.Version), zap.String("user", def.CreatedBy))
+	if def.Enabled {
+		service.changed(c, def.Uri)
+	}
+	ginshared.RespondOK(c, def)
+}
+
+func (service *RawQuerySerice) enableDefinition(c *gin.Context) {
+	req := struct {
+		Enabled *bool `form:"enabled" binding:"required"`
+	}{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	def := &QueryDefinition{}
+	if err := service.db.WithContext(c).First(def, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, ErrDefinitionNotFound.Error())
+			return
+		}
+		ginshared.RespondErr(c, err, service.logger)
+		return
+	}
+	if err := service.db.WithContext(c).Model(def).Update("enabled", *req.Enabled).Error; err != nil {
+		ginshared.RespondErr(c, err, service.logger)
+		return
+	}
+	service.logger.Info("query definition updated", zap.String("uri", def.Uri), zap.Int("version", def.Version),
+		zap.Bool("enabled", *req.Enabled), zap.String("user", c.GetString("user")))
+	service.changed(c, def.Uri)
+	ginshared.RespondOK(c, def)
+}
+
+func (service *RawQuerySerice) preview(c *gin.Context) {
+	req := &PreviewReq{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	result, err := service.Preview(c, req)
+	if err != nil {
+		// SQL errors are the point of preview, reply them to admins.
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	ginshared.RespondOK(c, result)
+}
@@ -1,84 +1,332 @@
 package query
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 	"github.com/spf13/viper"
 	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/export"
 	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
 	"github.com/techquest-tech/gin-shared/pkg/orm"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrNotFound     = errors.New("no records found")
+	ErrMultiRows    = errors.New("multi records found")
+	ErrNoConnection = errors.New("query connection is not configured")
+)
+
 type RawQuerySerice struct {
 	db          *gorm.DB
 	logger      *zap.Logger
 	Source      string
 	EnabledAuth bool
 	Base        string
+	ErrorCode   int           // reply code of failed queries, default 500
+	Timeout     time.Duration // default SQL timeout of items
+	MaxRows     int           // default max rows of items
 	Items       []SerivceItem
+
+	// Dynamic serves items of config & QueryDefinition rows by catch-all route under Base, reloaded on changes.
+	Dynamic        bool
+	ReloadInterval time.Duration // reload definitions periodically if set, besides change events
+	AdminBase      string        // endpoints of definitions, default /admin/queries, must not be under Base
+	AdminPerm      string        // permission of admin endpoints, default queries:admin
+
+	authservice *auth.AuthService
+	lock        sync.RWMutex
+	routes      []*route
+	reloading   sync.Mutex
+	operations  []openapi.Operation // documented by the last Reload
 }
 
 type SerivceItem struct {
-	Uri     string
-	Query   RawQuery  // header
-	Details *RawQuery // details
+	Uri         string
+	Method      string        // GET by default, params read from JSON body too for POST, PUT & PATCH
+	Public      bool          // no auth required even if EnabledAuth
+	Permissions []string      // all required, see auth.Require
+	Roles       []string      // any of the roles required
+	Cache       time.Duration // response cache TTL, cached by params & owner
+	Query       RawQuery      // header
+	Details     *RawQuery     // details
+
+	cache *cache.Cache[any]
 }
 
 func init() {
 	ginshared.GetContainer().Provide(initRawQuery, ginshared.ControllerOptions)
 }
 
-func initRawQuery(logger *zap.Logger, router *gin.Engine, authservice *auth.AuthService, db *gorm.DB) ginshared.DiController {
+// loadRawQuery reads Queries config, nil if not configured.
+func loadRawQuery(logger *zap.Logger, db *gorm.DB) *RawQuerySerice {
 	settings := viper.Sub("Queries")
 	if settings == nil {
-		logger.Warn("not queries in config files, ignored.")
 		return nil
 	}
 	serivce := &RawQuerySerice{
 		logger:      logger,
 		EnabledAuth: true,
+		ErrorCode:   http.StatusInternalServerError,
+		AdminBase:   "/admin/queries",
+		AdminPerm:   "queries:admin",
 	}
 
 	settings.Unmarshal(serivce)
 
-	//init DB connections.
-	dbsettings := serivce.Source
-	serivce.db = orm.InitDB(dbsettings, logger)
+	//init DB connections, opened once by orm.Registry.
+	serivce.db = orm.DB(serivce.Source)
 
 	if serivce.db == nil {
 		serivce.db = db
 	}
 
+	for i := range serivce.Items {
+		serivce.prepare(&serivce.Items[i])
+	}
+
 	logger.Debug("load query defines done", zap.Any("service", serivce))
+	return serivce
+}
+
+// prepare applies defaults of the service to the item, response cache created if enabled.
+func (service *RawQuerySerice) prepare(item *SerivceItem) {
+	item.Method = strings.ToUpper(lo.Ternary(item.Method == "", http.MethodGet, item.Method))
+	service.defaults(&item.Query)
+	if item.Details != nil {
+		service.defaults(item.Details)
+	}
+	if item.Cache > 0 {
+		item.cache = cache.NewWithTimeout[any](item.Cache)
+	}
+}
+
+// register served items as SumRef by Uri, never items previewed or saved only.
+func register(items []SerivceItem) {
+	for _, item := range items {
+		RegisterQuery(item.Uri, item.Query)
+	}
+}
+
+// defaults applies service timeout & max rows to the query & its children.
+func (service *RawQuerySerice) defaults(q *RawQuery) {
+	if q.Timeout == 0 {
+		q.Timeout = service.Timeout
+	}
+	if q.MaxRows == 0 {
+		q.MaxRows = service.MaxRows
+	}
+	if q.Connection != "" && orm.DB(q.Connection) == nil {
+		service.logger.Error("query connection is not configured, the query responds 500", zap.String("connection", q.Connection))
+	}
+	for i := range q.Children {
+		service.defaults(&q.Children[i].Query)
+	}
+}
+
+func initRawQuery(logger *zap.Logger, router *gin.Engine, authservice *auth.AuthService, db *gorm.DB) ginshared.DiController {
+	serivce := loadRawQuery(logger, db)
+	if serivce == nil {
+		logger.Warn("not queries in config files, ignored.")
+		return nil
+	}
 
 	uri := viper.GetString("baseUri")
 	serivce.Base = uri + serivce.Base
+	serivce.authservice = authservice
 
-	// if serivce.EnabledAuth {
-	group := auth.NewAuthedRouter(router, authservice, logger, serivce.Base, 299)
-	// }
 	if !serivce.EnabledAuth {
 		logger.Warn("auth disabled for raw query", zap.String("base", serivce.Base))
-		group = router.Group(serivce.Base)
 	}
+	group := router.Group(serivce.Base)
 
-	for _, item := range serivce.Items {
-		if item.Details == nil {
-			group.GET(item.Uri, serivce.handler(item.Query))
-		} else {
-			group.GET(item.Uri, serivce.handleDetails(item.Query, *item.Details))
+	if serivce.Dynamic {
+		serivce.AdminBase = uri + serivce.AdminBase
+		serivce.mount(router, group)
+		return nil
+	}
+
+	register(serivce.Items)
+	for i := range serivce.Items {
+		item := &serivce.Items[i]
+		handlers := serivce.handlers(item)
+		group.Handle(item.Method, item.Uri, handlers...)
+		openapi.Add(serivce.operation(*item, handlers))
+	}
+
+	return nil
+}
+
+// handlers of the item, auth, permissions & roles checked unless public.
+func (service *RawQuerySerice) handlers(item *SerivceItem) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0)
+	if service.EnabledAuth && !item.Public {
+		handlers = append(handlers, service.authservice.Auth)
+		if len(item.Permissions) > 0 {
+			handlers = append(handlers, auth.Require(item.Permissions...))
+		}
+		if len(item.Roles) > 0 {
+			handlers = append(handlers, requireRoles(item.Roles))
+		}
+	}
+	return append(handlers, service.handler(item))
+}
+
+// requireRoles checks current user has any of the roles, admin role included.
+func requireRoles(roles []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current := auth.RolesOf(c)
+		if !lo.Contains(current, auth.RoleAdmin) && len(lo.Intersect(current, roles)) == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, ginshared.GeneralResp{
+				ErrorCode:    "Forbidden",
+				ErrorMessage: "role required, " + strings.Join(roles, ","),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// operation introspects query item for openapi, Params & Where keys become query params, security detected from handlers.
+func (service *RawQuerySerice) operation(item SerivceItem, handlers []gin.HandlerFunc) openapi.Operation {
+	op := openapi.Operation{
+		Method:  item.Method,
+		Path:    service.Base + "/" + item.Uri,
+		Summary: item.Uri,
+		Tags:    []string{"queries"},
+	}
+	if item.Details == nil && item.Query.Keyset != "" {
+		op.Params = append(op.Params,
+			openapi.Param{Name: KeyCursor, Description: "Next or Prev of the previous page"},
+			openapi.Param{Name: KeyPageSize},
+			openapi.Param{Name: KeyEstimate, Description: "return estimated Total"},
+		)
+	}
+	openapi.Detect(&op, handlers...)
+	pathParams := pathParamNames(op.Path)
+	types := map[string]string{TypeInt: "integer", TypeFloat: "number", TypeBool: "boolean", TypeStrings: "array", TypeInts: "array"}
+	appendParams := func(q RawQuery) {
+		declared := map[string]ParamDef{}
+		for _, d := range q.Args {
+			declared[d.Name] = d
+		}
+		param := func(name, description string, required bool) openapi.Param {
+			p := openapi.Param{Name: name, Description: description, Required: required}
+			if d, ok := declared[name]; ok {
+				p.Type = types[d.Type]
+				p.Required = d.Required || (required && d.Default == nil)
+				if len(d.Enum) > 0 {
+					p.Description = strings.TrimSpace(p.Description + " one of " + strings.Join(d.Enum, ","))
+				}
+			}
+			return p
+		}
+		for _, p := range q.Params {
+			if _, ok := q.Preset[p]; ok || lo.Contains(pathParams, p) {
+				continue
+			}
+			op.Params = append(op.Params, param(p, "", true))
+		}
+		keys := lo.Keys(q.Where)
+		sort.Strings(keys)
+		for _, p := range keys {
+			op.Params = append(op.Params, param(p, q.Where[p], false))
+		}
+	}
+	appendParams(item.Query)
+	if item.Details == nil && len(item.Query.Columns) > 0 {
+		columns := strings.Join(item.Query.Columns, ", ")
+		op.Params = append(op.Params,
+			openapi.Param{Name: KeyFilter, Description: "field:op:value;..., op: eq ne gt gte lt lte in nin between null contains icontains prefix, fields: " + columns},
+			openapi.Param{Name: KeySort, Description: "e.g. -created_at,id"},
+			openapi.Param{Name: KeyFields, Description: "e.g. id,name"},
+		)
+		if len(item.Query.Search) > 0 {
+			op.Params = append(op.Params, openapi.Param{Name: KeySearch, Description: "search " + strings.Join(item.Query.Search, ", ")})
 		}
+	}
+	if hasBody(item.Method) {
+		op.Request = map[string]any{}
+	}
+	op.Response = []map[string]any{}
+	if item.Query.Keyset != "" || item.Query.summarized() {
+		op.Response = PagingResult[map[string]any]{}
+	}
+	if item.Details != nil {
+		op.Response = map[string]any{}
+	}
+	return op
+}
 
+func pathParamNames(path string) []string {
+	params := make([]string, 0)
+	for _, item := range strings.Split(path, "/") {
+		if strings.HasPrefix(item, ":") || strings.HasPrefix(item, "*") {
+			params = append(params, item[1:])
+		}
 	}
+	return params
+}
 
+// dbOf returns connection of the query, named connection from orm.Registry if Connection set.
+func (service *RawQuerySerice) dbOf(q RawQuery) (*gorm.DB, error) {
+	if q.Connection == "" {
+		return service.db, nil
+	}
+	db := orm.DB(q.Connection)
+	if db == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoConnection, q.Connection)
+	}
+	return db, nil
+}
+
+// checkConnections checks connections of the query & its children are configured.
+func checkConnections(q RawQuery) error {
+	if q.Connection != "" && orm.DB(q.Connection) == nil {
+		return fmt.Errorf("%w: %s", ErrNoConnection, q.Connection)
+	}
+	for _, child := range q.Children {
+		if err := checkConnections(child.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkItem checks connections of the item, details included.
+func checkItem(item SerivceItem) error {
+	if err := checkConnections(item.Query); err != nil {
+		return err
+	}
+	if item.Details != nil {
+		return checkConnections(*item.Details)
+	}
 	return nil
 }
 
-func readParams(c *gin.Context) map[string]interface{} {
+func hasBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+// readParams merges path params, query string & JSON object body, body wins.
+func readParams(c *gin.Context) (map[string]interface{}, error) {
 	allParams := map[string]interface{}{}
 
 	for _, v := range c.Params {
@@ -93,53 +341,199 @@ func readParams(c *gin.Context) map[string]interface{} {
 		}
 
 	}
-	return allParams
+	if hasBody(c.Request.Method) && c.Request.Body != nil {
+		body := map[string]any{}
+		decoder := json.NewDecoder(c.Request.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%w: JSON object body expected, %s", ErrInvalidParam, err.Error())
+		}
+		maps.Copy(allParams, body)
+	}
+	return allParams, nil
 }
 
-func (service *RawQuerySerice) handleDetails(header, details RawQuery) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		p := readParams(c)
-		result := map[string]any{}
-		r, err := header.Query(service.db, p)
+// run executes the query with timeout, then children for each row.
+func (service *RawQuerySerice) run(ctx context.Context, q RawQuery, params map[string]any) ([]map[string]any, error) {
+	if q.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.Timeout)
+		defer cancel()
+	}
+	db, err := service.dbOf(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(db.WithContext(ctx), params)
+	if err != nil {
+		return nil, err
+	}
+	return rows, service.children(ctx, q, params, rows)
+}
+
+func (service *RawQuerySerice) children(ctx context.Context, q RawQuery, params map[string]any, rows []map[string]any) error {
+	for _, child := range q.Children {
+		for _, row := range rows {
+			p := maps.Clone(params)
+			maps.Copy(p, row)
+			sub, err := service.run(ctx, child.Query, p)
+			if err != nil {
+				return fmt.Errorf("query %s failed, %w", child.Key, err)
+			}
+			if !child.Single {
+				row[child.Key] = sub
+				continue
+			}
+			row[child.Key] = nil
+			if len(sub) > 0 {
+				row[child.Key] = sub[0]
+			}
+		}
+	}
+	return nil
+}
+
+// execute runs the item: header & details, keyset page or rows, children included.
+func (service *RawQuerySerice) execute(ctx context.Context, item SerivceItem, params map[string]any) (any, error) {
+	q := item.Query
+	if item.Details != nil {
+		r, err := service.run(ctx, q, params)
 		if err != nil {
-			service.logger.Error("read header information failed.", zap.Error(err), zap.String("sql", header.Sql))
-			panic(err)
+			return nil, err
 		}
 		switch len(r) {
 		case 1:
-			result = r[0]
-			for k, v := range r[0] {
-				p[k] = v
-			}
 		case 0:
-			service.logger.Warn("read header failed, no records found", zap.String("sql", header.Sql))
-			c.JSON(404, "no records found")
-			return
+			return nil, ErrNotFound
 		default:
-			service.logger.Warn("read header failed, multi records found.", zap.String("sql", header.Sql), zap.Int("len", len(r)))
-			panic(fmt.Errorf("multi-recourds found"))
+			return nil, fmt.Errorf("%w: %d", ErrMultiRows, len(r))
 		}
-
+		result := r[0]
+		p := maps.Clone(params)
+		maps.Copy(p, result)
 		//read details
-		sub, err := details.Query(service.db, p)
+		sub, err := service.run(ctx, *item.Details, p)
 		if err != nil {
-			service.logger.Error("read detail records failed.", zap.Error(err), zap.String("sql", details.Sql))
-			panic(err)
+			return nil, err
 		}
 		result["details"] = sub
-		c.JSON(http.StatusOK, result)
+		return result, nil
+	}
+	if q.Keyset != "" {
+		if q.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, q.Timeout)
+			defer cancel()
+		}
+		db, err := service.dbOf(q)
+		if err != nil {
+			return nil, err
+		}
+		result, err := q.KeysetQuery(db.WithContext(ctx), params)
+		if err != nil {
+			return nil, err
+		}
+		return result, service.children(ctx, q, params, result.Data)
+	}
+	rows, err := service.run(ctx, q, params)
+	if err != nil || !q.ShouldPagingResult(params) {
+		return rows, err
 	}
+	if q.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.Timeout)
+		defer cancel()
+	}
+	db, err := service.dbOf(q)
+	if err != nil {
+		return nil, err
+	}
+	return q.PagingResult(db.WithContext(ctx), rows, params)
 }
 
-func (service *RawQuerySerice) handler(item RawQuery) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		allParams := readParams(c)
-		result, err := item.Query(service.db, allParams)
+// fail replies bad request for invalid params, 404 for header not found, 504 for timeout,
+// 500 for connection not configured, ErrorCode for others.
+func (service *RawQuerySerice) fail(c *gin.Context, item SerivceItem, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidParam), errors.Is(err, orm.ErrInvalidFilter), errors.Is(err, orm.ErrInvalidCursor):
+		ginshared.ReportBadrequest(c, err)
+	case errors.Is(err, ErrNotFound):
+		service.logger.Warn("read header failed, no records found", zap.String("uri", item.Uri))
+		c.JSON(http.StatusNotFound, "no records found")
+	case errors.Is(err, ErrNoConnection):
+		service.logger.Error("query connection is not configured", zap.String("uri", item.Uri), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ginshared.GeneralResp{ErrorCode: "NoConnection", ErrorMessage: "query connection is not configured"})
+	case errors.Is(err, context.DeadlineExceeded):
+		service.logger.Error("query timeout", zap.String("uri", item.Uri), zap.Error(err))
+		c.JSON(http.StatusGatewayTimeout, ginshared.GeneralResp{ErrorCode: "Timeout", ErrorMessage: "query timeout"})
+	default:
+		service.logger.Error("query failed.", zap.String("uri", item.Uri), zap.Error(err))
+		c.JSON(service.ErrorCode, ginshared.GeneralResp{ErrorCode: "QueryFailed", ErrorMessage: "query failed"})
+	}
+}
+
+// cacheKey of the request, route, params & owner hashed.
+func cacheKey(c *gin.Context, params map[string]any) string {
+	raw, _ := json.Marshal(params)
+	hash := sha1.New()
+	hash.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n" + c.GetString("owner") + "\n"))
+	hash.Write(raw)
+	return hex.EncodeToString(hash.Sum(nil))
+}
 
+func (service *RawQuerySerice) handler(item *SerivceItem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allParams, err := readParams(c)
 		if err != nil {
-			panic(err)
+			ginshared.ReportBadrequest(c, err)
+			return
+		}
+		if export.Requested(c) && item.Details == nil {
+			service.export(c, item.Query, allParams)
+			return
+		}
+		key := ""
+		if item.cache != nil {
+			key = cacheKey(c, allParams)
+			if result, ok := item.cache.Get(key); ok {
+				c.JSON(http.StatusOK, result)
+				return
+			}
+		}
+		result, err := service.execute(c, *item, allParams)
+		if err != nil {
+			service.fail(c, *item, err)
+			return
+		}
+		if item.cache != nil {
+			item.cache.Set(key, result)
 		}
-
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+// export streams the whole result by DB cursor, paging params ignored.
+func (service *RawQuerySerice) export(c *gin.Context, item RawQuery, allParams map[string]any) {
+	if _, ok := export.GetFormat(export.FormatOf(c)); !ok {
+		ginshared.ReportBadrequest(c, fmt.Errorf("%w: %s", export.ErrUnknownFormat, export.FormatOf(c)))
+		return
+	}
+	delete(allParams, KeyPage)
+	delete(allParams, KeyPageSize)
+	sql, params, err := item.Build(allParams)
+	if err != nil {
+		ginshared.ReportBadrequest(c, err)
+		return
+	}
+	db, err := service.dbOf(item)
+	if err != nil {
+		service.logger.Error("export query failed.", zap.Error(err), zap.String("sql", item.Sql))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ginshared.GeneralResp{ErrorCode: "NoConnection", ErrorMessage: err.Error()})
+		return
+	}
+	err = export.Raw(c, db, item.Export, sql, params...)
+	if err != nil {
+		// responded by export, 4xx/5xx or trailer X-Export-Error once streaming started.
+		service.logger.Error("export query result failed.", zap.Error(err), zap.String("sql", item.Sql))
+	}
+}
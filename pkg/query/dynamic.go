@@ -0,0 +1,284 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/messaging"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// EventQueryChanged published to core.Bus & broadcasted to other nodes (messaging.Broadcaster) when definitions changed, payload uri.
+const EventQueryChanged = "query.changed"
+
+// QueryDefinition stores query item, the latest enabled version of each uri is served in dynamic mode.
+type QueryDefinition struct {
+	gorm.Model
+	Uri        string `gorm:"size:255;uniqueIndex:idx_query_definition_version"`
+	Version    int    `gorm:"uniqueIndex:idx_query_definition_version"`
+	Enabled    bool
+	Definition string `gorm:"type:text"` // YAML or JSON, same as items of Queries config
+	Remark     string `gorm:"size:255"`
+	CreatedBy  string `gorm:"size:64"`
+}
+
+func init() {
+	orm.AppendEntity(&QueryDefinition{})
+}
+
+// ParseItem parses definition (YAML or JSON) as the item of uri.
+func ParseItem(uri, definition string) (SerivceItem, error) {
+	item := SerivceItem{}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(definition)); err != nil {
+		return item, fmt.Errorf("%w: %s", ErrInvalidParam, err.Error())
+	}
+	if err := v.Unmarshal(&item); err != nil {
+		return item, fmt.Errorf("%w: %s", ErrInvalidParam, err.Error())
+	}
+	item.Uri = strings.Trim(uri, "/")
+	if item.Uri == "" || item.Query.Sql == "" {
+		return item, fmt.Errorf("%w: uri & Query.Sql are required", ErrInvalidParam)
+	}
+	return item, nil
+}
+
+// LatestDefinitions returns the latest enabled version of each uri.
+func LatestDefinitions(db *gorm.DB) ([]QueryDefinition, error) {
+	rows := make([]QueryDefinition, 0)
+	if err := db.Where("enabled = ?", true).Order("uri, version desc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make([]QueryDefinition, 0, len(rows))
+	for _, item := range rows {
+		if len(result) == 0 || result[len(result)-1].Uri != item.Uri {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// route of dynamic mode, gin style uri, e.g. orders/:id
+type route struct {
+	segments []string
+	params   int
+	item     *SerivceItem
+	handlers []gin.HandlerFunc
+}
+
+func newRoute(item *SerivceItem, handlers []gin.HandlerFunc) *route {
+	r := &route{segments: strings.Split(strings.Trim(item.Uri, "/"), "/"), item: item, handlers: handlers}
+	for _, s := range r.segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			r.params++
+		}
+	}
+	return r
+}
+
+func (r *route) match(method, path string) (gin.Params, bool) {
+	if r.item.Method != method {
+		return nil, false
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	params := gin.Params{}
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "*") {
+			return append(params, gin.Param{Key: s[1:], Value: "/" + strings.Join(parts[i:], "/")}), true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(s, ":"):
+			params = append(params, gin.Param{Key: s[1:], Value: parts[i]})
+		case s != parts[i]:
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(r.segments)
+}
+
+// Reload rebuilds routes of dynamic mode from config items & definitions, definitions win on the same method & uri.
+func (service *RawQuerySerice) Reload() error {
+	service.reloading.Lock()
+	defer service.reloading.Unlock()
+	items := make([]SerivceItem, len(service.Items))
+	copy(items, service.Items)
+	defs, err := LatestDefinitions(service.db)
+	if err != nil {
+		return err
+	}
+	for _, def := range defs {
+		item, err := ParseItem(def.Uri, def.Definition)
+		if err != nil {
+			service.logger.Error("invalid query definition ignored", zap.String("uri", def.Uri), zap.Int("version", def.Version), zap.Error(err))
+			continue
+		}
+		if err := checkItem(item); err != nil {
+			service.logger.Error("query definition ignored", zap.String("uri", def.Uri), zap.Int("version", def.Version), zap.Error(err))
+			continue
+		}
+		service.prepare(&item)
+		replaced := false
+		for i := range items {
+			if items[i].Method == item.Method && strings.Trim(items[i].Uri, "/") == item.Uri {
+				items[i], replaced = item, true
+			}
+		}
+		if !replaced {
+			items = append(items, item)
+		}
+	}
+	for _, item := range items {
+		if err := checkItem(item); err != nil {
+			service.logger.Error("query responds 500 until the connection configured", zap.String("uri", item.Uri), zap.Error(err))
+		}
+	}
+	register(items)
+	routes := make([]*route, 0, len(items))
+	ops := make([]openapi.Operation, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		handlers := service.handlers(item)
+		routes = append(routes, newRoute(item, handlers))
+		op := service.operation(*item, handlers)
+		openapi.Add(op)
+		ops = append(ops, op)
+	}
+	// drop operations of items removed or disabled since the last reload.
+	for _, prev := range service.operations {
+		if !lo.ContainsBy(ops, func(op openapi.Operation) bool {
+			return strings.EqualFold(op.Method, prev.Method) && op.Path == prev.Path
+		}) {
+			openapi.Remove(prev.Method, prev.Path)
+		}
+	}
+	service.operations = ops
+	// static segments first, e.g. orders/summary before orders/:id
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].params < routes[j].params })
+	service.lock.Lock()
+	service.routes = routes
+	service.lock.Unlock()
+	service.logger.Info("query definitions loaded", zap.Int("items", len(routes)), zap.Int("definitions", len(defs)))
+	return nil
+}
+
+// dispatch serves the catch-all route by the current routes.
+func (service *RawQuerySerice) dispatch(c *gin.Context) {
+	service.lock.RLock()
+	routes := service.routes
+	service.lock.RUnlock()
+	for _, r := range routes {
+		params, ok := r.match(c.Request.Method, c.Param("path"))
+		if !ok {
+			continue
+		}
+		c.Params = params
+		for _, h := range r.handlers {
+			h(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+		return
+	}
+	c.JSON(http.StatusNotFound, "query not found")
+}
+
+// node tags broadcasts of this process, which reloaded by core.Bus already.
+var node = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}()
+
+// queryChanged is the broadcast payload of EventQueryChanged.
+type queryChanged struct {
+	Node string
+	Uri  string
+}
+
+// changed reloads this node by core.Bus, notifies other nodes by messaging if available.
+func (service *RawQuerySerice) changed(ctx context.Context, uri string) {
+	core.Bus.Publish(EventQueryChanged, uri)
+	if b := broadcaster(); b != nil {
+		if err := b.Broadcast(ctx, EventQueryChanged, queryChanged{Node: node, Uri: uri}); err != nil {
+			service.logger.Error("publish query changed failed.", zap.Error(err))
+		}
+	}
+}
+
+// broadcaster of messaging service, nil if not available.
+func broadcaster() messaging.Broadcaster {
+	var b messaging.Broadcaster
+	core.GetContainer().Invoke(func(p core.OptionalParam[messaging.MessagingService]) {
+		b, _ = p.P.(messaging.Broadcaster)
+	})
+	return b
+}
+
+// mount registers catch-all & admin routes, reloads on change events of this node & others.
+func (service *RawQuerySerice) mount(router *gin.Engine, group *gin.RouterGroup) {
+	if err := service.Reload(); err != nil {
+		service.logger.Error("load query definitions failed.", zap.Error(err))
+	}
+	group.Any("/*path", service.dispatch)
+	service.mountAdmin(router)
+
+	reload := func(uri string) {
+		if err := service.Reload(); err != nil {
+			service.logger.Error("reload query definitions failed.", zap.String("uri", uri), zap.Error(err))
+		}
+	}
+	core.OnEvent(EventQueryChanged, reload)
+	core.OnServiceStarted(func() {
+		b := broadcaster()
+		if b == nil {
+			return
+		}
+		// pub/sub fan-out, every node reloads, missed changes picked up by ReloadInterval.
+		err := b.Listen(context.Background(), EventQueryChanged, func(ctx context.Context, payload []byte) {
+			changed := queryChanged{}
+			json.Unmarshal(payload, &changed)
+			if changed.Node == node {
+				return
+			}
+			reload(changed.Uri)
+		})
+		if err != nil {
+			service.logger.Error("subscribe query changes failed.", zap.Error(err))
+		}
+	})
+	if service.ReloadInterval > 0 {
+		ticker := time.NewTicker(service.ReloadInterval)
+		stop := make(chan struct{})
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					reload("")
+				case <-stop:
+					return
+				}
+			}
+		}()
+		core.OnServiceStopping(func() {
+			close(stop)
+		})
+	}
+}
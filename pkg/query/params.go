@@ -0,0 +1,222 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+var ErrInvalidParam = errors.New("invalid param")
+
+// param types of ParamDef
+const (
+	TypeString  = "string"
+	TypeInt     = "int"
+	TypeFloat   = "float"
+	TypeBool    = "bool"
+	TypeTime    = "time" // RFC3339, 2006-01-02 15:04:05 or 2006-01-02
+	TypeDate    = "date" // 2006-01-02
+	TypeStrings = "strings"
+	TypeInts    = "ints"
+)
+
+// ParamDef declares type, default & validation of a request param.
+type ParamDef struct {
+	Name     string
+	Type     string // string (default), int, float, bool, time, date, strings, ints
+	Default  any
+	Required bool
+	Enum     []string
+	Min      *float64 // value of numbers, length of strings
+	Max      *float64
+	Pattern  string // regexp for strings
+}
+
+func (d ParamDef) invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s, %s", ErrInvalidParam, d.Name, fmt.Sprintf(format, args...))
+}
+
+func scalar(v any) string {
+	switch value := v.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case []string:
+		if len(value) > 0 {
+			return strings.TrimSpace(value[0])
+		}
+		return ""
+	case []any:
+		if len(value) > 0 {
+			return fmt.Sprint(value[0])
+		}
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func list(v any) []string {
+	switch value := v.(type) {
+	case string:
+		return lo.Map(strings.Split(value, ","), func(item string, _ int) string { return strings.TrimSpace(item) })
+	case []string:
+		if len(value) == 1 {
+			return list(value[0])
+		}
+		return value
+	case []any:
+		return lo.Map(value, func(item any, _ int) string { return fmt.Sprint(item) })
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// convert returns value of the declared type, query string, JSON body values or row values of parent query.
+func (d ParamDef) convert(v any) (any, error) {
+	switch d.Type {
+	case TypeInt:
+		switch value := v.(type) {
+		case int, int32, int64, uint, uint32, uint64:
+			return value, nil
+		case float64:
+			return int64(value), nil
+		}
+		i, err := strconv.ParseInt(scalar(v), 10, 64)
+		if err != nil {
+			return nil, d.invalid("int expected")
+		}
+		return i, nil
+	case TypeFloat:
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		f, err := strconv.ParseFloat(scalar(v), 64)
+		if err != nil {
+			return nil, d.invalid("number expected")
+		}
+		return f, nil
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		b, err := strconv.ParseBool(scalar(v))
+		if err != nil {
+			return nil, d.invalid("bool expected")
+		}
+		return b, nil
+	case TypeTime, TypeDate:
+		if t, ok := v.(time.Time); ok {
+			return t, nil
+		}
+		layouts := []string{time.RFC3339Nano, time.DateTime, time.DateOnly}
+		if d.Type == TypeDate {
+			layouts = []string{time.DateOnly}
+		}
+		for _, layout := range layouts {
+			if t, err := time.ParseInLocation(layout, scalar(v), time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return nil, d.invalid("%s expected", d.Type)
+	case TypeStrings:
+		return list(v), nil
+	case TypeInts:
+		result := make([]int64, 0)
+		for _, item := range list(v) {
+			i, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return nil, d.invalid("ints expected")
+			}
+			result = append(result, i)
+		}
+		return result, nil
+	case "", TypeString:
+		if n, ok := v.(json.Number); ok {
+			return n.String(), nil
+		}
+		if b, ok := v.([]byte); ok {
+			return string(b), nil
+		}
+		if _, ok := v.(string); ok {
+			return scalar(v), nil
+		}
+		if _, ok := v.([]string); ok {
+			return scalar(v), nil
+		}
+		return v, nil
+	}
+	return nil, d.invalid("unknown type %s", d.Type)
+}
+
+func number(v any) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	case string:
+		return float64(len([]rune(value))), true
+	}
+	return 0, false
+}
+
+func (d ParamDef) validate(v any) error {
+	values := []any{v}
+	switch items := v.(type) {
+	case []string:
+		values = lo.ToAnySlice(items)
+	case []int64:
+		values = lo.ToAnySlice(items)
+	}
+	for _, item := range values {
+		if len(d.Enum) > 0 && !lo.Contains(d.Enum, fmt.Sprint(item)) {
+			return d.invalid("one of %s expected", strings.Join(d.Enum, ","))
+		}
+		if n, ok := number(item); ok {
+			if d.Min != nil && n < *d.Min {
+				return d.invalid("min %v", *d.Min)
+			}
+			if d.Max != nil && n > *d.Max {
+				return d.invalid("max %v", *d.Max)
+			}
+		}
+		if s, ok := item.(string); ok && d.Pattern != "" {
+			matched, err := regexp.MatchString(d.Pattern, s)
+			if err != nil || !matched {
+				return d.invalid("pattern %s expected", d.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// bind converts & validates declared params in place, defaults applied for missing ones.
+func (r *RawQuery) bind(params map[string]any) error {
+	for _, d := range r.Args {
+		v, ok := params[d.Name]
+		if !ok || scalar(v) == "" {
+			if d.Required {
+				return d.invalid("required")
+			}
+			if d.Default == nil {
+				continue
+			}
+			v = d.Default
+		}
+		value, err := d.convert(v)
+		if err != nil {
+			return err
+		}
+		if err := d.validate(value); err != nil {
+			return err
+		}
+		params[d.Name] = value
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/openapi"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRawQueryService(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	for _, stmt := range []string{
+		"create table orders (id integer primary key, code text, status text)",
+		"create table lines (id integer primary key, order_id int, sku text)",
+		"create table notes (id integer primary key, line_id int, note text)",
+		"insert into orders values (1, 'A', 'new'), (2, 'B', 'new'), (3, 'C', 'done')",
+		"insert into lines values (1, 1, 'x'), (2, 1, 'y'), (3, 2, 'z')",
+		"insert into notes values (1, 1, 'fragile')",
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+
+	max := float64(2)
+	service := &RawQuerySerice{db: db, logger: zap.NewNop(), ErrorCode: http.StatusInternalServerError}
+	item := &SerivceItem{
+		Uri:    "orders",
+		Method: http.MethodPost,
+		Query: RawQuery{
+			Sql:     "select id, code from orders",
+			Where:   map[string]string{"status": "status = ?", "ids": "id in ?"},
+			Args:    []ParamDef{{Name: "status", Enum: []string{"new", "done"}, Default: "new"}, {Name: "ids", Type: TypeInts}},
+			MaxRows: 10,
+			Children: []ChildQuery{{
+				Key: "lines",
+				Query: RawQuery{
+					Sql:    "select id as line_id, sku from lines where order_id = ? order by id",
+					Params: []string{"id"},
+					Args:   []ParamDef{{Name: "id", Type: TypeInt, Required: true, Max: &max}},
+					Children: []ChildQuery{{
+						Key:    "note",
+						Single: true,
+						Query:  RawQuery{Sql: "select note from notes where line_id = ?", Params: []string{"line_id"}},
+					}},
+				},
+			}},
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(item.Method, "/orders", service.handler(item))
+	call := func(query, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders"+query, strings.NewReader(body)))
+		return w
+	}
+
+	w := call("", `{"ids":[1,2]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	result := []map[string]any{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Len(t, result, 2, "status defaults to new")
+	lines := result[0]["lines"].([]any)
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "fragile", lines[0].(map[string]any)["note"].(map[string]any)["note"])
+	assert.Nil(t, lines[1].(map[string]any)["note"])
+
+	assert.Equal(t, http.StatusBadRequest, call("?status=lost", "").Code, "not in enum")
+	assert.Equal(t, http.StatusBadRequest, call("", `{"ids":"a,b"}`).Code, "ints expected")
+	assert.Equal(t, http.StatusBadRequest, call("", `[1]`).Code, "object body expected")
+	w = call("?status=done", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "id of child over max")
+	assert.Contains(t, w.Body.String(), "lines")
+
+	assert.Equal(t, 0, toInt(map[string]any{KeyPage: []int{1}}, KeyPage), "no panic on unknown types")
+	assert.Equal(t, 3, toInt(map[string]any{KeyPage: json.Number("3")}, KeyPage))
+}
+
+func TestDynamicQueries(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&QueryDefinition{}))
+	assert.NoError(t, db.Exec("create table orders (id integer primary key, code text)").Error)
+	assert.NoError(t, db.Exec("insert into orders values (1, 'A'), (2, 'B')").Error)
+	assert.NoError(t, db.Create(&[]QueryDefinition{
+		{Uri: "orders/:id", Version: 1, Enabled: true, Definition: "query:\n  sql: select code from orders where id = ?\n  params: [id]\n"},
+		{Uri: "orders/:id", Version: 2, Enabled: false, Definition: "query:\n  sql: select 'disabled' as code\n"},
+		{Uri: "orders/count", Version: 1, Enabled: true, Definition: "query:\n  sql: select count(*) as total from orders\n"},
+		{Uri: "broken", Version: 1, Enabled: true, Definition: "query: ["},
+		{Uri: "remote", Version: 1, Enabled: true, Definition: "query:\n  sql: select 1\n  connection: missing\n"},
+	}).Error)
+
+	service := &RawQuerySerice{db: db, logger: zap.NewNop(), Dynamic: true, ErrorCode: http.StatusInternalServerError}
+	assert.NoError(t, service.Reload())
+	assert.Len(t, service.routes, 2, "latest enabled versions only, broken & missing connection ignored")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Any("/q/*path", service.dispatch)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	w := get("/q/orders/2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"B"`)
+	w = get("/q/orders/count")
+	assert.Equal(t, http.StatusOK, w.Code, "static segment wins")
+	assert.Contains(t, w.Body.String(), `"total":2`)
+	assert.Equal(t, http.StatusNotFound, get("/q/customers").Code)
+
+	service.Items = []SerivceItem{{Uri: "legacy", Method: http.MethodGet, Query: RawQuery{Sql: "select 1", Connection: "missing"}}}
+	assert.NoError(t, service.Reload())
+	assert.Equal(t, http.StatusInternalServerError, get("/q/legacy").Code, "no panic")
+	service.Items = nil
+
+	assert.NoError(t, db.Model(&QueryDefinition{}).Where("uri = ? and version = 2", "orders/:id").Update("enabled", true).Error)
+	assert.NoError(t, service.Reload())
+	assert.Contains(t, get("/q/orders/2").Body.String(), "disabled")
+	assert.False(t, lo.ContainsBy(openapi.Operations(), func(op openapi.Operation) bool { return op.Path == "/legacy" }), "removed item undocumented")
+	assert.True(t, lo.ContainsBy(openapi.Operations(), func(op openapi.Operation) bool { return op.Path == "/orders/count" }))
+
+	result, err := service.Preview(context.Background(), &PreviewReq{Definition: "query:\n  sql: select id from orders\n", Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, result.Data, 1)
+	assert.NotEmpty(t, result.Plan)
+	_, err = service.Preview(context.Background(), &PreviewReq{Definition: "query:\n  sql: select nope from orders\n"})
+	assert.Error(t, err)
+	_, err = service.Preview(context.Background(), &PreviewReq{Uri: "orders/count", Definition: "query:\n  sql: select 0 as total\n"})
+	assert.NoError(t, err)
+	for _, stmt := range []string{"drop table orders", "select 1; delete from orders", "-- note\n  update orders set code = 'X'"} {
+		_, err = service.Preview(context.Background(), &PreviewReq{Definition: "query:\n  sql: \"" + strings.ReplaceAll(stmt, "\n", "\\n") + "\"\n"})
+		assert.ErrorIs(t, err, ErrPreviewReadOnly, stmt)
+	}
+	assert.True(t, readOnly("/* c */ (select 1)"))
+	assert.True(t, readOnly("with t as (select 1) select * from t;"))
+	count := int64(0)
+	db.Table("orders").Count(&count)
+	assert.Equal(t, int64(2), count)
+	served, ok := LookupQuery("orders/count")
+	assert.True(t, ok)
+	assert.Contains(t, served.Sql, "from orders", "preview doesn't replace the served SumRef")
+}
+
+func TestSummary(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Exec("create table orders (id integer primary key, status text, amount int)").Error)
+	assert.NoError(t, db.Exec("insert into orders values (1, 'new', 10), (2, 'new', 20), (3, 'done', 30)").Error)
+
+	q := &RawQuery{
+		Sql:     "WITH o AS (SELECT id, status, amount FROM orders) SELECT id, amount, (select count(1) from orders) AS n FROM o",
+		Where:   map[string]string{"status": "status = ?"},
+		Columns: []string{"id", "amount"},
+		Totals:  []Aggregate{{Column: "amount", Func: "sum"}, {Column: "amount", Func: "max", As: "top"}},
+	}
+	data := map[string]any{"status": "new", KeyPageSize: "1"}
+	rows, err := q.Query(db, data)
+	assert.NoError(t, err)
+	result, err := q.PagingResult(db, rows, data)
+	assert.NoError(t, err)
+	assert.Len(t, result.Data, 1)
+	assert.Equal(t, int64(2), result.Total)
+	assert.Equal(t, int64(2), result.TotalPage)
+	assert.EqualValues(t, 30, result.Totals["amount_sum"])
+	assert.EqualValues(t, 20, result.Totals["top"])
+
+	summary, err := q.summary(db, map[string]any{"status": "done"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Total)
+
+	_, err = q.summary(db, map[string]any{KeyFilter: "amount:gt:15"})
+	assert.NoError(t, err)
+	q.Totals = []Aggregate{{Column: "amount", Func: "median"}}
+	_, err = q.summary(db, data)
+	assert.Error(t, err)
+
+	assert.True(t, q.unfiltered(nil, map[string]any{}))
+	assert.False(t, q.unfiltered([]any{"new"}, data), "EstimateTable skipped for filtered queries")
+	assert.False(t, q.unfiltered(nil, map[string]any{"groupby": "status"}))
+
+	RegisterQuery("orders/totals", RawQuery{Sql: "select count(1) as total, sum(amount) as amount from orders", Where: map[string]string{"status": "status = ?"}})
+	ref := &RawQuery{Sql: "select id from orders", SumRef: "/orders/totals"}
+	summary, err = ref.summary(db, map[string]any{"status": "new"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), summary.Total)
+	assert.EqualValues(t, 30, summary.Totals["amount"])
+}
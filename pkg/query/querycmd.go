@@ -0,0 +1,71 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var queryOptions = struct {
+	params map[string]string
+	body   string
+}{}
+
+// QueryCmd runs query items of Queries config from CLI, e.g. query test orders/:id -p id=1
+var QueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "configured raw queries",
+}
+
+var queryTestCmd = &cobra.Command{
+	Use:   "test <uri>",
+	Short: "run the query item & print the result as JSON, auth & cache skipped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return core.GetContainer().Invoke(func(db *gorm.DB, logger *zap.Logger) error {
+			service := loadRawQuery(logger, db)
+			if service == nil {
+				return fmt.Errorf("no queries in config files")
+			}
+			register(service.Items)
+			uri := strings.Trim(args[0], "/")
+			for _, item := range service.Items {
+				if strings.Trim(item.Uri, "/") != uri {
+					continue
+				}
+				params := map[string]any{}
+				if queryOptions.body != "" {
+					decoder := json.NewDecoder(strings.NewReader(queryOptions.body))
+					decoder.UseNumber()
+					if err := decoder.Decode(&params); err != nil {
+						return err
+					}
+				}
+				for k, v := range queryOptions.params {
+					params[k] = v
+				}
+				result, err := service.execute(context.Background(), item, params)
+				if err != nil {
+					return err
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(result)
+			}
+			return fmt.Errorf("query %s not found", args[0])
+		})
+	},
+}
+
+func init() {
+	queryTestCmd.Flags().StringToStringVarP(&queryOptions.params, "param", "p", map[string]string{}, "params, e.g. -p status=new -p page_size=10")
+	queryTestCmd.Flags().StringVarP(&queryOptions.body, "body", "b", "", "JSON object params, e.g. '{\"ids\":[1,2]}'")
+	QueryCmd.AddCommand(queryTestCmd)
+}
@@ -1,12 +1,18 @@
 package query
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"maps"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/samber/lo"
+	"github.com/techquest-tech/gin-shared/pkg/export"
+	"github.com/techquest-tech/gin-shared/pkg/orm"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -15,15 +21,27 @@ const (
 	KeyWhere    = "{{.where}}"
 	KeyPageSize = "page_size"
 	KeyPage     = "page"
+	KeyCursor   = "cursor"
+	KeyEstimate = "estimate"
+	KeyFilter   = "filter"
+	KeySort     = "sort"
+	KeyFields   = "fields"
+	KeySearch   = "q"
 )
 
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
 type PagingResult[T any] struct {
 	Page      int
 	PageSize  int
 	TotalPage int64
 	Total     int64
-	Error     error `json:",omitempty"`
-	Data      []T   `json:",omitempty"`
+	Error     error          `json:",omitempty"`
+	Data      []T            `json:",omitempty"`
+	Next      string         `json:",omitempty"`
+	Prev      string         `json:",omitempty"`
+	Estimated bool           `json:",omitempty"`
+	Totals    map[string]any `json:",omitempty"`
 }
 
 var (
@@ -40,13 +58,47 @@ type RawQueryWhere struct {
 type RawQuery struct {
 	Connection string // which connection should be used for query.
 	Sql        string
-	SumRef     string // reference to another RawQuery define, not used yet.
-	SumEnabled bool
+	SumRef     string // registered query of totals, run with the same params, see RegisterQuery
+	SumEnabled bool   // PagingResult with Total
 	Preset     map[string]any
 	Params     []string
 	Where      map[string]string // key: where condition, value: param key, e.g. "id = ?", "id"
 	Orderby    string
 	Groupby    string
+	Export     *export.Settings // columns & file name when request with export param
+	// Keyset enables keyset paging, result columns e.g. "created_at desc, id", the last one must be unique.
+	Keyset        string
+	EstimateTable string // table for estimated totals of keyset paging, count the query if not supported
+	// Columns allowed by filter, sort & fields params, and by orderby & groupby params if set.
+	Columns []string
+	Search  []string // columns searched by q param, case insensitive contains
+
+	Args     []ParamDef    // typed params, converted & validated before query
+	Timeout  time.Duration // SQL timeout, e.g. 30s
+	MaxRows  int           // caps limit & page size, exports included
+	Children []ChildQuery  // nested queries run for each result row
+
+	Totals      []Aggregate   // aggregates of the whole result returned with PagingResult
+	TotalsCache time.Duration // caches totals per params, e.g. 1m
+}
+
+// ChildQuery runs for each row of the parent, params of the parent request & the row,
+// the result set to the row as Key, the first row only if Single.
+type ChildQuery struct {
+	Key    string
+	Single bool
+	Query  RawQuery
+}
+
+// merged returns Preset & data merged, declared params converted & validated.
+func (r *RawQuery) merged(data map[string]any) (map[string]any, error) {
+	allParams := map[string]any{}
+	maps.Copy(allParams, r.Preset)
+	maps.Copy(allParams, data)
+	if err := r.bind(allParams); err != nil {
+		return nil, err
+	}
+	return allParams, nil
 }
 
 func (r *RawQuery) Query(db *gorm.DB, data map[string]any) ([]map[string]any, error) {
@@ -57,7 +109,7 @@ func (r *RawQuery) Query(db *gorm.DB, data map[string]any) ([]map[string]any, er
 func (r *RawQuery) ShouldPagingResult(req map[string]any) bool {
 	pageSize := toInt(req, KeyPageSize)
 	_, ok := req["export"]
-	return (r.SumRef != "" || r.SumEnabled) && pageSize != -1 && !ok
+	return r.summarized() && pageSize != -1 && !ok
 }
 
 func (r *RawQuery) PagingResult(db *gorm.DB, result []map[string]any, req map[string]any) (*PagingResult[map[string]any], error) {
@@ -72,7 +124,8 @@ func (r *RawQuery) PagingResult(db *gorm.DB, result []map[string]any, req map[st
 		PageSize: pageSize,
 		Data:     result,
 	}
-	if page == 0 {
+	// the first & only page counted by rows, unless aggregates required.
+	if page == 0 && r.SumRef == "" && len(r.Totals) == 0 {
 		if len(result) == 0 {
 			// resp.TotalPage = 0
 			return resp, nil
@@ -85,14 +138,13 @@ func (r *RawQuery) PagingResult(db *gorm.DB, result []map[string]any, req map[st
 		}
 	}
 
-	total, err := r.sum(db, req)
+	summary, err := r.summary(db, req)
 	if err != nil {
 		return nil, err
 	}
-	pageTotal := (total + int64(pageSize-1)) / int64(pageSize)
-
-	resp.TotalPage = pageTotal
-	resp.Total = total
+	resp.TotalPage = (summary.Total + int64(pageSize-1)) / int64(pageSize)
+	resp.Total = summary.Total
+	resp.Totals = summary.Totals
 	return resp, nil
 }
 
@@ -135,57 +187,59 @@ func (r *RawQuery) where(allParams map[string]any, params []any, sql string) (st
 	return sql, params, nil
 }
 
-func (r *RawQuery) sum(db *gorm.DB, data map[string]any) (int64, error) {
-	allParams := map[string]any{}
-	maps.Copy(allParams, r.Preset)
-	maps.Copy(allParams, data)
+// base renders sql & params with where conditions & groupby applied.
+func (r *RawQuery) base(data map[string]any) (string, []any, error) {
+	allParams, err := r.merged(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql := r.Sql
 
 	params := make([]any, 0)
 	for _, key := range r.Params {
 		params = append(params, allParams[key])
 	}
 
-	end := strings.Index(r.Sql, "from")
-	sql := "select count(1) as sum " + r.Sql[end:]
 	if len(r.Where) > 0 {
-		var err error
 		sql, params, err = r.where(allParams, params, sql)
 		if err != nil {
-			return 0, err
+			return "", nil, err
 		}
 	}
 
-	count := int64(0)
-	zap.L().Debug("run summary sql", zap.String("sql", sql), zap.Any("params", params))
-	tx := db.Raw(sql, params...)
+	groupby := r.Groupby
+	if g, ok := param(data, "groupby"); ok {
+		columns := make([]string, 0)
+		for _, item := range strings.Split(g, ",") {
+			item = strings.TrimSpace(item)
+			if err := r.allowed(item); err != nil {
+				return "", nil, err
+			}
+			columns = append(columns, item)
+		}
+		groupby = strings.Join(columns, ", ")
+	}
 
-	err := tx.Find(&count).Error
-	if err != nil {
-		return 0, err
+	if groupby != "" {
+		sql = sql + " group by " + groupby
 	}
-	zap.L().Debug("summary sql result", zap.Int64("count", count))
-	return count, nil
+	return sql, params, nil
 }
 
-func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
-	allParams := map[string]any{}
-
-	sql := r.Sql
-
-	maps.Copy(allParams, r.Preset)
-	maps.Copy(allParams, data)
-
-	params := make([]any, 0)
-	for _, key := range r.Params {
-		params = append(params, allParams[key])
+// Build renders the final sql & params for request data, paging/orderby/groupby applied.
+func (r *RawQuery) Build(data map[string]any) (string, []any, error) {
+	sql, params, err := r.base(data)
+	if err != nil {
+		return "", nil, err
 	}
-
-	if len(r.Where) > 0 {
-		var err error
-		sql, params, err = r.where(allParams, params, sql)
-		if err != nil {
-			return nil, err
-		}
+	sql, params, sort, err := r.list(sql, params, data)
+	if err != nil {
+		return "", nil, err
+	}
+	allParams, err := r.merged(data)
+	if err != nil {
+		return "", nil, err
 	}
 
 	page := toInt(data, KeyPage)
@@ -195,18 +249,14 @@ func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
 		pageSize = PageSize
 	}
 
-	groupby := r.Groupby
-	if g, ok := data["groupby"]; ok {
-		groupby = g.(string)
-	}
-
-	if groupby != "" {
-		sql = sql + " group by " + groupby
-	}
-
 	orderby := r.Orderby
-	if o, ok := data["orderby"]; ok {
-		orderby = o.(string)
+	if o, ok := param(data, "orderby"); ok {
+		if orderby, err = r.orderBy(o); err != nil {
+			return "", nil, err
+		}
+	}
+	if sort != "" {
+		orderby = sort
 	}
 	if orderby != "" {
 		sql = fmt.Sprintf("%s order by %s", sql, orderby)
@@ -218,6 +268,10 @@ func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
 		limit = pageSize
 	}
 
+	if r.MaxRows > 0 && (limit <= 0 || limit > r.MaxRows) {
+		limit = r.MaxRows
+	}
+
 	if limit > 0 {
 		sql = fmt.Sprintf("%s limit %d", sql, limit)
 	}
@@ -229,6 +283,170 @@ func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
 	if offset > 0 {
 		sql = fmt.Sprintf("%s offset %d", sql, offset)
 	}
+	return sql, params, nil
+}
+
+// KeysetQuery returns the page after (or before) cursor param, ordered by Keyset columns.
+// the query wrapped as sub query, so Keyset columns are names of result columns.
+func (r *RawQuery) KeysetQuery(db *gorm.DB, data map[string]any) (*PagingResult[map[string]any], error) {
+	keys, err := orm.ParseSort(r.Keyset)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := toInt(data, KeyPageSize)
+	if pageSize <= 0 {
+		pageSize = PageSize
+	}
+	if r.MaxRows > 0 && pageSize > r.MaxRows {
+		pageSize = r.MaxRows
+	}
+	sql, params, err := r.base(data)
+	if err != nil {
+		return nil, err
+	}
+	sql, params, _, err = r.list(sql, params, data)
+	if err != nil {
+		return nil, err
+	}
+	resp := &PagingResult[map[string]any]{PageSize: pageSize}
+	if _, ok := data[KeyEstimate]; ok {
+		estimated := false
+		if r.EstimateTable != "" && r.unfiltered(params, data) {
+			resp.Total, estimated, err = orm.EstimateCount(db, r.EstimateTable)
+		}
+		if err == nil && !estimated {
+			var summary *Summary
+			if summary, err = r.summary(db, data); err == nil {
+				resp.Total, resp.Totals = summary.Total, summary.Totals
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Estimated = estimated
+		resp.TotalPage = (resp.Total + int64(pageSize-1)) / int64(pageSize)
+	}
+
+	// columns from config, not request.
+	column := func(name string) string { return name }
+	cursor, _ := data[KeyCursor].(string)
+	backward := false
+	sql = "select * from (" + sql + ") t"
+	if cursor != "" {
+		var values []any
+		values, backward, err = orm.DecodeCursor(keys, cursor)
+		if err != nil {
+			return nil, err
+		}
+		cond, p := orm.KeysetCond(keys, values, backward, column)
+		sql = sql + " where " + cond
+		params = append(params, p...)
+	}
+	sql = fmt.Sprintf("%s order by %s limit %d", sql, orm.KeysetOrder(keys, backward, column), pageSize+1)
+
+	rows := make([]map[string]any, 0)
+	zap.L().Debug("run keyset sql", zap.String("sql", sql), zap.Any("params", params))
+	if err := db.Raw(sql, params...).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	resp.Data, resp.Next, resp.Prev, err = orm.KeysetPage(rows, keys, pageSize, backward, cursor != "", func(row map[string]any) []any {
+		values := make([]any, len(keys))
+		for i, key := range keys {
+			values[i] = row[key.Column]
+		}
+		return values
+	})
+	return resp, err
+}
+
+// allowed validates column from request params, identifier only & allow-listed by Columns if set.
+func (r *RawQuery) allowed(column string) error {
+	if !identifier.MatchString(column) {
+		return fmt.Errorf("%w: invalid column %s", orm.ErrInvalidFilter, column)
+	}
+	if len(r.Columns) > 0 && !lo.Contains(r.Columns, column) {
+		return fmt.Errorf("%w: column %s is not allowed", orm.ErrInvalidFilter, column)
+	}
+	return nil
+}
+
+// orderBy validates orderby param, e.g. "created_at desc, id" or "-created_at,id"
+func (r *RawQuery) orderBy(value string) (string, error) {
+	keys, err := orm.ParseSort(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", orm.ErrInvalidFilter, err.Error())
+	}
+	for _, item := range keys {
+		if err := r.allowed(item.Column); err != nil {
+			return "", err
+		}
+	}
+	return orm.KeysetOrder(keys, false, func(column string) string { return column }), nil
+}
+
+// list applies filter, fields & q params on the query wrapped as sub query, returns order by of sort param.
+// the params are ignored if Columns not configured.
+func (r *RawQuery) list(sql string, params []any, data map[string]any) (string, []any, string, error) {
+	if len(r.Columns) == 0 {
+		return sql, params, "", nil
+	}
+	allowed := orm.AllowColumns(r.Columns...)
+	allowed.Search = r.Search
+	filter, _ := param(data, KeyFilter)
+	sort, _ := param(data, KeySort)
+	fields, _ := param(data, KeyFields)
+	q, err := allowed.Parse(filter, sort, fields)
+	if err != nil {
+		return "", nil, "", err
+	}
+	q.Search, _ = param(data, KeySearch)
+
+	column := func(name string) string { return name }
+	orderby := ""
+	if len(q.Sort) > 0 {
+		orderby = orm.KeysetOrder(q.Sort, false, column)
+	}
+	cond, args := q.Where("", column)
+	if cond == "" && len(q.Fields) == 0 {
+		return sql, params, orderby, nil
+	}
+	selected := "*"
+	if len(q.Fields) > 0 {
+		selected = strings.Join(q.Fields, ", ")
+	}
+	sql = fmt.Sprintf("select %s from (%s) t", selected, sql)
+	if cond != "" {
+		sql = sql + " where " + cond
+		params = append(params, args...)
+	}
+	return sql, params, orderby, nil
+}
+
+// unfiltered returns true if no params bound (Params, Where, tenant, owner, filter & search) and no group by,
+// only then EstimateTable counts the result.
+func (r *RawQuery) unfiltered(params []any, data map[string]any) bool {
+	_, grouped := param(data, "groupby")
+	return len(params) == 0 && r.Groupby == "" && !grouped
+}
+
+// param returns string value of request param, the first one if multiple.
+func param(data map[string]any, key string) (string, bool) {
+	switch v := data[key].(type) {
+	case string:
+		return v, true
+	case []string:
+		if len(v) > 0 {
+			return v[0], true
+		}
+	}
+	return "", false
+}
+
+func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
+	sql, params, err := r.Build(data)
+	if err != nil {
+		return nil, err
+	}
 
 	result := make([]T, 0)
 
@@ -236,19 +454,25 @@ func Query[T any](db *gorm.DB, r *RawQuery, data map[string]any) ([]T, error) {
 
 	zap.L().Debug("run sql", zap.String("sql", sql), zap.Any("params", params))
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 
 	return result, err
 }
 
 func toInt(data map[string]any, key string) int {
-	if v, ok := data[key]; ok {
-		if i, ok := v.(int); ok {
-			return i
-		}
-		if i, err := strconv.Atoi(v.(string)); err == nil {
-			return i
-		}
+	switch v := data[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	case string, []string:
+		i, _ := strconv.Atoi(scalar(v))
+		return i
 	}
 	return 0
 }
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// KeyTotal is the count column of totals query, SumRef queries should return it too.
+const KeyTotal = "total"
+
+var aggregates = []string{"sum", "avg", "min", "max", "count"}
+
+// Aggregate column of totals, e.g. {Column: amount, Func: sum}, named As or amount_sum.
+type Aggregate struct {
+	Column string
+	Func   string // sum, avg, min, max or count
+	As     string
+}
+
+func (a Aggregate) name() string {
+	return lo.CoalesceOrEmpty(a.As, a.Column+"_"+strings.ToLower(a.Func))
+}
+
+// Summary of the query for the request params, count & aggregates.
+type Summary struct {
+	Total  int64
+	Totals map[string]any
+}
+
+var namedQueries sync.Map
+
+// RegisterQuery makes the query available as SumRef by name, items of Queries registered by Uri.
+func RegisterQuery(name string, q RawQuery) {
+	namedQueries.Store(strings.Trim(name, "/"), q)
+}
+
+// LookupQuery returns the registered query of name.
+func LookupQuery(name string) (RawQuery, bool) {
+	if v, ok := namedQueries.Load(strings.Trim(name, "/")); ok {
+		return v.(RawQuery), true
+	}
+	return RawQuery{}, false
+}
+
+var summaryCaches sync.Map // TotalsCache -> *cache.Cache[Summary]
+
+func summaryCache(ttl time.Duration) *cache.Cache[Summary] {
+	if v, ok := summaryCaches.Load(ttl); ok {
+		return v.(*cache.Cache[Summary])
+	}
+	v, _ := summaryCaches.LoadOrStore(ttl, cache.NewWithTimeout[Summary](ttl))
+	return v.(*cache.Cache[Summary])
+}
+
+// summarized tells the query returns PagingResult with totals.
+func (r *RawQuery) summarized() bool {
+	return r.SumRef != "" || r.SumEnabled || len(r.Totals) > 0
+}
+
+// summarySQL renders totals sql: the SumRef query if set, or the query wrapped as sub query
+// with count & Totals aggregates, filter params applied.
+func (r *RawQuery) summarySQL(data map[string]any) (string, []any, error) {
+	if r.SumRef != "" {
+		ref, ok := LookupQuery(r.SumRef)
+		if !ok {
+			return "", nil, fmt.Errorf("SumRef query %s is not registered", r.SumRef)
+		}
+		return ref.base(data)
+	}
+	sql, params, err := r.base(data)
+	if err != nil {
+		return "", nil, err
+	}
+	sql, params, _, err = r.list(sql, params, data)
+	if err != nil {
+		return "", nil, err
+	}
+	columns := []string{"count(1) as " + KeyTotal}
+	for _, a := range r.Totals {
+		fn := strings.ToLower(a.Func)
+		if !lo.Contains(aggregates, fn) {
+			return "", nil, fmt.Errorf("unsupported aggregate %s, one of %s", a.Func, strings.Join(aggregates, ","))
+		}
+		if err := r.allowed(a.Column); err != nil {
+			return "", nil, err
+		}
+		if !identifier.MatchString(a.name()) {
+			return "", nil, fmt.Errorf("invalid aggregate name %s", a.name())
+		}
+		columns = append(columns, fmt.Sprintf("%s(%s) as %s", fn, a.Column, a.name()))
+	}
+	return fmt.Sprintf("select %s from (%s) c", strings.Join(columns, ", "), sql), params, nil
+}
+
+// summary runs totals sql, cached by sql & params for TotalsCache if set.
+func (r *RawQuery) summary(db *gorm.DB, data map[string]any) (*Summary, error) {
+	sql, params, err := r.summarySQL(data)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	if r.TotalsCache > 0 {
+		raw, _ := json.Marshal(params)
+		hash := sha1.Sum(append([]byte(sql+"\n"), raw...))
+		key = hex.EncodeToString(hash[:])
+		if s, ok := summaryCache(r.TotalsCache).Get(key); ok {
+			return &s, nil
+		}
+	}
+	zap.L().Debug("run summary sql", zap.String("sql", sql), zap.Any("params", params))
+	rows := make([]map[string]any, 0, 1)
+	if err := db.Raw(sql, params...).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := &Summary{}
+	if len(rows) > 0 {
+		row := rows[0]
+		for k, v := range row {
+			// expression columns scanned as *interface{} by some drivers.
+			if p, ok := v.(*any); ok && p != nil {
+				row[k] = *p
+			}
+		}
+		result.Total = int64(toInt(row, KeyTotal))
+		delete(row, KeyTotal)
+		if len(row) > 0 {
+			result.Totals = row
+		}
+	}
+	if key != "" {
+		summaryCache(r.TotalsCache).Set(key, *result)
+	}
+	return result, nil
+}
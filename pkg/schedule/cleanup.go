@@ -49,6 +49,13 @@ func (cs *CleanupService) Cleanup(req *DBCleanupReq) error {
 		req.Cnn = "default"
 	}
 	db, ok := cs.DBMap[req.Cnn]
+	if !ok {
+		// named connections of orm.Registry registered on first use
+		if db = orm.DB(req.Cnn); db != nil {
+			cs.RegConnection(req.Cnn, db)
+			ok = true
+		}
+	}
 	if !ok {
 		cs.logger.Error("db connection is not found", zap.String("cnn", req.Cnn))
 		return fmt.Errorf("DB connection %s is not found", req.Cnn)
@@ -10,6 +10,7 @@ import (
 	"github.com/robfig/cron/v3"
 	"github.com/samber/lo"
 	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/tenant"
 	"go.uber.org/zap"
 )
 
@@ -135,6 +136,13 @@ func CreateScheduledJobWithContext(jobname, schedule string, cmd func(ctx contex
 	return CreateScheduledJob(jobname, schedule, fn, opts...)
 }
 
+// CreateAdminJob runs cmd across all tenants, tenant scoping disabled explicitly for the job context.
+func CreateAdminJob(jobname, schedule string, cmd func(ctx context.Context) error, opts ...ScheduleOptions) error {
+	return CreateScheduledJobWithContext(jobname, schedule, func(ctx context.Context) error {
+		return cmd(tenant.WithoutTenant(ctx, "schedule:"+jobname))
+	}, opts...)
+}
+
 func CreateSchedule(jobname, schedule string, cmd func(), opts ...ScheduleOptions) error {
 	return CreateScheduledJob(jobname, schedule, func() error {
 		cmd()
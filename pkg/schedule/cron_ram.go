@@ -7,6 +7,11 @@ import (
 	"go.uber.org/zap"
 )
 
+// IsLeader is always true in RAM mode, single instance.
+func IsLeader() bool {
+	return true
+}
+
 func CreateScheduledJob(jobname, schedule string, cmd func() error, opts ...ScheduleOptions) error {
 	err := core.GetContainer().Invoke(func(logger *zap.Logger) error {
 		opt := &ScheduleOptions{}
@@ -0,0 +1,348 @@
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"github.com/techquest-tech/gin-shared/pkg/cache"
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"github.com/thanhpk/randstr"
+	"go.uber.org/zap"
+)
+
+const KeySession = "session" // *Session of current request
+
+var (
+	ErrNoSession = errors.New("no session")
+	ErrExpired   = errors.New("session expired")
+	ErrRevoked   = errors.New("session revoked")
+)
+
+// Settings of session, config key session
+type Settings struct {
+	CookieName  string
+	CsrfCookie  string // readable by scripts, send it back by CsrfHeader
+	CsrfHeader  string
+	Domain      string
+	Path        string
+	Secure      bool
+	SameSite    string        // lax, strict or none
+	IdleTimeout time.Duration // sliding expiration
+	MaxAge      time.Duration // absolute lifetime
+	Prefix      string        // key prefix in cache.Hash
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		CookieName:  "session",
+		CsrfCookie:  "csrf_token",
+		CsrfHeader:  "X-CSRF-Token",
+		Path:        "/",
+		Secure:      true,
+		SameSite:    "lax",
+		IdleTimeout: 30 * time.Minute,
+		MaxAge:      12 * time.Hour,
+		Prefix:      "session:",
+	}
+}
+
+// Session of browser login, stored server side, cookie only carries the encrypted ID.
+type Session struct {
+	ID        string
+	User      string
+	Owner     string
+	Roles     []string
+	CSRF      string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	Data      map[string]string // e.g. refresh token of oidc login
+}
+
+// Manager creates, loads and revokes sessions, cookies encrypted & authenticated by AES-GCM with key from ConfigSecret.
+type Manager struct {
+	Settings *Settings
+	Store    cache.Hash
+	logger   *zap.Logger
+	aead     cipher.AEAD
+}
+
+func NewManager(secret core.ConfigSecret, store cache.Hash, logger *zap.Logger) (*Manager, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("config secret is required for session")
+	}
+	key := sha256.Sum256(append([]byte("session:"), secret...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	settings := DefaultSettings()
+	if sub := viper.Sub("session"); sub != nil {
+		sub.Unmarshal(settings)
+	}
+	return &Manager{
+		Settings: settings,
+		Store:    store,
+		logger:   logger,
+		aead:     aead,
+	}, nil
+}
+
+func init() {
+	core.Provide(NewManager)
+}
+
+// seal encrypts session ID, cookie name as additional data.
+func (m *Manager) seal(id string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(id), []byte(m.Settings.CookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (m *Manager) open(value string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < m.aead.NonceSize() {
+		return "", ErrNoSession
+	}
+	size := m.aead.NonceSize()
+	id, err := m.aead.Open(nil, raw[:size], raw[size:], []byte(m.Settings.CookieName))
+	if err != nil {
+		return "", ErrNoSession
+	}
+	return string(id), nil
+}
+
+func (m *Manager) sameSite() http.SameSite {
+	switch strings.ToLower(m.Settings.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func (m *Manager) setCookies(c *gin.Context, value, csrf string, maxAge int) {
+	c.SetSameSite(m.sameSite())
+	c.SetCookie(m.Settings.CookieName, value, maxAge, m.Settings.Path, m.Settings.Domain, m.Settings.Secure, true)
+	c.SetCookie(m.Settings.CsrfCookie, csrf, maxAge, m.Settings.Path, m.Settings.Domain, m.Settings.Secure, false)
+}
+
+func (m *Manager) key(id string) string {
+	return m.Settings.Prefix + id
+}
+
+func (m *Manager) userKey(user string) string {
+	return m.Settings.Prefix + "user:" + user
+}
+
+func (m *Manager) save(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return err
+	}
+	key := m.key(s.ID)
+	err = m.Store.SetValues(ctx, key, map[string]any{
+		"user":     s.User,
+		"owner":    s.Owner,
+		"roles":    strings.Join(s.Roles, ","),
+		"csrf":     s.CSRF,
+		"created":  s.CreatedAt.Format(time.RFC3339Nano),
+		"lastSeen": s.LastSeen.Format(time.RFC3339Nano),
+		"data":     string(data),
+	})
+	if err != nil {
+		return err
+	}
+	m.Store.SetTTL(ctx, key, m.Settings.IdleTimeout)
+	return nil
+}
+
+// Login creates session for the user and sets cookies, ID & CSRF filled.
+func (m *Manager) Login(c *gin.Context, s *Session) error {
+	now := time.Now()
+	s.ID = randstr.Hex(32)
+	s.CSRF = randstr.Hex(16)
+	s.CreatedAt = now
+	s.LastSeen = now
+	if err := m.save(c, s); err != nil {
+		return err
+	}
+	if s.User != "" {
+		if err := m.Store.SetValues(c, m.userKey(s.User), map[string]any{s.ID: now.Format(time.RFC3339)}); err != nil {
+			return err
+		}
+		m.Store.SetTTL(c, m.userKey(s.User), m.Settings.MaxAge)
+	}
+	value, err := m.seal(s.ID)
+	if err != nil {
+		return err
+	}
+	m.setCookies(c, value, s.CSRF, int(m.Settings.MaxAge.Seconds()))
+	m.logger.Info("session created", zap.String("user", s.User))
+	return nil
+}
+
+// Get loads session by ID, expired & revoked sessions rejected.
+func (m *Manager) Get(ctx context.Context, id string) (*Session, error) {
+	values, err := m.Store.GetAll(ctx, m.key(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 || values["created"] == "" {
+		return nil, ErrNoSession
+	}
+	if values["revoked"] != "" {
+		return nil, ErrRevoked
+	}
+	s := &Session{
+		ID:    id,
+		User:  values["user"],
+		Owner: values["owner"],
+		CSRF:  values["csrf"],
+		Data:  map[string]string{},
+	}
+	if roles := values["roles"]; roles != "" {
+		s.Roles = strings.Split(roles, ",")
+	}
+	s.CreatedAt, _ = time.Parse(time.RFC3339Nano, values["created"])
+	s.LastSeen, _ = time.Parse(time.RFC3339Nano, values["lastSeen"])
+	if data := values["data"]; data != "" {
+		json.Unmarshal([]byte(data), &s.Data)
+	}
+	now := time.Now()
+	if now.Sub(s.CreatedAt) > m.Settings.MaxAge || now.Sub(s.LastSeen) > m.Settings.IdleTimeout {
+		return nil, ErrExpired
+	}
+	return s, nil
+}
+
+// Load returns session of the request cookie, last seen slid at most once a minute.
+func (m *Manager) Load(c *gin.Context) (*Session, error) {
+	value, err := c.Cookie(m.Settings.CookieName)
+	if err != nil || value == "" {
+		return nil, ErrNoSession
+	}
+	id, err := m.open(value)
+	if err != nil {
+		return nil, err
+	}
+	s, err := m.Get(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now(); now.Sub(s.LastSeen) > time.Minute {
+		s.LastSeen = now
+		key := m.key(id)
+		if err := m.Store.SetValues(c, key, map[string]any{"lastSeen": now.Format(time.RFC3339Nano)}); err != nil {
+			m.logger.Warn("slide session failed", zap.Error(err))
+		}
+		m.Store.SetTTL(c, key, m.Settings.IdleTimeout)
+	}
+	return s, nil
+}
+
+// Update saves Data & roles of the session.
+func (m *Manager) Update(ctx context.Context, s *Session) error {
+	return m.save(ctx, s)
+}
+
+// Revoke invalidates the session immediately.
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	key := m.key(id)
+	if err := m.Store.SetValues(ctx, key, map[string]any{"revoked": time.Now().Format(time.RFC3339)}); err != nil {
+		return err
+	}
+	m.Store.SetTTL(ctx, key, m.Settings.IdleTimeout)
+	return nil
+}
+
+// RevokeUser invalidates all sessions of the user, returns sessions revoked.
+func (m *Manager) RevokeUser(ctx context.Context, user string) (int, error) {
+	ids, err := m.Store.GetAll(ctx, m.userKey(user))
+	if err != nil {
+		return 0, err
+	}
+	for id := range ids {
+		if err := m.Revoke(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	m.logger.Info("sessions of user revoked", zap.String("user", user), zap.Int("sessions", len(ids)))
+	return len(ids), nil
+}
+
+// Logout revokes current session and clears cookies.
+func (m *Manager) Logout(c *gin.Context) error {
+	s, err := m.Load(c)
+	m.setCookies(c, "", "", -1)
+	if err != nil {
+		return nil
+	}
+	return m.Revoke(c, s.ID)
+}
+
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// Middleware loads session and current user, same context keys as auth.AuthService.Auth,
+// CSRF header checked for unsafe methods. required false continues without session.
+func (m *Manager) Middleware(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, err := m.Load(c)
+		if err != nil {
+			if !required {
+				c.Next()
+				return
+			}
+			m.logger.Debug("session rejected", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginshared.GeneralResp{
+				ErrorCode:    "AuthFailed",
+				ErrorMessage: err.Error(),
+			})
+			return
+		}
+		if unsafeMethod(c.Request.Method) &&
+			subtle.ConstantTimeCompare([]byte(c.GetHeader(m.Settings.CsrfHeader)), []byte(s.CSRF)) != 1 {
+			m.logger.Warn("csrf token mismatched", zap.String("user", s.User), zap.String("path", c.Request.URL.Path))
+			c.AbortWithStatusJSON(http.StatusForbidden, ginshared.GeneralResp{
+				ErrorCode:    "Forbidden",
+				ErrorMessage: fmt.Sprintf("%s mismatched", m.Settings.CsrfHeader),
+			})
+			return
+		}
+		role := strings.Join(s.Roles, ",")
+		c.Set(KeySession, s)
+		c.Set(auth.KeyUser, &auth.AuthKey{UserName: s.User, Owner: s.Owner, Role: role})
+		c.Set("owner", s.Owner)
+		c.Set("user", s.User)
+		c.Set(auth.KeyRole, role)
+		c.Next()
+	}
+}
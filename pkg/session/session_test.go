@@ -0,0 +1,120 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/techquest-tech/gin-shared/pkg/auth"
+	"go.uber.org/zap"
+)
+
+type memHash struct {
+	lock sync.Mutex
+	data map[string]map[string]string
+}
+
+func (h *memHash) Existed(ctx context.Context, key string) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, ok := h.data[key]
+	return ok, nil
+}
+
+func (h *memHash) SetTTL(ctx context.Context, key string, ttl time.Duration) {}
+
+func (h *memHash) GetValues(ctx context.Context, key string, fields ...string) ([]any, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := make([]any, len(fields))
+	for i, f := range fields {
+		if v, ok := h.data[key][f]; ok {
+			result[i] = v
+		}
+	}
+	return result, nil
+}
+
+func (h *memHash) SetValues(ctx context.Context, key string, values map[string]any) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.data[key] == nil {
+		h.data[key] = map[string]string{}
+	}
+	for k, v := range values {
+		h.data[key][k] = v.(string)
+	}
+	return nil
+}
+
+func (h *memHash) GetAll(ctx context.Context, key string) (map[string]string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := map[string]string{}
+	for k, v := range h.data[key] {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func TestSession(t *testing.T) {
+	m, err := NewManager([]byte("secret"), &memHash{data: map[string]map[string]string{}}, zap.NewNop())
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) {
+		assert.NoError(t, m.Login(c, &Session{User: "bob", Owner: "acme", Roles: []string{"operator"}}))
+	})
+	r.Use(m.Middleware(true))
+	r.Any("/me", func(c *gin.Context) {
+		key := c.MustGet(auth.KeyUser).(*auth.AuthKey)
+		c.String(http.StatusOK, key.UserName+"/"+key.Owner+"/"+c.GetString(auth.KeyRole))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 2)
+	session, csrf := cookies[0], cookies[1]
+	assert.True(t, session.HttpOnly)
+	assert.NotContains(t, session.Value, "bob")
+
+	call := func(method string, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/me", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = call(http.MethodGet, session, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bob/acme/operator", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, nil, "").Code)
+
+	tampered := *session
+	flip := "A"
+	if session.Value[10] == 'A' {
+		flip = "B"
+	}
+	tampered.Value = session.Value[:10] + flip + session.Value[11:]
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, &tampered, "").Code)
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, session, "").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, session, csrf.Value).Code)
+
+	revoked, err := m.RevokeUser(context.Background(), "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, session, "").Code)
+}
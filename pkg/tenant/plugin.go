@@ -0,0 +1,197 @@
+package tenant
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/techquest-tech/gin-shared/pkg/core"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrTenantRequired = errors.New("tenant is required")
+	ErrTenantMismatch = errors.New("tenant mismatched")
+)
+
+// TenantScoped marks the model scoped by tenant, embed it into the model.
+type TenantScoped struct {
+	Tenant string `gorm:"size:64;index" json:"tenant"`
+}
+
+func (TenantScoped) tenantScoped() {}
+
+type scoped interface {
+	tenantScoped()
+}
+
+var scopedType = reflect.TypeOf((*scoped)(nil)).Elem()
+
+// Plugin injects tenant predicates on query/update/delete and fills tenant on create
+// for models embedded TenantScoped. raw sql is not touched.
+type Plugin struct {
+	Strict bool // scoped model without tenant & not bypassed failed with ErrTenantRequired
+	fields sync.Map
+}
+
+func NewPlugin() *Plugin {
+	return &Plugin{Strict: GetSettings().Strict}
+}
+
+func (p *Plugin) Name() string {
+	return "tenant"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("tenant:create", p.create),
+		cb.Query().Before("gorm:query").Register("tenant:query", p.query),
+		cb.Row().Before("gorm:row").Register("tenant:row", p.query),
+		cb.Update().Before("gorm:update").Register("tenant:update", p.change),
+		cb.Delete().Before("gorm:delete").Register("tenant:delete", p.change),
+	)
+}
+
+// field returns tenant field if the model is tenant scoped.
+func (p *Plugin) field(s *schema.Schema) *schema.Field {
+	if s == nil {
+		return nil
+	}
+	if f, ok := p.fields.Load(s); ok {
+		return f.(*schema.Field)
+	}
+	var f *schema.Field
+	if reflect.PointerTo(s.ModelType).Implements(scopedType) {
+		f = s.LookUpField("Tenant")
+	}
+	p.fields.Store(s, f)
+	return f
+}
+
+// tenant returns tenant of the statement, empty means skipped.
+func (p *Plugin) tenant(db *gorm.DB) (*schema.Field, string, bool) {
+	f := p.field(db.Statement.Schema)
+	if f == nil || db.Error != nil {
+		return nil, "", false
+	}
+	ctx := db.Statement.Context
+	if _, ok := Bypassed(ctx); ok {
+		return nil, "", false
+	}
+	tenant, ok := FromContext(ctx)
+	if !ok {
+		if p.Strict {
+			db.AddError(ErrTenantRequired)
+		} else {
+			zap.L().Warn("no tenant in context, query not scoped.", zap.String("table", db.Statement.Table))
+		}
+		return nil, "", false
+	}
+	return f, tenant, true
+}
+
+func (p *Plugin) create(db *gorm.DB) {
+	f, tenant, ok := p.tenant(db)
+	if !ok {
+		return
+	}
+	ctx := db.Statement.Context
+	set := func(rv reflect.Value) {
+		v, zero := f.ValueOf(ctx, rv)
+		if zero {
+			db.AddError(f.Set(ctx, rv, tenant))
+			return
+		}
+		if v != tenant {
+			db.AddError(ErrTenantMismatch)
+		}
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
+
+func (p *Plugin) query(db *gorm.DB) {
+	f, tenant, ok := p.tenant(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: tenant},
+	}})
+}
+
+// change scopes update/delete, tenant predicate must not turn missing where into a global update.
+func (p *Plugin) change(db *gorm.DB) {
+	f, tenant, ok := p.tenant(db)
+	if !ok {
+		return
+	}
+	if _, ok := db.Statement.Clauses["WHERE"]; !ok && !db.AllowGlobalUpdate && !hasPrimaryKey(db) {
+		db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: tenant},
+	}})
+}
+
+// hasPrimaryKey checks if gorm will add primary key conditions from the model value.
+func hasPrimaryKey(db *gorm.DB) bool {
+	s := db.Statement.Schema
+	if s == nil || len(s.PrimaryFields) == 0 {
+		return false
+	}
+	ctx := db.Statement.Context
+	check := func(rv reflect.Value) bool {
+		if rv.Kind() != reflect.Struct || rv.Type() != s.ModelType {
+			return false
+		}
+		for _, f := range s.PrimaryFields {
+			if _, zero := f.ValueOf(ctx, rv); zero {
+				return false
+			}
+		}
+		return true
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if check(reflect.Indirect(rv.Index(i))) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(rv)
+}
+
+// Register enables tenant plugin on db, default DB registered on startup.
+func Register(db *gorm.DB) error {
+	return db.Use(NewPlugin())
+}
+
+func init() {
+	core.ProvideStartup(func(db core.OptionalParam[*gorm.DB], logger *zap.Logger) core.Startup {
+		if db.P == nil {
+			return nil
+		}
+		if err := Register(db.P); err != nil {
+			logger.Error("register tenant plugin failed.", zap.Error(err))
+			return nil
+		}
+		logger.Info("tenant plugin enabled", zap.Bool("strict", GetSettings().Strict))
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type order struct {
+	gorm.Model
+	TenantScoped
+	Code string
+}
+
+type unscoped struct {
+	ID   uint
+	Code string
+}
+
+func setup(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&order{}, &unscoped{}))
+	assert.NoError(t, db.Use(&Plugin{Strict: true}))
+	return db
+}
+
+func TestPlugin(t *testing.T) {
+	db := setup(t)
+	a := WithTenant(context.Background(), "a")
+	b := WithTenant(context.Background(), "b")
+
+	assert.ErrorIs(t, db.Create(&order{Code: "x"}).Error, ErrTenantRequired)
+	assert.NoError(t, db.Create(&unscoped{Code: "x"}).Error)
+
+	o := &order{Code: "a1"}
+	assert.NoError(t, db.WithContext(a).Create(o).Error)
+	assert.Equal(t, "a", o.Tenant)
+	assert.NoError(t, db.WithContext(a).Create(&[]order{{Code: "a2"}, {Code: "a3"}}).Error)
+	assert.NoError(t, db.WithContext(b).Create(&order{Code: "b1"}).Error)
+	assert.ErrorIs(t, db.WithContext(b).Create(&order{Code: "b2", TenantScoped: TenantScoped{Tenant: "a"}}).Error, ErrTenantMismatch)
+
+	count := int64(0)
+	db.WithContext(a).Model(&order{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+
+	found := &order{}
+	assert.ErrorIs(t, db.WithContext(b).First(found, o.ID).Error, gorm.ErrRecordNotFound)
+
+	result := db.WithContext(b).Model(&order{}).Where("code like ?", "a%").Update("code", "hacked")
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	assert.ErrorIs(t, db.WithContext(a).Model(&order{}).Update("code", "all").Error, gorm.ErrMissingWhereClause)
+	assert.NoError(t, db.WithContext(a).Model(o).Update("code", "a1-1").Error)
+
+	result = db.WithContext(b).Delete(&order{}, o.ID)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	all := WithoutTenant(context.Background(), "test")
+	db.WithContext(all).Model(&order{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+}
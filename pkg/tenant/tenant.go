@@ -0,0 +1,163 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+	"github.com/techquest-tech/gin-shared/pkg/ginshared"
+	"go.uber.org/zap"
+)
+
+const (
+	KeyTenant = "tenant"
+
+	SourceOwner    = "owner"    // AuthKey.Owner set by auth.AuthService
+	SourceKeycloak = "keycloak" // claim of keycloak token
+	SourceHeader   = "header"   // request header, only if listed in sources
+)
+
+type Settings struct {
+	Sources []string // resolve order, default owner, keycloak
+	Header  string   // default X-Tenant-ID
+	Claim   string   // keycloak claim, top level or in custom_claims, default tenant
+	Strict  bool     // scoped model without tenant in context failed, default true
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Sources: []string{SourceOwner, SourceKeycloak},
+		Header:  "X-Tenant-ID",
+		Claim:   "tenant",
+		Strict:  true,
+	}
+}
+
+var settings *Settings
+
+func GetSettings() *Settings {
+	if settings == nil {
+		s := DefaultSettings()
+		if sub := viper.Sub("tenant"); sub != nil {
+			sub.Unmarshal(s)
+		}
+		settings = s
+	}
+	return settings
+}
+
+type tenantKey struct{}
+type bypassKey struct{}
+
+// WithTenant puts tenant on context, e.g. background job processes data for one tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// WithoutTenant disables tenant scoping for the context, for admin jobs across all tenants.
+// reason is required and logged, it should be the job name or who is asking.
+func WithoutTenant(ctx context.Context, reason string) context.Context {
+	if reason == "" {
+		panic("reason is required for tenant bypass")
+	}
+	zap.L().Info("tenant scoping bypassed", zap.String("reason", reason))
+	return context.WithValue(ctx, bypassKey{}, reason)
+}
+
+// Bypassed returns the reason if tenant scoping disabled by WithoutTenant.
+func Bypassed(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	reason, ok := ctx.Value(bypassKey{}).(string)
+	return reason, ok
+}
+
+// FromContext returns tenant of the context, *gin.Context resolved from the request directly.
+func FromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant, tenant != ""
+	}
+	if c, ok := ctx.(*gin.Context); ok {
+		return Resolve(c)
+	}
+	return "", false
+}
+
+// Resolve tenant of the request by configured sources.
+func Resolve(c *gin.Context) (string, bool) {
+	if tenant := c.GetString(KeyTenant); tenant != "" {
+		return tenant, true
+	}
+	if tenant, ok := c.Request.Context().Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant, true
+	}
+	s := GetSettings()
+	for _, source := range s.Sources {
+		tenant := ""
+		switch strings.ToLower(source) {
+		case SourceOwner:
+			tenant = c.GetString("owner")
+		case SourceKeycloak:
+			tenant = claimOf(c, s.Claim)
+		case SourceHeader:
+			tenant = strings.TrimSpace(c.GetHeader(s.Header))
+		}
+		if tenant != "" {
+			return tenant, true
+		}
+	}
+	return "", false
+}
+
+func claimOf(c *gin.Context, claim string) string {
+	tk, ok := c.Get("token")
+	if !ok {
+		return ""
+	}
+	token, ok := tk.(ginkeycloak.KeyCloakToken)
+	if !ok {
+		return ""
+	}
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return ""
+	}
+	claims := map[string]any{}
+	json.Unmarshal(raw, &claims)
+	if v, ok := claims[claim].(string); ok {
+		return v
+	}
+	if custom, ok := claims["custom_claims"].(map[string]any); ok {
+		if v, ok := custom[claim].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Middleware resolves tenant and puts it on request context, use it after auth middleware.
+// required rejects requests without tenant.
+func Middleware(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := Resolve(c)
+		if !ok {
+			if required {
+				c.AbortWithStatusJSON(http.StatusForbidden, ginshared.UnifiedResp{Error: ErrTenantRequired.Error()})
+				return
+			}
+			c.Next()
+			return
+		}
+		c.Set(KeyTenant, tenant)
+		c.Request = c.Request.WithContext(WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}